@@ -0,0 +1,195 @@
+//go:build !fabric1
+// +build !fabric1
+
+// Package ledgersim runs Fabric v0.6 chaincode against an in-memory ledger so
+// it can be exercised without a peer, e.g. from a chaincode's unit tests:
+//
+//	l := ledgersim.New("mgi", new(SimpleChaincode))
+//	l.SetCaller(map[string]string{"role": "admin", "member": "moneygram"})
+//	l.Init("init", "1")
+//	out, err := l.Invoke("create_event", "tr1", ...)
+//
+// Every call runs in its own transaction. Writes are buffered on the stub and
+// only reach the ledger when the chaincode function returns without an error,
+// as a peer discards the write set of a failed transaction. Transaction IDs and
+// timestamps are deterministic so results can be compared across runs.
+package ledgersim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// DefaultStart is the timestamp of the first transaction on a new Ledger.
+var DefaultStart = time.Date(2016, 11, 26, 17, 0, 0, 0, time.UTC)
+
+// Event is a chaincode event set by a committed transaction.
+type Event struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+// Ledger is the world state of one chaincode.
+type Ledger struct {
+	// Name prefixes the generated transaction IDs.
+	Name string
+	// Start is the timestamp of the first transaction; Step is added for
+	// every transaction after it.
+	Start time.Time
+	Step  time.Duration
+
+	mu        sync.Mutex
+	chaincode shim.Chaincode
+	state     map[string][]byte
+	attrs     map[string][]byte
+	cert      []byte
+	events    []Event
+	txCount   int
+}
+
+// New returns an empty ledger for the chaincode.
+func New(name string, cc shim.Chaincode) *Ledger {
+	return &Ledger{
+		Name:      name,
+		Start:     DefaultStart,
+		Step:      time.Second,
+		chaincode: cc,
+		state:     map[string][]byte{},
+		attrs:     map[string][]byte{},
+	}
+}
+
+// SetCaller sets the eCert attributes ReadCertAttribute returns for the
+// following calls.
+func (l *Ledger) SetCaller(attrs map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.attrs = make(map[string][]byte, len(attrs))
+	for k, v := range attrs {
+		l.attrs[k] = []byte(v)
+	}
+}
+
+// SetCallerCertificate sets the bytes GetCallerCertificate returns.
+func (l *Ledger) SetCallerCertificate(cert []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cert = cert
+}
+
+// Init deploys the chaincode by calling its Init function.
+func (l *Ledger) Init(function string, args ...string) ([]byte, error) {
+	return l.run(false, function, args, l.chaincode.Init)
+}
+
+// Invoke runs an invoke transaction.
+func (l *Ledger) Invoke(function string, args ...string) ([]byte, error) {
+	return l.run(false, function, args, l.chaincode.Invoke)
+}
+
+// Query runs a query. Any attempt to write state fails, as it does on a peer.
+func (l *Ledger) Query(function string, args ...string) ([]byte, error) {
+	return l.run(true, function, args, l.chaincode.Query)
+}
+
+// LastTxID returns the ID of the most recent transaction.
+func (l *Ledger) LastTxID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.txID(l.txCount)
+}
+
+// Get returns the committed value of a key, or nil.
+func (l *Ledger) Get(key string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return copyBytes(l.state[key])
+}
+
+// Put writes a committed value directly, bypassing the chaincode.
+func (l *Ledger) Put(key string, value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state[key] = copyBytes(value)
+}
+
+// Keys returns every committed key in order.
+func (l *Ledger) Keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, len(l.state))
+	for k := range l.state {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Events returns the events of every committed transaction, oldest first.
+func (l *Ledger) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
+
+type chaincodeFunc func(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error)
+
+func (l *Ledger) run(readOnly bool, function string, args []string, fn chaincodeFunc) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.txCount++
+	stub := &Stub{
+		ledger:    l,
+		txID:      l.txID(l.txCount),
+		timestamp: l.Start.Add(time.Duration(l.txCount-1) * l.Step),
+		function:  function,
+		args:      append([]string(nil), args...),
+		readOnly:  readOnly,
+		writes:    map[string][]byte{},
+		attrs:     l.attrs,
+		cert:      l.cert,
+	}
+
+	out, err := fn(stub, function, stub.args)
+	if err != nil {
+		return out, err
+	}
+
+	if !readOnly {
+		for k, v := range stub.writes {
+			if v == nil {
+				delete(l.state, k)
+			} else {
+				l.state[k] = v
+			}
+		}
+		if stub.event != nil {
+			l.events = append(l.events, *stub.event)
+		}
+	}
+
+	return out, nil
+}
+
+func (l *Ledger) txID(n int) string {
+	return fmt.Sprintf("%s-tx%06d", l.Name, n)
+}
+
+// errReadOnly is returned by writes made from a query.
+var errReadOnly = errors.New("ledgersim: state cannot be changed by a query")
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
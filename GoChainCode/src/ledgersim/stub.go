@@ -0,0 +1,268 @@
+//go:build !fabric1
+// +build !fabric1
+
+package ledgersim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/crypto/attr"
+)
+
+// Stub is the shim.ChaincodeStubInterface handed to one chaincode call.
+type Stub struct {
+	ledger    *Ledger
+	txID      string
+	timestamp time.Time
+	function  string
+	args      []string
+	readOnly  bool
+	attrs     map[string][]byte
+	cert      []byte
+
+	// writes buffers the transaction's write set; a nil value is a delete.
+	writes map[string][]byte
+	event  *Event
+}
+
+var _ shim.ChaincodeStubInterface = (*Stub)(nil)
+
+// errNotSupported is returned by the parts of the interface the simulator does
+// not model.
+func errNotSupported(name string) error {
+	return errors.New("ledgersim: " + name + " is not supported")
+}
+
+// GetArgs returns the function name followed by the arguments.
+func (s *Stub) GetArgs() [][]byte {
+	args := [][]byte{[]byte(s.function)}
+	for _, a := range s.args {
+		args = append(args, []byte(a))
+	}
+	return args
+}
+
+// GetStringArgs returns the function name followed by the arguments.
+func (s *Stub) GetStringArgs() []string {
+	return append([]string{s.function}, s.args...)
+}
+
+// GetTxID returns the deterministic transaction ID.
+func (s *Stub) GetTxID() string {
+	return s.txID
+}
+
+// InvokeChaincode is not supported.
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	return nil, errNotSupported("InvokeChaincode")
+}
+
+// QueryChaincode is not supported.
+func (s *Stub) QueryChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	return nil, errNotSupported("QueryChaincode")
+}
+
+// GetState returns the value of a key as seen by this transaction, including
+// its own uncommitted writes. A missing key returns nil and no error.
+func (s *Stub) GetState(key string) ([]byte, error) {
+	if v, ok := s.writes[key]; ok {
+		return copyBytes(v), nil
+	}
+	return copyBytes(s.ledger.state[key]), nil
+}
+
+// PutState buffers a write.
+func (s *Stub) PutState(key string, value []byte) error {
+	if s.readOnly {
+		return errReadOnly
+	}
+	if key == "" {
+		return errors.New("ledgersim: key must not be empty")
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	s.writes[key] = copyBytes(value)
+	return nil
+}
+
+// DelState buffers a delete.
+func (s *Stub) DelState(key string) error {
+	if s.readOnly {
+		return errReadOnly
+	}
+	s.writes[key] = nil
+	return nil
+}
+
+// RangeQueryState iterates over the keys from startKey to endKey inclusive,
+// in key order, as seen by this transaction. An empty endKey has no upper
+// bound.
+func (s *Stub) RangeQueryState(startKey, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	inRange := func(k string) bool {
+		return k >= startKey && (endKey == "" || k <= endKey)
+	}
+
+	merged := map[string][]byte{}
+	for k, v := range s.ledger.state {
+		if inRange(k) {
+			merged[k] = v
+		}
+	}
+	for k, v := range s.writes {
+		if !inRange(k) {
+			continue
+		}
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+
+	it := &rangeIterator{}
+	for k := range merged {
+		it.keys = append(it.keys, k)
+	}
+	sort.Strings(it.keys)
+	for _, k := range it.keys {
+		it.values = append(it.values, copyBytes(merged[k]))
+	}
+	return it, nil
+}
+
+// CreateTable is not supported; the chaincodes in this repository keep their
+// records as JSON state.
+func (s *Stub) CreateTable(name string, columnDefinitions []*shim.ColumnDefinition) error {
+	return errNotSupported("CreateTable")
+}
+
+// GetTable is not supported.
+func (s *Stub) GetTable(tableName string) (*shim.Table, error) {
+	return nil, errNotSupported("GetTable")
+}
+
+// DeleteTable is not supported.
+func (s *Stub) DeleteTable(tableName string) error {
+	return errNotSupported("DeleteTable")
+}
+
+// InsertRow is not supported.
+func (s *Stub) InsertRow(tableName string, row shim.Row) (bool, error) {
+	return false, errNotSupported("InsertRow")
+}
+
+// ReplaceRow is not supported.
+func (s *Stub) ReplaceRow(tableName string, row shim.Row) (bool, error) {
+	return false, errNotSupported("ReplaceRow")
+}
+
+// GetRow is not supported.
+func (s *Stub) GetRow(tableName string, key []shim.Column) (shim.Row, error) {
+	return shim.Row{}, errNotSupported("GetRow")
+}
+
+// GetRows is not supported.
+func (s *Stub) GetRows(tableName string, key []shim.Column) (<-chan shim.Row, error) {
+	return nil, errNotSupported("GetRows")
+}
+
+// DeleteRow is not supported.
+func (s *Stub) DeleteRow(tableName string, key []shim.Column) error {
+	return errNotSupported("DeleteRow")
+}
+
+// ReadCertAttribute returns an attribute set with Ledger.SetCaller.
+func (s *Stub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	v, ok := s.attrs[attributeName]
+	if !ok {
+		return nil, fmt.Errorf("ledgersim: caller certificate has no attribute %q", attributeName)
+	}
+	return copyBytes(v), nil
+}
+
+// VerifyAttribute reports whether the caller has the attribute with the value.
+func (s *Stub) VerifyAttribute(attributeName string, attributeValue []byte) (bool, error) {
+	v, ok := s.attrs[attributeName]
+	return ok && bytes.Equal(v, attributeValue), nil
+}
+
+// VerifyAttributes reports whether the caller has every attribute.
+func (s *Stub) VerifyAttributes(attrs ...*attr.Attribute) (bool, error) {
+	for _, a := range attrs {
+		if ok, _ := s.VerifyAttribute(a.Name, a.Value); !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// VerifySignature is not supported.
+func (s *Stub) VerifySignature(certificate, signature, message []byte) (bool, error) {
+	return false, errNotSupported("VerifySignature")
+}
+
+// GetCallerCertificate returns the bytes set with Ledger.SetCallerCertificate.
+func (s *Stub) GetCallerCertificate() ([]byte, error) {
+	return copyBytes(s.cert), nil
+}
+
+// GetCallerMetadata returns no metadata.
+func (s *Stub) GetCallerMetadata() ([]byte, error) {
+	return nil, nil
+}
+
+// GetBinding returns the transaction ID, which is unique per transaction.
+func (s *Stub) GetBinding() ([]byte, error) {
+	return []byte(s.txID), nil
+}
+
+// GetPayload returns no payload.
+func (s *Stub) GetPayload() ([]byte, error) {
+	return nil, nil
+}
+
+// GetTxTimestamp returns the deterministic transaction time.
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.timestamp.Unix(), Nanos: int32(s.timestamp.Nanosecond())}, nil
+}
+
+// SetEvent sets the transaction's event. As on a peer only the last call
+// counts, and the event is dropped if the transaction fails.
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("ledgersim: event name must not be empty")
+	}
+	s.event = &Event{TxID: s.txID, Name: name, Payload: copyBytes(payload)}
+	return nil
+}
+
+type rangeIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+	closed bool
+}
+
+func (it *rangeIterator) HasNext() bool {
+	return !it.closed && it.pos < len(it.keys)
+}
+
+func (it *rangeIterator) Next() (string, []byte, error) {
+	if !it.HasNext() {
+		return "", nil, errors.New("ledgersim: iterator exhausted")
+	}
+	it.pos++
+	return it.keys[it.pos-1], it.values[it.pos-1], nil
+}
+
+func (it *rangeIterator) Close() error {
+	it.closed = true
+	return nil
+}
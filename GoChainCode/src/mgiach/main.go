@@ -0,0 +1,99 @@
+// Command mgiach writes a NACHA ACH file for the USD settlement legs of a closed
+// settlement batch, and verifies the control totals of ACH files.
+//
+//	mgiach -chaincode <name> -config ach.json -batch 3 > settle3.ach
+//	mgiach -verify settle3.ach
+//
+// The config file holds the originator fields of nacha.Config and an
+// "accounts" object mapping member IDs to
+// {"name": "...", "routing": "...", "account": "...", "savings": false}.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"moneygram/fabric"
+	"moneygram/model"
+	"moneygram/nacha"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user to query as")
+	config := flag.String("config", "", "JSON file with the originator and member accounts")
+	batch := flag.String("batch", "", "closed settlement batch ID")
+	date := flag.String("date", "", "effective entry date YYYY-MM-DD (default tomorrow)")
+	modifier := flag.String("modifier", "A", "file ID modifier, A-Z or 0-9")
+	verify := flag.String("verify", "", "ACH file to verify")
+	flag.Parse()
+
+	if *verify != "" {
+		verifyFile(*verify)
+		return
+	}
+
+	if *chaincode == "" || *config == "" || *batch == "" {
+		fail("-chaincode, -config and -batch are required")
+	}
+
+	raw, err := ioutil.ReadFile(*config)
+	if err != nil {
+		fail("%v", err)
+	}
+	var cfg nacha.Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		fail("reading config: %v", err)
+	}
+
+	now := time.Now().UTC()
+	effective := now.AddDate(0, 0, 1)
+	if *date != "" {
+		if effective, err = time.Parse("2006-01-02", *date); err != nil {
+			fail("invalid -date: %v", err)
+		}
+	}
+
+	client := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+	out, err := client.Query("get_settlement_batch", []string{*batch})
+	if err != nil {
+		fail("%v", err)
+	}
+	var b model.SettlementBatch
+	if err := json.Unmarshal(out, &b); err != nil {
+		fail("%v", err)
+	}
+
+	file, err := nacha.Generate(b, cfg, now, effective, *modifier)
+	if err != nil {
+		fail("%v", err)
+	}
+	os.Stdout.Write(file)
+}
+
+func verifyFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fail("%v", err)
+	}
+	defer f.Close()
+
+	sum, err := nacha.Verify(f)
+	if sum != nil {
+		out, _ := json.MarshalIndent(sum, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiach: "+format+"\n", args...)
+	os.Exit(1)
+}
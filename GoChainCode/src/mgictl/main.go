@@ -0,0 +1,516 @@
+// Command mgictl runs the MoneyGram chaincode's functions from the shell, so
+// nobody has to hand-write JSON-RPC bodies for the peer.
+//
+//	mgictl -chaincode <name> -secure-context admin create -tran tr1 -sender "Ann Lee" -sender-country USA \
+//	    -receiver "Luis Ruiz" -receiver-country MEX -amount 250 -from wf -to bbva
+//	mgictl -chaincode <name> create -file transfers.json     create_events, all or none
+//	mgictl -chaincode <name> get tr1
+//	mgictl -chaincode <name> -o csv list -all
+//	mgictl -chaincode <name> batches
+//	mgictl -chaincode <name> batch -obligations 3
+//	mgictl -chaincode <name> proof tr1                   check tr1 was in its closed batch
+//	mgictl -chaincode <name> -secure-context bbva -pickup-key pickup.key pickup verify 4817305522 73314402
+//	mgictl -chaincode <name> -secure-context bbva -receiver-id-key bbva-id.key payout confirm tr1 passport G1234567 "Agent 12, Puebla" USD 250
+//	mgictl -chaincode <name> member list
+//	mgictl -chaincode <name> member register bbva "BBVA Mexico" 500000
+//	mgictl -chaincode <name> member cap bbva 750000
+//	mgictl -chaincode <name> member limit wf bbva 100000
+//	mgictl -chaincode <name> member exposure bbva
+//
+// Output is a table unless -o json or -o csv is given; JSON is the record the
+// chaincode returned. A Fabric v0.6 peer only returns a transaction ID for an
+// invoke, so check the result with get once the transaction has committed.
+// Pickup codes are hashed under the network's pickup key, a hex file given
+// with -pickup-key, before they are sent, and receivers' ID documents under
+// the payout member's key, given with -receiver-id-key. For development, run the chaincode built with the devpeer tag and point
+// -url at it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"moneygram/fabric"
+	"moneygram/merkle"
+	"moneygram/model"
+)
+
+const pageSize = 500
+
+// backend runs chaincode functions. fabric.Client satisfies it.
+type backend interface {
+	Invoke(function string, args []string) (string, error)
+	Query(function string, args []string) ([]byte, error)
+}
+
+var transferColumns = []string{"tranID", "status", "amount", "fee", "sendingMember", "payoutMember",
+	"sender", "senderCountry", "receiver", "receiverCountry", "payout", "batchID", "createdAt", "reference"}
+
+var batchColumns = []string{"batchID", "status", "currency", "transfers", "openedAt", "closedAt", "merkleRoot"}
+
+func main() {
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user to run as")
+	format := flag.String("o", formatTable, "output format: table, json or csv")
+	pickupKey := flag.String("pickup-key", "", "file holding the network's pickup key in hex")
+	receiverIDKey := flag.String("receiver-id-key", "", "file holding the member's receiver ID key in hex")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *format != formatTable && *format != formatJSON && *format != formatCSV {
+		fail("unknown output format %q", *format)
+	}
+
+	if *chaincode == "" {
+		fail("-chaincode is required")
+	}
+	ledger := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+
+	c := &cli{ledger: ledger, out: printer{format: *format, w: os.Stdout}}
+	if *pickupKey != "" {
+		var err error
+		if c.pickupKey, err = model.LoadKey(*pickupKey); err != nil {
+			fail("%v", err)
+		}
+	}
+	if *receiverIDKey != "" {
+		var err error
+		if c.receiverIDKey, err = model.LoadKey(*receiverIDKey); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	args := flag.Args()
+	var err error
+	switch args[0] {
+	case "create":
+		err = c.create(args[1:])
+	case "get":
+		err = c.get(args[1:])
+	case "list":
+		err = c.list(args[1:])
+	case "batches":
+		err = c.batches(args[1:])
+	case "batch":
+		err = c.batch(args[1:])
+	case "proof":
+		err = c.proof(args[1:])
+	case "pickup":
+		err = c.pickup(args[1:])
+	case "payout":
+		err = c.payout(args[1:])
+	case "member":
+		err = c.member(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: mgictl [flags] command [arguments]
+
+commands:
+  create -tran ID -sender NAME -sender-country C -receiver NAME -receiver-country C
+         -amount A -from MEMBER -to MEMBER [-fee F] [-payout-currency C -payout-amount A]
+         [-pickup-code CODE] [-agent-location ID]
+  create -file transfers.json
+  get TRANID
+  list [-offset N] [-limit N] [-member MEMBER] [-all]
+  batches
+  batch [-obligations] BATCHID|open
+  proof TRANID
+  pickup status|verify|unlock REFERENCE [CODE]
+  payout status TRANID
+  payout confirm TRANID ID-TYPE ID-NUMBER LOCATION CURRENCY AMOUNT
+  member list
+  member register MEMBERID NAME NETDEBITCAP
+  member cap MEMBERID NETDEBITCAP
+  member limit CREDITOR DEBTOR LIMIT
+  member exposure MEMBERID
+
+flags:`)
+	flag.PrintDefaults()
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgictl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+type cli struct {
+	ledger        backend
+	out           printer
+	pickupKey     []byte
+	receiverIDKey []byte
+}
+
+// invoke submits a transaction and prints its ID.
+func (c *cli) invoke(function string, args []string) error {
+	txID, err := c.ledger.Invoke(function, args)
+	if err != nil {
+		return err
+	}
+	return c.out.print(map[string]string{"txID": txID}, []string{"txID"}, [][]string{{txID}})
+}
+
+// query runs a chaincode query and decodes its result into v.
+func (c *cli) query(function string, args []string, v interface{}) error {
+	out, err := c.ledger.Query(function, args)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("%s: %v", function, err)
+	}
+	return nil
+}
+
+func (c *cli) create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	file := fs.String("file", "", "JSON array of transfers to create all or none")
+	var e model.TransactionEvent
+	fs.StringVar(&e.TranID, "tran", "", "tranID")
+	fs.StringVar(&e.SenderName, "sender", "", "sender name")
+	fs.StringVar(&e.SenderCountry, "sender-country", "", "sender country")
+	fs.StringVar(&e.ReceiverName, "receiver", "", "receiver name")
+	fs.StringVar(&e.ReceiverCountry, "receiver-country", "", "receiver country")
+	fs.StringVar(&e.Amount, "amount", "", "amount in "+model.SettlementCurrency)
+	fs.StringVar(&e.SendingMember, "from", "", "sending member")
+	fs.StringVar(&e.PayoutMember, "to", "", "payout member")
+	fs.StringVar(&e.Fee, "fee", "", "fee in "+model.SettlementCurrency)
+	fs.StringVar(&e.PayoutCurrency, "payout-currency", "", "currency the receiver is paid in")
+	fs.StringVar(&e.PayoutAmount, "payout-amount", "", "amount the receiver is paid in the payout currency")
+	fs.StringVar(&e.PickupCode, "pickup-code", "", "secret code the receiver gives to pick the transfer up in cash")
+	fs.StringVar(&e.AgentLocation, "agent-location", "", "sending member's ID for the agent location taking the transfer")
+	fs.Parse(args)
+
+	if *file != "" {
+		raw, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		var events []model.TransactionEvent
+		if err := json.Unmarshal(raw, &events); err != nil {
+			return fmt.Errorf("%s: expecting a JSON array of transfers: %v", *file, err)
+		}
+		for i := range events {
+			if err := events[i].HashPickupCode(c.pickupKey); err != nil {
+				return fmt.Errorf("transfer %s: %v", events[i].TranID, err)
+			}
+		}
+		arg, err := model.CreateEventsArg(events)
+		if err != nil {
+			return err
+		}
+		return c.invoke("create_events", []string{arg})
+	}
+
+	if e.TranID == "" || e.Amount == "" || e.SendingMember == "" || e.PayoutMember == "" {
+		return fmt.Errorf("create needs -tran, -amount, -from and -to, or -file")
+	}
+	if err := e.HashPickupCode(c.pickupKey); err != nil {
+		return err
+	}
+	return c.invoke("create_event", e.CreateArgs())
+}
+
+func (c *cli) get(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: get TRANID")
+	}
+	var e model.TransactionEvent
+	if err := c.query("get_event_details", args, &e); err != nil {
+		return err
+	}
+	return c.out.print(e, transferColumns, [][]string{transferRow(e)})
+}
+
+func (c *cli) list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	offset := fs.Int("offset", 0, "transfers to skip")
+	limit := fs.Int("limit", 50, "most transfers to list")
+	member := fs.String("member", "", "only transfers sent or paid out by this member")
+	all := fs.Bool("all", false, "list every transfer from -offset on")
+	fs.Parse(args)
+
+	page := model.EventPage{Offset: *offset, Events: []model.TransactionEvent{}}
+	for {
+		n := *limit
+		if *all {
+			n = pageSize
+		}
+		queryArgs := []string{strconv.Itoa(*offset + len(page.Events)), strconv.Itoa(n)}
+		if *member != "" {
+			queryArgs = append(queryArgs, *member)
+		}
+
+		var next model.EventPage
+		if err := c.query("get_events", queryArgs, &next); err != nil {
+			return err
+		}
+		page.Total = next.Total
+		page.Events = append(page.Events, next.Events...)
+
+		if !*all || len(next.Events) == 0 || *offset+len(page.Events) >= next.Total {
+			break
+		}
+	}
+
+	rows := make([][]string, 0, len(page.Events))
+	for _, e := range page.Events {
+		rows = append(rows, transferRow(e))
+	}
+	return c.out.print(page, transferColumns, rows)
+}
+
+func (c *cli) batches(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: batches")
+	}
+	var holder struct {
+		BatchIDs []string `json:"batchIDs"`
+	}
+	if err := c.query("get_settlement_batches", nil, &holder); err != nil {
+		return err
+	}
+
+	batches := make([]model.SettlementBatch, 0, len(holder.BatchIDs))
+	rows := make([][]string, 0, len(holder.BatchIDs))
+	for _, id := range holder.BatchIDs {
+		var b model.SettlementBatch
+		if err := c.query("get_settlement_batch", []string{id}, &b); err != nil {
+			return err
+		}
+		batches = append(batches, b)
+		rows = append(rows, batchRow(b))
+	}
+	return c.out.print(batches, batchColumns, rows)
+}
+
+func (c *cli) batch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	obligations := fs.Bool("obligations", false, "list the net obligations of a closed batch")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: batch [-obligations] BATCHID|open")
+	}
+
+	var b model.SettlementBatch
+	if err := c.query("get_settlement_batch", fs.Args(), &b); err != nil {
+		return err
+	}
+
+	if !*obligations {
+		return c.out.print(b, batchColumns, [][]string{batchRow(b)})
+	}
+	rows := make([][]string, 0, len(b.Obligations))
+	for _, o := range b.Obligations {
+		rows = append(rows, []string{o.Debtor, o.Creditor, o.Amount})
+	}
+	return c.out.print(b.Obligations, []string{"debtor", "creditor", "amount"}, rows)
+}
+
+// proof fetches a transfer's inclusion proof and checks it against the
+// transfer and the Merkle root of its batch, each read separately.
+func (c *cli) proof(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: proof TRANID")
+	}
+	var p model.InclusionProof
+	if err := c.query("get_inclusion_proof", args, &p); err != nil {
+		return err
+	}
+	var e model.TransactionEvent
+	if err := c.query("get_event_details", args, &e); err != nil {
+		return err
+	}
+	var b model.SettlementBatch
+	if err := c.query("get_settlement_batch", []string{e.BatchID}, &b); err != nil {
+		return err
+	}
+	if err := merkle.Verify(p, e, b.MerkleRoot); err != nil {
+		return fmt.Errorf("transfer %s: %v", e.TranID, err)
+	}
+
+	row := []string{p.TranID, p.BatchID, strconv.Itoa(p.LeafIndex), strconv.Itoa(p.LeafCount), p.LeafHash, b.MerkleRoot, "verified"}
+	return c.out.print(p, []string{"tranID", "batchID", "leafIndex", "leafCount", "leafHash", "merkleRoot", "result"}, [][]string{row})
+}
+
+// pickup checks and manages the pickup code of a cash pickup transfer. A
+// Fabric v0.6 peer does not return the result of verify, so it is followed
+// by pickup status once the transaction has committed.
+func (c *cli) pickup(args []string) error {
+	usage := fmt.Errorf("usage: pickup status|verify|unlock REFERENCE [CODE]")
+	if len(args) < 2 {
+		return usage
+	}
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "status":
+		if len(args) != 1 {
+			return usage
+		}
+		var p model.PickupStatus
+		if err := c.query("get_pickup", args, &p); err != nil {
+			return err
+		}
+		row := []string{p.ReferenceNumber, p.TranID, p.Status, p.VerifiedAt, strconv.Itoa(p.FailedAttempts), strconv.Itoa(p.AttemptsLeft), strconv.FormatBool(p.Locked)}
+		return c.out.print(p, []string{"reference", "tranID", "status", "verifiedAt", "failedAttempts", "attemptsLeft", "locked"}, [][]string{row})
+
+	case "verify":
+		if len(args) != 2 {
+			return usage
+		}
+		var p model.PickupStatus
+		if err := c.query("get_pickup", args[:1], &p); err != nil {
+			return err
+		}
+		hash, err := model.PickupCodeHash(c.pickupKey, p.TranID, args[1])
+		if err != nil {
+			return err
+		}
+		return c.invoke("verify_pickup", []string{args[0], hash})
+
+	case "unlock":
+		if len(args) != 1 {
+			return usage
+		}
+		return c.invoke("unlock_pickup", args)
+	}
+	return usage
+}
+
+// payout confirms that a transfer has been paid to its receiver, as its
+// payout member, and shows the confirmation once the transaction has
+// committed.
+func (c *cli) payout(args []string) error {
+	usage := fmt.Errorf("usage: payout status TRANID | payout confirm TRANID ID-TYPE ID-NUMBER LOCATION CURRENCY AMOUNT")
+	if len(args) < 2 {
+		return usage
+	}
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "status":
+		if len(args) != 1 {
+			return usage
+		}
+		var p model.Payout
+		if err := c.query("get_payout", args, &p); err != nil {
+			return err
+		}
+		row := []string{p.TranID, p.PayoutMember, p.Location, p.Currency, p.Amount, p.PaidAt, p.TxID}
+		return c.out.print(p, []string{"tranID", "payoutMember", "location", "currency", "amount", "paidAt", "txID"}, [][]string{row})
+
+	case "confirm":
+		if len(args) != 6 {
+			return usage
+		}
+		idHash, err := model.ReceiverIDHash(c.receiverIDKey, args[0], args[1], args[2])
+		if err != nil {
+			return err
+		}
+		return c.invoke("confirm_payout", []string{args[0], idHash, args[3], args[4], args[5]})
+	}
+	return usage
+}
+
+func (c *cli) member(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: member list|register|cap|limit|exposure")
+	}
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "list":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: member list")
+		}
+		var holder struct {
+			MemberIDs []string `json:"memberIDs"`
+		}
+		if err := c.query("get_members", nil, &holder); err != nil {
+			return err
+		}
+		members := make([]model.Exposure, 0, len(holder.MemberIDs))
+		rows := make([][]string, 0, len(holder.MemberIDs))
+		for _, id := range holder.MemberIDs {
+			// A member may only read its own exposure; the others are left out
+			var x model.Exposure
+			if err := c.query("get_exposure", []string{id}, &x); err != nil {
+				if strings.Contains(err.Error(), "Permission Denied") {
+					continue
+				}
+				return err
+			}
+			members = append(members, x)
+			rows = append(rows, []string{x.MemberID, x.NetDebitCap, x.NetPosition, x.NetDebitUnused})
+		}
+		return c.out.print(members, []string{"memberID", "netDebitCap", "netPosition", "netDebitUnused"}, rows)
+
+	case "register":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: member register MEMBERID NAME NETDEBITCAP")
+		}
+		return c.invoke("register_member", args)
+
+	case "cap":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: member cap MEMBERID NETDEBITCAP")
+		}
+		return c.invoke("set_net_debit_cap", args)
+
+	case "limit":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: member limit CREDITOR DEBTOR LIMIT")
+		}
+		return c.invoke("set_credit_limit", args)
+
+	case "exposure":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: member exposure MEMBERID")
+		}
+		var x model.Exposure
+		if err := c.query("get_exposure", args, &x); err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(x.Counterparties))
+		for _, cp := range x.Counterparties {
+			rows = append(rows, []string{cp.Counterparty, cp.LimitGranted, cp.LimitGiven, cp.NetOwed, cp.Available})
+		}
+		return c.out.print(x, []string{"counterparty", "limitGranted", "limitGiven", "netOwed", "available"}, rows)
+	}
+	return fmt.Errorf("unknown member command %q", cmd)
+}
+
+func transferRow(e model.TransactionEvent) []string {
+	status := e.Status
+	if status == "" {
+		status = model.StatusSent
+	}
+	payout := ""
+	if e.PayoutCurrency != "" {
+		payout = e.PayoutAmount + " " + e.PayoutCurrency
+	}
+	return []string{e.TranID, status, e.Amount, e.Fee, e.SendingMember, e.PayoutMember,
+		e.SenderName, e.SenderCountry, e.ReceiverName, e.ReceiverCountry, payout, e.BatchID, e.CreatedAt, e.ReferenceNumber}
+}
+
+func batchRow(b model.SettlementBatch) []string {
+	return []string{b.BatchID, b.Status, b.Currency, strconv.Itoa(len(b.TranIDs)), b.OpenedAt, b.ClosedAt, b.MerkleRoot}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// printer writes a command's result. JSON output is the chaincode's record as
+// returned; table and CSV output are the columns and rows the command picks
+// out of it.
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func (p printer) print(v interface{}, columns []string, rows [][]string) error {
+	switch p.format {
+	case formatJSON:
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case formatCSV:
+		out := csv.NewWriter(p.w)
+		out.Write(columns)
+		out.WriteAll(rows)
+		return out.Error()
+
+	case formatTable:
+		tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", p.format)
+}
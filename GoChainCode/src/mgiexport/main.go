@@ -0,0 +1,91 @@
+// Command mgiexport writes the remittance history on the ledger as a
+// tamper-evident log for auditors; see package auditlog. mgiverify checks a
+// log against the exporter's public key.
+//
+//	mgiexport -genkey export                                  write export.key and export.pub
+//	mgiexport -chaincode <name> -secure-context admin -key export.key -out extract.jsonl
+//
+// The secure context must be an admin, or an auditor of the member that
+// deployed the network; mgiexport refuses to write a log of only one
+// member's transfers. Give auditors the .pub file through a channel other than the
+// one the log travels by. A log is only complete once mgiexport has exited
+// without an error; one cut short does not verify.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"moneygram/auditlog"
+	"moneygram/fabric"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled admin or auditor user to query as")
+	keyFile := flag.String("key", "", "Ed25519 private key to sign checkpoints with")
+	out := flag.String("out", "", "file to write the log to; standard output when empty")
+	every := flag.Int("checkpoint-every", auditlog.DefaultCheckpointEvery, "entries between signed checkpoints")
+	genkey := flag.String("genkey", "", "write a new key pair to NAME.key and NAME.pub and exit")
+	flag.Parse()
+
+	if *genkey != "" {
+		private, public, err := auditlog.GenerateKey()
+		if err != nil {
+			fail("%v", err)
+		}
+		if err := ioutil.WriteFile(*genkey+".key", private, 0600); err != nil {
+			fail("%v", err)
+		}
+		if err := ioutil.WriteFile(*genkey+".pub", public, 0644); err != nil {
+			fail("%v", err)
+		}
+		return
+	}
+
+	if *keyFile == "" {
+		fail("-key is required; create one with -genkey")
+	}
+	key, err := auditlog.LoadPrivateKey(*keyFile)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	if *chaincode == "" {
+		fail("-chaincode is required")
+	}
+	src := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fail("%v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	lw, err := auditlog.NewWriter(w, key, *every, *chaincode)
+	if err != nil {
+		fail("%v", err)
+	}
+	count, err := auditlog.Export(src, lw)
+	if err != nil {
+		fail("%v", err)
+	}
+	if err := lw.Close(); err != nil {
+		fail("%v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "mgiexport: %d transfers in %d entries, head %s\n", count, lw.Entries(), lw.Head())
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiexport: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,89 @@
+// Command mgiextract writes an auditor's extract of the ledger: the
+// transfers the auditor may see, created in a date range, as CSV, JSON or
+// XLSX with the columns of the web application's audit page.
+//
+//	mgiextract -chaincode <name> -secure-context mgi_auditor -from 2016-11-01 -to 2016-11-30 -out nov.csv
+//	mgiextract -chaincode <name> -secure-context walmart_auditor -format xlsx -out walmart.xlsx
+//
+// The chaincode decides which transfers the secure context may see from its
+// enrolled role and member: an auditor sees their own member's, and an
+// auditor of the member that deployed the network, or an admin, every
+// member's. The manifest records the member the extract was limited to.
+//
+// The manifest, with the record count, the total amount and the SHA-256 of
+// the extract, is written to the -out file with .manifest.json appended, or
+// to standard error when the extract goes to standard output. An XLSX
+// extract also carries it on a Manifest sheet.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"moneygram/extract"
+	"moneygram/fabric"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user to query as")
+	member := flag.String("member", "", "only transfers sent or paid out by this member")
+	from := flag.String("from", "", "first day, YYYY-MM-DD")
+	to := flag.String("to", "", "last day, YYYY-MM-DD")
+	format := flag.String("format", extract.FormatCSV, "extract format: csv, json or xlsx")
+	out := flag.String("out", "", "file to write the extract to; standard output when empty")
+	flag.Parse()
+
+	if *chaincode == "" {
+		fail("-chaincode is required")
+	}
+	src := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out != "" {
+		var err error
+		if f, err = os.Create(*out); err != nil {
+			fail("%v", err)
+		}
+		w = f
+	}
+
+	opts := extract.Options{Member: *member, From: *from, To: *to, Format: *format}
+	m, err := extract.Write(src, w, opts)
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(*out)
+		}
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		fail("%v", err)
+	}
+	manifest = append(manifest, '\n')
+	if *out == "" {
+		os.Stderr.Write(manifest)
+		return
+	}
+	if err := ioutil.WriteFile(*out+".manifest.json", manifest, 0644); err != nil {
+		fail("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "mgiextract: %d records, %s %s, written to %s\n", m.Records, m.Amount, m.Currency, *out)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiextract: "+format+"\n", args...)
+	os.Exit(1)
+}
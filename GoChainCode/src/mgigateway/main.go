@@ -0,0 +1,123 @@
+// Command mgigateway serves the gateway JSON API for the web application.
+//
+//	mgigateway -listen :8080 -chaincode <name> -secure-context user_type1_0
+//	mgigateway -listen :8443 -chaincode <name> -tokens tokens.json -tls-cert gw.pem -tls-key gw.key
+//
+// The tokens file maps each enrolled user to the hex SHA-256 of the bearer
+// token issued to them, e.g. {"bbva_agent": "9f86d0..."}. With it every
+// request must send "Authorization: Bearer <token>" and the peer signs its
+// transaction as that user; without it every request runs as
+// -secure-context. Serve tokens over TLS. The -pickup-key file holds the
+// network's pickup key in hex; without it the gateway cannot create or
+// verify cash pickup transfers. The -receiver-id-key file holds the payout
+// member's key for receivers' ID documents, needed to confirm payouts. For
+// development, run the chaincode built
+// with the devpeer tag and point -url at it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"moneygram/fabric"
+	"moneygram/gateway"
+	"moneygram/model"
+)
+
+var tokenHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to serve the API on")
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user for requests without a token")
+	tokens := flag.String("tokens", "", "JSON file mapping enrolled users to the SHA-256 of their bearer token")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate to serve with")
+	tlsKey := flag.String("tls-key", "", "TLS private key to serve with")
+	pickupKey := flag.String("pickup-key", "", "file holding the network's pickup key in hex")
+	receiverIDKey := flag.String("receiver-id-key", "", "file holding the member's receiver ID key in hex")
+	flag.Parse()
+
+	if *chaincode == "" {
+		fail("-chaincode is required")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fail("-tls-cert and -tls-key go together")
+	}
+
+	srv := &gateway.Server{
+		Backend: &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure},
+		ForUser: func(user string) gateway.Backend {
+			return &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: user}
+		},
+	}
+
+	if *pickupKey != "" {
+		var err error
+		if srv.PickupKey, err = model.LoadKey(*pickupKey); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	if *receiverIDKey != "" {
+		var err error
+		if srv.ReceiverIDKey, err = model.LoadKey(*receiverIDKey); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	if *tokens != "" {
+		var err error
+		if srv.Tokens, err = loadTokens(*tokens); err != nil {
+			fail("%v", err)
+		}
+		if *tlsCert == "" {
+			log.Printf("mgigateway: warning: bearer tokens are served without TLS")
+		}
+	}
+
+	log.Printf("mgigateway: serving chaincode %s on %s", *chaincode, *listen)
+	if *tlsCert != "" {
+		log.Fatal(http.ListenAndServeTLS(*listen, *tlsCert, *tlsKey, srv))
+	}
+	log.Fatal(http.ListenAndServe(*listen, srv))
+}
+
+// loadTokens reads a tokens file and returns the gateway's map from token
+// hash to user.
+func loadTokens(path string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var byUser map[string]string
+	if err := json.Unmarshal(raw, &byUser); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	byHash := map[string]string{}
+	for user, hash := range byUser {
+		hash = strings.ToLower(hash)
+		if !tokenHashPattern.MatchString(hash) {
+			return nil, fmt.Errorf("%s: the token hash for %s is not a hex SHA-256", path, user)
+		}
+		if other, ok := byHash[hash]; ok {
+			return nil, fmt.Errorf("%s: %s and %s have the same token", path, other, user)
+		}
+		byHash[hash] = user
+	}
+	return byHash, nil
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgigateway: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,169 @@
+// Command mgiindex keeps a SQLite copy of the remittance history on the
+// ledger for analysts, and answers ad-hoc SQL over it.
+//
+//	mgiindex -db history.db -chaincode <name> -secure-context admin           sync every 30s
+//	mgiindex -db history.db -chaincode <name> -secure-context admin -once     sync and exit
+//	mgiindex -db history.db -chaincode <name> -listen :8081                   sync and serve queries
+//	mgiindex -db history.db -query "SELECT payout_member, SUM(amount_cents) FROM transfers GROUP BY 1"
+//
+// The secure context must be an admin, or only one member's transfers are
+// indexed. With -listen, GET /query?sql=...&limit=... returns the result as
+// JSON and GET /status the checkpoints; -query prints CSV. The tables are
+// transfers, status_changes, batches, obligations and checkpoints. Amounts
+// are held in cents.
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"moneygram/fabric"
+	"moneygram/indexer"
+)
+
+func main() {
+	path := flag.String("db", "mgi.db", "SQLite database file")
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled admin user to query as")
+	interval := flag.Duration("interval", 30*time.Second, "time between syncs")
+	once := flag.Bool("once", false, "sync once and exit")
+	reset := flag.Bool("reset", false, "replay the whole ledger, overwriting indexed rows")
+	query := flag.String("query", "", "run a SELECT against the database, print CSV and exit")
+	listen := flag.String("listen", "", "address to serve ad-hoc queries on")
+	flag.Parse()
+
+	db, err := sql.Open("sqlite3", *path)
+	if err != nil {
+		fail("%v", err)
+	}
+	defer db.Close()
+
+	if *query != "" {
+		printCSV(db, *query)
+		return
+	}
+
+	if *chaincode == "" {
+		fail("-chaincode is required to sync")
+	}
+
+	ix := &indexer.Indexer{
+		DB:     db,
+		Source: &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure},
+	}
+	if err := ix.Init(); err != nil {
+		fail("%v", err)
+	}
+	if *reset {
+		if err := ix.Reset(); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	if *once {
+		if err := sync(ix); err != nil {
+			fail("%v", err)
+		}
+		return
+	}
+
+	if *listen != "" {
+		// Queries go through a read-only connection to the same file
+		ro, err := sql.Open("sqlite3", "file:"+*path+"?mode=ro")
+		if err != nil {
+			fail("%v", err)
+		}
+		go serve(*listen, ro, ix)
+	}
+
+	for {
+		if err := sync(ix); err != nil {
+			log.Printf("mgiindex: %v", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func sync(ix *indexer.Indexer) error {
+	stats, err := ix.Sync()
+	if stats.Transfers > 0 || stats.Changes > 0 || stats.Batches > 0 {
+		log.Printf("mgiindex: indexed %d transfers, %d status changes and %d batches; %d transfers checkpointed", stats.Transfers, stats.Changes, stats.Batches, stats.Checkpoint)
+	}
+	return err
+}
+
+func serve(addr string, db *sql.DB, ix *indexer.Indexer) {
+	http.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			limit = 1000
+		}
+		res, err := indexer.Query(db, r.URL.Query().Get("sql"), limit)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := map[string]int{}
+		for _, name := range []string{indexer.CheckpointTransfers, indexer.CheckpointChanges, indexer.CheckpointBatches} {
+			position, err := ix.Checkpoint(name)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			status[name] = position
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+
+	log.Printf("mgiindex: serving queries on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+func printCSV(db *sql.DB, query string) {
+	res, err := indexer.Query(db, query, 0)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	out := csv.NewWriter(os.Stdout)
+	out.Write(res.Columns)
+	for _, row := range res.Rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			if v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		fail("%v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiindex: "+format+"\n", args...)
+	os.Exit(1)
+}
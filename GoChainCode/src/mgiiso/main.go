@@ -0,0 +1,237 @@
+// Command mgiiso queries the MoneyGram chaincode and prints ISO 20022 messages
+// for the settlement banks, and ingests pain.001 files from corporate customers.
+//
+//	mgiiso -chaincode <name> -msg pacs.008 -tran tr1,tr2
+//	mgiiso -chaincode <name> -msg pacs.008 -batch 3
+//	mgiiso -chaincode <name> -msg pacs.009 -batch 3 -agents agents.json
+//	mgiiso -chaincode <name> -ingest payroll.xml -member walmart -agents agents.json -report pain.002
+//
+// The agents file maps member IDs to {"bic": "...", "name": "..."}. Ingestion
+// prints a status line per entry as JSON, or a pain.002 status report. A peer
+// only returns a transaction ID for an invoke, so each submitted entry is
+// looked up once its transaction has had -confirm to commit; an entry that is
+// still not on the ledger then was rejected by the chaincode. With -confirm 0
+// submitted entries are reported PDNG.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"moneygram/fabric"
+	"moneygram/iso20022"
+	"moneygram/model"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user to query as")
+	msg := flag.String("msg", "pacs.008", "message type: pacs.008 or pacs.009")
+	trans := flag.String("tran", "", "comma separated tranIDs (pacs.008)")
+	batch := flag.String("batch", "", "settlement batch ID")
+	msgID := flag.String("msgid", "", "message ID (default derived from the batch or time)")
+	date := flag.String("date", "", "interbank settlement date YYYY-MM-DD (default today)")
+	agents := flag.String("agents", "", "JSON file mapping member IDs to BIC and name")
+	ingest := flag.String("ingest", "", "pain.001 file to submit through create_event")
+	member := flag.String("member", "", "sending member for ingested transfers")
+	report := flag.String("report", "json", "ingestion report format: json or pain.002")
+	wait := flag.Duration("confirm", time.Minute, "how long to wait for ingested transfers to commit; 0 reports them pending")
+	flag.Parse()
+
+	if *chaincode == "" {
+		fail("-chaincode is required")
+	}
+
+	client := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+
+	now := time.Now().UTC()
+	opts := iso20022.Options{MsgID: *msgID, CreatedAt: now}
+
+	if *date != "" {
+		d, err := time.Parse("2006-01-02", *date)
+		if err != nil {
+			fail("invalid -date: %v", err)
+		}
+		opts.SettlementDate = d
+	}
+
+	if *agents != "" {
+		var err error
+		if opts.Agents, err = loadAgents(*agents); err != nil {
+			fail("reading agents: %v", err)
+		}
+	}
+
+	if *ingest != "" {
+		ingestFile(client, *ingest, *member, *report, *wait, opts, now)
+		return
+	}
+
+	if opts.MsgID == "" {
+		opts.MsgID = "MGI" + now.Format("20060102150405")
+		if *batch != "" {
+			opts.MsgID = "MGI-B" + *batch + "-" + now.Format("20060102150405")
+		}
+	}
+
+	var doc interface{}
+	var err error
+
+	switch *msg {
+	case "pacs.008":
+		ids := splitList(*trans)
+		if *batch != "" {
+			b, err := getBatch(client, *batch)
+			if err != nil {
+				fail("%v", err)
+			}
+			ids = append(ids, b.TranIDs...)
+		}
+		if len(ids) == 0 {
+			fail("pacs.008 needs -tran or -batch")
+		}
+
+		var events []model.TransactionEvent
+		for _, id := range ids {
+			e, err := getEvent(client, id)
+			if err != nil {
+				fail("%v", err)
+			}
+			events = append(events, e)
+		}
+		doc, err = iso20022.NewPacs008(events, opts)
+
+	case "pacs.009":
+		if *batch == "" {
+			fail("pacs.009 needs -batch")
+		}
+		b, berr := getBatch(client, *batch)
+		if berr != nil {
+			fail("%v", berr)
+		}
+		doc, err = iso20022.NewPacs009(b, opts)
+
+	default:
+		fail("unknown message type %q", *msg)
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+
+	out, err := iso20022.Marshal(doc)
+	if err != nil {
+		fail("%v", err)
+	}
+	os.Stdout.Write(out)
+	fmt.Println()
+}
+
+func ingestFile(client *fabric.Client, path string, member string, report string, wait time.Duration, opts iso20022.Options, now time.Time) {
+	if member == "" {
+		fail("-ingest needs -member")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fail("%v", err)
+	}
+	defer f.Close()
+
+	doc, err := iso20022.ParsePain001(f)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	entries := doc.Entries(iso20022.IngestOptions{SendingMember: member, Agents: opts.Agents})
+	results := iso20022.Ingest(entries, client)
+	if wait > 0 {
+		iso20022.Confirm(entries, results, client, wait, 2*time.Second)
+	}
+
+	switch report {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range results {
+			enc.Encode(r)
+		}
+	case "pain.002":
+		msgID := opts.MsgID
+		if msgID == "" {
+			msgID = "MGI-STS" + now.Format("20060102150405")
+		}
+		out, err := iso20022.Marshal(iso20022.NewPain002(doc, results, msgID, now))
+		if err != nil {
+			fail("%v", err)
+		}
+		os.Stdout.Write(out)
+		fmt.Println()
+	default:
+		fail("unknown report format %q", report)
+	}
+}
+
+func getEvent(client *fabric.Client, tranID string) (model.TransactionEvent, error) {
+	var e model.TransactionEvent
+	out, err := client.Query("get_event_details", []string{tranID})
+	if err != nil {
+		return e, err
+	}
+	return e, json.Unmarshal(out, &e)
+}
+
+func getBatch(client *fabric.Client, batchID string) (model.SettlementBatch, error) {
+	var b model.SettlementBatch
+	out, err := client.Query("get_settlement_batch", []string{batchID})
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(out, &b); err != nil {
+		return b, err
+	}
+	if b.Member != "" {
+		return b, fmt.Errorf("only the transfers of %s in batch %s are visible to this identity", b.Member, b.BatchID)
+	}
+	return b, nil
+}
+
+func loadAgents(path string) (map[string]iso20022.Agent, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]struct {
+		BIC  string `json:"bic"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+
+	agents := make(map[string]iso20022.Agent, len(entries))
+	for id, e := range entries {
+		agents[id] = iso20022.Agent{BIC: e.BIC, Name: e.Name}
+	}
+	return agents, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiiso: "+format+"\n", args...)
+	os.Exit(1)
+}
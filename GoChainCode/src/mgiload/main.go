@@ -0,0 +1,130 @@
+// Command mgiload sends synthetic remittance traffic to the network and
+// reports throughput and latency.
+//
+//	mgiload -target gateway -gateway http://localhost:8080 -n 10000 -c 16
+//	mgiload -target fabric -chaincode <name> -secure-context admin -setup -n 10000 -rate 50
+//	mgiload -config traffic.json -print -n 20              print the transfers and exit
+//
+// The traffic follows the config file, a JSON loadgen.Config; fields it
+// leaves out keep their defaults, which -print-config shows. -results
+// writes each transfer's anomaly and outcome as JSON lines, to compare with
+// the alerts the chaincode raises. -setup registers the config's members
+// and credit limits first, and needs an admin. To measure the chaincode
+// itself without a peer, build it with the loadgen tag; see loadgen.go in
+// the chaincode.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"moneygram/fabric"
+	"moneygram/loadgen"
+)
+
+func main() {
+	target := flag.String("target", "gateway", "where to send transfers: gateway or fabric")
+	gatewayURL := flag.String("gateway", "http://localhost:8080", "gateway address")
+	token := flag.String("token", "", "bearer token of the enrolled user the gateway submits as")
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user to invoke as")
+	configFile := flag.String("config", "", "traffic config file (JSON)")
+	seed := flag.Int64("seed", 0, "random seed, overriding the config's")
+	prefix := flag.String("prefix", "", "tranID prefix, overriding the config's")
+	n := flag.Int("n", 1000, "transfers to send")
+	concurrency := flag.Int("c", 8, "transfers in flight")
+	rate := flag.Float64("rate", 0, "most transfers started per second; 0 for no limit")
+	setup := flag.Bool("setup", false, "register the members and credit limits first (fabric target)")
+	netDebitCap := flag.String("cap", "1000000000", "net debit cap for -setup")
+	limit := flag.String("limit", "1000000000", "credit limit for -setup")
+	results := flag.String("results", "", "file to write each transfer's outcome to")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	printOnly := flag.Bool("print", false, "print the transfers as JSON lines instead of sending them")
+	printConfig := flag.Bool("print-config", false, "print the config in use and exit")
+	flag.Parse()
+
+	cfg := loadgen.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadgen.LoadConfig(*configFile); err != nil {
+			fail("%v", err)
+		}
+	}
+	if *seed != 0 {
+		cfg.Seed = *seed
+	}
+	if *prefix != "" {
+		cfg.TranIDPrefix = *prefix
+	}
+
+	if *printConfig {
+		writeJSON(cfg)
+		return
+	}
+
+	g, err := loadgen.NewGenerator(cfg)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	if *printOnly {
+		enc := json.NewEncoder(os.Stdout)
+		for i := 0; i < *n; i++ {
+			enc.Encode(g.Next())
+		}
+		return
+	}
+
+	var t loadgen.Target
+	switch *target {
+	case "gateway":
+		t = loadgen.GatewayTarget{URL: *gatewayURL, Token: *token}
+	case "fabric":
+		if *chaincode == "" {
+			fail("-chaincode is required for the fabric target")
+		}
+		client := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+		if *setup {
+			if err := loadgen.Setup(client, cfg, *netDebitCap, *limit); err != nil {
+				fail("%v", err)
+			}
+		}
+		t = loadgen.ChaincodeTarget{Invoker: client}
+	default:
+		fail("unknown target %q", *target)
+	}
+
+	opts := loadgen.Options{Transfers: *n, Concurrency: *concurrency, Rate: *rate}
+	if *results != "" {
+		f, err := os.Create(*results)
+		if err != nil {
+			fail("%v", err)
+		}
+		defer f.Close()
+		opts.Results = f
+	}
+
+	report, err := loadgen.Run(g, t, opts)
+	if err != nil {
+		fail("%v", err)
+	}
+	if *asJSON {
+		writeJSON(report)
+	} else {
+		report.Print(os.Stdout)
+	}
+}
+
+func writeJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiload: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,131 @@
+// Command mgimt converts between SWIFT MT103 messages and the MoneyGram
+// chaincode's transaction events.
+//
+//	mgimt -chaincode <name> -banks banks.json -tran tr1      print an MT103 for tr1
+//	mgimt -banks banks.json -parse msg.fin                   print the event as JSON
+//	mgimt -chaincode <name> -banks banks.json -parse msg.fin -submit
+//
+// The banks file maps member IDs to {"bic": "..."}, the same layout mgiiso uses
+// for its agents file.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"moneygram/fabric"
+	"moneygram/model"
+	"moneygram/mt103"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:7050", "peer REST address")
+	chaincode := flag.String("chaincode", "", "deployed chaincode name")
+	secure := flag.String("secure-context", "", "enrolled user to invoke as")
+	banksFile := flag.String("banks", "", "JSON file mapping member IDs to BICs")
+	tran := flag.String("tran", "", "tranID to export as MT103")
+	date := flag.String("date", "", "value date YYYY-MM-DD (default today)")
+	parse := flag.String("parse", "", "MT103 file to read")
+	submit := flag.Bool("submit", false, "submit the parsed MT103 through create_event")
+	flag.Parse()
+
+	if *banksFile == "" {
+		fail("-banks is required")
+	}
+	banks, err := loadBanks(*banksFile)
+	if err != nil {
+		fail("reading banks: %v", err)
+	}
+	opts := mt103.Options{Banks: banks}
+
+	if *date != "" {
+		if opts.ValueDate, err = time.Parse("2006-01-02", *date); err != nil {
+			fail("invalid -date: %v", err)
+		}
+	}
+
+	client := &fabric.Client{URL: *url, ChaincodeID: *chaincode, SecureContext: *secure}
+
+	switch {
+	case *tran != "":
+		if *chaincode == "" {
+			fail("-tran needs -chaincode")
+		}
+		out, err := client.Query("get_event_details", []string{*tran})
+		if err != nil {
+			fail("%v", err)
+		}
+		var e model.TransactionEvent
+		if err := json.Unmarshal(out, &e); err != nil {
+			fail("%v", err)
+		}
+		m, err := mt103.FromEvent(e, opts)
+		if err != nil {
+			fail("%v", err)
+		}
+		msg, err := m.Format()
+		if err != nil {
+			fail("%v", err)
+		}
+		fmt.Println(msg)
+
+	case *parse != "":
+		f, err := os.Open(*parse)
+		if err != nil {
+			fail("%v", err)
+		}
+		m, err := mt103.Read(f)
+		f.Close()
+		if err != nil {
+			fail("%v", err)
+		}
+		e, err := m.Event(opts)
+		if err != nil {
+			fail("%v", err)
+		}
+
+		if *submit {
+			if *chaincode == "" {
+				fail("-submit needs -chaincode")
+			}
+			txID, err := client.Invoke("create_event", e.CreateArgs())
+			if err != nil {
+				fail("%v", err)
+			}
+			fmt.Fprintln(os.Stderr, "submitted", e.TranID, "as transaction", txID)
+		}
+		json.NewEncoder(os.Stdout).Encode(e)
+
+	default:
+		fail("one of -tran or -parse is required")
+	}
+}
+
+func loadBanks(path string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]struct {
+		BIC string `json:"bic"`
+	}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+
+	banks := make(map[string]string, len(entries))
+	for id, e := range entries {
+		banks[id] = e.BIC
+	}
+	return banks, nil
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgimt: "+format+"\n", args...)
+	os.Exit(1)
+}
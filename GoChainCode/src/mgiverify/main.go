@@ -0,0 +1,77 @@
+// Command mgiverify checks that a log written by mgiexport has not been
+// edited since it was exported: that its hash chain is intact, that it is
+// complete, and that its checkpoints are signed by the exporter's key.
+//
+//	mgiverify -pub export.pub extract.jsonl
+//	mgiverify -pub export.pub -json < extract.jsonl
+//
+// It exits with status 1 and names the first line that fails. The public key
+// must come from the exporter, not from whoever sent the log.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"moneygram/auditlog"
+)
+
+func main() {
+	pubFile := flag.String("pub", "", "exporter's Ed25519 public key")
+	asJSON := flag.Bool("json", false, "print the summary as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mgiverify -pub KEY [-json] [LOG]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *pubFile == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	pub, err := auditlog.LoadPublicKey(*pubFile)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	var r io.Reader = os.Stdin
+	name := "standard input"
+	if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fail("%v", err)
+		}
+		defer f.Close()
+		r, name = f, flag.Arg(0)
+	}
+
+	s, err := auditlog.Verify(r, pub)
+	if err != nil {
+		fail("%s: %v", name, err)
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(s, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Printf("%s: verified\n", name)
+	fmt.Printf("  exported %s from %s, signed %s\n", s.ExportedAt, orUnknown(s.Chaincode), s.SignedAt)
+	fmt.Printf("  %d transfers, %d status changes, %d checkpoints, %d entries\n", s.Transfers, s.StatusChanges, s.Checkpoints, s.Entries)
+	fmt.Printf("  head %s\n", s.Head)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "an unnamed chaincode"
+	}
+	return s
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mgiverify: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,174 @@
+// Package auditlog writes the ledger's remittance history as a tamper-evident
+// log for auditors, and verifies such logs. Every transfer and every status
+// change it went through becomes one entry, and each entry's hash covers the
+// hash of the entry before it, so editing, inserting or removing an entry
+// breaks every hash after it. Signed checkpoints every so many entries, and
+// at the end, tie the chain to the exporter's key: an extract that verifies
+// against the exporter's public key is the one that left the ledger.
+//
+// A log is JSON Lines, one Entry per line. The first entry is a header and
+// the last a final checkpoint. An entry's hash is SHA-256 over its seq,
+// kind, prevHash and record, each written as a 4-byte big-endian length
+// followed by its bytes; the record is hashed exactly as it appears in the
+// file, so a log must not be reformatted. The prevHash of the header is
+// GenesisHash.
+package auditlog
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// Format names the version of the log format in the header.
+const Format = "mgi-auditlog/1"
+
+// GenesisHash is the prevHash of the first entry of a log.
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Entry kinds.
+const (
+	KindHeader       = "header"
+	KindEvent        = "event"
+	KindStatusChange = "statusChange"
+	KindCheckpoint   = "checkpoint"
+)
+
+// Entry is one line of a log. Record holds a Header, a TransactionEvent, a
+// StatusChange or a Checkpoint, according to Kind.
+type Entry struct {
+	Seq      int             `json:"seq"`
+	Kind     string          `json:"kind"`
+	PrevHash string          `json:"prevHash"`
+	Record   json.RawMessage `json:"record"`
+	Hash     string          `json:"hash"`
+}
+
+// Header opens a log.
+type Header struct {
+	Format     string `json:"format"`
+	Chaincode  string `json:"chaincode,omitempty"`
+	ExportedAt string `json:"exportedAt"`
+	KeyID      string `json:"keyID"`
+}
+
+// StatusChange is a status a transfer reached. Entries for a transfer's
+// status changes follow its event entry, whose statusHistory is left out.
+type StatusChange struct {
+	TranID string `json:"tranID"`
+	N      int    `json:"n"`
+	Status string `json:"status"`
+	At     string `json:"at"`
+	TxID   string `json:"txID"`
+}
+
+// Checkpoint signs the hash of the entry before it, and so every entry up
+// to it. Only the last checkpoint of a log is final; a log that does not end
+// with one has been cut short.
+type Checkpoint struct {
+	Entries   int    `json:"entries"`
+	Head      string `json:"head"`
+	Final     bool   `json:"final,omitempty"`
+	SignedAt  string `json:"signedAt"`
+	KeyID     string `json:"keyID"`
+	Signature string `json:"signature"`
+}
+
+// EntryHash returns the hash of an entry from its other fields.
+func EntryHash(seq int, kind string, prevHash string, record []byte) string {
+	h := sha256.New()
+	for _, f := range [][]byte{[]byte(strconv.Itoa(seq)), []byte(kind), []byte(prevHash), record} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(f)))
+		h.Write(size[:])
+		h.Write(f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signedMessage returns the bytes a checkpoint's signature is over.
+func signedMessage(seq int, c Checkpoint) []byte {
+	return []byte(Format + "\n" + strconv.Itoa(seq) + "\n" + strconv.Itoa(c.Entries) + "\n" + c.Head + "\n" +
+		strconv.FormatBool(c.Final) + "\n" + c.SignedAt)
+}
+
+// KeyID identifies a public key in headers and checkpoints: the hex SHA-256
+// of the key.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns a new signing key and its public key, both PEM
+// encoded. The private key is PKCS #8 and the public key PKIX.
+func GenerateKey() (private []byte, public []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), nil
+}
+
+// LoadPrivateKey reads a PEM encoded Ed25519 private key written by
+// GenerateKey.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": not an Ed25519 key")
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key written by
+// GenerateKey.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New(path + ": not an Ed25519 key")
+	}
+	return pub, nil
+}
+
+func readPEM(path string, kind string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != kind {
+		return nil, errors.New(path + ": no " + kind + " PEM block")
+	}
+	return block, nil
+}
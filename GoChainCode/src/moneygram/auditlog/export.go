@@ -0,0 +1,49 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"moneygram/model"
+)
+
+// Source runs chaincode queries. fabric.Client satisfies it. Export must
+// query as an admin, or as the auditor of the member that deployed the
+// network, to see every member's transfers.
+type Source interface {
+	Query(function string, args []string) ([]byte, error)
+}
+
+const exportPageSize = 200
+
+// Export appends every transfer on the ledger, in tranIDs order, to lw and
+// returns how many there were. It does not close lw.
+func Export(src Source, lw *Writer) (int, error) {
+	count := 0
+	for position := 0; ; {
+		out, err := src.Query("scan_events", []string{strconv.Itoa(position), strconv.Itoa(exportPageSize)})
+		if err != nil {
+			return count, err
+		}
+		var scan model.EventScan
+		if err := json.Unmarshal(out, &scan); err != nil {
+			return count, errors.New("scan_events: " + err.Error())
+		}
+		if scan.Member != "" {
+			return count, errors.New("scan_events: only the transfers of " + scan.Member + " are visible to this identity")
+		}
+
+		for _, e := range scan.Events {
+			if err := lw.WriteEvent(e); err != nil {
+				return count, err
+			}
+		}
+		count += len(scan.Events)
+
+		position = scan.Next
+		if !scan.More {
+			return count, nil
+		}
+	}
+}
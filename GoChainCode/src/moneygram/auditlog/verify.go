@@ -0,0 +1,159 @@
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Summary describes a log that verified.
+type Summary struct {
+	Chaincode     string `json:"chaincode,omitempty"`
+	ExportedAt    string `json:"exportedAt"`
+	SignedAt      string `json:"signedAt"`
+	KeyID         string `json:"keyID"`
+	Entries       int    `json:"entries"`
+	Transfers     int    `json:"transfers"`
+	StatusChanges int    `json:"statusChanges"`
+	Checkpoints   int    `json:"checkpoints"`
+	Head          string `json:"head"`
+}
+
+// Verify reads a log from r and checks its hash chain, that it starts with a
+// header and ends with a final checkpoint, and that every checkpoint is
+// signed by pub. The public key must come from the exporter, not from the
+// log. The first problem found is returned with its line number.
+func Verify(r io.Reader, pub ed25519.PublicKey) (Summary, error) {
+	s := Summary{KeyID: KeyID(pub)}
+	head := GenesisHash
+	final := false
+	tranID, changes := "", 0
+
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return s, err
+		}
+
+		fail := func(format string, args ...interface{}) (Summary, error) {
+			return s, fmt.Errorf("line %d: "+format, append([]interface{}{line}, args...)...)
+		}
+
+		if final {
+			return fail("entry after the final checkpoint")
+		}
+
+		var e Entry
+		if err := decodeStrict(data, &e); err != nil {
+			return fail("%v", err)
+		}
+		if e.Seq != s.Entries {
+			return fail("entry %d where %d was expected", e.Seq, s.Entries)
+		}
+		if e.PrevHash != head {
+			return fail("prevHash does not match the hash of the entry before")
+		}
+		if EntryHash(e.Seq, e.Kind, e.PrevHash, e.Record) != e.Hash {
+			return fail("hash does not match the entry")
+		}
+
+		if e.Seq == 0 && e.Kind != KindHeader {
+			return fail("the log does not start with a header")
+		}
+
+		switch e.Kind {
+		case KindHeader:
+			if e.Seq != 0 {
+				return fail("header in the middle of the log")
+			}
+			var h Header
+			if err := decodeStrict(e.Record, &h); err != nil {
+				return fail("header: %v", err)
+			}
+			if h.Format != Format {
+				return fail("unsupported format %q", h.Format)
+			}
+			if h.KeyID != s.KeyID {
+				return fail("the log was exported with key %s, not the key given", h.KeyID)
+			}
+			s.Chaincode, s.ExportedAt = h.Chaincode, h.ExportedAt
+
+		case KindEvent:
+			var ev struct {
+				TranID string `json:"tranID"`
+			}
+			if err := json.Unmarshal(e.Record, &ev); err != nil || ev.TranID == "" {
+				return fail("event without a tranID")
+			}
+			tranID, changes = ev.TranID, 0
+			s.Transfers++
+
+		case KindStatusChange:
+			var c StatusChange
+			if err := decodeStrict(e.Record, &c); err != nil {
+				return fail("status change: %v", err)
+			}
+			if c.TranID != tranID || c.N != changes {
+				return fail("status change %d of %s is out of place", c.N, c.TranID)
+			}
+			changes++
+			s.StatusChanges++
+
+		case KindCheckpoint:
+			var c Checkpoint
+			if err := decodeStrict(e.Record, &c); err != nil {
+				return fail("checkpoint: %v", err)
+			}
+			if c.Entries != e.Seq || c.Head != e.PrevHash {
+				return fail("checkpoint does not cover the entries before it")
+			}
+			if c.KeyID != s.KeyID {
+				return fail("checkpoint signed with key %s, not the key given", c.KeyID)
+			}
+			sig, err := base64.StdEncoding.DecodeString(c.Signature)
+			if err != nil || !ed25519.Verify(pub, signedMessage(e.Seq, c), sig) {
+				return fail("bad checkpoint signature")
+			}
+			final = c.Final
+			s.SignedAt = c.SignedAt
+			s.Checkpoints++
+
+		default:
+			return fail("unknown entry kind %q", e.Kind)
+		}
+
+		head = e.Hash
+		s.Entries++
+	}
+
+	if s.Entries == 0 {
+		return s, errors.New("the log is empty")
+	}
+	if !final {
+		return s, fmt.Errorf("the log ends after entry %d without a final checkpoint; it has been cut short", s.Entries-1)
+	}
+	s.Head = head
+	return s, nil
+}
+
+// decodeStrict decodes one JSON value that has no fields v does not.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("trailing data")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package auditlog
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"moneygram/model"
+)
+
+// DefaultCheckpointEvery is the number of entries between checkpoints when
+// a Writer does not set one.
+const DefaultCheckpointEvery = 1000
+
+// Writer appends entries to a log, checkpointing as it goes. Call Close to
+// write the final checkpoint; a log without it does not verify.
+type Writer struct {
+	w       *bufio.Writer
+	key     ed25519.PrivateKey
+	keyID   string
+	every   int
+	seq     int
+	head    string
+	pending int
+	closed  bool
+}
+
+// NewWriter writes the header of a log signed with key to w. A checkpoint is
+// written after every checkpointEvery entries, or DefaultCheckpointEvery
+// when it is zero or less. chaincode is recorded in the header.
+func NewWriter(w io.Writer, key ed25519.PrivateKey, checkpointEvery int, chaincode string) (*Writer, error) {
+	if checkpointEvery <= 0 {
+		checkpointEvery = DefaultCheckpointEvery
+	}
+	lw := &Writer{
+		w:     bufio.NewWriter(w),
+		key:   key,
+		keyID: KeyID(key.Public().(ed25519.PublicKey)),
+		every: checkpointEvery,
+		head:  GenesisHash,
+	}
+
+	header := Header{Format: Format, Chaincode: chaincode, ExportedAt: lw.timestamp(), KeyID: lw.keyID}
+	if err := lw.append(KindHeader, header); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+// WriteEvent appends a transfer followed by a status change entry for each
+// step of its status history.
+func (lw *Writer) WriteEvent(e model.TransactionEvent) error {
+	history := e.StatusHistory
+	e.StatusHistory = nil
+
+	if err := lw.Append(KindEvent, e); err != nil {
+		return err
+	}
+	for i, s := range history {
+		change := StatusChange{TranID: e.TranID, N: i, Status: s.Status, At: s.At, TxID: s.TxID}
+		if err := lw.Append(KindStatusChange, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append adds an entry holding record, then a checkpoint if one is due.
+func (lw *Writer) Append(kind string, record interface{}) error {
+	if lw.closed {
+		return errors.New("auditlog: append to a closed log")
+	}
+	if err := lw.append(kind, record); err != nil {
+		return err
+	}
+	lw.pending++
+	if lw.pending >= lw.every {
+		return lw.checkpoint(false)
+	}
+	return nil
+}
+
+// Close writes the final checkpoint and flushes the log. It does not close
+// the underlying writer.
+func (lw *Writer) Close() error {
+	if lw.closed {
+		return nil
+	}
+	if err := lw.checkpoint(true); err != nil {
+		return err
+	}
+	lw.closed = true
+	return lw.w.Flush()
+}
+
+// Entries returns the number of entries written so far.
+func (lw *Writer) Entries() int {
+	return lw.seq
+}
+
+// Head returns the hash of the last entry written.
+func (lw *Writer) Head() string {
+	return lw.head
+}
+
+func (lw *Writer) checkpoint(final bool) error {
+	c := Checkpoint{Entries: lw.seq, Head: lw.head, Final: final, SignedAt: lw.timestamp(), KeyID: lw.keyID}
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(lw.key, signedMessage(lw.seq, c)))
+
+	lw.pending = 0
+	return lw.append(KindCheckpoint, c)
+}
+
+func (lw *Writer) append(kind string, record interface{}) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("auditlog: entry " + strconv.Itoa(lw.seq) + ": " + err.Error())
+	}
+
+	entry := Entry{Seq: lw.seq, Kind: kind, PrevHash: lw.head, Record: raw}
+	entry.Hash = EntryHash(entry.Seq, kind, entry.PrevHash, raw)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := lw.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	lw.seq++
+	lw.head = entry.Hash
+	return nil
+}
+
+func (lw *Writer) timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
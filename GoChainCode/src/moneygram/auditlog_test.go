@@ -0,0 +1,121 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ledgersim"
+	"moneygram/auditlog"
+	"moneygram/model"
+)
+
+//==============================================================================================================================
+//	ledger_source - Runs the queries of the auditlog and extract packages against a ledgersim ledger.
+//==============================================================================================================================
+type ledger_source struct {
+	ledger *ledgersim.Ledger
+}
+
+func (s ledger_source) Query(function string, args []string) ([]byte, error) {
+	return s.ledger.Query(function, args...)
+}
+
+func TestAuditLog(t *testing.T) {
+
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	pub := key.Public().(ed25519.PublicKey)
+
+	tests := []struct {
+		name      string
+		transfers int
+		paid      int
+		every     int
+	}{
+		{"empty ledger", 0, 0, 0},
+		{"one checkpoint", 3, 1, 0},
+		{"several checkpoints", 7, 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			for i := 0; i < tt.transfers; i++ {
+				l.invoke("create_event", transfer(fmt.Sprint("t", i), "10").CreateArgs()...)
+			}
+
+			idHash, err := model.ReceiverIDHash(test_pickup_key, "t0", "passport", "G123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.SetCaller(bancomer_caller)
+			for i := 0; i < tt.paid; i++ {
+				l.invoke("confirm_payout", fmt.Sprint("t", i), idHash, "Puebla", "USD", "10")
+			}
+
+			// Only the whole ledger is exported
+			var partial bytes.Buffer
+			lw, err := auditlog.NewWriter(&partial, key, tt.every, "mgi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := auditlog.Export(ledger_source{l.Ledger}, lw); err == nil {
+				t.Fatal("a member exported the log")
+			}
+
+			l.SetCaller(admin_caller)
+
+			var log bytes.Buffer
+			lw, err = auditlog.NewWriter(&log, key, tt.every, "mgi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, err := auditlog.Export(ledger_source{l.Ledger}, lw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := lw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.transfers {
+				t.Fatalf("exported %v transfers, want %v", count, tt.transfers)
+			}
+
+			sum, err := auditlog.Verify(bytes.NewReader(log.Bytes()), pub)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, log.Bytes())
+			}
+			if sum.Transfers != tt.transfers || sum.StatusChanges < tt.paid || sum.Head != lw.Head() {
+				t.Fatalf("summary %+v", sum)
+			}
+
+			other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize))
+			if _, err := auditlog.Verify(bytes.NewReader(log.Bytes()), other.Public().(ed25519.PublicKey)); err == nil {
+				t.Fatal("the log verified with another key")
+			}
+
+			lines := strings.SplitAfter(log.String(), "\n")
+
+			cut := strings.Join(lines[:len(lines)-2], "")
+			if _, err := auditlog.Verify(strings.NewReader(cut), pub); err == nil {
+				t.Fatal("a log without its final checkpoint verified")
+			}
+
+			if tt.transfers > 0 {
+				changed := strings.Replace(log.String(), `"amount":"10"`, `"amount":"11"`, 1)
+				if changed == log.String() {
+					t.Fatal("no amount to change in the log")
+				}
+				if _, err := auditlog.Verify(strings.NewReader(changed), pub); err == nil {
+					t.Fatal("a changed transfer verified")
+				}
+			}
+		})
+	}
+}
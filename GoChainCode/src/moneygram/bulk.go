@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"router"
+)
+
+//==============================================================================================================================
+//	 BULK_MAX_EVENTS - The most transfers one create_events invoke may carry.
+//==============================================================================================================================
+const BULK_MAX_EVENTS = 5000
+
+//==============================================================================================================================
+//	 BULK_MAX_ERRORS - The most rejected transfers the error of a rejected create_events batch names.
+//==============================================================================================================================
+const BULK_MAX_ERRORS = 20
+
+//==============================================================================================================================
+//	 BULK_CREATED - The status of every transfer of an applied batch. When any transfer is rejected nothing is written and
+//					create_events fails, naming the rejected transfers.
+//==============================================================================================================================
+const BULK_CREATED = "created"
+
+//==============================================================================================================================
+//	BulkResult - The result of create_events, with one entry per transfer in the order they were given.
+//==============================================================================================================================
+type BulkResult struct {
+	Applied  bool             `json:"applied"`
+	Count    int              `json:"count"`
+	Rejected int              `json:"rejected"`
+	Results  []BulkItemResult `json:"results"`
+}
+
+//==============================================================================================================================
+//	BulkItemResult - What happened to one transfer of a create_events batch.
+//==============================================================================================================================
+type BulkItemResult struct {
+	Index   int    `json:"index"`
+	TranID  string `json:"tranID"`
+	Status  string `json:"status"`
+	BatchID string `json:"batchID,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+//==============================================================================================================================
+//	BulkTransfer - One transfer of a create_events batch: the create_event fields of a TransactionEvent and the optional
+//				   keyed hash of a pickup code, which is not stored on the event.
+//==============================================================================================================================
+type BulkTransfer struct {
+	TransactionEvent
+	PickupCodeHash string `json:"pickupCodeHash,omitempty"`
+}
+
+//==============================================================================================================================
+//	overlay_stub - A stub that keeps every write in memory on top of another stub until flush is called. Reads see the
+//				   overlay's own writes first, which the Fabric 1.x shim does not do within a transaction, so transfers
+//				   later in a batch are checked against the limits, batch and indexes left by the ones before them.
+//				   Every other call goes to the underlying stub.
+//==============================================================================================================================
+type overlay_stub struct {
+	shim.ChaincodeStubInterface
+	writes  map[string][]byte
+	deleted map[string]bool
+	keys    []string
+}
+
+//==============================================================================================================================
+//	 new_overlay - Returns an empty overlay on stub.
+//==============================================================================================================================
+func new_overlay(stub shim.ChaincodeStubInterface) *overlay_stub {
+	return &overlay_stub{ChaincodeStubInterface: stub, writes: map[string][]byte{}, deleted: map[string]bool{}}
+}
+
+//==============================================================================================================================
+//	 GetState - Returns the overlay's value of key, or the underlying stub's if the overlay has not written it.
+//==============================================================================================================================
+func (o *overlay_stub) GetState(key string) ([]byte, error) {
+
+	if o.deleted[key] {
+		return nil, nil
+	}
+
+	if value, ok := o.writes[key]; ok {
+		return value, nil
+	}
+
+	return o.ChaincodeStubInterface.GetState(key)
+}
+
+//==============================================================================================================================
+//	 PutState - Records a write in the overlay.
+//==============================================================================================================================
+func (o *overlay_stub) PutState(key string, value []byte) error {
+
+	o.touch(key)
+	delete(o.deleted, key)
+	o.writes[key] = value
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 DelState - Records a delete in the overlay.
+//==============================================================================================================================
+func (o *overlay_stub) DelState(key string) error {
+
+	o.touch(key)
+	delete(o.writes, key)
+	o.deleted[key] = true
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 touch - Remembers the order keys were first written in, so flush writes them in the same order on every peer.
+//==============================================================================================================================
+func (o *overlay_stub) touch(key string) {
+
+	if _, ok := o.writes[key]; ok {
+		return
+	}
+	if o.deleted[key] {
+		return
+	}
+
+	o.keys = append(o.keys, key)
+}
+
+//==============================================================================================================================
+//	 flush - Writes the final value of every key the overlay changed to the underlying stub, once per key.
+//==============================================================================================================================
+func (o *overlay_stub) flush() error {
+
+	for _, key := range o.keys {
+
+		var err error
+
+		if o.deleted[key] {
+			err = o.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = o.ChaincodeStubInterface.PutState(key, o.writes[key])
+		}
+
+		if err != nil {
+			return errors.New("Error storing " + key)
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	event_index - The updates new transfers make to the records that list or count every transfer: the tranIDs index, the
+//				  open settlement batch and the statistics aggregates. add_event collects them here so a batch of
+//				  transfers rewrites each of these records once, in save_event_index, rather than once per transfer.
+//				  aggregate_keys keeps the order aggregates were first changed in, so every peer writes them alike.
+//==============================================================================================================================
+type event_index struct {
+	batchID        string
+	tranIDs        []string
+	aggregates     map[string]Aggregate
+	aggregate_keys []string
+}
+
+//==============================================================================================================================
+//	 new_event_index - Returns an empty index update.
+//==============================================================================================================================
+func new_event_index() *event_index {
+	return &event_index{aggregates: map[string]Aggregate{}}
+}
+
+//==============================================================================================================================
+//	 open_batch_id - Returns the ID of the batch new transfers settle in, reading it from the ledger the first time.
+//==============================================================================================================================
+func (idx *event_index) open_batch_id(t *SimpleChaincode, stub shim.ChaincodeStubInterface) (string, error) {
+
+	if idx.batchID != "" {
+		return idx.batchID, nil
+	}
+
+	b, err := t.retrieve_open_batch(stub)
+	if err != nil {
+		return "", err
+	}
+
+	idx.batchID = b.BatchID
+
+	return idx.batchID, nil
+}
+
+//==============================================================================================================================
+//	 aggregate - Returns an aggregate as idx has changed it, or as it is on the ledger. A nil idx reads the ledger.
+//==============================================================================================================================
+func (idx *event_index) aggregate(t *SimpleChaincode, stub shim.ChaincodeStubInterface, dimension string, key string, period string) (Aggregate, error) {
+
+	if idx != nil {
+		if a, ok := idx.aggregates[aggregate_key(dimension, key, period)]; ok {
+			return a, nil
+		}
+	}
+
+	return t.retrieve_aggregate(stub, dimension, key, period)
+}
+
+//==============================================================================================================================
+//	 put_aggregate - Keeps a changed aggregate until idx is saved. A nil idx writes it straight away.
+//==============================================================================================================================
+func (idx *event_index) put_aggregate(t *SimpleChaincode, stub shim.ChaincodeStubInterface, a Aggregate) error {
+
+	if idx == nil {
+		return t.save_aggregate(stub, a)
+	}
+
+	key := aggregate_key(a.Dimension, a.Key, a.Period)
+	if _, ok := idx.aggregates[key]; !ok {
+		idx.aggregate_keys = append(idx.aggregate_keys, key)
+	}
+	idx.aggregates[key] = a
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 save_event_index - Writes the updates collected in idx: the new tranIDs are appended to the tranIDs index and the
+//						open batch, and every changed aggregate is saved.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_event_index(stub shim.ChaincodeStubInterface, idx *event_index) error {
+
+	if len(idx.tranIDs) > 0 {
+
+		tranHld, err := t.retrieve_tran_ids(stub)
+		if err != nil {
+			return err
+		}
+
+		tranHld.TranIDs = append(tranHld.TranIDs, idx.tranIDs...)
+
+		err = t.save_tran_ids(stub, tranHld)
+		if err != nil {
+			return err
+		}
+
+		err = t.add_to_open_batch(stub, idx.tranIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range idx.aggregate_keys {
+		err := t.save_aggregate(stub, idx.aggregates[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 bulk_args - Converts a transfer of a create_events batch into the arguments of create_event.
+//==============================================================================================================================
+func bulk_args(e BulkTransfer) ([]string, error) {
+
+	args := []string{e.TranID, e.SenderName, e.SenderCountry, e.ReceiverName, e.ReceiverCountry, e.Amount, e.SendingMember, e.PayoutMember}
+
+	fee := e.Fee
+	if fee == "" {
+		fee = "0"
+	}
+
+	if (e.PayoutCurrency == "") != (e.PayoutAmount == "") {
+		return nil, errors.New("A payoutCurrency must be given with a payoutAmount")
+	}
+
+	if e.AgentLocation != "" {
+		return append(args, fee, e.PayoutCurrency, e.PayoutAmount, e.PickupCodeHash, e.AgentLocation), nil
+	}
+
+	if e.PickupCodeHash != "" {
+		return append(args, fee, e.PayoutCurrency, e.PayoutAmount, e.PickupCodeHash), nil
+	}
+
+	if e.PayoutCurrency != "" {
+		return append(args, fee, e.PayoutCurrency, e.PayoutAmount), nil
+	}
+
+	return append(args, fee), nil
+}
+
+//=================================================================================================================================
+//	 create_events - Creates a batch of transfers, all or none. Each transfer gets the create_event route's argument and
+//					 permission checks and is then added exactly as if it had been sent on its own, in order, against
+//					 the state the transfers before it leave behind. The tranIDs index, the open settlement batch and the
+//					 statistics are updated once for the whole batch. If any transfer is rejected nothing is written and
+//					 the error names the rejected transfers; otherwise returns a BulkResult. A Fabric v0.6 peer does not
+//					 pass invoke results back, so check get_event_details there.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_events(stub shim.ChaincodeStubInterface, transfers string) ([]byte, error) {
+
+	var events []BulkTransfer
+
+	err := json.Unmarshal([]byte(transfers), &events)
+	if err != nil {
+		return nil, errors.New("Invalid transfers. Expecting a JSON array of transfers: " + err.Error())
+	}
+
+	if len(events) == 0 {
+		return nil, errors.New("No transfers given")
+	}
+
+	if len(events) > BULK_MAX_EVENTS {
+		return nil, errors.New(fmt.Sprintf("Too many transfers. At most %v may be created at once", BULK_MAX_EVENTS))
+	}
+
+	routes := t.routes()
+	batch := new_overlay(stub)
+	idx := new_event_index()
+	result := BulkResult{Applied: true, Count: len(events), Results: []BulkItemResult{}}
+	rejections := []string{}
+
+	for i, e := range events {
+
+		// Each transfer writes to its own overlay, which is only kept if it is accepted. Once one is rejected the
+		// batch is not written, so what the rejected transfer left in idx does not matter.
+		single := new_overlay(batch)
+
+		var created TransactionEvent
+
+		args, err := bulk_args(e)
+		if err == nil {
+			_, err = routes.Check(single, router.Invoke, "create_event", args)
+		}
+		if err == nil {
+			created, err = t.add_event(single, args, idx)
+		}
+		if err == nil {
+			err = single.flush()
+		}
+
+		if err != nil {
+			result.Applied = false
+			result.Rejected++
+
+			if len(rejections) < BULK_MAX_ERRORS {
+				rejections = append(rejections, fmt.Sprintf("#%v %v: %v", i, e.TranID, err))
+			}
+			continue
+		}
+
+		result.Results = append(result.Results, BulkItemResult{Index: i, TranID: e.TranID, Status: BULK_CREATED, BatchID: created.BatchID})
+	}
+
+	if !result.Applied {
+		if result.Rejected > len(rejections) {
+			rejections = append(rejections, fmt.Sprintf("and %v more", result.Rejected-len(rejections)))
+		}
+		return nil, errors.New(fmt.Sprintf("create_events rejected %v of %v transfers, none were created: %v", result.Rejected, result.Count, strings.Join(rejections, "; ")))
+	}
+
+	err = t.save_event_index(batch, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = batch.flush()
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.New("Error converting create_events result")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,82 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"moneygram/model"
+)
+
+func TestCreateEventsRollback(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		transfers []model.TransactionEvent
+		err       string
+	}{
+		{"bad amount", []model.TransactionEvent{transfer("t1", "100"), transfer("t2", "-5")}, "#1 t2"},
+		{"repeated transfer", []model.TransactionEvent{transfer("t1", "100"), transfer("t1", "100")}, "already exists"},
+		{"existing transfer", []model.TransactionEvent{transfer("t1", "100"), transfer("t0", "100")}, "already exists"},
+		{"limit reached by the batch", []model.TransactionEvent{transfer("t1", "3000"), transfer("t2", "3000")}, "Bilateral credit limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("create_event", transfer("t0", "100").CreateArgs()...)
+
+			before := map[string]string{}
+			for _, key := range l.Keys() {
+				before[key] = string(l.Get(key))
+			}
+
+			bulk, err := json.Marshal(tt.transfers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.invoke_fails(tt.err, "create_events", string(bulk))
+
+			// A rejected batch writes nothing, not even the transfers before the rejected one
+			after := l.Keys()
+			if len(after) != len(before) {
+				t.Fatalf("keys %v, want %v", after, len(before))
+			}
+			for _, key := range after {
+				if string(l.Get(key)) != before[key] {
+					t.Fatalf("%v changed", key)
+				}
+			}
+
+			l.query_fails("", "get_event_details", "t1")
+		})
+	}
+
+	t.Run("applied", func(t *testing.T) {
+
+		l := new_network(t)
+
+		bulk, err := json.Marshal([]model.TransactionEvent{transfer("t1", "3000"), transfer("t2", "2000")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result BulkResult
+		if err := json.Unmarshal(l.invoke("create_events", string(bulk)), &result); err != nil {
+			t.Fatal(err)
+		}
+		if !result.Applied || result.Count != 2 || len(result.Results) != 2 {
+			t.Fatalf("result %+v", result)
+		}
+
+		var page EventPage
+		l.query(&page, "get_events", "0", "10")
+
+		if page.Total != 2 {
+			t.Fatalf("%v transfers on the ledger, want 2", page.Total)
+		}
+	})
+}
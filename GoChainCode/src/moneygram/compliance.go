@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Compliance - Transfers are funded in cash at the sending member's counter, so every transfer is a cash transfer for
+//				  reporting purposes. Each one is added to its sender's running total for the day across the whole
+//				  network, which no single member can see. A total over the CTR threshold produces a currency transaction
+//				  report; patterns that suggest a sender is avoiding the report produce a suspicious activity candidate
+//				  for a reviewer to disposition.
+//==============================================================================================================================
+
+//==============================================================================================================================
+//	 Suspicious activity candidate status types
+//==============================================================================================================================
+const SAR_OPEN = "open"
+const SAR_FILED = "filed"
+const SAR_DISMISSED = "dismissed"
+const SAR_ESCALATED = "escalated"
+
+//==============================================================================================================================
+//	 Suspicious activity reason codes
+//==============================================================================================================================
+const REASON_MULTIPLE_MEMBERS = "multiple_members"
+const REASON_BELOW_THRESHOLD = "below_threshold"
+const REASON_REPEATED_SENDS = "repeated_sends"
+
+//==============================================================================================================================
+//	ComplianceConfig - The reporting thresholds, in minor units. A sender's same-day cash over CTRThreshold is reported.
+//					   A transfer within NearMissPercent of CTRThreshold, or a sender's MaxDailySends'th transfer of the
+//					   day, is suspicious once the day's total reaches SARThreshold. Stored under "compliance_config".
+//==============================================================================================================================
+type ComplianceConfig struct {
+	SchemaVersion   int   `json:"schemaVersion"`
+	CTRThreshold    int64 `json:"ctrThreshold"`
+	SARThreshold    int64 `json:"sarThreshold"`
+	NearMissPercent int   `json:"nearMissPercent"`
+	MaxDailySends   int   `json:"maxDailySends"`
+}
+
+//==============================================================================================================================
+//	 default_compliance_config - The thresholds used until an admin sets them: US$10,000 for CTRs and US$2,000 for SARs.
+//==============================================================================================================================
+func default_compliance_config() ComplianceConfig {
+	return ComplianceConfig{CTRThreshold: 1000000, SARThreshold: 200000, NearMissPercent: 10, MaxDailySends: 3}
+}
+
+//==============================================================================================================================
+//	SenderDay - A sender's cash transfers on one day across every member. Stored under
+//				"senderday_<date>_<senderCountry>_<SENDER NAME>".
+//==============================================================================================================================
+type SenderDay struct {
+	SchemaVersion  int      `json:"schemaVersion"`
+	Date           string   `json:"date"`
+	SenderName     string   `json:"senderName"`
+	SenderCountry  string   `json:"senderCountry"`
+	Total          int64    `json:"total"`
+	TranIDs        []string `json:"tranIDs"`
+	SendingMembers []string `json:"sendingMembers"`
+	CTRID          string   `json:"ctrID"`
+	SARID          string   `json:"sarID"`
+}
+
+//==============================================================================================================================
+//	CTRReport - A currency transaction report for one sender and day. Stored under "ctr_<ctrID>" and updated while the
+//				sender keeps sending that day.
+//==============================================================================================================================
+type CTRReport struct {
+	SchemaVersion  int      `json:"schemaVersion"`
+	CTRID          string   `json:"ctrID"`
+	Date           string   `json:"date"`
+	SenderName     string   `json:"senderName"`
+	SenderCountry  string   `json:"senderCountry"`
+	Total          string   `json:"total"`
+	Currency       string   `json:"currency"`
+	TranIDs        []string `json:"tranIDs"`
+	SendingMembers []string `json:"sendingMembers"`
+	CreatedAt      string   `json:"createdAt"`
+	UpdatedAt      string   `json:"updatedAt"`
+}
+
+//==============================================================================================================================
+//	SARCandidate - A sender and day a reviewer must look at, with every reason found. Stored under "sar_<sarID>".
+//==============================================================================================================================
+type SARCandidate struct {
+	SchemaVersion  int      `json:"schemaVersion"`
+	SARID          string   `json:"sarID"`
+	Date           string   `json:"date"`
+	SenderName     string   `json:"senderName"`
+	SenderCountry  string   `json:"senderCountry"`
+	Total          string   `json:"total"`
+	Currency       string   `json:"currency"`
+	TranIDs        []string `json:"tranIDs"`
+	SendingMembers []string `json:"sendingMembers"`
+	Reasons        []Reason `json:"reasons"`
+	Status         string   `json:"status"`
+	Reviewer       string   `json:"reviewer"`
+	Note           string   `json:"note"`
+	CreatedAt      string   `json:"createdAt"`
+	ReviewedAt     string   `json:"reviewedAt"`
+}
+
+//==============================================================================================================================
+//	Reason - Why a transfer made its sender and day suspicious.
+//==============================================================================================================================
+type Reason struct {
+	Code   string `json:"code"`
+	TranID string `json:"tranID"`
+	Detail string `json:"detail"`
+}
+
+//==============================================================================================================================
+//	Report Holder - Defines the structure that holds the IDs of the CTR reports or SAR candidates, oldest first.
+//==============================================================================================================================
+type REPORT_Holder struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	IDs           []string `json:"ids"`
+}
+
+//==============================================================================================================================
+//	ReportPage - One page of CTR reports or SAR candidates.
+//==============================================================================================================================
+type ReportPage struct {
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Reports []interface{} `json:"reports"`
+}
+
+//==============================================================================================================================
+//	 party_key - Identifies a sender or receiver across members. Names are compared without regard to case or spacing.
+//==============================================================================================================================
+func party_key(country string, name string) string {
+	return strings.ToUpper(strings.TrimSpace(country)) + "_" + strings.ToUpper(strings.Join(strings.Fields(name), " "))
+}
+
+//==============================================================================================================================
+//	 sender_key - The state key of a sender's day.
+//==============================================================================================================================
+func sender_key(date string, country string, name string) string {
+	return "senderday_" + date + "_" + party_key(country, name)
+}
+
+//==============================================================================================================================
+//	 retrieve_compliance_config - Gets the thresholds, or the defaults if none have been set.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_compliance_config(stub shim.ChaincodeStubInterface) (ComplianceConfig, error) {
+
+	config := default_compliance_config()
+
+	_, err := read_record(stub, KIND_COMPLIANCE_CONFIG, "compliance_config", &config)
+	if err != nil {
+		return config, errors.New("retrieve_compliance_config: " + err.Error())
+	}
+
+	return config, nil
+}
+
+//==============================================================================================================================
+//	 save_record - Writes a record of the current schema version under key. v must have a SchemaVersion field that the
+//				   caller has set.
+//==============================================================================================================================
+func save_record(stub shim.ChaincodeStubInterface, key string, v interface{}) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return errors.New("Error converting " + key)
+	}
+
+	err = stub.PutState(key, bytes)
+	if err != nil {
+		return errors.New("Error storing " + key)
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 next_report_id - Appends a new ID to the CTR or SAR index and returns it. IDs are sequence numbers so every peer
+//					  allocates the same one.
+//==============================================================================================================================
+func (t *SimpleChaincode) next_report_id(stub shim.ChaincodeStubInterface, index string) (string, error) {
+
+	var holder REPORT_Holder
+
+	_, err := read_record(stub, KIND_REPORT_HOLDER, index, &holder)
+	if err != nil {
+		return "", errors.New("Unable to get " + index + ": " + err.Error())
+	}
+
+	id := strconv.Itoa(len(holder.IDs) + 1)
+
+	holder.SchemaVersion = SCHEMA_VERSION
+	holder.IDs = append(holder.IDs, id)
+
+	return id, save_record(stub, index, holder)
+}
+
+//==============================================================================================================================
+//	 check_compliance - Adds a new transfer to its sender's day and files or updates the CTR report and SAR candidate it
+//						calls for. Called by create_event.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_compliance(stub shim.ChaincodeStubInterface, e TransactionEvent, amount int64, when time.Time) error {
+
+	config, err := t.retrieve_compliance_config(stub)
+	if err != nil {
+		return err
+	}
+
+	date := when.UTC().Format(STATS_DAY)
+	key := sender_key(date, e.SenderCountry, e.SenderName)
+
+	day := SenderDay{Date: date, SenderName: e.SenderName, SenderCountry: e.SenderCountry, TranIDs: []string{}, SendingMembers: []string{}}
+
+	_, err = read_record(stub, KIND_SENDER_DAY, key, &day)
+	if err != nil {
+		return errors.New("check_compliance: " + err.Error())
+	}
+
+	day.Total += amount
+	day.TranIDs = append(day.TranIDs, e.TranID)
+	if !contains_string(day.SendingMembers, e.SendingMember) {
+		day.SendingMembers = append(day.SendingMembers, e.SendingMember)
+	}
+
+	// Currency transaction report
+	if day.Total > config.CTRThreshold {
+
+		ctr := CTRReport{CreatedAt: when.Format(TIME_LAYOUT)}
+
+		if day.CTRID == "" {
+			day.CTRID, err = t.next_report_id(stub, "ctrIDs")
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = read_record(stub, KIND_CTR, "ctr_"+day.CTRID, &ctr)
+			if err != nil {
+				return errors.New("check_compliance: " + err.Error())
+			}
+		}
+
+		ctr = CTRReport{
+			SchemaVersion:  SCHEMA_VERSION,
+			CTRID:          day.CTRID,
+			Date:           date,
+			SenderName:     day.SenderName,
+			SenderCountry:  day.SenderCountry,
+			Total:          format_amount(day.Total),
+			Currency:       SETTLEMENT_CURRENCY,
+			TranIDs:        day.TranIDs,
+			SendingMembers: day.SendingMembers,
+			CreatedAt:      ctr.CreatedAt,
+			UpdatedAt:      when.Format(TIME_LAYOUT),
+		}
+
+		err = save_record(stub, "ctr_"+ctr.CTRID, ctr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Suspicious activity
+	reasons := []Reason{}
+
+	if day.Total > config.CTRThreshold && len(day.SendingMembers) > 1 {
+		reasons = append(reasons, Reason{Code: REASON_MULTIPLE_MEMBERS, TranID: e.TranID, Detail: fmt.Sprintf("Same-day cash of %v through %v members is over the reporting threshold of %v", format_amount(day.Total), len(day.SendingMembers), format_amount(config.CTRThreshold))})
+	}
+
+	if day.Total >= config.SARThreshold {
+
+		near := config.CTRThreshold - config.CTRThreshold*int64(config.NearMissPercent)/100
+
+		if amount >= near && amount <= config.CTRThreshold {
+			reasons = append(reasons, Reason{Code: REASON_BELOW_THRESHOLD, TranID: e.TranID, Detail: fmt.Sprintf("Transfer of %v is just at or below the reporting threshold of %v", format_amount(amount), format_amount(config.CTRThreshold))})
+		}
+
+		if config.MaxDailySends > 0 && len(day.TranIDs) == config.MaxDailySends {
+			reasons = append(reasons, Reason{Code: REASON_REPEATED_SENDS, TranID: e.TranID, Detail: fmt.Sprintf("%v transfers totalling %v on one day", len(day.TranIDs), format_amount(day.Total))})
+		}
+	}
+
+	if len(reasons) > 0 || day.SARID != "" {
+
+		sar := SARCandidate{Status: SAR_OPEN, Reasons: []Reason{}, CreatedAt: when.Format(TIME_LAYOUT)}
+
+		if day.SARID == "" {
+			day.SARID, err = t.next_report_id(stub, "sarIDs")
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = read_record(stub, KIND_SAR, "sar_"+day.SARID, &sar)
+			if err != nil {
+				return errors.New("check_compliance: " + err.Error())
+			}
+		}
+
+		// A candidate that has been dispositioned is left as the reviewer saw it; new reasons open a fresh one
+		if sar.Status != SAR_OPEN && len(reasons) > 0 {
+			day.SARID, err = t.next_report_id(stub, "sarIDs")
+			if err != nil {
+				return err
+			}
+			sar = SARCandidate{Status: SAR_OPEN, Reasons: []Reason{}, CreatedAt: when.Format(TIME_LAYOUT)}
+		}
+
+		if sar.Status == SAR_OPEN {
+			sar.SchemaVersion = SCHEMA_VERSION
+			sar.SARID = day.SARID
+			sar.Date = date
+			sar.SenderName = day.SenderName
+			sar.SenderCountry = day.SenderCountry
+			sar.Total = format_amount(day.Total)
+			sar.Currency = SETTLEMENT_CURRENCY
+			sar.TranIDs = day.TranIDs
+			sar.SendingMembers = day.SendingMembers
+			sar.Reasons = append(sar.Reasons, reasons...)
+
+			err = save_record(stub, "sar_"+sar.SARID, sar)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	day.SchemaVersion = SCHEMA_VERSION
+
+	return save_record(stub, key, day)
+}
+
+//=================================================================================================================================
+//	 Compliance Functions
+//=================================================================================================================================
+//	 set_compliance_config - Changes the reporting thresholds. Only an admin may change them; they apply to transfers
+//							 created afterwards.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_compliance_config(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//		0			  1				2				3
+	//	ctrThreshold, sarThreshold, nearMissPercent, maxDailySends
+
+	ctr, err := parse_amount(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid CTR threshold: " + err.Error())
+	}
+
+	sar, err := parse_amount(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid SAR threshold: " + err.Error())
+	}
+
+	percent, _ := strconv.Atoi(args[2])
+	if percent < 0 || percent > 100 {
+		return nil, errors.New("Invalid near miss percent: " + args[2])
+	}
+
+	sends, _ := strconv.Atoi(args[3])
+	if sends < 0 {
+		return nil, errors.New("Invalid max daily sends: " + args[3])
+	}
+
+	if sar > ctr {
+		return nil, errors.New("The SAR threshold must not be above the CTR threshold")
+	}
+
+	config := ComplianceConfig{SchemaVersion: SCHEMA_VERSION, CTRThreshold: ctr, SARThreshold: sar, NearMissPercent: percent, MaxDailySends: sends}
+
+	return nil, save_record(stub, "compliance_config", config)
+}
+
+//=================================================================================================================================
+//	 get_compliance_config - Returns the reporting thresholds as decimal amounts.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_compliance_config(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	config, err := t.retrieve_compliance_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(map[string]interface{}{
+		"ctrThreshold":    format_amount(config.CTRThreshold),
+		"sarThreshold":    format_amount(config.SARThreshold),
+		"nearMissPercent": config.NearMissPercent,
+		"maxDailySends":   config.MaxDailySends,
+		"currency":        SETTLEMENT_CURRENCY,
+	})
+	if err != nil {
+		return nil, errors.New("Error converting compliance config")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 disposition_sar_candidate - Records a reviewer's decision on an open SAR candidate: filed with the regulator,
+//								 dismissed or escalated. Escalated candidates may be dispositioned again.
+//=================================================================================================================================
+func (t *SimpleChaincode) disposition_sar_candidate(stub shim.ChaincodeStubInterface, reviewer string, args []string) ([]byte, error) {
+
+	//Args
+	//		0		1			2
+	//	sarID, disposition, note
+
+	disposition := args[1]
+	if disposition != SAR_FILED && disposition != SAR_DISMISSED && disposition != SAR_ESCALATED {
+		return nil, errors.New("Invalid disposition " + disposition + ". Expecting " + SAR_FILED + ", " + SAR_DISMISSED + " or " + SAR_ESCALATED)
+	}
+
+	var sar SARCandidate
+
+	found, err := read_record(stub, KIND_SAR, "sar_"+args[0], &sar)
+	if err != nil {
+		return nil, errors.New("disposition_sar_candidate: " + err.Error())
+	}
+
+	if !found {
+		return nil, errors.New("Unknown SAR candidate " + args[0])
+	}
+
+	if sar.Status != SAR_OPEN && sar.Status != SAR_ESCALATED {
+		return nil, errors.New(fmt.Sprintf("SAR candidate %v has already been %v", sar.SARID, sar.Status))
+	}
+
+	sar.SchemaVersion = SCHEMA_VERSION
+	sar.Status = disposition
+	sar.Reviewer = reviewer
+	sar.Note = args[2]
+
+	sar.ReviewedAt, err = tx_timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, save_record(stub, "sar_"+sar.SARID, sar)
+}
+
+//=================================================================================================================================
+//	 get_reports - Returns a page of CTR reports, SAR candidates or structuring alerts, oldest first. SAR candidates may
+//				   be filtered by status and alerts by rule.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_reports(stub shim.ChaincodeStubInterface, index string, args []string) ([]byte, error) {
+
+	//Args
+	//		0		1		2
+	//	offset, limit, [status or ruleID]
+
+	offset, _ := strconv.Atoi(args[0])
+	limit, _ := strconv.Atoi(args[1])
+	if offset < 0 || limit <= 0 {
+		return nil, errors.New("Invalid offset or limit")
+	}
+
+	filter := ""
+	if len(args) == 3 {
+		filter = args[2]
+	}
+
+	var holder REPORT_Holder
+
+	_, err := read_record(stub, KIND_REPORT_HOLDER, index, &holder)
+	if err != nil {
+		return nil, errors.New("Unable to get " + index + ": " + err.Error())
+	}
+
+	page := ReportPage{Offset: offset, Reports: []interface{}{}}
+
+	for _, id := range holder.IDs {
+
+		var report interface{}
+
+		switch index {
+		case "ctrIDs":
+			var ctr CTRReport
+			_, err = read_record(stub, KIND_CTR, "ctr_"+id, &ctr)
+			report = ctr
+		case "sarIDs":
+			var sar SARCandidate
+			_, err = read_record(stub, KIND_SAR, "sar_"+id, &sar)
+			if err == nil && filter != "" && sar.Status != filter {
+				continue
+			}
+			report = sar
+		default:
+			var alert Alert
+			_, err = read_record(stub, KIND_ALERT, "alert_"+id, &alert)
+			if err == nil && filter != "" && alert.RuleID != filter {
+				continue
+			}
+			report = alert
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if page.Total >= offset && len(page.Reports) < limit {
+			page.Reports = append(page.Reports, report)
+		}
+		page.Total++
+	}
+
+	bytes, err := json.Marshal(page)
+	if err != nil {
+		return nil, errors.New("Error converting report page")
+	}
+
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	 contains_string - Reports whether list holds s.
+//==============================================================================================================================
+func contains_string(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Detection - Looks for structuring across members in the transfers of one settlement batch. A structurer keeps each
+//				 transfer small or spreads them over agents and senders so that no single member sees the pattern;
+//				 the ledger sees every member's transfers, so the rules run here. A structurer can also spread the
+//				 transfers over several settlement cycles, so each rule looks back over a window of days before the
+//				 batch's last transfer, and a pattern is found for the batch when one of its transfers is part of it.
+//==============================================================================================================================
+
+//==============================================================================================================================
+//	 DETECTION_WINDOW_DAYS - The window of the default rules. DETECTION_MAX_WINDOW_DAYS bounds how many settlement
+//							 batches one run reads.
+//==============================================================================================================================
+const DETECTION_WINDOW_DAYS = 7
+const DETECTION_MAX_WINDOW_DAYS = 90
+
+//==============================================================================================================================
+//	 Detection rules
+//==============================================================================================================================
+const RULE_JUST_UNDER = "just_under_threshold"
+const RULE_MANY_LOCATIONS = "many_locations"
+const RULE_MANY_SENDERS = "many_senders"
+
+//==============================================================================================================================
+//	DetectionRule - One configurable rule. MinCount is the number of transfers, agent locations or senders that raises
+//					an alert. Threshold and Percent are only used by just_under_threshold: a transfer counts when it is
+//					below Threshold by no more than Percent of it. WindowDays is how far back before the batch's last
+//					transfer the rule looks; 0 runs it over the batch alone.
+//==============================================================================================================================
+type DetectionRule struct {
+	RuleID     string `json:"ruleID"`
+	Enabled    bool   `json:"enabled"`
+	Threshold  int64  `json:"threshold"`
+	Percent    int    `json:"percent"`
+	MinCount   int    `json:"minCount"`
+	WindowDays int    `json:"windowDays"`
+}
+
+//==============================================================================================================================
+//	DetectionRules - Every rule, in the order they run. Stored under "detection_rules".
+//==============================================================================================================================
+type DetectionRules struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Rules         []DetectionRule `json:"rules"`
+}
+
+//==============================================================================================================================
+//	 default_detection_rules - The rules used until an admin changes them. US$3,000 is the amount above which money
+//							   transmitters must keep sender records, a common figure to stay under.
+//==============================================================================================================================
+func default_detection_rules() DetectionRules {
+	return DetectionRules{Rules: []DetectionRule{
+		{RuleID: RULE_JUST_UNDER, Enabled: true, Threshold: 300000, Percent: 10, MinCount: 2, WindowDays: DETECTION_WINDOW_DAYS},
+		{RuleID: RULE_MANY_LOCATIONS, Enabled: true, MinCount: 3, WindowDays: DETECTION_WINDOW_DAYS},
+		{RuleID: RULE_MANY_SENDERS, Enabled: true, MinCount: 5, WindowDays: DETECTION_WINDOW_DAYS},
+	}}
+}
+
+//==============================================================================================================================
+//	Finding - A pattern found by a rule. Subject is the sender or receiver the transfers have in common and Members the
+//			  sending and payout members they went through. TranIDs includes the transfers of earlier batches in the
+//			  rule's window.
+//==============================================================================================================================
+type Finding struct {
+	RuleID  string   `json:"ruleID"`
+	Subject string   `json:"subject"`
+	TranIDs []string `json:"tranIDs"`
+	Members []string `json:"members"`
+	Detail  string   `json:"detail"`
+}
+
+//==============================================================================================================================
+//	Alert - A finding written back to the ledger by raise_alerts. Stored under "alert_<alertID>"; running the rules
+//			over the same batch again updates the alert rather than raising another. A pattern that carries on into
+//			later batches raises an alert for each of them.
+//==============================================================================================================================
+type Alert struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	AlertID       string   `json:"alertID"`
+	BatchID       string   `json:"batchID"`
+	RuleID        string   `json:"ruleID"`
+	Subject       string   `json:"subject"`
+	TranIDs       []string `json:"tranIDs"`
+	Members       []string `json:"members"`
+	Detail        string   `json:"detail"`
+	RaisedBy      string   `json:"raisedBy"`
+	RaisedAt      string   `json:"raisedAt"`
+	UpdatedAt     string   `json:"updatedAt"`
+}
+
+//==============================================================================================================================
+//	BatchAlerts - The alerts raised for a batch, keyed by "<ruleID>|<subject>". Stored under "batchalerts_<batchID>".
+//==============================================================================================================================
+type BatchAlerts struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	BatchID       string            `json:"batchID"`
+	Alerts        map[string]string `json:"alerts"`
+}
+
+//==============================================================================================================================
+//	DetectionResult - The result of detect_structuring. Transfers counts the batch's transfers and Earlier those of
+//					  earlier batches within the longest window.
+//==============================================================================================================================
+type DetectionResult struct {
+	BatchID   string          `json:"batchID"`
+	Transfers int             `json:"transfers"`
+	Earlier   int             `json:"earlier"`
+	Rules     []DetectionRule `json:"rules"`
+	Findings  []Finding       `json:"findings"`
+}
+
+//==============================================================================================================================
+//	 retrieve_detection_rules - Gets the rules, or the defaults if none have been set.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_detection_rules(stub shim.ChaincodeStubInterface) (DetectionRules, error) {
+
+	rules := default_detection_rules()
+
+	_, err := read_record(stub, KIND_DETECTION_RULES, "detection_rules", &rules)
+	if err != nil {
+		return rules, errors.New("retrieve_detection_rules: " + err.Error())
+	}
+
+	return rules, nil
+}
+
+//==============================================================================================================================
+//	 detect - Runs the enabled rules over the transfers of batchID and the earlier transfers in each rule's window before
+//			  end, given oldest first. A pattern is only found when one of the batch's transfers is part of it. Findings
+//			  are in rule order and then in the order each subject first appears, so every peer returns the same result.
+//==============================================================================================================================
+func detect(rules []DetectionRule, batchID string, events []TransactionEvent, end time.Time) ([]Finding, error) {
+
+	findings := []Finding{}
+
+	for _, rule := range rules {
+
+		if !rule.Enabled {
+			continue
+		}
+
+		start := end.AddDate(0, 0, -rule.WindowDays)
+
+		var subjects []string
+		groups := map[string][]TransactionEvent{}
+		counted := map[string]map[string]bool{}
+		in_batch := map[string]bool{}
+
+		for _, e := range events {
+
+			if e.BatchID != batchID && !created_since(e, start) {
+				continue
+			}
+
+			subject, value := "", ""
+
+			switch rule.RuleID {
+			case RULE_JUST_UNDER:
+				amount, err := parse_amount(e.Amount)
+				if err != nil {
+					return nil, errors.New(fmt.Sprintf("%v has an invalid amount %v", e.TranID, e.Amount))
+				}
+				if amount >= rule.Threshold || amount < rule.Threshold-rule.Threshold*int64(rule.Percent)/100 {
+					continue
+				}
+				subject, value = party_key(e.SenderCountry, e.SenderName), e.TranID
+			case RULE_MANY_LOCATIONS:
+				// Transfers recorded without their agent location cannot be told apart by it
+				if e.AgentLocation == "" {
+					continue
+				}
+				subject, value = party_key(e.SenderCountry, e.SenderName), e.SendingMember+"/"+e.AgentLocation
+			case RULE_MANY_SENDERS:
+				subject, value = party_key(e.ReceiverCountry, e.ReceiverName), party_key(e.SenderCountry, e.SenderName)
+			default:
+				return nil, errors.New("Unknown detection rule " + rule.RuleID)
+			}
+
+			if _, ok := groups[subject]; !ok {
+				subjects = append(subjects, subject)
+				counted[subject] = map[string]bool{}
+			}
+			groups[subject] = append(groups[subject], e)
+			counted[subject][value] = true
+			if e.BatchID == batchID {
+				in_batch[subject] = true
+			}
+		}
+
+		for _, subject := range subjects {
+
+			count := len(counted[subject])
+			if count < rule.MinCount || !in_batch[subject] {
+				continue
+			}
+
+			f := Finding{RuleID: rule.RuleID, Subject: subject, TranIDs: []string{}, Members: []string{}}
+
+			for _, e := range groups[subject] {
+				f.TranIDs = append(f.TranIDs, e.TranID)
+				for _, member := range []string{e.SendingMember, e.PayoutMember} {
+					if !contains_string(f.Members, member) {
+						f.Members = append(f.Members, member)
+					}
+				}
+			}
+
+			switch rule.RuleID {
+			case RULE_JUST_UNDER:
+				f.Detail = fmt.Sprintf("%v transfers within %v%% below %v", count, rule.Percent, format_amount(rule.Threshold))
+			case RULE_MANY_LOCATIONS:
+				f.Detail = fmt.Sprintf("Sent through %v agent locations", count)
+			case RULE_MANY_SENDERS:
+				f.Detail = fmt.Sprintf("Paid by %v different senders", count)
+			}
+
+			if rule.WindowDays > 0 {
+				f.Detail += fmt.Sprintf(" in %v days", rule.WindowDays)
+			}
+
+			findings = append(findings, f)
+		}
+	}
+
+	return findings, nil
+}
+
+//==============================================================================================================================
+//	 created_since - Reports whether a transfer was created at or after start. Transfers written before schema version 3
+//					 have no creation time and are never in a window.
+//==============================================================================================================================
+func created_since(e TransactionEvent, start time.Time) bool {
+
+	created, err := time.Parse(TIME_LAYOUT, e.CreatedAt)
+	if err != nil {
+		return false
+	}
+
+	return !created.Before(start)
+}
+
+//==============================================================================================================================
+//	 detect_batch - Runs the rules over a batch, with "open" meaning the open batch, and the earlier transfers in their
+//					windows. The windows end at the batch's last transfer, so a closed batch always gives the same
+//					result. Earlier batches are read newest first until one closed before the longest window began.
+//==============================================================================================================================
+func (t *SimpleChaincode) detect_batch(stub shim.ChaincodeStubInterface, batchID string) (DetectionResult, error) {
+
+	result := DetectionResult{BatchID: batchID}
+
+	if batchID == BATCH_OPEN {
+		batchHld, err := t.retrieve_batch_ids(stub)
+		if err != nil {
+			return result, err
+		}
+		if len(batchHld.BatchIDs) == 0 {
+			return result, errors.New("No settlement batch has been opened")
+		}
+		result.BatchID = batchHld.BatchIDs[len(batchHld.BatchIDs)-1]
+	}
+
+	b, err := t.retrieve_batch(stub, result.BatchID)
+	if err != nil {
+		return result, err
+	}
+
+	rules, err := t.retrieve_detection_rules(stub)
+	if err != nil {
+		return result, err
+	}
+	result.Rules = rules.Rules
+
+	events := []TransactionEvent{}
+	var end time.Time
+
+	for _, tranID := range b.TranIDs {
+		e, err := t.retrieve_tranEvent(stub, tranID)
+		if err != nil {
+			return result, err
+		}
+		events = append(events, e)
+
+		created, err := time.Parse(TIME_LAYOUT, e.CreatedAt)
+		if err == nil && created.After(end) {
+			end = created
+		}
+	}
+	result.Transfers = len(events)
+
+	window := 0
+	for _, rule := range rules.Rules {
+		if rule.Enabled && rule.WindowDays > window {
+			window = rule.WindowDays
+		}
+	}
+
+	if window > 0 && !end.IsZero() {
+
+		batchHld, err := t.retrieve_batch_ids(stub)
+		if err != nil {
+			return result, err
+		}
+
+		start := end.AddDate(0, 0, -window)
+		earlier := []TransactionEvent{}
+
+		position := len(batchHld.BatchIDs)
+		for i, id := range batchHld.BatchIDs {
+			if id == result.BatchID {
+				position = i
+			}
+		}
+
+		for i := position - 1; i >= 0; i-- {
+
+			previous, err := t.retrieve_batch(stub, batchHld.BatchIDs[i])
+			if err != nil {
+				return result, err
+			}
+
+			closed, err := time.Parse(TIME_LAYOUT, previous.ClosedAt)
+			if err != nil || closed.Before(start) {
+				break
+			}
+
+			batch_events := []TransactionEvent{}
+			for _, tranID := range previous.TranIDs {
+				e, err := t.retrieve_tranEvent(stub, tranID)
+				if err != nil {
+					return result, err
+				}
+				if created_since(e, start) {
+					batch_events = append(batch_events, e)
+				}
+			}
+
+			earlier = append(batch_events, earlier...)
+		}
+
+		result.Earlier = len(earlier)
+		events = append(earlier, events...)
+	}
+
+	result.Findings, err = detect(rules.Rules, result.BatchID, events, end)
+
+	return result, err
+}
+
+//=================================================================================================================================
+//	 Detection Functions
+//=================================================================================================================================
+//	 set_detection_rule - Changes one rule. Only an admin may change the rules; they apply the next time they are run. A
+//						  rule keeps its window when none is given.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_detection_rule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//		0		1		  2			3		 4			5
+	//	ruleID, enabled, threshold, percent, minCount, [windowDays]
+
+	threshold, err := parse_amount(args[2])
+	if err != nil {
+		return nil, errors.New("Invalid threshold: " + err.Error())
+	}
+
+	percent, _ := strconv.Atoi(args[3])
+	if percent < 0 || percent > 100 {
+		return nil, errors.New("Invalid percent: " + args[3])
+	}
+
+	minCount, _ := strconv.Atoi(args[4])
+	if minCount < 1 {
+		return nil, errors.New("Invalid minimum count: " + args[4])
+	}
+
+	windowDays := -1
+	if len(args) > 5 {
+		windowDays, _ = strconv.Atoi(args[5])
+		if windowDays < 0 || windowDays > DETECTION_MAX_WINDOW_DAYS {
+			return nil, errors.New(fmt.Sprintf("Invalid window: %v. Expecting 0 to %v days", args[5], DETECTION_MAX_WINDOW_DAYS))
+		}
+	}
+
+	rules, err := t.retrieve_detection_rules(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for i := range rules.Rules {
+		if rules.Rules[i].RuleID == args[0] {
+			if windowDays < 0 {
+				windowDays = rules.Rules[i].WindowDays
+			}
+			rules.Rules[i] = DetectionRule{RuleID: args[0], Enabled: args[1] == "true", Threshold: threshold, Percent: percent, MinCount: minCount, WindowDays: windowDays}
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, errors.New("Unknown detection rule " + args[0] + ". Expecting " + RULE_JUST_UNDER + ", " + RULE_MANY_LOCATIONS + " or " + RULE_MANY_SENDERS)
+	}
+
+	rules.SchemaVersion = SCHEMA_VERSION
+
+	return nil, save_record(stub, "detection_rules", rules)
+}
+
+//=================================================================================================================================
+//	 get_detection_rules - Returns the rules with thresholds as decimal amounts.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_detection_rules(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	rules, err := t.retrieve_detection_rules(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []map[string]interface{}{}
+	for _, r := range rules.Rules {
+		out = append(out, map[string]interface{}{
+			"ruleID":     r.RuleID,
+			"enabled":    r.Enabled,
+			"threshold":  format_amount(r.Threshold),
+			"percent":    r.Percent,
+			"minCount":   r.MinCount,
+			"windowDays": r.WindowDays,
+		})
+	}
+
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.New("Error converting detection rules")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 detect_structuring - Runs the rules over a batch and returns what they find without raising alerts.
+//=================================================================================================================================
+func (t *SimpleChaincode) detect_structuring(stub shim.ChaincodeStubInterface, batchID string) ([]byte, error) {
+
+	result, err := t.detect_batch(stub, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.New("Error converting detection result")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 raise_alerts - Runs the rules over a batch and writes every finding back as an alert. An alert already raised for
+//					the same rule and subject in the batch is brought up to date, so the open batch can be checked as
+//					often as needed.
+//=================================================================================================================================
+func (t *SimpleChaincode) raise_alerts(stub shim.ChaincodeStubInterface, caller string, batchID string) ([]byte, error) {
+
+	result, err := t.detect_batch(stub, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	raised := BatchAlerts{BatchID: result.BatchID, Alerts: map[string]string{}}
+
+	_, err = read_record(stub, KIND_BATCH_ALERTS, "batchalerts_"+result.BatchID, &raised)
+	if err != nil {
+		return nil, errors.New("raise_alerts: " + err.Error())
+	}
+
+	if raised.Alerts == nil {
+		raised.Alerts = map[string]string{}
+	}
+
+	now, err := tx_timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range result.Findings {
+
+		raisedAt := now
+
+		alertID, ok := raised.Alerts[f.RuleID+"|"+f.Subject]
+		if !ok {
+			alertID, err = t.next_report_id(stub, "alertIDs")
+			if err != nil {
+				return nil, err
+			}
+			raised.Alerts[f.RuleID+"|"+f.Subject] = alertID
+		} else {
+			var previous Alert
+			_, err = read_record(stub, KIND_ALERT, "alert_"+alertID, &previous)
+			if err != nil {
+				return nil, errors.New("raise_alerts: " + err.Error())
+			}
+			raisedAt = previous.RaisedAt
+		}
+
+		alert := Alert{
+			SchemaVersion: SCHEMA_VERSION,
+			AlertID:       alertID,
+			BatchID:       result.BatchID,
+			RuleID:        f.RuleID,
+			Subject:       f.Subject,
+			TranIDs:       f.TranIDs,
+			Members:       f.Members,
+			Detail:        f.Detail,
+			RaisedBy:      caller,
+			RaisedAt:      raisedAt,
+			UpdatedAt:     now,
+		}
+
+		err = save_record(stub, "alert_"+alertID, alert)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	raised.SchemaVersion = SCHEMA_VERSION
+
+	return nil, save_record(stub, "batchalerts_"+result.BatchID, raised)
+}
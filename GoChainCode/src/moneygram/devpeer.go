@@ -0,0 +1,304 @@
+//go:build devpeer && !fabric1 && !loadgen
+// +build devpeer,!fabric1,!loadgen
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"ledgersim"
+)
+
+//==============================================================================================================================
+//	 Development peer entry point - Built with the devpeer tag, the chaincode runs in-process on a ledgersim ledger behind
+//									the /chaincode JSON-RPC endpoint of a Fabric v0.6 peer, so mgictl, mgigateway and
+//									the other tools can be pointed at it during development:
+//
+//									  go build -tags devpeer -o mgidevpeer moneygram
+//									  mgidevpeer -listen :7050 -users users.json -state dev.json
+//									  mgictl -url http://localhost:7050 -chaincode mgi -secure-context admin list
+//
+//									The users file maps each enrolled user to the eCert attributes the chaincode
+//									reads, e.g. {"admin": {"role": "admin", "member": "moneygram"}}. Every request
+//									must name one of them as its secureContext, as a peer with security enabled
+//									requires. Roles, limits and batches are the chaincode's own. As on a v0.6 peer an
+//									invoke only returns its transaction ID; a rejected transaction is logged and
+//									leaves the ledger unchanged. The ledger is kept in the -state file between runs
+//									and the chaincode is deployed as -deployer when that file is empty.
+//==============================================================================================================================
+func main() {
+
+	listen := flag.String("listen", ":7050", "address to serve the peer REST API on")
+	name := flag.String("name", "mgi", "chaincode name clients deploy and call")
+	usersFile := flag.String("users", "", "JSON file mapping enrolled users to their eCert attributes")
+	state := flag.String("state", "", "file to keep the ledger in between runs")
+	deployer := flag.String("deployer", "admin", "user that deploys the chaincode on an empty ledger")
+	flag.Parse()
+
+	if *usersFile == "" {
+		devpeer_fail(errors.New("-users is required"))
+	}
+
+	raw, err := ioutil.ReadFile(*usersFile)
+	if err != nil {
+		devpeer_fail(err)
+	}
+
+	p := &dev_peer{name: *name, state: *state, users: map[string]map[string]string{}}
+
+	err = json.Unmarshal(raw, &p.users)
+	if err != nil {
+		devpeer_fail(errors.New(*usersFile + ": " + err.Error()))
+	}
+
+	// Transaction IDs and times carry on from the previous run rather than starting again
+	start := time.Now().UTC()
+	p.ledger = ledgersim.New("dev"+strconv.FormatInt(start.Unix(), 36), new(SimpleChaincode))
+	p.ledger.Start, p.ledger.Step = start, time.Millisecond
+
+	err = p.load()
+	if err != nil {
+		devpeer_fail(err)
+	}
+
+	if len(p.ledger.Keys()) == 0 {
+		_, err = p.run(*deployer, "deploy", "init", []string{"dev"})
+		if err != nil {
+			devpeer_fail(errors.New("deploying the chaincode: " + err.Error()))
+		}
+	}
+
+	log.Printf("mgidevpeer: serving chaincode %s on %s", *name, *listen)
+	log.Fatal(http.ListenAndServe(*listen, p))
+}
+
+//==============================================================================================================================
+//	dev_peer - The ledger and the enrolled users of a development peer. mu makes choosing the caller and running the call
+//			   one step, as ledgersim keeps a single caller.
+//==============================================================================================================================
+type dev_peer struct {
+	mu     sync.Mutex
+	name   string
+	state  string
+	users  map[string]map[string]string
+	ledger *ledgersim.Ledger
+}
+
+//==============================================================================================================================
+//	rpc_request, rpc_response - The JSON-RPC bodies of the v0.6 peer's /chaincode endpoint.
+//==============================================================================================================================
+type rpc_request struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  struct {
+		Type        int `json:"type"`
+		ChaincodeID struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		} `json:"chaincodeID"`
+		CtorMsg struct {
+			Function string   `json:"function"`
+			Args     []string `json:"args"`
+		} `json:"ctorMsg"`
+		SecureContext string `json:"secureContext"`
+	} `json:"params"`
+	ID interface{} `json:"id"`
+}
+
+type rpc_result struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type rpc_error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+type rpc_response struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Result  *rpc_result `json:"result,omitempty"`
+	Error   *rpc_error  `json:"error,omitempty"`
+	ID      interface{} `json:"id"`
+}
+
+//==============================================================================================================================
+//	 ServeHTTP - Answers a JSON-RPC request on /chaincode.
+//==============================================================================================================================
+func (p *dev_peer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != "/chaincode" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req rpc_request
+	resp := rpc_response{JSONRPC: "2.0"}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		resp.Error = &rpc_error{Code: -32700, Message: "Parse error", Data: err.Error()}
+		devpeer_reply(w, resp)
+		return
+	}
+	resp.ID = req.ID
+
+	name := req.Params.ChaincodeID.Name
+	if req.Method == "deploy" {
+		name = p.name
+	}
+
+	if name != p.name {
+		resp.Error = &rpc_error{Code: -32602, Message: "Invalid params", Data: "No chaincode named " + name}
+		devpeer_reply(w, resp)
+		return
+	}
+
+	out, err := p.run(req.Params.SecureContext, req.Method, req.Params.CtorMsg.Function, req.Params.CtorMsg.Args)
+
+	switch {
+	case err != nil:
+		resp.Error = &rpc_error{Code: -32003, Message: req.Method + " failure", Data: err.Error()}
+	case req.Method == "deploy":
+		resp.Result = &rpc_result{Status: "OK", Message: p.name}
+	default:
+		resp.Result = &rpc_result{Status: "OK", Message: string(out)}
+	}
+
+	devpeer_reply(w, resp)
+}
+
+//==============================================================================================================================
+//	 run - Runs a deploy, invoke or query as an enrolled user. An invoke returns its transaction ID; when the chaincode
+//		   rejects it, the error is logged rather than returned, as on a v0.6 peer.
+//==============================================================================================================================
+func (p *dev_peer) run(user string, method string, function string, args []string) ([]byte, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	attrs, ok := p.users[user]
+	if !ok {
+		return nil, errors.New("Unknown secure context '" + user + "'")
+	}
+	p.ledger.SetCaller(attrs)
+
+	switch method {
+
+	case "query":
+		return p.ledger.Query(function, args...)
+
+	case "deploy", "invoke":
+		var err error
+		if method == "deploy" {
+			_, err = p.ledger.Init(function, args...)
+		} else {
+			_, err = p.ledger.Invoke(function, args...)
+		}
+
+		txID := p.ledger.LastTxID()
+		if err != nil {
+			if method == "deploy" {
+				return nil, err
+			}
+			log.Printf("mgidevpeer: transaction %s (%s by %s) rejected: %v", txID, function, user, err)
+			return []byte(txID), nil
+		}
+
+		err = p.save()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(txID), nil
+	}
+
+	return nil, errors.New("Unknown method " + method)
+}
+
+//==============================================================================================================================
+//	 load, save - Read and write the -state file, a JSON object of every key on the ledger and its value.
+//==============================================================================================================================
+func (p *dev_peer) load() error {
+
+	if p.state == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(p.state)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var values map[string]string
+	err = json.Unmarshal(raw, &values)
+	if err != nil {
+		return errors.New(p.state + ": " + err.Error())
+	}
+
+	for k, v := range values {
+		p.ledger.Put(k, []byte(v))
+	}
+	return nil
+}
+
+func (p *dev_peer) save() error {
+
+	if p.state == "" {
+		return nil
+	}
+
+	values := map[string]string{}
+	for _, k := range p.ledger.Keys() {
+		values[k] = string(p.ledger.Get(k))
+	}
+
+	raw, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := p.state + ".tmp"
+	err = ioutil.WriteFile(tmp, raw, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.state)
+}
+
+//==============================================================================================================================
+//	 read_cert_attribute - Reads an attribute from the caller's eCert, as in the v0.6 build.
+//==============================================================================================================================
+func read_cert_attribute(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	return stub.ReadCertAttribute(name)
+}
+
+//==============================================================================================================================
+//	 devpeer_reply - Writes a JSON-RPC response.
+//==============================================================================================================================
+func devpeer_reply(w http.ResponseWriter, resp rpc_response) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+//==============================================================================================================================
+//	 devpeer_fail - Reports an error that stops the development peer.
+//==============================================================================================================================
+func devpeer_fail(err error) {
+	fmt.Fprintf(os.Stderr, "mgidevpeer: %v\n", err)
+	os.Exit(1)
+}
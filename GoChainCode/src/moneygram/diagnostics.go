@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Health states - A ledger is healthy when every index agrees with the records it points to.
+//==============================================================================================================================
+const HEALTH_OK = "ok"
+const HEALTH_INCONSISTENT = "inconsistent"
+
+//==============================================================================================================================
+//	Diagnostics - The health report returned by the diagnostics query. Problems lists every inconsistency found between
+//				  the indexes and the records; Status is inconsistent when there is at least one.
+//==============================================================================================================================
+type Diagnostics struct {
+	Status           string         `json:"status"`
+	ChaincodeVersion string         `json:"chaincodeVersion"`
+	SchemaVersion    int            `json:"schemaVersion"`
+	EventCount       int            `json:"eventCount"`
+	Indexes          map[string]int `json:"indexes"`
+	OpenBatch        *OpenBatch     `json:"openBatch"`
+	Migration        string         `json:"migration"`
+	ConfigHash       string         `json:"configHash"`
+	Problems         []string       `json:"problems"`
+}
+
+//==============================================================================================================================
+//	OpenBatch - The settlement window transfers are currently collected into.
+//==============================================================================================================================
+type OpenBatch struct {
+	BatchID   string `json:"batchID"`
+	Transfers int    `json:"transfers"`
+	Total     string `json:"total"`
+}
+
+//==============================================================================================================================
+//	NetworkConfig - The configuration the network runs under. Its hash is reported by diagnostics so operators can check
+//					every peer and environment agrees without comparing records one by one. Positions and amounts owed
+//					are state, not configuration, and are left out.
+//==============================================================================================================================
+type NetworkConfig struct {
+	ChaincodeVersion string           `json:"chaincodeVersion"`
+	Members          []MemberConfig   `json:"members"`
+	Limits           []LimitConfig    `json:"limits"`
+	Compliance       ComplianceConfig `json:"compliance"`
+	Detection        []DetectionRule  `json:"detection"`
+}
+
+//==============================================================================================================================
+//	MemberConfig - The configured part of a Member.
+//==============================================================================================================================
+type MemberConfig struct {
+	MemberID    string `json:"memberID"`
+	Name        string `json:"name"`
+	NetDebitCap int64  `json:"netDebitCap"`
+}
+
+//==============================================================================================================================
+//	LimitConfig - The configured part of a BilateralLimit.
+//==============================================================================================================================
+type LimitConfig struct {
+	Creditor string `json:"creditor"`
+	Debtor   string `json:"debtor"`
+	Limit    int64  `json:"limit"`
+}
+
+//=================================================================================================================================
+//	 Diagnostics Functions
+//=================================================================================================================================
+//	 diagnostics - Checks the ledger and reports its health. Every indexed record is read, so the cost grows with the
+//				   number of transfers; it is a query and never changes state.
+//=================================================================================================================================
+func (t *SimpleChaincode) diagnostics(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	d := Diagnostics{
+		Status:        HEALTH_OK,
+		SchemaVersion: SCHEMA_VERSION,
+		Indexes:       map[string]int{},
+		Problems:      []string{},
+	}
+
+	problem := func(format string, args ...interface{}) {
+		d.Problems = append(d.Problems, fmt.Sprintf(format, args...))
+	}
+
+	deployment, deployed, err := t.retrieve_deployment(stub)
+	if err != nil {
+		problem("%v", err)
+	} else if !deployed {
+		problem("No deployment has been recorded; Init has not run since the chaincode was upgraded")
+	}
+	d.ChaincodeVersion = deployment.ChaincodeVersion
+
+	migration, err := t.retrieve_migration(stub)
+	if err != nil {
+		problem("%v", err)
+	} else if migration.Done {
+		d.Migration = "done"
+	} else if _, scanned := migration_scans[migration.Stage]; scanned && migration.After != "" {
+		d.Migration = fmt.Sprintf("%v after %v", migration.Stage, migration.After)
+	} else {
+		d.Migration = fmt.Sprintf("%v at %v", migration.Stage, migration.Offset)
+	}
+
+	// Members and their positions
+	memberHld, err := t.retrieve_member_ids(stub)
+	if err != nil {
+		problem("%v", err)
+	}
+	d.Indexes["memberIDs"] = len(memberHld.MemberIDs)
+
+	members := map[string]Member{}
+	config := NetworkConfig{ChaincodeVersion: deployment.ChaincodeVersion, Members: []MemberConfig{}, Limits: []LimitConfig{}}
+	var positions int64
+
+	for _, id := range memberHld.MemberIDs {
+		if _, ok := members[id]; ok {
+			problem("memberIDs lists %v more than once", id)
+			continue
+		}
+
+		m, err := t.retrieve_member(stub, id)
+		if err != nil {
+			problem("memberIDs lists %v: %v", id, err)
+			continue
+		}
+
+		members[id] = m
+		positions += m.NetPosition
+		config.Members = append(config.Members, MemberConfig{MemberID: m.MemberID, Name: m.Name, NetDebitCap: m.NetDebitCap})
+	}
+
+	if positions != 0 {
+		problem("Net positions sum to %v instead of zero", format_amount(positions))
+	}
+
+	// Bilateral limits; a member's position must equal what it owes less what it is owed
+	owes := map[string]int64{}
+
+	for _, creditor := range memberHld.MemberIDs {
+		for _, debtor := range memberHld.MemberIDs {
+			if creditor == debtor {
+				continue
+			}
+
+			l, err := t.retrieve_limit(stub, creditor, debtor)
+			if err != nil {
+				problem("%v", err)
+				continue
+			}
+
+			if l.Limit != 0 {
+				config.Limits = append(config.Limits, LimitConfig{Creditor: creditor, Debtor: debtor, Limit: l.Limit})
+			}
+
+			owes[debtor] += l.Owed
+			owes[creditor] -= l.Owed
+		}
+	}
+
+	for _, id := range memberHld.MemberIDs {
+		m, ok := members[id]
+		if ok && owes[id] != m.NetPosition {
+			problem("Member %v has a net position of %v but its bilateral balances net to %v", id, format_amount(m.NetPosition), format_amount(owes[id]))
+		}
+	}
+
+	config.Compliance, err = t.retrieve_compliance_config(stub)
+	if err != nil {
+		problem("%v", err)
+	}
+	config.Compliance.SchemaVersion = 0
+
+	rules, err := t.retrieve_detection_rules(stub)
+	if err != nil {
+		problem("%v", err)
+	}
+	config.Detection = rules.Rules
+
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.New("Error converting network configuration")
+	}
+	sum := sha256.Sum256(bytes)
+	d.ConfigHash = hex.EncodeToString(sum[:])
+
+	// Transfers
+	tranHld, err := t.retrieve_tran_ids(stub)
+	if err != nil {
+		problem("%v", err)
+	}
+	d.Indexes["tranIDs"] = len(tranHld.TranIDs)
+
+	events := map[string]TransactionEvent{}
+
+	for _, id := range tranHld.TranIDs {
+		if _, ok := events[id]; ok {
+			problem("tranIDs lists %v more than once", id)
+			continue
+		}
+
+		e, err := t.retrieve_tranEvent(stub, id)
+		if err != nil {
+			problem("tranIDs lists %v: %v", id, err)
+			continue
+		}
+
+		if e.TranID != id {
+			problem("tranIDs lists %v but the record is for %v", id, e.TranID)
+		}
+
+		events[id] = e
+	}
+	d.EventCount = len(events)
+
+	// Settlement batches; every transfer must be in the batch it names, and only the last batch may be open
+	batchHld, err := t.retrieve_batch_ids(stub)
+	if err != nil {
+		problem("%v", err)
+	}
+	d.Indexes["batchIDs"] = len(batchHld.BatchIDs)
+
+	batched := map[string]string{}
+
+	for i, id := range batchHld.BatchIDs {
+		b, err := t.retrieve_batch(stub, id)
+		if err != nil {
+			problem("batchIDs lists %v: %v", id, err)
+			continue
+		}
+
+		last := i == len(batchHld.BatchIDs)-1
+
+		if last && b.Status != BATCH_OPEN {
+			problem("The last batch %v is %v, not open", id, b.Status)
+		}
+		if !last && b.Status == BATCH_OPEN {
+			problem("Batch %v is open but is not the last batch", id)
+		}
+
+		var total int64
+		for _, tranID := range b.TranIDs {
+			e, ok := events[tranID]
+			if !ok {
+				problem("Batch %v lists %v, which is not in tranIDs", id, tranID)
+				continue
+			}
+			if other, ok := batched[tranID]; ok {
+				problem("%v is in both batch %v and batch %v", tranID, other, id)
+			}
+			batched[tranID] = id
+
+			amount, err := parse_amount(e.Amount)
+			if err != nil {
+				problem("%v has an invalid amount %v", tranID, e.Amount)
+			}
+			total += amount
+		}
+
+		if last && b.Status == BATCH_OPEN {
+			d.OpenBatch = &OpenBatch{BatchID: id, Transfers: len(b.TranIDs), Total: format_amount(total)}
+		}
+	}
+
+	for _, id := range tranHld.TranIDs {
+		e, ok := events[id]
+		if ok && e.BatchID != batched[id] {
+			problem("%v names batch %v but is listed in batch %v", id, e.BatchID, batched[id])
+		}
+	}
+
+	if len(d.Problems) > 0 {
+		d.Status = HEALTH_INCONSISTENT
+	}
+
+	bytes, err = json.Marshal(d)
+	if err != nil {
+		return nil, errors.New("Error converting diagnostics")
+	}
+
+	return bytes, nil
+}
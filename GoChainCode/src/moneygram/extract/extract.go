@@ -0,0 +1,238 @@
+// Package extract writes auditor extracts of the ledger: the transfers an
+// auditor may see, created in a date range, in the column layout of the web
+// application's TransactionLedgerDO. Extracts are CSV, JSON or XLSX and are
+// written as the ledger is scanned, so their size is not limited by memory.
+// Each comes with a Manifest of what it holds.
+//
+// Which transfers an auditor may see is decided by the chaincode from the
+// identity the Source queries as: a user with the auditor role sees their
+// own member's transfers, and one acting for the member that deployed the
+// network sees every member's, as an admin does.
+package extract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"moneygram/model"
+)
+
+// Extract formats.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatXLSX = "xlsx"
+)
+
+// Columns are the fields of TransactionLedgerDO, in its order.
+var Columns = []string{"senderName", "senderCountryName", "receiverName", "receiverCountryName",
+	"amount", "dateTime", "depositAccountNumber"}
+
+// Source runs chaincode queries. fabric.Client satisfies it.
+type Source interface {
+	Query(function string, args []string) ([]byte, error)
+}
+
+const pageSize = 200
+
+// Record is one row of an extract.
+type Record struct {
+	SenderName           string `json:"senderName"`
+	SenderCountryName    string `json:"senderCountryName"`
+	ReceiverName         string `json:"receiverName"`
+	ReceiverCountryName  string `json:"receiverCountryName"`
+	Amount               string `json:"amount"`
+	DateTime             string `json:"dateTime"`
+	DepositAccountNumber string `json:"depositAccountNumber"`
+}
+
+func (r Record) values() []string {
+	return []string{r.SenderName, r.SenderCountryName, r.ReceiverName, r.ReceiverCountryName,
+		r.Amount, r.DateTime, r.DepositAccountNumber}
+}
+
+// Options choose what an extract holds.
+type Options struct {
+	// Member narrows the extract to one member's transfers. The chaincode
+	// refuses a member the caller may not see.
+	Member string
+	// From and To are the first and last days, YYYY-MM-DD, the transfers
+	// were created on. Either may be empty for an open range.
+	From string
+	To   string
+	// Format is FormatCSV, FormatJSON or FormatXLSX.
+	Format string
+}
+
+// Manifest describes an extract. Member is the member the chaincode limited
+// it to, empty when it holds every member's transfers. SHA256 and Bytes
+// cover the extract exactly as written. Transfers created before the ledger
+// recorded creation times cannot be placed in a date range; when one is
+// given they are left out and counted in Undated.
+type Manifest struct {
+	Member      string   `json:"member,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          string   `json:"to,omitempty"`
+	Format      string   `json:"format"`
+	Columns     []string `json:"columns"`
+	GeneratedAt string   `json:"generatedAt"`
+	Records     int      `json:"records"`
+	Amount      string   `json:"amount"`
+	Currency    string   `json:"currency"`
+	Undated     int      `json:"undated"`
+	Bytes       int64    `json:"bytes"`
+	SHA256      string   `json:"sha256"`
+}
+
+// rowWriter writes one format.
+type rowWriter interface {
+	header(columns []string) error
+	row(r Record) error
+	close(m Manifest) error
+}
+
+// Write writes the extract opts asks for to w and returns its manifest.
+func Write(src Source, w io.Writer, opts Options) (Manifest, error) {
+	for _, day := range []string{opts.From, opts.To} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			return Manifest{}, fmt.Errorf("invalid date %q: want YYYY-MM-DD", day)
+		}
+	}
+	if opts.From != "" && opts.To != "" && opts.From > opts.To {
+		return Manifest{}, errors.New("from is after to")
+	}
+
+	m := Manifest{
+		Member:      opts.Member,
+		From:        opts.From,
+		To:          opts.To,
+		Format:      opts.Format,
+		Columns:     Columns,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Currency:    model.SettlementCurrency,
+	}
+
+	out := &countingWriter{w: w, h: sha256.New()}
+	var rw rowWriter
+	switch opts.Format {
+	case FormatCSV:
+		rw = newCSVWriter(out)
+	case FormatJSON:
+		rw = newJSONWriter(out)
+	case FormatXLSX:
+		rw = newXLSXWriter(out)
+	default:
+		return Manifest{}, fmt.Errorf("unknown format %q", opts.Format)
+	}
+
+	if err := rw.header(Columns); err != nil {
+		return m, err
+	}
+
+	var total int64
+	for position := 0; ; {
+		args := []string{strconv.Itoa(position), strconv.Itoa(pageSize)}
+		if opts.Member != "" {
+			args = append(args, opts.Member)
+		}
+		data, err := src.Query("scan_events", args)
+		if err != nil {
+			return m, err
+		}
+		var scan model.EventScan
+		if err := json.Unmarshal(data, &scan); err != nil {
+			return m, errors.New("scan_events: " + err.Error())
+		}
+		m.Member = scan.Member
+
+		for _, e := range scan.Events {
+			if !inRange(e, opts.From, opts.To, &m) {
+				continue
+			}
+
+			cents, err := model.ParseAmount(e.Amount)
+			if err != nil {
+				return m, errors.New("transfer " + e.TranID + ": " + err.Error())
+			}
+			total += cents
+
+			if err := rw.row(recordOf(e, cents)); err != nil {
+				return m, err
+			}
+			m.Records++
+		}
+
+		position = scan.Next
+		if !scan.More {
+			break
+		}
+	}
+
+	m.Amount = model.FormatAmount(total)
+	if err := rw.close(m); err != nil {
+		return m, err
+	}
+
+	m.Bytes = out.n
+	m.SHA256 = hex.EncodeToString(out.h.Sum(nil))
+	return m, nil
+}
+
+// inRange reports whether e was created between from and to, counting it in
+// m.Undated when a range is given and e has no creation time.
+func inRange(e model.TransactionEvent, from string, to string, m *Manifest) bool {
+	if from == "" && to == "" {
+		return true
+	}
+	if len(e.CreatedAt) < len("2006-01-02") {
+		m.Undated++
+		return false
+	}
+	day := e.CreatedAt[:len("2006-01-02")]
+	return (from == "" || day >= from) && (to == "" || day <= to)
+}
+
+func recordOf(e model.TransactionEvent, cents int64) Record {
+	dateTime := e.CreatedAt
+	if dateTime == "" {
+		dateTime = e.DateTime
+	}
+	return Record{
+		SenderName:           e.SenderName,
+		SenderCountryName:    e.SenderCountry,
+		ReceiverName:         e.ReceiverName,
+		ReceiverCountryName:  e.ReceiverCountry,
+		Amount:               model.FormatAmount(cents),
+		DateTime:             dateTime,
+		DepositAccountNumber: maskAccount(e.AccountNumber),
+	}
+}
+
+// maskAccount hides all but the last four characters of an account number,
+// as the web application shows them.
+func maskAccount(account string) string {
+	if len(account) <= 4 {
+		return account
+	}
+	return "******" + account[len(account)-4:]
+}
+
+type countingWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
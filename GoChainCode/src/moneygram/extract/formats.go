@@ -0,0 +1,196 @@
+package extract
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) header(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) row(r Record) error {
+	return c.w.Write(r.values())
+}
+
+func (c *csvWriter) close(m Manifest) error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter writes a JSON array of records, one to a line.
+type jsonWriter struct {
+	w     *bufio.Writer
+	first bool
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{w: bufio.NewWriter(w), first: true}
+}
+
+func (j *jsonWriter) header(columns []string) error {
+	_, err := j.w.WriteString("[")
+	return err
+}
+
+func (j *jsonWriter) row(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.first {
+		sep, j.first = "\n", false
+	}
+	j.w.WriteString(sep)
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) close(m Manifest) error {
+	j.w.WriteString("\n]\n")
+	return j.w.Flush()
+}
+
+// xlsxWriter writes an Office Open XML workbook with the records on an
+// Extract sheet and the manifest on a Manifest sheet. Cells hold inline
+// strings, apart from amounts, so the sheet streams without a shared string
+// table. A zip archive is written one file at a time, which is why the
+// manifest sheet follows the records rather than leading them.
+type xlsxWriter struct {
+	z     *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+const (
+	xlsxMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRels = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xlsxDoc  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxType = "application/vnd.openxmlformats-officedocument.spreadsheetml"
+)
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="` + xlsxType + `.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="` + xlsxType + `.worksheet+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet2.xml" ContentType="` + xlsxType + `.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="` + xlsxRels + `">` +
+		`<Relationship Id="rId1" Type="` + xlsxDoc + `/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="` + xlsxMain + `" xmlns:r="` + xlsxDoc + `"><sheets>` +
+		`<sheet name="Extract" sheetId="1" r:id="rId1"/>` +
+		`<sheet name="Manifest" sheetId="2" r:id="rId2"/>` +
+		`</sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + xlsxRels + `">` +
+		`<Relationship Id="rId1" Type="` + xlsxDoc + `/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="` + xlsxDoc + `/worksheet" Target="worksheets/sheet2.xml"/>` +
+		`</Relationships>`},
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{z: zip.NewWriter(w)}
+}
+
+func (x *xlsxWriter) create(name string) (io.Writer, error) {
+	return x.z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
+
+func (x *xlsxWriter) header(columns []string) error {
+	for _, part := range xlsxParts {
+		f, err := x.create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := x.create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(xml.Header + `<worksheet xmlns="` + xlsxMain + `"><sheetData>`)
+	return x.writeRow(columns, -1)
+}
+
+func (x *xlsxWriter) row(r Record) error {
+	// The amount, the fifth column, is written as a number
+	return x.writeRow(r.values(), 4)
+}
+
+func (x *xlsxWriter) close(m Manifest) error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+
+	f, err := x.create("xl/worksheets/sheet2.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet, x.rows = bufio.NewWriter(f), 0
+	x.sheet.WriteString(xml.Header + `<worksheet xmlns="` + xlsxMain + `"><sheetData>`)
+	for _, kv := range [][]string{
+		{"member", m.Member},
+		{"from", m.From},
+		{"to", m.To},
+		{"generatedAt", m.GeneratedAt},
+		{"records", strconv.Itoa(m.Records)},
+		{"amount", m.Amount},
+		{"currency", m.Currency},
+		{"undated", strconv.Itoa(m.Undated)},
+		{"columns", strings.Join(m.Columns, ",")},
+	} {
+		if err := x.writeRow(kv, -1); err != nil {
+			return err
+		}
+	}
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+
+	return x.z.Close()
+}
+
+// writeRow writes a row of inline strings, apart from column number, which
+// is written as a number. The buffered writer keeps the first write error,
+// so the last write of the row returns it.
+func (x *xlsxWriter) writeRow(values []string, number int) error {
+	x.rows++
+	x.sheet.WriteString(`<row r="` + strconv.Itoa(x.rows) + `">`)
+	for i, v := range values {
+		if i == number {
+			x.sheet.WriteString(`<c t="n"><v>`)
+			xml.EscapeText(x.sheet, []byte(v))
+			x.sheet.WriteString(`</v></c>`)
+			continue
+		}
+		x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(x.sheet, []byte(v))
+		x.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
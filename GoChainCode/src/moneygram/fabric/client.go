@@ -0,0 +1,131 @@
+// Package fabric is a small client for the Fabric v0.6 peer REST API. It sends
+// the same JSON-RPC bodies as the samples in JSON_Scripts.
+package fabric
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Client talks to the /chaincode endpoint of a single peer.
+type Client struct {
+	// URL is the peer's REST address, e.g. http://localhost:7050.
+	URL string
+	// ChaincodeID is the name returned when the chaincode was deployed.
+	ChaincodeID string
+	// SecureContext is the enrolled user the peer signs transactions as.
+	SecureContext string
+	// HTTPClient is used for requests; http.DefaultClient when nil.
+	HTTPClient *http.Client
+
+	id int64
+}
+
+type chaincodeID struct {
+	Name string `json:"name"`
+}
+
+type ctorMsg struct {
+	Function string   `json:"function"`
+	Args     []string `json:"args"`
+}
+
+type params struct {
+	Type          int         `json:"type"`
+	ChaincodeID   chaincodeID `json:"chaincodeID"`
+	CtorMsg       ctorMsg     `json:"ctorMsg"`
+	SecureContext string      `json:"secureContext,omitempty"`
+}
+
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  params `json:"params"`
+	ID      int64  `json:"id"`
+}
+
+type result struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+type response struct {
+	Result *result   `json:"result"`
+	Error  *rpcError `json:"error"`
+}
+
+// Invoke submits a transaction. The peer returns the transaction ID; the
+// chaincode's own return value is not available until the block commits.
+func (c *Client) Invoke(function string, args []string) (string, error) {
+	return c.call("invoke", function, args)
+}
+
+// Query runs a read-only chaincode function and returns its result.
+func (c *Client) Query(function string, args []string) ([]byte, error) {
+	msg, err := c.call("query", function, args)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(msg), nil
+}
+
+func (c *Client) call(method string, function string, args []string) (string, error) {
+	if args == nil {
+		args = []string{}
+	}
+
+	body, err := json.Marshal(request{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params: params{
+			Type:          1,
+			ChaincodeID:   chaincodeID{Name: c.ChaincodeID},
+			CtorMsg:       ctorMsg{Function: function, Args: args},
+			SecureContext: c.SecureContext,
+		},
+		ID: atomic.AddInt64(&c.id, 1),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := httpClient.Post(strings.TrimRight(c.URL, "/")+"/chaincode", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("%s %s: decoding peer response: %v", method, function, err)
+	}
+
+	if r.Error != nil {
+		return "", fmt.Errorf("%s %s: %s: %s", method, function, r.Error.Message, r.Error.Data)
+	}
+	if r.Result == nil {
+		return "", errors.New(method + " " + function + ": empty peer response")
+	}
+	if r.Result.Status != "OK" {
+		return "", fmt.Errorf("%s %s: %s", method, function, r.Result.Message)
+	}
+
+	return r.Result.Message, nil
+}
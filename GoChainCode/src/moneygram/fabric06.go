@@ -0,0 +1,28 @@
+//go:build !fabric1 && !loadgen && !devpeer
+// +build !fabric1,!loadgen,!devpeer
+
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Fabric v0.6 entry points - The v0.6 peer calls Init, Invoke and Query on SimpleChaincode directly. This is the
+//								default build; build with the fabric1 tag for a Fabric 1.x network instead, or with the
+//								loadgen tag to run synthetic traffic through it in-process (see loadgen.go).
+//==============================================================================================================================
+func main() {
+	err := shim.Start(new(SimpleChaincode))
+	if err != nil {
+		fmt.Printf("Error starting Simple chaincode: %s", err)
+	}
+}
+
+//==============================================================================================================================
+//	 read_cert_attribute - Reads an attribute from the caller's eCert.
+//==============================================================================================================================
+func read_cert_attribute(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	return stub.ReadCertAttribute(name)
+}
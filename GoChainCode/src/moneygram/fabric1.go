@@ -0,0 +1,150 @@
+//go:build fabric1
+// +build fabric1
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"unicode/utf8"
+)
+
+//==============================================================================================================================
+//	 Fabric 1.x entry points - Fabric 1.x calls Init(stub) and Invoke(stub) and has no separate query call. Fabric1Chaincode
+//							   wraps SimpleChaincode so the functions and state layout are the same as on v0.6: the function
+//							   name and args come from GetFunctionAndParameters, and the functions registered as queries in
+//							   the routing table are routed to Query. Build with the fabric1 tag against a Fabric 1.x shim.
+//==============================================================================================================================
+type Fabric1Chaincode struct {
+	SimpleChaincode
+}
+
+//==============================================================================================================================
+//	 Attribute extension - Fabric CA stores the attributes of an enrollment certificate as JSON, {"attrs":{"role":"admin"}},
+//						   in an extension with this OID.
+//==============================================================================================================================
+var attrs_oid = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+func main() {
+	err := shim.Start(new(Fabric1Chaincode))
+	if err != nil {
+		fmt.Printf("Error starting Simple chaincode: %s", err)
+	}
+}
+
+//==============================================================================================================================
+//	 Init - Called when the chaincode is instantiated or upgraded, with the same function and args a v0.6 deploy used.
+//==============================================================================================================================
+func (t *Fabric1Chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	return to_response(t.SimpleChaincode.Init(stub, function, args))
+}
+
+//==============================================================================================================================
+//	 Invoke - Called for every transaction proposal, invoke or query.
+//==============================================================================================================================
+func (t *Fabric1Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	if t.routes().IsQuery(function) {
+		return to_response(t.SimpleChaincode.Query(stub, function, args))
+	}
+
+	return to_response(t.SimpleChaincode.Invoke(stub, function, args))
+}
+
+//==============================================================================================================================
+//	 to_response - Converts the ([]byte, error) result of a v0.6 style function into a pb.Response.
+//==============================================================================================================================
+func to_response(payload []byte, err error) pb.Response {
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+//==============================================================================================================================
+//	 read_cert_attribute - Reads an attribute from the enrollment certificate of the identity that created the proposal.
+//==============================================================================================================================
+func read_cert_attribute(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return nil, errors.New("Couldn't get creator. Error: " + err.Error())
+	}
+
+	var identity msp.SerializedIdentity
+	err = proto.Unmarshal(creator, &identity)
+	if err != nil {
+		return nil, errors.New("Couldn't decode creator identity. Error: " + err.Error())
+	}
+
+	block, _ := pem.Decode(identity.IdBytes)
+	if block == nil {
+		return nil, errors.New("Creator identity is not a PEM certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New("Couldn't parse creator certificate. Error: " + err.Error())
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(attrs_oid) {
+			continue
+		}
+
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		err = json.Unmarshal(ext.Value, &attrs)
+		if err != nil {
+			return nil, errors.New("Corrupt attribute extension in creator certificate")
+		}
+
+		value, ok := attrs.Attrs[name]
+		if ok {
+			return []byte(value), nil
+		}
+	}
+
+	return nil, errors.New("Attribute '" + name + "' not found in creator certificate")
+}
+
+//==============================================================================================================================
+//	 scan_keys - Returns up to limit keys that start with prefix and sort after the key after, in key order. An empty
+//				 after starts from the first key with the prefix. The Fabric 1.x range query excludes its end key.
+//==============================================================================================================================
+func scan_keys(stub shim.ChaincodeStubInterface, prefix string, after string, limit int) ([]string, error) {
+
+	start := prefix
+	if after != "" {
+		start = after + "\x00"
+	}
+
+	iter, err := stub.GetStateByRange(start, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, errors.New("Error scanning " + prefix + " keys")
+	}
+	defer iter.Close()
+
+	keys := []string{}
+	for iter.HasNext() && len(keys) < limit {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, errors.New("Error scanning " + prefix + " keys")
+		}
+		keys = append(keys, kv.Key)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,500 @@
+// Package gateway serves a typed JSON API over the MoneyGram chaincode for the
+// web application. Each request becomes one chaincode invoke or query on a
+// Backend, a Fabric v0.6 peer through fabric.Client. For development, point
+// it at the chaincode built with the devpeer tag.
+//
+// When the Server has Tokens, every request must carry a bearer token and
+// acts as the enrolled user the token was issued to. A request may still
+// send the UserHeader, but only naming that same user.
+//
+// Transfers and pickup checks carry a plain pickupCode, which the gateway
+// hashes under its PickupKey before it goes to the chaincode; payout
+// confirmations carry the receiver's ID, hashed under its ReceiverIDKey.
+//
+//	POST /api/transfers                  create_event
+//	POST /api/transfers/batch            create_events, all or none
+//	GET  /api/transfers?offset=&limit=   get_events
+//	GET  /api/transfers/{tranID}         get_event_details
+//	GET  /api/transfers/{tranID}/proof   get_inclusion_proof
+//	GET  /api/transfers/{tranID}/payout  get_payout
+//	POST /api/transfers/{tranID}/payout  confirm_payout
+//	GET  /api/audit?member=              scan_events, to the end, with totals
+//	GET  /api/settlement/batches         get_settlement_batches
+//	GET  /api/settlement/batches/{id}    get_settlement_batch
+//	GET  /api/pickups/{reference}        get_pickup
+//	POST /api/pickups/{reference}/verify verify_pickup
+//	GET  /api/diagnostics                diagnostics
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"moneygram/model"
+)
+
+// Backend runs chaincode functions. fabric.Client satisfies it.
+type Backend interface {
+	Invoke(function string, args []string) (string, error)
+	Query(function string, args []string) ([]byte, error)
+}
+
+// UserHeader names the enrolled user a request acts as. It is only accepted
+// from a request whose bearer token was issued to that user.
+const UserHeader = "X-Secure-Context"
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Server is an http.Handler for the gateway API.
+type Server struct {
+	// Backend serves requests that are not made as an enrolled user.
+	Backend Backend
+	// ForUser, when set, returns the backend for the enrolled user a
+	// request authenticated as, so the chaincode sees that user's eCert.
+	ForUser func(user string) Backend
+	// Tokens maps the hex SHA-256 of each bearer token to the enrolled user
+	// it was issued to. When it is nil no request can act as a user.
+	Tokens map[string]string
+	// PickupKey is the network's pickup key; see model.PickupCodeHash.
+	// Without it cash pickup transfers cannot be created or verified.
+	PickupKey []byte
+	// ReceiverIDKey is the payout member's key for receivers' ID documents;
+	// see model.ReceiverIDHash. Without it payouts cannot be confirmed.
+	ReceiverIDKey []byte
+}
+
+type userKey struct{}
+
+// CreateResponse is returned when a transfer has been submitted. On a Fabric
+// peer the transfer is only on the ledger once the transaction commits.
+type CreateResponse struct {
+	TxID   string `json:"txID"`
+	TranID string `json:"tranID"`
+}
+
+// CreateBatchResponse is returned when a batch of transfers has been
+// submitted. On a Fabric peer the transfers are only on the ledger, all of
+// them or none, once the transaction commits.
+type CreateBatchResponse struct {
+	TxID    string   `json:"txID"`
+	TranIDs []string `json:"tranIDs"`
+}
+
+// VerifyPickupRequest is the body of a pickup code check.
+type VerifyPickupRequest struct {
+	PickupCode string `json:"pickupCode"`
+}
+
+// VerifyPickupResponse is returned when a pickup code check has been
+// submitted. verify_pickup returns nothing, so the result is only known once
+// the transaction commits; read it from GET /api/pickups/{reference}.
+type VerifyPickupResponse struct {
+	TxID            string `json:"txID"`
+	ReferenceNumber string `json:"referenceNumber"`
+}
+
+// ConfirmPayoutRequest is the body of a payout confirmation. The currency and
+// amount must be those the transfer promised its receiver.
+type ConfirmPayoutRequest struct {
+	IDType   string `json:"idType"`
+	IDNumber string `json:"idNumber"`
+	Location string `json:"location"`
+	Currency string `json:"currency"`
+	Amount   string `json:"amount"`
+}
+
+// ConfirmPayoutResponse is returned when a payout confirmation has been
+// submitted. On a Fabric v0.6 peer the transfer is only paid once the
+// transaction commits; read it from GET /api/transfers/{tranID}/payout.
+type ConfirmPayoutResponse struct {
+	TxID   string `json:"txID"`
+	TranID string `json:"tranID"`
+}
+
+// AuditReport lists every transfer a member sent or paid out, with totals.
+type AuditReport struct {
+	Member    string                   `json:"member,omitempty"`
+	Count     int                      `json:"count"`
+	Total     string                   `json:"total"`
+	Currency  string                   `json:"currency"`
+	Transfers []model.TransactionEvent `json:"transfers"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, status, err := s.authenticate(r)
+	if err != nil {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mgigateway"`)
+		}
+		writeError(w, status, err)
+		return
+	}
+	if user != "" {
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+	}
+
+	path := strings.TrimRight(r.URL.Path, "/")
+
+	switch {
+	case path == "/api/transfers" && r.Method == http.MethodPost:
+		s.createTransfer(w, r)
+	case path == "/api/transfers/batch" && r.Method == http.MethodPost:
+		s.createTransfers(w, r)
+	case path == "/api/transfers" && r.Method == http.MethodGet:
+		s.listTransfers(w, r)
+	case strings.HasPrefix(path, "/api/transfers/") && strings.HasSuffix(path, "/proof") && r.Method == http.MethodGet:
+		s.query(w, r, "get_inclusion_proof", strings.TrimSuffix(strings.TrimPrefix(path, "/api/transfers/"), "/proof"))
+	case strings.HasPrefix(path, "/api/transfers/") && strings.HasSuffix(path, "/payout") && r.Method == http.MethodPost:
+		s.confirmPayout(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/api/transfers/"), "/payout"))
+	case strings.HasPrefix(path, "/api/transfers/") && strings.HasSuffix(path, "/payout") && r.Method == http.MethodGet:
+		s.query(w, r, "get_payout", strings.TrimSuffix(strings.TrimPrefix(path, "/api/transfers/"), "/payout"))
+	case strings.HasPrefix(path, "/api/transfers/") && r.Method == http.MethodGet:
+		s.query(w, r, "get_event_details", strings.TrimPrefix(path, "/api/transfers/"))
+	case path == "/api/audit" && r.Method == http.MethodGet:
+		s.audit(w, r)
+	case path == "/api/settlement/batches" && r.Method == http.MethodGet:
+		s.query(w, r, "get_settlement_batches")
+	case strings.HasPrefix(path, "/api/settlement/batches/") && r.Method == http.MethodGet:
+		s.query(w, r, "get_settlement_batch", strings.TrimPrefix(path, "/api/settlement/batches/"))
+	case strings.HasPrefix(path, "/api/pickups/") && strings.HasSuffix(path, "/verify") && r.Method == http.MethodPost:
+		s.verifyPickup(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/api/pickups/"), "/verify"))
+	case strings.HasPrefix(path, "/api/pickups/") && r.Method == http.MethodGet:
+		s.query(w, r, "get_pickup", strings.TrimPrefix(path, "/api/pickups/"))
+	case path == "/api/diagnostics" && r.Method == http.MethodGet:
+		s.query(w, r, "diagnostics")
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.Method+" "+r.URL.Path))
+	}
+}
+
+// authenticate returns the enrolled user a request acts as, or the status
+// to refuse it with. Without Tokens the UserHeader is refused, as nothing
+// vouches for the name in it.
+func (s *Server) authenticate(r *http.Request) (string, int, error) {
+	named := r.Header.Get(UserHeader)
+
+	if s.Tokens == nil {
+		if named != "" {
+			return "", http.StatusForbidden, errors.New(UserHeader + " is not accepted by this gateway")
+		}
+		return "", 0, nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", http.StatusUnauthorized, errors.New("a bearer token is required")
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))))
+	user, ok := s.Tokens[hex.EncodeToString(sum[:])]
+	if !ok {
+		return "", http.StatusUnauthorized, errors.New("unknown bearer token")
+	}
+
+	if named != "" && named != user {
+		return "", http.StatusForbidden, errors.New("the bearer token was not issued to " + named)
+	}
+	return user, 0, nil
+}
+
+func (s *Server) backend(r *http.Request) Backend {
+	if user, _ := r.Context().Value(userKey{}).(string); user != "" && s.ForUser != nil {
+		return s.ForUser(user)
+	}
+	return s.Backend
+}
+
+func (s *Server) createTransfer(w http.ResponseWriter, r *http.Request) {
+	var e model.TransactionEvent
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid transfer: "+err.Error()))
+		return
+	}
+
+	if e.TranID == "" || e.SendingMember == "" || e.PayoutMember == "" {
+		writeError(w, http.StatusBadRequest, errors.New("tranID, sendingMember and payoutMember are required"))
+		return
+	}
+	if cents, err := model.ParseAmount(e.Amount); err != nil || cents == 0 {
+		if err == nil {
+			err = errors.New("amount must be greater than zero")
+		}
+		writeError(w, http.StatusBadRequest, errors.New("invalid amount: "+err.Error()))
+		return
+	}
+	if err := e.HashPickupCode(s.PickupKey); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	txID, err := s.backend(r).Invoke("create_event", e.CreateArgs())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, CreateResponse{TxID: txID, TranID: e.TranID})
+}
+
+func (s *Server) createTransfers(w http.ResponseWriter, r *http.Request) {
+	var transfers []model.TransactionEvent
+	if err := json.NewDecoder(r.Body).Decode(&transfers); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid transfers: "+err.Error()))
+		return
+	}
+
+	if len(transfers) == 0 || len(transfers) > model.MaxBulkEvents {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("between 1 and %d transfers are required", model.MaxBulkEvents))
+		return
+	}
+
+	tranIDs := make([]string, len(transfers))
+	for i := range transfers {
+		tranIDs[i] = transfers[i].TranID
+		if err := transfers[i].HashPickupCode(s.PickupKey); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("transfer %s: %v", tranIDs[i], err))
+			return
+		}
+	}
+
+	arg, err := model.CreateEventsArg(transfers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	txID, err := s.backend(r).Invoke("create_events", []string{arg})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, CreateBatchResponse{TxID: txID, TranIDs: tranIDs})
+}
+
+func (s *Server) verifyPickup(w http.ResponseWriter, r *http.Request, reference string) {
+	var req VerifyPickupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request: "+err.Error()))
+		return
+	}
+	if reference == "" || strings.Contains(reference, "/") {
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.URL.Path))
+		return
+	}
+	if req.PickupCode == "" {
+		writeError(w, http.StatusBadRequest, errors.New("pickupCode is required"))
+		return
+	}
+
+	// The code is hashed with the tranID, which only the reference number's pickup record gives
+	backend := s.backend(r)
+	out, err := backend.Query("get_pickup", []string{reference})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	var status model.PickupStatus
+	if err := json.Unmarshal(out, &status); err != nil {
+		writeError(w, http.StatusBadGateway, errors.New("get_pickup: "+err.Error()))
+		return
+	}
+	hash, err := model.PickupCodeHash(s.PickupKey, status.TranID, req.PickupCode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	txID, err := backend.Invoke("verify_pickup", []string{reference, hash})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, VerifyPickupResponse{TxID: txID, ReferenceNumber: reference})
+}
+
+func (s *Server) confirmPayout(w http.ResponseWriter, r *http.Request, tranID string) {
+	var req ConfirmPayoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request: "+err.Error()))
+		return
+	}
+	if tranID == "" || strings.Contains(tranID, "/") {
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.URL.Path))
+		return
+	}
+	for _, f := range []struct{ name, value string }{
+		{"idType", req.IDType}, {"idNumber", req.IDNumber}, {"location", req.Location},
+		{"currency", req.Currency}, {"amount", req.Amount},
+	} {
+		if f.value == "" {
+			writeError(w, http.StatusBadRequest, errors.New(f.name+" is required"))
+			return
+		}
+	}
+
+	idHash, err := model.ReceiverIDHash(s.ReceiverIDKey, tranID, req.IDType, req.IDNumber)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	args := []string{tranID, idHash, req.Location, req.Currency, req.Amount}
+	txID, err := s.backend(r).Invoke("confirm_payout", args)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, ConfirmPayoutResponse{TxID: txID, TranID: tranID})
+}
+
+func (s *Server) listTransfers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	offset, err := intParam(q.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid offset"))
+		return
+	}
+	limit, err := intParam(q.Get("limit"), defaultLimit)
+	if err != nil || limit <= 0 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxLimit)))
+		return
+	}
+
+	page, err := getEvents(s.backend(r), offset, limit, q.Get("member"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) audit(w http.ResponseWriter, r *http.Request) {
+	member := r.URL.Query().Get("member")
+	backend := s.backend(r)
+
+	report := AuditReport{Member: member, Currency: model.SettlementCurrency, Transfers: []model.TransactionEvent{}}
+	var total int64
+
+	for position := 0; ; {
+		scan, err := scanEvents(backend, position, maxLimit, member)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		report.Member = scan.Member
+
+		for _, e := range scan.Events {
+			cents, err := model.ParseAmount(e.Amount)
+			if err != nil {
+				writeError(w, http.StatusBadGateway, errors.New("transfer "+e.TranID+": "+err.Error()))
+				return
+			}
+			total += cents
+			report.Transfers = append(report.Transfers, e)
+		}
+
+		position = scan.Next
+		if !scan.More {
+			break
+		}
+	}
+
+	report.Count = len(report.Transfers)
+	report.Total = model.FormatAmount(total)
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request, function string, args ...string) {
+	for _, a := range args {
+		if a == "" || strings.Contains(a, "/") {
+			writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.URL.Path))
+			return
+		}
+	}
+
+	out, err := s.backend(r).Query(function, args)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if !json.Valid(out) {
+		writeError(w, http.StatusBadGateway, errors.New(function+" returned invalid JSON"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+func getEvents(backend Backend, offset int, limit int, member string) (model.EventPage, error) {
+	var page model.EventPage
+
+	args := []string{strconv.Itoa(offset), strconv.Itoa(limit)}
+	if member != "" {
+		args = append(args, member)
+	}
+
+	out, err := backend.Query("get_events", args)
+	if err != nil {
+		return page, err
+	}
+	if err := json.Unmarshal(out, &page); err != nil {
+		return page, errors.New("get_events: " + err.Error())
+	}
+	return page, nil
+}
+
+func scanEvents(backend Backend, position int, limit int, member string) (model.EventScan, error) {
+	var scan model.EventScan
+
+	args := []string{strconv.Itoa(position), strconv.Itoa(limit)}
+	if member != "" {
+		args = append(args, member)
+	}
+
+	out, err := backend.Query("scan_events", args)
+	if err != nil {
+		return scan, err
+	}
+	if err := json.Unmarshal(out, &scan); err != nil {
+		return scan, errors.New("scan_events: " + err.Error())
+	}
+	return scan, nil
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
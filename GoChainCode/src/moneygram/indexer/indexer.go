@@ -0,0 +1,424 @@
+// Package indexer copies remittance history from the ledger into a SQL
+// database so analysts can query it without range scans on a peer. It replays
+// ledger state through the chaincode's queries: transfers in tranIDs order
+// with get_events, and settlement batches with get_settlement_batch.
+//
+// Progress is kept in the checkpoints table in the same database
+// transaction as the rows it covers, so an indexer that stops at any point
+// resumes where it left off. Transfers change status after they are
+// created; every sync reads the changes made since the last one with
+// get_changes and rewrites the transfers they name.
+//
+// The SQL is plain enough for SQLite, which is what mgiindex uses.
+package indexer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"moneygram/model"
+)
+
+// Source runs chaincode queries. fabric.Client satisfies it. The indexer
+// must query as an admin to see every member's transfers.
+type Source interface {
+	Query(function string, args []string) ([]byte, error)
+}
+
+// Checkpoint names.
+const (
+	// CheckpointTransfers is the number of transfers in tranIDs order that
+	// have been indexed.
+	CheckpointTransfers = "transfers"
+	// CheckpointChanges is the number of status changes, in the order they
+	// were made, that have been indexed.
+	CheckpointChanges = "changes"
+	// CheckpointBatches is the number of batches, in batchIDs order, that
+	// were closed when indexed and so will not change again.
+	CheckpointBatches = "batches"
+)
+
+const defaultPageSize = 200
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS transfers (
+		tran_id          TEXT PRIMARY KEY,
+		seq              INTEGER NOT NULL,
+		sender_name      TEXT NOT NULL,
+		sender_country   TEXT NOT NULL,
+		receiver_name    TEXT NOT NULL,
+		receiver_country TEXT NOT NULL,
+		amount_cents     INTEGER NOT NULL,
+		fee_cents        INTEGER NOT NULL,
+		currency         TEXT NOT NULL,
+		sending_member   TEXT NOT NULL,
+		payout_member    TEXT NOT NULL,
+		batch_id         TEXT NOT NULL,
+		status           TEXT NOT NULL,
+		payout_currency  TEXT,
+		payout_amount    TEXT,
+		created_at       TEXT,
+		updated_at       TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS transfers_sending ON transfers (sending_member, created_at)`,
+	`CREATE INDEX IF NOT EXISTS transfers_payout ON transfers (payout_member, created_at)`,
+	`CREATE INDEX IF NOT EXISTS transfers_batch ON transfers (batch_id)`,
+	`CREATE TABLE IF NOT EXISTS status_changes (
+		tran_id TEXT NOT NULL,
+		n       INTEGER NOT NULL,
+		status  TEXT NOT NULL,
+		at      TEXT NOT NULL,
+		tx_id   TEXT NOT NULL,
+		PRIMARY KEY (tran_id, n)
+	)`,
+	`CREATE TABLE IF NOT EXISTS batches (
+		batch_id  TEXT PRIMARY KEY,
+		seq       INTEGER NOT NULL,
+		status    TEXT NOT NULL,
+		currency  TEXT NOT NULL,
+		transfers INTEGER NOT NULL,
+		opened_at TEXT,
+		closed_at TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS obligations (
+		batch_id     TEXT NOT NULL,
+		debtor       TEXT NOT NULL,
+		creditor     TEXT NOT NULL,
+		amount_cents INTEGER NOT NULL,
+		PRIMARY KEY (batch_id, debtor, creditor)
+	)`,
+	`CREATE TABLE IF NOT EXISTS checkpoints (
+		name     TEXT PRIMARY KEY,
+		position INTEGER NOT NULL
+	)`,
+}
+
+// Indexer replays the ledger into DB.
+type Indexer struct {
+	DB     *sql.DB
+	Source Source
+	// PageSize is the number of transfers read per get_events or
+	// get_changes query; 200 when zero.
+	PageSize int
+}
+
+// SyncStats reports what one Sync wrote.
+type SyncStats struct {
+	Transfers     int `json:"transfers"`
+	Changes       int `json:"changes"`
+	Batches       int `json:"batches"`
+	Checkpoint    int `json:"checkpoint"`
+	ClosedBatches int `json:"closedBatches"`
+}
+
+// Init creates the tables that do not exist yet.
+func (ix *Indexer) Init() error {
+	for _, stmt := range schema {
+		if _, err := ix.DB.Exec(stmt); err != nil {
+			return fmt.Errorf("creating schema: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reset clears the checkpoints so the next Sync replays the whole ledger.
+// Indexed rows are kept and overwritten as they are read again.
+func (ix *Indexer) Reset() error {
+	_, err := ix.DB.Exec(`DELETE FROM checkpoints`)
+	return err
+}
+
+// Checkpoint returns the position stored under name, or 0.
+func (ix *Indexer) Checkpoint(name string) (int, error) {
+	var position int
+	err := ix.DB.QueryRow(`SELECT position FROM checkpoints WHERE name = ?`, name).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return position, err
+}
+
+// Sync indexes every transfer and batch added or changed since the last
+// Sync. Each page of transfers and each batch is committed with its
+// checkpoint, so an error leaves the database consistent and the next Sync
+// retries from there.
+func (ix *Indexer) Sync() (SyncStats, error) {
+	var stats SyncStats
+
+	if err := ix.syncTransfers(&stats); err != nil {
+		return stats, err
+	}
+	if err := ix.syncChanges(&stats); err != nil {
+		return stats, err
+	}
+	if err := ix.syncBatches(&stats); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func (ix *Indexer) pageSize() int {
+	if ix.PageSize <= 0 {
+		return defaultPageSize
+	}
+	return ix.PageSize
+}
+
+func (ix *Indexer) syncTransfers(stats *SyncStats) error {
+	pageSize := ix.pageSize()
+
+	checkpoint, err := ix.Checkpoint(CheckpointTransfers)
+	if err != nil {
+		return err
+	}
+
+	offset := checkpoint
+
+	for {
+		out, err := ix.Source.Query("get_events", []string{strconv.Itoa(offset), strconv.Itoa(pageSize)})
+		if err != nil {
+			return err
+		}
+		var page model.EventPage
+		if err := json.Unmarshal(out, &page); err != nil {
+			return errors.New("get_events: " + err.Error())
+		}
+		if len(page.Events) == 0 {
+			break
+		}
+
+		tx, err := ix.DB.Begin()
+		if err != nil {
+			return err
+		}
+		for i, e := range page.Events {
+			if err := putTransfer(tx, offset+i, e); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		offset += len(page.Events)
+		checkpoint = offset
+		if err := putCheckpoint(tx, CheckpointTransfers, checkpoint); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		stats.Transfers += len(page.Events)
+		if offset >= page.Total {
+			break
+		}
+	}
+
+	stats.Checkpoint = checkpoint
+	return nil
+}
+
+// syncChanges rewrites the transfers named by the changes since the
+// checkpoint. A transfer not indexed yet was created after syncTransfers
+// read the ledger and is skipped; the next Sync indexes it as it is then.
+func (ix *Indexer) syncChanges(stats *SyncStats) error {
+	pageSize := ix.pageSize()
+
+	offset, err := ix.Checkpoint(CheckpointChanges)
+	if err != nil {
+		return err
+	}
+
+	for {
+		out, err := ix.Source.Query("get_changes", []string{strconv.Itoa(offset), strconv.Itoa(pageSize)})
+		if err != nil {
+			return err
+		}
+		var page model.EventPage
+		if err := json.Unmarshal(out, &page); err != nil {
+			return errors.New("get_changes: " + err.Error())
+		}
+		if len(page.Events) == 0 {
+			break
+		}
+
+		tx, err := ix.DB.Begin()
+		if err != nil {
+			return err
+		}
+		for _, e := range page.Events {
+			var seq int
+			err := tx.QueryRow(`SELECT seq FROM transfers WHERE tran_id = ?`, e.TranID).Scan(&seq)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err == nil {
+				err = putTransfer(tx, seq, e)
+			}
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		offset += len(page.Events)
+		if err := putCheckpoint(tx, CheckpointChanges, offset); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		stats.Changes += len(page.Events)
+		if offset >= page.Total {
+			break
+		}
+	}
+	return nil
+}
+
+func (ix *Indexer) syncBatches(stats *SyncStats) error {
+	checkpoint, err := ix.Checkpoint(CheckpointBatches)
+	if err != nil {
+		return err
+	}
+
+	out, err := ix.Source.Query("get_settlement_batches", nil)
+	if err != nil {
+		return err
+	}
+	var holder struct {
+		BatchIDs []string `json:"batchIDs"`
+	}
+	if err := json.Unmarshal(out, &holder); err != nil {
+		return errors.New("get_settlement_batches: " + err.Error())
+	}
+
+	for seq := checkpoint; seq < len(holder.BatchIDs); seq++ {
+		out, err := ix.Source.Query("get_settlement_batch", []string{holder.BatchIDs[seq]})
+		if err != nil {
+			return err
+		}
+		var b model.SettlementBatch
+		if err := json.Unmarshal(out, &b); err != nil {
+			return errors.New("get_settlement_batch: " + err.Error())
+		}
+
+		tx, err := ix.DB.Begin()
+		if err != nil {
+			return err
+		}
+		if err := putBatch(tx, seq, b); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// Only a run of closed batches moves the checkpoint; the open one is
+		// read again next time
+		if b.Status == model.BatchClosed && seq == checkpoint {
+			checkpoint++
+			stats.ClosedBatches++
+			if err := putCheckpoint(tx, CheckpointBatches, checkpoint); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		stats.Batches++
+	}
+	return nil
+}
+
+func putTransfer(tx *sql.Tx, seq int, e model.TransactionEvent) error {
+	amount, err := model.ParseAmount(e.Amount)
+	if err != nil {
+		return fmt.Errorf("%s: invalid amount %q", e.TranID, e.Amount)
+	}
+	var fee int64
+	if e.Fee != "" {
+		if fee, err = model.ParseAmount(e.Fee); err != nil {
+			return fmt.Errorf("%s: invalid fee %q", e.TranID, e.Fee)
+		}
+	}
+	status := e.Status
+	if status == "" {
+		status = model.StatusSent
+	}
+
+	if _, err := tx.Exec(`DELETE FROM status_changes WHERE tran_id = ?`, e.TranID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM transfers WHERE tran_id = ?`, e.TranID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO transfers (tran_id, seq, sender_name, sender_country, receiver_name, receiver_country,
+		amount_cents, fee_cents, currency, sending_member, payout_member, batch_id, status, payout_currency, payout_amount,
+		created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.TranID, seq, e.SenderName, e.SenderCountry, e.ReceiverName, e.ReceiverCountry,
+		amount, fee, model.SettlementCurrency, e.SendingMember, e.PayoutMember, e.BatchID, status,
+		nullable(e.PayoutCurrency), nullable(e.PayoutAmount), nullable(e.CreatedAt), nullable(e.UpdatedAt))
+	if err != nil {
+		return err
+	}
+
+	for n, c := range e.StatusHistory {
+		_, err := tx.Exec(`INSERT INTO status_changes (tran_id, n, status, at, tx_id) VALUES (?, ?, ?, ?, ?)`,
+			e.TranID, n, c.Status, c.At, c.TxID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putBatch(tx *sql.Tx, seq int, b model.SettlementBatch) error {
+	if _, err := tx.Exec(`DELETE FROM obligations WHERE batch_id = ?`, b.BatchID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM batches WHERE batch_id = ?`, b.BatchID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`INSERT INTO batches (batch_id, seq, status, currency, transfers, opened_at, closed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		b.BatchID, seq, b.Status, b.Currency, len(b.TranIDs), nullable(b.OpenedAt), nullable(b.ClosedAt))
+	if err != nil {
+		return err
+	}
+
+	for _, o := range b.Obligations {
+		amount, err := model.ParseAmount(o.Amount)
+		if err != nil {
+			return fmt.Errorf("batch %s: invalid obligation %q", b.BatchID, o.Amount)
+		}
+		_, err = tx.Exec(`INSERT INTO obligations (batch_id, debtor, creditor, amount_cents) VALUES (?, ?, ?, ?)`,
+			b.BatchID, o.Debtor, o.Creditor, amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putCheckpoint(tx *sql.Tx, name string, position int) error {
+	if _, err := tx.Exec(`DELETE FROM checkpoints WHERE name = ?`, name); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO checkpoints (name, position) VALUES (?, ?)`, name, position)
+	return err
+}
+
+// nullable stores empty strings as NULL.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Result is the outcome of an ad-hoc query.
+type Result struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// Query runs a single SELECT (or WITH ... SELECT) statement and returns at
+// most limit rows; limit <= 0 means no limit. Anything else is refused, and
+// the statement runs in a transaction that is always rolled back, so an
+// analyst cannot change the index. Open db read-only as well where the driver
+// supports it.
+func Query(db *sql.DB, query string, limit int, args ...interface{}) (Result, error) {
+	var res Result
+
+	stmt := strings.TrimSpace(query)
+	stmt = strings.TrimSpace(strings.TrimSuffix(stmt, ";"))
+	lower := strings.ToLower(stmt)
+	if !strings.HasPrefix(lower, "select") && !strings.HasPrefix(lower, "with") {
+		return res, errors.New("only SELECT queries are allowed")
+	}
+	if strings.Contains(stmt, ";") {
+		return res, errors.New("only one statement is allowed")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(stmt, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	if res.Columns, err = rows.Columns(); err != nil {
+		return res, err
+	}
+	res.Rows = [][]interface{}{}
+
+	for rows.Next() {
+		if limit > 0 && len(res.Rows) == limit {
+			break
+		}
+
+		values := make([]interface{}, len(res.Columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return res, err
+		}
+
+		// Drivers return TEXT as []byte, which would encode as base64
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		res.Rows = append(res.Rows, values)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,201 @@
+// Package iso20022 renders ledger records as ISO 20022 payment messages:
+// pacs.008 for the customer credit transfers behind each TransactionEvent and
+// pacs.009 for the interbank obligations of a closed settlement batch.
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	isoDateTime = "2006-01-02T15:04:05"
+	isoDate     = "2006-01-02"
+)
+
+// SettlementMethod is the settlement method declared in every group header:
+// obligations are settled through the network's clearing system.
+const SettlementMethod = "CLRG"
+
+// Agent identifies the financial institution behind a network member. Members
+// without a BIC are identified by their member ID alone.
+type Agent struct {
+	BIC  string
+	Name string
+}
+
+// Options controls the group header and agent identification of a message.
+type Options struct {
+	// MsgID identifies the message; at most 35 characters.
+	MsgID string
+	// CreatedAt is the message creation time.
+	CreatedAt time.Time
+	// SettlementDate is the interbank settlement date.
+	SettlementDate time.Time
+	// Agents maps member IDs to institution details.
+	Agents map[string]Agent
+}
+
+// ActiveCurrencyAndAmount is an amount with its ISO 4217 currency attribute.
+type ActiveCurrencyAndAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// SettlementInstruction is SttlmInf.
+type SettlementInstruction struct {
+	Method string `xml:"SttlmMtd"`
+}
+
+// GenericIdentification is Othr inside a FinInstnId.
+type GenericIdentification struct {
+	ID string `xml:"Id"`
+}
+
+// FinancialInstitutionIdentification is FinInstnId.
+type FinancialInstitutionIdentification struct {
+	BICFI string                 `xml:"BICFI,omitempty"`
+	Name  string                 `xml:"Nm,omitempty"`
+	Other *GenericIdentification `xml:"Othr,omitempty"`
+}
+
+// BranchAndFinancialInstitution wraps a FinInstnId (DbtrAgt, CdtrAgt and the
+// pacs.009 Dbtr and Cdtr).
+type BranchAndFinancialInstitution struct {
+	FinInstnID FinancialInstitutionIdentification `xml:"FinInstnId"`
+}
+
+// PostalAddress is PstlAdr, reduced to the mandatory country.
+type PostalAddress struct {
+	Country string `xml:"Ctry"`
+}
+
+// PartyIdentification is a debtor or creditor party.
+type PartyIdentification struct {
+	Name          string        `xml:"Nm"`
+	PostalAddress PostalAddress `xml:"PstlAdr"`
+}
+
+// PaymentIdentification is PmtId.
+type PaymentIdentification struct {
+	InstrID    string `xml:"InstrId,omitempty"`
+	EndToEndID string `xml:"EndToEndId"`
+	TxID       string `xml:"TxId,omitempty"`
+}
+
+var (
+	max35Text  = regexp.MustCompile(`^.{1,35}$`)
+	max140Text = regexp.MustCompile(`^.{1,140}$`)
+	bicPattern = regexp.MustCompile(`^[A-Z0-9]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	amtPattern = regexp.MustCompile(`^[0-9]{1,13}(\.[0-9]{1,5})?$`)
+)
+
+// Marshal encodes a message document with the XML declaration.
+func Marshal(doc interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+func (o Options) header() (string, string, error) {
+	if !max35Text.MatchString(o.MsgID) {
+		return "", "", errors.New("MsgId must be 1 to 35 characters")
+	}
+	if o.CreatedAt.IsZero() {
+		return "", "", errors.New("creation time is required")
+	}
+	return o.MsgID, o.CreatedAt.UTC().Format(isoDateTime), nil
+}
+
+func (o Options) settlementDate() string {
+	if o.SettlementDate.IsZero() {
+		return o.CreatedAt.UTC().Format(isoDate)
+	}
+	return o.SettlementDate.Format(isoDate)
+}
+
+func (o Options) agent(memberID string) (BranchAndFinancialInstitution, error) {
+	if !max35Text.MatchString(memberID) {
+		return BranchAndFinancialInstitution{}, fmt.Errorf("member ID %q must be 1 to 35 characters", memberID)
+	}
+
+	id := FinancialInstitutionIdentification{Other: &GenericIdentification{ID: memberID}}
+	if a, ok := o.Agents[memberID]; ok {
+		if a.BIC != "" {
+			if !bicPattern.MatchString(a.BIC) {
+				return BranchAndFinancialInstitution{}, fmt.Errorf("member %s: invalid BIC %q", memberID, a.BIC)
+			}
+			id.BICFI = a.BIC
+		}
+		if a.Name != "" {
+			id.Name = truncate(a.Name, 140)
+		}
+	}
+
+	return BranchAndFinancialInstitution{FinInstnID: id}, nil
+}
+
+func party(name string, country string) (PartyIdentification, error) {
+	name = strings.TrimSpace(name)
+	if !max140Text.MatchString(name) {
+		return PartyIdentification{}, fmt.Errorf("party name %q must be 1 to 140 characters", name)
+	}
+
+	code, err := CountryCode(country)
+	if err != nil {
+		return PartyIdentification{}, err
+	}
+
+	return PartyIdentification{Name: name, PostalAddress: PostalAddress{Country: code}}, nil
+}
+
+func amount(currency string, value string) (ActiveCurrencyAndAmount, error) {
+	if !amtPattern.MatchString(value) {
+		return ActiveCurrencyAndAmount{}, fmt.Errorf("invalid amount %q", value)
+	}
+	if len(currency) != 3 || strings.ToUpper(currency) != currency {
+		return ActiveCurrencyAndAmount{}, fmt.Errorf("invalid currency %q", currency)
+	}
+	return ActiveCurrencyAndAmount{Currency: currency, Value: value}, nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+var countries = map[string]string{
+	"US": "US", "USA": "US", "UNITED STATES": "US", "UNITED STATES OF AMERICA": "US",
+	"MX": "MX", "MEX": "MX", "MEXICO": "MX",
+	"IN": "IN", "IND": "IN", "INDIA": "IN",
+	"PH": "PH", "PHL": "PH", "PHILIPPINES": "PH",
+	"GT": "GT", "GTM": "GT", "GUATEMALA": "GT",
+	"SV": "SV", "SLV": "SV", "EL SALVADOR": "SV",
+	"HN": "HN", "HND": "HN", "HONDURAS": "HN",
+	"DO": "DO", "DOM": "DO", "DOMINICAN REPUBLIC": "DO",
+	"CO": "CO", "COL": "CO", "COLOMBIA": "CO",
+	"CN": "CN", "CHN": "CN", "CHINA": "CN",
+	"VN": "VN", "VNM": "VN", "VIETNAM": "VN", "VIET NAM": "VN",
+	"NG": "NG", "NGA": "NG", "NIGERIA": "NG",
+	"GB": "GB", "GBR": "GB", "UNITED KINGDOM": "GB",
+	"CA": "CA", "CAN": "CA", "CANADA": "CA",
+}
+
+// CountryCode maps the country names and codes used on the ledger ("USA",
+// "Mexico", "IN") to ISO 3166 alpha-2 codes.
+func CountryCode(country string) (string, error) {
+	code, ok := countries[strings.ToUpper(strings.TrimSpace(country))]
+	if !ok {
+		return "", fmt.Errorf("unknown country %q", country)
+	}
+	return code, nil
+}
@@ -0,0 +1,243 @@
+package iso20022
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"moneygram/model"
+)
+
+// Entry statuses reported for each ingested transfer. A submitted entry is
+// pending until Confirm finds it on the ledger.
+const (
+	StatusAccepted = "ACTC"
+	StatusRejected = "RJCT"
+	StatusPartial  = "PART"
+	StatusPending  = "PDNG"
+)
+
+// Ledger is the part of the chaincode client ingestion needs. fabric.Client
+// satisfies it.
+type Ledger interface {
+	Invoke(function string, args []string) (string, error)
+	Query(function string, args []string) ([]byte, error)
+}
+
+// IngestResult is the outcome for one pain.001 entry.
+type IngestResult struct {
+	PmtInfID   string     `json:"pmtInfId"`
+	EndToEndID string     `json:"endToEndId"`
+	TranID     string     `json:"tranID"`
+	Status     string     `json:"status"`
+	TxID       string     `json:"txID,omitempty"`
+	Rejection  *Rejection `json:"rejection,omitempty"`
+}
+
+// Ingest submits every valid entry to the chaincode with create_event and
+// returns one result per entry, in order. Entries already on the ledger are
+// rejected as duplicates, including those of other members, which the
+// chaincode will not show the submitter. A submitted entry is pending: a Fabric v0.6 peer
+// only returns a transaction ID, before the chaincode has run, so whether the
+// transfer was accepted is only known once Confirm has found it on the
+// ledger. Errors the peer does return, such as an unknown user, are mapped
+// onto reason codes where they are recognised.
+func Ingest(entries []Pain001Entry, ledger Ledger) []IngestResult {
+	results := make([]IngestResult, 0, len(entries))
+
+	for _, e := range entries {
+		r := IngestResult{PmtInfID: e.PmtInfID, EndToEndID: e.EndToEndID, TranID: e.Event.TranID, Rejection: e.Rejection}
+
+		if r.Rejection == nil {
+			if _, err := ledger.Query("get_event_details", []string{e.Event.TranID}); err == nil || held(err) {
+				r.Rejection = &Rejection{ReasonDuplication, "tranID " + e.Event.TranID + " is already on the ledger"}
+			}
+		}
+
+		if r.Rejection == nil {
+			txID, err := ledger.Invoke("create_event", e.Event.CreateArgs())
+			if err != nil {
+				r.Rejection = peerRejection(err)
+			}
+			r.TxID = txID
+		}
+
+		r.Status = StatusPending
+		if r.Rejection != nil {
+			r.Status = StatusRejected
+		}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// Confirm settles the pending results of Ingest, which must be in the order
+// of entries. A pending transfer found on the ledger as it was submitted is
+// accepted. One still missing after wait was rejected by the chaincode, which
+// a Fabric v0.6 peer only records in its log, and one on the ledger with
+// other details, or one the submitter may not read, was a tranID another
+// submitter took first. The ledger is polled every interval until nothing is
+// pending or wait has passed; a zero wait checks once.
+func Confirm(entries []Pain001Entry, results []IngestResult, ledger Ledger, wait time.Duration, interval time.Duration) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		pending := 0
+		for i := range results {
+			if results[i].Status != StatusPending {
+				continue
+			}
+			out, err := ledger.Query("get_event_details", []string{results[i].TranID})
+			if err != nil && held(err) {
+				results[i].Status, results[i].Rejection = StatusRejected, &Rejection{ReasonDuplication, "tranID " + results[i].TranID + " was taken by another member"}
+				continue
+			}
+			if err != nil {
+				pending++
+				continue
+			}
+			confirm(&results[i], entries[i].Event, out)
+		}
+
+		if pending == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	for i := range results {
+		if results[i].Status == StatusPending {
+			results[i].Status = StatusRejected
+			results[i].Rejection = &Rejection{ReasonNarrative, "transaction " + results[i].TxID + " did not create tranID " + results[i].TranID + "; the chaincode rejected it"}
+		}
+	}
+}
+
+// confirm decides a pending result from the transfer the ledger holds under
+// its tranID.
+func confirm(r *IngestResult, sent model.TransactionEvent, stored []byte) {
+	var e model.TransactionEvent
+	if err := json.Unmarshal(stored, &e); err != nil {
+		r.Status, r.Rejection = StatusRejected, &Rejection{ReasonNarrative, "get_event_details: " + err.Error()}
+		return
+	}
+
+	sentCents, _ := model.ParseAmount(sent.Amount)
+	storedCents, err := model.ParseAmount(e.Amount)
+	if err != nil || storedCents != sentCents || e.SendingMember != sent.SendingMember ||
+		e.PayoutMember != sent.PayoutMember || e.ReceiverName != sent.ReceiverName {
+		r.Status, r.Rejection = StatusRejected, &Rejection{ReasonDuplication, "tranID " + r.TranID + " was taken by another transfer"}
+		return
+	}
+
+	r.Status = StatusAccepted
+}
+
+// held reports whether a get_event_details error means the transfer exists
+// but belongs to members other than the caller.
+func held(err error) bool {
+	return strings.Contains(err.Error(), "Permission Denied")
+}
+
+func peerRejection(err error) *Rejection {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "limit exceeded"), strings.Contains(msg, "cap exceeded"):
+		return &Rejection{ReasonExceedsAgreedLimit, msg}
+	case strings.Contains(msg, "already exists"):
+		return &Rejection{ReasonDuplication, msg}
+	case strings.Contains(msg, "Invalid amount"):
+		return &Rejection{ReasonInvalidAmount, msg}
+	case strings.Contains(msg, "Unknown member"):
+		return &Rejection{ReasonUnknownAgent, msg}
+	}
+	return &Rejection{ReasonNarrative, msg}
+}
+
+// Pain002Namespace is the schema of the status report.
+const Pain002Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.002.001.03"
+
+// Pain002Document is a CustomerPaymentStatusReport answering a pain.001.
+type Pain002Document struct {
+	XMLName xml.Name `xml:"Document"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Report  struct {
+		GroupHeader struct {
+			MsgID            string `xml:"MsgId"`
+			CreationDateTime string `xml:"CreDtTm"`
+		} `xml:"GrpHdr"`
+		Original struct {
+			MsgID       string `xml:"OrgnlMsgId"`
+			MsgNameID   string `xml:"OrgnlMsgNmId"`
+			GroupStatus string `xml:"GrpSts"`
+		} `xml:"OrgnlGrpInfAndSts"`
+		Payments []pain002Payment `xml:"OrgnlPmtInfAndSts"`
+	} `xml:"CstmrPmtStsRpt"`
+}
+
+type pain002Payment struct {
+	PmtInfID     string               `xml:"OrgnlPmtInfId"`
+	Transactions []pain002Transaction `xml:"TxInfAndSts"`
+}
+
+type pain002Transaction struct {
+	EndToEndID string         `xml:"OrgnlEndToEndId"`
+	Status     string         `xml:"TxSts"`
+	Reason     *pain002Reason `xml:"StsRsnInf,omitempty"`
+}
+
+type pain002Reason struct {
+	Code           string `xml:"Rsn>Cd"`
+	AdditionalInfo string `xml:"AddtlInf,omitempty"`
+}
+
+// NewPain002 builds the status report for an ingested pain.001 file.
+func NewPain002(original *Pain001Document, results []IngestResult, msgID string, created time.Time) *Pain002Document {
+	doc := &Pain002Document{Xmlns: Pain002Namespace}
+	doc.Report.GroupHeader.MsgID = msgID
+	doc.Report.GroupHeader.CreationDateTime = created.UTC().Format(isoDateTime)
+	doc.Report.Original.MsgID = original.Initiation.GroupHeader.MsgID
+	doc.Report.Original.MsgNameID = "pain.001.001.03"
+	if strings.Contains(original.XMLName.Space, "pain.001.001.09") {
+		doc.Report.Original.MsgNameID = "pain.001.001.09"
+	}
+
+	accepted, rejected, pending := 0, 0, 0
+	index := map[string]int{}
+
+	for _, r := range results {
+		i, ok := index[r.PmtInfID]
+		if !ok {
+			i = len(doc.Report.Payments)
+			index[r.PmtInfID] = i
+			doc.Report.Payments = append(doc.Report.Payments, pain002Payment{PmtInfID: r.PmtInfID})
+		}
+
+		tx := pain002Transaction{EndToEndID: r.EndToEndID, Status: r.Status}
+		switch {
+		case r.Rejection != nil:
+			tx.Reason = &pain002Reason{Code: r.Rejection.Code, AdditionalInfo: truncate(r.Rejection.Reason, 105)}
+			rejected++
+		case r.Status == StatusPending:
+			pending++
+		default:
+			accepted++
+		}
+		doc.Report.Payments[i].Transactions = append(doc.Report.Payments[i].Transactions, tx)
+	}
+
+	switch {
+	case rejected == 0 && pending == 0:
+		doc.Report.Original.GroupStatus = StatusAccepted
+	case accepted == 0 && pending == 0:
+		doc.Report.Original.GroupStatus = StatusRejected
+	case accepted == 0 && rejected == 0:
+		doc.Report.Original.GroupStatus = StatusPending
+	default:
+		doc.Report.Original.GroupStatus = StatusPartial
+	}
+
+	return doc
+}
@@ -0,0 +1,210 @@
+package iso20022
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"moneygram/model"
+)
+
+// fakeLedger answers get_event_details as the chaincode does for walmart:
+// its own transfers are returned, those of other members are denied. Transfers
+// submitted with create_event are stored unless the chaincode rejects them.
+type fakeLedger struct {
+	events   map[string]model.TransactionEvent
+	rejected map[string]bool
+	peerErr  map[string]error
+	invoked  []string
+}
+
+func newFakeLedger() *fakeLedger {
+	return &fakeLedger{events: map[string]model.TransactionEvent{}, rejected: map[string]bool{}, peerErr: map[string]error{}}
+}
+
+func (l *fakeLedger) Invoke(function string, args []string) (string, error) {
+	if function != "create_event" {
+		return "", errors.New("unexpected invoke " + function)
+	}
+	tranID := args[0]
+	l.invoked = append(l.invoked, tranID)
+
+	if err := l.peerErr[tranID]; err != nil {
+		return "", err
+	}
+	if !l.rejected[tranID] {
+		l.events[tranID] = model.TransactionEvent{TranID: tranID, SenderName: args[1], SenderCountry: args[2], ReceiverName: args[3],
+			ReceiverCountry: args[4], Amount: args[5], SendingMember: args[6], PayoutMember: args[7]}
+	}
+	return "tx-" + tranID, nil
+}
+
+func (l *fakeLedger) Query(function string, args []string) ([]byte, error) {
+	if function != "get_event_details" {
+		return nil, errors.New("unexpected query " + function)
+	}
+
+	e, ok := l.events[args[0]]
+	if !ok {
+		return nil, errors.New("query get_event_details: Error when querying chaincode: retrieve_tranEvent: Unknown TransactionEvent with tranEventID = " + args[0])
+	}
+	if e.SendingMember != "walmart" && e.PayoutMember != "walmart" {
+		return nil, errors.New("query get_event_details: Error when querying chaincode: Permission Denied. get_event_details. walmart === " + e.SendingMember + "|" + e.PayoutMember)
+	}
+	return json.Marshal(e)
+}
+
+func TestIngest(t *testing.T) {
+	other := model.TransactionEvent{TranID: "E1", SenderName: "Bob", SenderCountry: "MX", ReceiverName: "Ann", ReceiverCountry: "US",
+		Amount: "5.00", SendingMember: "bancomer", PayoutMember: "ria"}
+	mine := other
+	mine.SendingMember, mine.PayoutMember = "walmart", "ria"
+
+	tests := []struct {
+		name     string
+		setup    func(l *fakeLedger, opts IngestOptions)
+		statuses [2]string
+		codes    [2]string
+		invoked  int
+	}{
+		{"accepted", func(l *fakeLedger, opts IngestOptions) {}, [2]string{StatusAccepted, StatusAccepted}, [2]string{"", ""}, 2},
+		{"already on the ledger", func(l *fakeLedger, opts IngestOptions) { l.events["E1"] = mine },
+			[2]string{StatusRejected, StatusAccepted}, [2]string{ReasonDuplication, ""}, 1},
+		{"held by another member", func(l *fakeLedger, opts IngestOptions) { l.events["E1"] = other },
+			[2]string{StatusRejected, StatusAccepted}, [2]string{ReasonDuplication, ""}, 1},
+		{"rejected by the chaincode", func(l *fakeLedger, opts IngestOptions) { l.rejected["P1-2"] = true },
+			[2]string{StatusAccepted, StatusRejected}, [2]string{"", ReasonNarrative}, 2},
+		{"peer error", func(l *fakeLedger, opts IngestOptions) {
+			l.peerErr["E1"] = errors.New("invoke create_event: Unknown member bancomer")
+		},
+			[2]string{StatusRejected, StatusAccepted}, [2]string{ReasonUnknownAgent, ""}, 2},
+		{"invalid entry", func(l *fakeLedger, opts IngestOptions) { delete(opts.Agents, "ria") },
+			[2]string{StatusAccepted, StatusRejected}, [2]string{"", ReasonUnknownAgent}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger()
+			opts := IngestOptions{SendingMember: "walmart", Agents: map[string]Agent{}}
+			for id, a := range testOptions.Agents {
+				opts.Agents[id] = a
+			}
+			tt.setup(l, opts)
+
+			entries := parseTestPain001(t, testPain001).Entries(opts)
+			results := Ingest(entries, l)
+			for i, r := range results {
+				if r.Rejection == nil && (r.Status != StatusPending || r.TxID != "tx-"+r.TranID) {
+					t.Fatalf("result %d %+v, want pending", i, r)
+				}
+			}
+			if len(l.invoked) != tt.invoked {
+				t.Fatalf("invoked %v, want %d transfers", l.invoked, tt.invoked)
+			}
+
+			Confirm(entries, results, l, 0, time.Millisecond)
+			for i, r := range results {
+				code := ""
+				if r.Rejection != nil {
+					code = r.Rejection.Code
+				}
+				if r.Status != tt.statuses[i] || code != tt.codes[i] {
+					t.Fatalf("result %d %+v, want %s %q", i, r, tt.statuses[i], tt.codes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored model.TransactionEvent
+		status string
+		code   string
+	}{
+		{"as submitted", model.TransactionEvent{TranID: "E1", Amount: "100.5", SendingMember: "walmart", PayoutMember: "bancomer", ReceiverName: "Jose Perez"},
+			StatusAccepted, ""},
+		{"other details", model.TransactionEvent{TranID: "E1", Amount: "7.00", SendingMember: "walmart", PayoutMember: "bancomer", ReceiverName: "Jose Perez"},
+			StatusRejected, ReasonDuplication},
+		{"taken by another member", model.TransactionEvent{TranID: "E1", Amount: "100.50", SendingMember: "bancomer", PayoutMember: "ria"},
+			StatusRejected, ReasonDuplication},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger()
+			entries := parseTestPain001(t, testPain001).Entries(testIngestOptions)[:1]
+			results := []IngestResult{{PmtInfID: "P1", EndToEndID: "E1", TranID: "E1", Status: StatusPending, TxID: "tx-E1"}}
+
+			l.events["E1"] = tt.stored
+			Confirm(entries, results, l, 0, time.Millisecond)
+
+			r := results[0]
+			if r.Status != tt.status || (tt.code == "") != (r.Rejection == nil) || (r.Rejection != nil && r.Rejection.Code != tt.code) {
+				t.Fatalf("result %+v, want %s %q", r, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestPain002(t *testing.T) {
+	doc := parseTestPain001(t, testPain001)
+	created := time.Date(2016, 11, 28, 9, 45, 0, 0, time.UTC)
+
+	accepted := IngestResult{PmtInfID: "P1", EndToEndID: "E1", TranID: "E1", Status: StatusAccepted}
+	pending := IngestResult{PmtInfID: "P1", EndToEndID: "NOTPROVIDED", TranID: "P1-2", Status: StatusPending}
+	rejected := IngestResult{PmtInfID: "P2", EndToEndID: "E3", TranID: "E3", Status: StatusRejected,
+		Rejection: &Rejection{ReasonNarrative, strings.Repeat("x", 200)}}
+
+	tests := []struct {
+		name    string
+		results []IngestResult
+		status  string
+	}{
+		{"all accepted", []IngestResult{accepted}, StatusAccepted},
+		{"all rejected", []IngestResult{rejected}, StatusRejected},
+		{"all pending", []IngestResult{pending}, StatusPending},
+		{"mixed", []IngestResult{accepted, pending, rejected}, StatusPartial},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewPain002(doc, tt.results, "R1", created)
+
+			out, err := Marshal(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, out, pain002Schema)
+
+			original := report.Report.Original
+			if original.MsgID != "F1" || original.MsgNameID != "pain.001.001.03" || original.GroupStatus != tt.status {
+				t.Fatalf("original group %+v, want status %s", original, tt.status)
+			}
+
+			n := 0
+			for _, p := range report.Report.Payments {
+				for _, tx := range p.Transactions {
+					r := tt.results[n]
+					if p.PmtInfID != r.PmtInfID || tx.EndToEndID != r.EndToEndID || tx.Status != r.Status || (tx.Reason != nil) != (r.Rejection != nil) {
+						t.Fatalf("transaction %+v in %s for %+v", tx, p.PmtInfID, r)
+					}
+					n++
+				}
+			}
+			if n != len(tt.results) {
+				t.Fatalf("%d transactions, want %d", n, len(tt.results))
+			}
+		})
+	}
+
+	t.Run("v09 original", func(t *testing.T) {
+		v09 := parseTestPain001(t, strings.Replace(testPain001, "pain.001.001.03", "pain.001.001.09", 1))
+		if got := NewPain002(v09, nil, "R1", created).Report.Original.MsgNameID; got != "pain.001.001.09" {
+			t.Fatalf("OrgnlMsgNmId %s", got)
+		}
+	})
+}
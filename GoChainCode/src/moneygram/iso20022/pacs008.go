@@ -0,0 +1,124 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"moneygram/model"
+)
+
+// Pacs008Namespace is the schema the pacs.008 document is rendered against.
+const Pacs008Namespace = "urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08"
+
+// Pacs008Document is an FIToFICustomerCreditTransfer message.
+type Pacs008Document struct {
+	XMLName  xml.Name                     `xml:"Document"`
+	Xmlns    string                       `xml:"xmlns,attr"`
+	Transfer FIToFICustomerCreditTransfer `xml:"FIToFICstmrCdtTrf"`
+}
+
+// FIToFICustomerCreditTransfer is the message body.
+type FIToFICustomerCreditTransfer struct {
+	GroupHeader  Pacs008GroupHeader          `xml:"GrpHdr"`
+	Transactions []CreditTransferTransaction `xml:"CdtTrfTxInf"`
+}
+
+// Pacs008GroupHeader is GrpHdr.
+type Pacs008GroupHeader struct {
+	MsgID                   string                   `xml:"MsgId"`
+	CreationDateTime        string                   `xml:"CreDtTm"`
+	NumberOfTransactions    string                   `xml:"NbOfTxs"`
+	TotalSettlementAmount   *ActiveCurrencyAndAmount `xml:"TtlIntrBkSttlmAmt,omitempty"`
+	InterbankSettlementDate string                   `xml:"IntrBkSttlmDt"`
+	SettlementInformation   SettlementInstruction    `xml:"SttlmInf"`
+}
+
+// CreditTransferTransaction is one CdtTrfTxInf of a pacs.008.
+type CreditTransferTransaction struct {
+	PaymentID        PaymentIdentification         `xml:"PmtId"`
+	SettlementAmount ActiveCurrencyAndAmount       `xml:"IntrBkSttlmAmt"`
+	ChargeBearer     string                        `xml:"ChrgBr"`
+	Debtor           PartyIdentification           `xml:"Dbtr"`
+	DebtorAgent      BranchAndFinancialInstitution `xml:"DbtrAgt"`
+	CreditorAgent    BranchAndFinancialInstitution `xml:"CdtrAgt"`
+	Creditor         PartyIdentification           `xml:"Cdtr"`
+}
+
+// NewPacs008 renders one credit transfer per event. The sender is the debtor,
+// the sending member the debtor agent, the payout member the creditor agent and
+// the receiver the creditor. Charges are shared.
+func NewPacs008(events []model.TransactionEvent, opts Options) (*Pacs008Document, error) {
+	if len(events) == 0 {
+		return nil, errors.New("pacs.008 needs at least one transaction")
+	}
+
+	msgID, created, err := opts.header()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Pacs008Document{Xmlns: Pacs008Namespace}
+	hdr := &doc.Transfer.GroupHeader
+	hdr.MsgID = msgID
+	hdr.CreationDateTime = created
+	hdr.NumberOfTransactions = strconv.Itoa(len(events))
+	hdr.InterbankSettlementDate = opts.settlementDate()
+	hdr.SettlementInformation = SettlementInstruction{Method: SettlementMethod}
+
+	var total int64
+	for _, e := range events {
+		tx, err := creditTransfer(e, opts)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", e.TranID, err)
+		}
+
+		cents, err := model.ParseAmount(e.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", e.TranID, err)
+		}
+		total += cents
+
+		doc.Transfer.Transactions = append(doc.Transfer.Transactions, tx)
+	}
+
+	hdr.TotalSettlementAmount = &ActiveCurrencyAndAmount{Currency: model.SettlementCurrency, Value: model.FormatAmount(total)}
+
+	return doc, nil
+}
+
+func creditTransfer(e model.TransactionEvent, opts Options) (CreditTransferTransaction, error) {
+	var tx CreditTransferTransaction
+
+	if !max35Text.MatchString(e.TranID) {
+		return tx, errors.New("tranID must be 1 to 35 characters to be used as EndToEndId")
+	}
+	tx.PaymentID = PaymentIdentification{InstrID: e.TranID, EndToEndID: e.TranID, TxID: e.TranID}
+
+	cents, err := model.ParseAmount(e.Amount)
+	if err != nil {
+		return tx, err
+	}
+	tx.SettlementAmount, err = amount(model.SettlementCurrency, model.FormatAmount(cents))
+	if err != nil {
+		return tx, err
+	}
+
+	tx.ChargeBearer = "SHAR"
+
+	if tx.Debtor, err = party(e.SenderName, e.SenderCountry); err != nil {
+		return tx, fmt.Errorf("debtor: %v", err)
+	}
+	if tx.DebtorAgent, err = opts.agent(e.SendingMember); err != nil {
+		return tx, fmt.Errorf("debtor agent: %v", err)
+	}
+	if tx.CreditorAgent, err = opts.agent(e.PayoutMember); err != nil {
+		return tx, fmt.Errorf("creditor agent: %v", err)
+	}
+	if tx.Creditor, err = party(e.ReceiverName, e.ReceiverCountry); err != nil {
+		return tx, fmt.Errorf("creditor: %v", err)
+	}
+
+	return tx, nil
+}
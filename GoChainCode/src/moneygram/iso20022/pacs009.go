@@ -0,0 +1,100 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"moneygram/model"
+)
+
+// Pacs009Namespace is the schema the pacs.009 document is rendered against.
+const Pacs009Namespace = "urn:iso:std:iso:20022:tech:xsd:pacs.009.001.08"
+
+// Pacs009Document is a FinancialInstitutionCreditTransfer message.
+type Pacs009Document struct {
+	XMLName  xml.Name                           `xml:"Document"`
+	Xmlns    string                             `xml:"xmlns,attr"`
+	Transfer FinancialInstitutionCreditTransfer `xml:"FICdtTrf"`
+}
+
+// FinancialInstitutionCreditTransfer is the message body.
+type FinancialInstitutionCreditTransfer struct {
+	GroupHeader  Pacs009GroupHeader                   `xml:"GrpHdr"`
+	Transactions []InterbankCreditTransferTransaction `xml:"CdtTrfTxInf"`
+}
+
+// Pacs009GroupHeader is GrpHdr.
+type Pacs009GroupHeader struct {
+	MsgID                 string                `xml:"MsgId"`
+	CreationDateTime      string                `xml:"CreDtTm"`
+	NumberOfTransactions  string                `xml:"NbOfTxs"`
+	SettlementInformation SettlementInstruction `xml:"SttlmInf"`
+}
+
+// InterbankCreditTransferTransaction is one CdtTrfTxInf of a pacs.009.
+type InterbankCreditTransferTransaction struct {
+	PaymentID               PaymentIdentification         `xml:"PmtId"`
+	SettlementAmount        ActiveCurrencyAndAmount       `xml:"IntrBkSttlmAmt"`
+	InterbankSettlementDate string                        `xml:"IntrBkSttlmDt"`
+	Debtor                  BranchAndFinancialInstitution `xml:"Dbtr"`
+	Creditor                BranchAndFinancialInstitution `xml:"Cdtr"`
+}
+
+// NewPacs009 renders one interbank transfer per net obligation of a closed
+// settlement batch. Obligations are identified as <batchID>-<n>.
+func NewPacs009(batch model.SettlementBatch, opts Options) (*Pacs009Document, error) {
+	if batch.Status != model.BatchClosed {
+		return nil, fmt.Errorf("settlement batch %s is %s; only closed batches have obligations", batch.BatchID, batch.Status)
+	}
+	if batch.Member != "" {
+		return nil, fmt.Errorf("settlement batch %s only holds the obligations of %s", batch.BatchID, batch.Member)
+	}
+	if len(batch.Obligations) == 0 {
+		return nil, errors.New("settlement batch " + batch.BatchID + " has no obligations")
+	}
+
+	msgID, created, err := opts.header()
+	if err != nil {
+		return nil, err
+	}
+
+	currency := batch.Currency
+	if currency == "" {
+		currency = model.SettlementCurrency
+	}
+
+	doc := &Pacs009Document{Xmlns: Pacs009Namespace}
+	hdr := &doc.Transfer.GroupHeader
+	hdr.MsgID = msgID
+	hdr.CreationDateTime = created
+	hdr.NumberOfTransactions = strconv.Itoa(len(batch.Obligations))
+	hdr.SettlementInformation = SettlementInstruction{Method: SettlementMethod}
+
+	for i, o := range batch.Obligations {
+		id := batch.BatchID + "-" + strconv.Itoa(i+1)
+		if !max35Text.MatchString(id) {
+			return nil, fmt.Errorf("obligation ID %q must be 1 to 35 characters", id)
+		}
+
+		tx := InterbankCreditTransferTransaction{
+			PaymentID:               PaymentIdentification{InstrID: id, EndToEndID: id, TxID: id},
+			InterbankSettlementDate: opts.settlementDate(),
+		}
+
+		if tx.SettlementAmount, err = amount(currency, o.Amount); err != nil {
+			return nil, fmt.Errorf("obligation %s: %v", id, err)
+		}
+		if tx.Debtor, err = opts.agent(o.Debtor); err != nil {
+			return nil, fmt.Errorf("obligation %s: debtor: %v", id, err)
+		}
+		if tx.Creditor, err = opts.agent(o.Creditor); err != nil {
+			return nil, fmt.Errorf("obligation %s: creditor: %v", id, err)
+		}
+
+		doc.Transfer.Transactions = append(doc.Transfer.Transactions, tx)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,146 @@
+package iso20022
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"moneygram/model"
+)
+
+var testOptions = Options{
+	MsgID:          "MGI-20161128-1",
+	CreatedAt:      time.Date(2016, 11, 28, 9, 30, 0, 0, time.UTC),
+	SettlementDate: time.Date(2016, 11, 29, 0, 0, 0, 0, time.UTC),
+	Agents: map[string]Agent{
+		"walmart":  {BIC: "WALMUS33XXX", Name: "Walmart"},
+		"bancomer": {BIC: "BCMRMXMM", Name: "BBVA Bancomer"},
+		"ria":      {Name: "Ria"},
+	},
+}
+
+func testEvent(tranID, amount, sending, payout string) model.TransactionEvent {
+	return model.TransactionEvent{TranID: tranID, SenderName: "Ann Smith", SenderCountry: "USA",
+		ReceiverName: "Jose Perez", ReceiverCountry: "Mexico", Amount: amount, SendingMember: sending, PayoutMember: payout}
+}
+
+func TestPacs008(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []model.TransactionEvent
+		opts   Options
+		total  string
+		err    string
+	}{
+		{"one transfer", []model.TransactionEvent{testEvent("t1", "100", "walmart", "bancomer")}, testOptions, "100.00", ""},
+		{"batch", []model.TransactionEvent{testEvent("t1", "100.5", "walmart", "bancomer"),
+			testEvent("t2", "0.25", "bancomer", "walmart"), testEvent("t3", "7", "walmart", "ria")}, testOptions, "107.75", ""},
+		{"member without an agent", []model.TransactionEvent{testEvent("t1", "100", "walmart", "elektra")}, testOptions, "100.00", ""},
+		{"no transfers", nil, testOptions, "", "at least one"},
+		{"long tranID", []model.TransactionEvent{testEvent(strings.Repeat("t", 36), "1", "walmart", "bancomer")}, testOptions, "", "EndToEndId"},
+		{"unknown country", []model.TransactionEvent{testEvent("t1", "1", "walmart", "bancomer")}, testOptions, "", "country"},
+		{"bad BIC", []model.TransactionEvent{testEvent("t1", "1", "walmart", "bancomer")},
+			Options{MsgID: "M1", CreatedAt: testOptions.CreatedAt, Agents: map[string]Agent{"walmart": {BIC: "WALMART"}}}, "", "invalid BIC"},
+		{"no message ID", []model.TransactionEvent{testEvent("t1", "1", "walmart", "bancomer")}, Options{CreatedAt: testOptions.CreatedAt}, "", "MsgId"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "unknown country" {
+				tt.events[0].ReceiverCountry = "Atlantis"
+			}
+
+			doc, err := NewPacs008(tt.events, tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, out, pacs008Schema)
+
+			hdr := doc.Transfer.GroupHeader
+			if hdr.TotalSettlementAmount.Value != tt.total || hdr.NumberOfTransactions != strconv.Itoa(len(tt.events)) ||
+				hdr.InterbankSettlementDate != "2016-11-29" || hdr.CreationDateTime != "2016-11-28T09:30:00" {
+				t.Fatalf("group header %+v", hdr)
+			}
+
+			for i, tx := range doc.Transfer.Transactions {
+				e := tt.events[i]
+				if tx.PaymentID.EndToEndID != e.TranID || tx.Debtor.PostalAddress.Country != "US" || tx.Creditor.PostalAddress.Country != "MX" {
+					t.Fatalf("transaction %+v for %+v", tx, e)
+				}
+				if tx.DebtorAgent.FinInstnID.Other.ID != e.SendingMember || tx.CreditorAgent.FinInstnID.Other.ID != e.PayoutMember {
+					t.Fatalf("agents %+v and %+v for %+v", tx.DebtorAgent, tx.CreditorAgent, e)
+				}
+			}
+		})
+	}
+}
+
+func TestPacs009(t *testing.T) {
+	closed := model.SettlementBatch{BatchID: "7", Status: model.BatchClosed, Currency: "USD", Obligations: []model.Obligation{
+		{Debtor: "walmart", Creditor: "bancomer", Amount: "150.25"},
+		{Debtor: "ria", Creditor: "walmart", Amount: "20.00"},
+	}}
+
+	tests := []struct {
+		name  string
+		batch func(b model.SettlementBatch) model.SettlementBatch
+		err   string
+	}{
+		{"closed batch", func(b model.SettlementBatch) model.SettlementBatch { return b }, ""},
+		{"no currency", func(b model.SettlementBatch) model.SettlementBatch { b.Currency = ""; return b }, ""},
+		{"open batch", func(b model.SettlementBatch) model.SettlementBatch { b.Status = model.BatchOpen; return b }, "only closed"},
+		{"member's view", func(b model.SettlementBatch) model.SettlementBatch { b.Member = "ria"; return b }, "only holds"},
+		{"settled even", func(b model.SettlementBatch) model.SettlementBatch { b.Obligations = nil; return b }, "no obligations"},
+		{"bad amount", func(b model.SettlementBatch) model.SettlementBatch {
+			b.Obligations = []model.Obligation{{Debtor: "walmart", Creditor: "bancomer", Amount: "-1"}}
+			return b
+		}, "invalid amount"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.batch(closed)
+
+			doc, err := NewPacs009(b, testOptions)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, out, pacs009Schema)
+
+			if doc.Transfer.GroupHeader.NumberOfTransactions != "2" || len(doc.Transfer.Transactions) != 2 {
+				t.Fatalf("%+v", doc.Transfer)
+			}
+			tx := doc.Transfer.Transactions[0]
+			if tx.PaymentID.EndToEndID != "7-1" || tx.SettlementAmount != (ActiveCurrencyAndAmount{"USD", "150.25"}) ||
+				tx.Debtor.FinInstnID.BICFI != "WALMUS33XXX" || tx.Creditor.FinInstnID.BICFI != "BCMRMXMM" || tx.InterbankSettlementDate != "2016-11-29" {
+				t.Fatalf("obligation %+v", tx)
+			}
+			if tx := doc.Transfer.Transactions[1]; tx.Debtor.FinInstnID.BICFI != "" || tx.Debtor.FinInstnID.Name != "Ria" {
+				t.Fatalf("member without a BIC %+v", tx.Debtor)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"moneygram/model"
+)
+
+// Status reason codes (ISO 20022 ExternalStatusReason1Code) used when an entry
+// of a pain.001 file is rejected.
+const (
+	ReasonZeroAmount         = "AM01"
+	ReasonNotAllowedCurrency = "AM03"
+	ReasonDuplication        = "AM05"
+	ReasonInvalidControlSum  = "AM10"
+	ReasonInvalidAmount      = "AM12"
+	ReasonExceedsAgreedLimit = "AM14"
+	ReasonInvalidNbOfTxs     = "AM18"
+	ReasonInvalidCountry     = "BE09"
+	ReasonUnknownAgent       = "RC01"
+	ReasonMissingDebtor      = "RR02"
+	ReasonMissingCreditor    = "RR03"
+	ReasonNarrative          = "NARR"
+)
+
+// Pain001Document is a CustomerCreditTransferInitiation. Elements are matched
+// by local name so both pain.001.001.03 and pain.001.001.09 files decode.
+type Pain001Document struct {
+	XMLName    xml.Name `xml:"Document"`
+	Initiation struct {
+		GroupHeader struct {
+			MsgID                string `xml:"MsgId"`
+			CreationDateTime     string `xml:"CreDtTm"`
+			NumberOfTransactions string `xml:"NbOfTxs"`
+			ControlSum           string `xml:"CtrlSum"`
+			InitiatingParty      struct {
+				Name string `xml:"Nm"`
+			} `xml:"InitgPty"`
+		} `xml:"GrpHdr"`
+		PaymentInformation []Pain001PaymentInformation `xml:"PmtInf"`
+	} `xml:"CstmrCdtTrfInitn"`
+}
+
+// Pain001PaymentInformation is one PmtInf block: a debtor and its transfers.
+type Pain001PaymentInformation struct {
+	PmtInfID    string            `xml:"PmtInfId"`
+	Method      string            `xml:"PmtMtd"`
+	Debtor      pain001Party      `xml:"Dbtr"`
+	DebtorAgent pain001Agent      `xml:"DbtrAgt"`
+	Transfers   []Pain001Transfer `xml:"CdtTrfTxInf"`
+}
+
+// Pain001Transfer is one CdtTrfTxInf.
+type Pain001Transfer struct {
+	PaymentID struct {
+		InstrID    string `xml:"InstrId"`
+		EndToEndID string `xml:"EndToEndId"`
+	} `xml:"PmtId"`
+	Amount struct {
+		Instructed struct {
+			Currency string `xml:"Ccy,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"InstdAmt"`
+	} `xml:"Amt"`
+	CreditorAgent pain001Agent `xml:"CdtrAgt"`
+	Creditor      pain001Party `xml:"Cdtr"`
+}
+
+type pain001Party struct {
+	Name    string `xml:"Nm"`
+	Country string `xml:"PstlAdr>Ctry"`
+}
+
+type pain001Agent struct {
+	BICFI string `xml:"FinInstnId>BICFI"`
+	BIC   string `xml:"FinInstnId>BIC"`
+	Other string `xml:"FinInstnId>Othr>Id"`
+}
+
+// Pain001Entry is a single credit transfer mapped onto a TransactionEvent.
+// Rejection is nil for entries that may be submitted.
+type Pain001Entry struct {
+	PmtInfID   string
+	EndToEndID string
+	Event      model.TransactionEvent
+	Rejection  *Rejection
+}
+
+// Rejection explains why an entry was not submitted.
+type Rejection struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// IngestOptions controls how pain.001 entries are mapped.
+type IngestOptions struct {
+	// SendingMember is the network member that received the file from its
+	// corporate customer.
+	SendingMember string
+	// Agents maps member IDs to their BIC, used to resolve each CdtrAgt to a
+	// payout member. A CdtrAgt may also name the member ID directly in Othr/Id.
+	Agents map[string]Agent
+}
+
+// ParsePain001 decodes a pain.001 file.
+func ParsePain001(r io.Reader) (*Pain001Document, error) {
+	var doc Pain001Document
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("pain.001: %v", err)
+	}
+	if doc.XMLName.Space != "" && !strings.Contains(doc.XMLName.Space, "pain.001") {
+		return nil, fmt.Errorf("pain.001: unexpected namespace %s", doc.XMLName.Space)
+	}
+	if len(doc.Initiation.PaymentInformation) == 0 {
+		return nil, fmt.Errorf("pain.001: no PmtInf blocks")
+	}
+	return &doc, nil
+}
+
+// Entries maps every credit transfer in the file to a TransactionEvent and
+// validates it. When the group header's NbOfTxs or CtrlSum disagree with the
+// file contents every entry is rejected, as the file cannot be trusted.
+func (d *Pain001Document) Entries(opts IngestOptions) []Pain001Entry {
+	var entries []Pain001Entry
+	var count int
+	var sum int64
+	sumValid := true
+
+	seen := map[string]bool{}
+
+	for _, pmt := range d.Initiation.PaymentInformation {
+		for i, tx := range pmt.Transfers {
+			count++
+
+			e := Pain001Entry{PmtInfID: pmt.PmtInfID, EndToEndID: tx.PaymentID.EndToEndID}
+			e.Event, e.Rejection = mapTransfer(pmt, i, tx, opts)
+
+			if cents, err := model.ParseAmount(tx.Amount.Instructed.Value); err == nil {
+				sum += cents
+			} else {
+				sumValid = false
+			}
+
+			if e.Rejection == nil && seen[e.Event.TranID] {
+				e.Rejection = &Rejection{ReasonDuplication, "duplicate EndToEndId " + e.Event.TranID + " in file"}
+			}
+			seen[e.Event.TranID] = true
+
+			entries = append(entries, e)
+		}
+	}
+
+	hdr := d.Initiation.GroupHeader
+	var fileRejection *Rejection
+
+	if n, err := strconv.Atoi(strings.TrimSpace(hdr.NumberOfTransactions)); err != nil || n != count {
+		fileRejection = &Rejection{ReasonInvalidNbOfTxs, fmt.Sprintf("NbOfTxs %q but file holds %d transfers", hdr.NumberOfTransactions, count)}
+	} else if strings.TrimSpace(hdr.ControlSum) != "" {
+		ctrl, err := model.ParseAmount(hdr.ControlSum)
+		if err != nil || !sumValid || ctrl != sum {
+			fileRejection = &Rejection{ReasonInvalidControlSum, fmt.Sprintf("CtrlSum %q but transfers total %s", hdr.ControlSum, model.FormatAmount(sum))}
+		}
+	}
+
+	if fileRejection != nil {
+		for i := range entries {
+			entries[i].Rejection = fileRejection
+		}
+	}
+
+	return entries
+}
+
+func mapTransfer(pmt Pain001PaymentInformation, index int, tx Pain001Transfer, opts IngestOptions) (model.TransactionEvent, *Rejection) {
+	e := model.TransactionEvent{
+		TranID:          strings.TrimSpace(tx.PaymentID.EndToEndID),
+		SenderName:      strings.TrimSpace(pmt.Debtor.Name),
+		SenderCountry:   strings.TrimSpace(pmt.Debtor.Country),
+		ReceiverName:    strings.TrimSpace(tx.Creditor.Name),
+		ReceiverCountry: strings.TrimSpace(tx.Creditor.Country),
+		SendingMember:   opts.SendingMember,
+	}
+
+	if e.TranID == "" || e.TranID == "NOTPROVIDED" {
+		e.TranID = pmt.PmtInfID + "-" + strconv.Itoa(index+1)
+	}
+
+	if pmt.Method != "" && pmt.Method != "TRF" {
+		return e, &Rejection{ReasonNarrative, "payment method " + pmt.Method + " is not a credit transfer"}
+	}
+
+	if e.SenderName == "" || e.SenderCountry == "" {
+		return e, &Rejection{ReasonMissingDebtor, "debtor name or country missing"}
+	}
+	if e.ReceiverName == "" || e.ReceiverCountry == "" {
+		return e, &Rejection{ReasonMissingCreditor, "creditor name or country missing"}
+	}
+	if _, err := CountryCode(e.SenderCountry); err != nil {
+		return e, &Rejection{ReasonInvalidCountry, err.Error()}
+	}
+	if _, err := CountryCode(e.ReceiverCountry); err != nil {
+		return e, &Rejection{ReasonInvalidCountry, err.Error()}
+	}
+
+	instructed := tx.Amount.Instructed
+	if instructed.Currency != model.SettlementCurrency {
+		return e, &Rejection{ReasonNotAllowedCurrency, "currency " + instructed.Currency + " is not " + model.SettlementCurrency}
+	}
+
+	cents, err := model.ParseAmount(instructed.Value)
+	if err != nil {
+		return e, &Rejection{ReasonInvalidAmount, err.Error()}
+	}
+	if cents == 0 {
+		return e, &Rejection{ReasonZeroAmount, "amount is zero"}
+	}
+	e.Amount = model.FormatAmount(cents)
+
+	payout, ok := resolveMember(tx.CreditorAgent, opts.Agents)
+	if !ok {
+		return e, &Rejection{ReasonUnknownAgent, "creditor agent is not a network member"}
+	}
+	e.PayoutMember = payout
+
+	return e, nil
+}
+
+func resolveMember(agent pain001Agent, agents map[string]Agent) (string, bool) {
+	bic := agent.BICFI
+	if bic == "" {
+		bic = agent.BIC
+	}
+
+	for id, a := range agents {
+		if bic != "" && (a.BIC == bic || (len(bic) == 8 && strings.HasPrefix(a.BIC, bic))) {
+			return id, true
+		}
+		if agent.Other != "" && agent.Other == id {
+			return id, true
+		}
+	}
+
+	if bic == "" && agent.Other != "" && agents == nil {
+		return agent.Other, true
+	}
+
+	return "", false
+}
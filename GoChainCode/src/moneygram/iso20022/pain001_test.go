@@ -0,0 +1,128 @@
+package iso20022
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"moneygram/model"
+)
+
+// testPain001 is a pain.001.001.03 file from walmart's corporate customer with
+// two transfers: one to bancomer by BIC and one to ria by member ID, without
+// an EndToEndId.
+const testPain001 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"><CstmrCdtTrfInitn>
+<GrpHdr><MsgId>F1</MsgId><CreDtTm>2016-11-28T09:00:00</CreDtTm><NbOfTxs>2</NbOfTxs><CtrlSum>150.50</CtrlSum><InitgPty><Nm>Acme</Nm></InitgPty></GrpHdr>
+<PmtInf><PmtInfId>P1</PmtInfId><PmtMtd>TRF</PmtMtd><Dbtr><Nm>Acme Corp</Nm><PstlAdr><Ctry>US</Ctry></PstlAdr></Dbtr>
+<DbtrAgt><FinInstnId><BIC>WALMUS33</BIC></FinInstnId></DbtrAgt>
+<CdtTrfTxInf><PmtId><EndToEndId>E1</EndToEndId></PmtId><Amt><InstdAmt Ccy="USD">100.5</InstdAmt></Amt>
+<CdtrAgt><FinInstnId><BIC>BCMRMXMM</BIC></FinInstnId></CdtrAgt><Cdtr><Nm>Jose Perez</Nm><PstlAdr><Ctry>MX</Ctry></PstlAdr></Cdtr></CdtTrfTxInf>
+<CdtTrfTxInf><PmtId><EndToEndId>NOTPROVIDED</EndToEndId></PmtId><Amt><InstdAmt Ccy="USD">50</InstdAmt></Amt>
+<CdtrAgt><FinInstnId><Othr><Id>ria</Id></Othr></FinInstnId></CdtrAgt><Cdtr><Nm>Maria Lopez</Nm><PstlAdr><Ctry>Mexico</Ctry></PstlAdr></Cdtr></CdtTrfTxInf>
+</PmtInf></CstmrCdtTrfInitn></Document>`
+
+var testIngestOptions = IngestOptions{SendingMember: "walmart", Agents: testOptions.Agents}
+
+func parseTestPain001(t *testing.T, file string) *Pain001Document {
+	t.Helper()
+
+	doc, err := ParsePain001(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestParsePain001(t *testing.T) {
+	v09 := strings.NewReplacer("pain.001.001.03", "pain.001.001.09", "<BIC>BCMRMXMM</BIC>", "<BICFI>WALMUS33</BICFI>").Replace(testPain001)
+
+	tests := []struct {
+		name   string
+		file   string
+		payout string
+		err    string
+	}{
+		{"v03", testPain001, "bancomer", ""},
+		{"v09", v09, "walmart", ""},
+		{"no namespace", strings.Replace(testPain001, ` xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"`, "", 1), "bancomer", ""},
+		{"other message", strings.Replace(testPain001, "pain.001.001.03", "pacs.008.001.08", 1), "", "unexpected namespace"},
+		{"no payments", testPain001[:strings.Index(testPain001, "<PmtInf>")] + "</CstmrCdtTrfInitn></Document>", "", "no PmtInf"},
+		{"not XML", "MGI,walmart,100", "", "pain.001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := ParsePain001(strings.NewReader(tt.file))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries := doc.Entries(testIngestOptions)
+			if len(entries) != 2 {
+				t.Fatalf("%d entries, want 2", len(entries))
+			}
+
+			want := []Pain001Entry{
+				{PmtInfID: "P1", EndToEndID: "E1", Event: model.TransactionEvent{TranID: "E1", SenderName: "Acme Corp", SenderCountry: "US",
+					ReceiverName: "Jose Perez", ReceiverCountry: "MX", Amount: "100.50", SendingMember: "walmart", PayoutMember: tt.payout}},
+				{PmtInfID: "P1", EndToEndID: "NOTPROVIDED", Event: model.TransactionEvent{TranID: "P1-2", SenderName: "Acme Corp", SenderCountry: "US",
+					ReceiverName: "Maria Lopez", ReceiverCountry: "Mexico", Amount: "50.00", SendingMember: "walmart", PayoutMember: "ria"}},
+			}
+			for i, e := range entries {
+				if e.Rejection != nil || e.PmtInfID != want[i].PmtInfID || e.EndToEndID != want[i].EndToEndID || !reflect.DeepEqual(e.Event, want[i].Event) {
+					t.Fatalf("entry %d %+v, want %+v", i, e, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEntryRejections(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace []string
+		codes   [2]string
+	}{
+		{"valid", nil, [2]string{"", ""}},
+		{"zero amount", []string{">100.5<", ">0<", "150.50", "50.00"}, [2]string{ReasonZeroAmount, ""}},
+		{"other currency", []string{`"USD">100.5`, `"EUR">100.5`}, [2]string{ReasonNotAllowedCurrency, ""}},
+		{"bad amount", []string{">100.5<", ">100.505<", "<CtrlSum>150.50</CtrlSum>", ""}, [2]string{ReasonInvalidAmount, ""}},
+		{"duplicate EndToEndId", []string{"NOTPROVIDED", "E1"}, [2]string{"", ReasonDuplication}},
+		{"unknown country", []string{"<Ctry>MX</Ctry>", "<Ctry>Atlantis</Ctry>"}, [2]string{ReasonInvalidCountry, ""}},
+		{"unknown agent", []string{"BCMRMXMM", "ZZZZMXMM"}, [2]string{ReasonUnknownAgent, ""}},
+		{"missing debtor", []string{"<Nm>Acme Corp</Nm>", ""}, [2]string{ReasonMissingDebtor, ReasonMissingDebtor}},
+		{"missing creditor", []string{"<Nm>Jose Perez</Nm>", ""}, [2]string{ReasonMissingCreditor, ""}},
+		{"cheque", []string{"<PmtMtd>TRF", "<PmtMtd>CHK"}, [2]string{ReasonNarrative, ReasonNarrative}},
+		{"wrong NbOfTxs", []string{"<NbOfTxs>2", "<NbOfTxs>3"}, [2]string{ReasonInvalidNbOfTxs, ReasonInvalidNbOfTxs}},
+		{"wrong CtrlSum", []string{"150.50", "150.00"}, [2]string{ReasonInvalidControlSum, ReasonInvalidControlSum}},
+		{"unparsable amount in the sum", []string{">100.5<", ">ten<"}, [2]string{ReasonInvalidControlSum, ReasonInvalidControlSum}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strings.NewReplacer(tt.replace...).Replace(testPain001)
+
+			entries := parseTestPain001(t, file).Entries(testIngestOptions)
+			if len(entries) != 2 {
+				t.Fatalf("%d entries, want 2", len(entries))
+			}
+
+			for i, e := range entries {
+				code := ""
+				if e.Rejection != nil {
+					code = e.Rejection.Code
+				}
+				if code != tt.codes[i] {
+					t.Fatalf("entry %d rejected with %+v, want %q", i, e.Rejection, tt.codes[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package iso20022
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// element is one entry in the sequence of an XSD complex type. typ names a
+// complex type in the same schema or one of simpleTypes; an element the
+// writers never produce has no type, and finding it fails the check.
+type element struct {
+	name     string
+	typ      string
+	min, max int
+}
+
+const unbounded = -1
+
+func required(name, typ string) element { return element{name, typ, 1, 1} }
+func optional(name, typ string) element { return element{name, typ, 0, 1} }
+func repeated(name, typ string) element { return element{name, typ, 0, unbounded} }
+
+// unused lists optional elements the writers never produce, so their
+// position in a sequence is still known.
+func unused(names ...string) []element {
+	out := make([]element, len(names))
+	for i, n := range names {
+		out[i] = optional(n, "")
+	}
+	return out
+}
+
+func seq(parts ...interface{}) []element {
+	var out []element
+	for _, p := range parts {
+		switch p := p.(type) {
+		case element:
+			out = append(out, p)
+		case []element:
+			out = append(out, p...)
+		}
+	}
+	return out
+}
+
+// schema is the part of an ISO 20022 message schema the writers use:
+// the document namespace, the root type and the complex types by name.
+type schema struct {
+	namespace string
+	root      string
+	types     map[string][]element
+}
+
+var simpleTypes = map[string]*regexp.Regexp{
+	"Max4Text":          regexp.MustCompile(`^.{1,4}$`),
+	"Max35Text":         regexp.MustCompile(`^.{1,35}$`),
+	"Max105Text":        regexp.MustCompile(`^.{1,105}$`),
+	"Max140Text":        regexp.MustCompile(`^.{1,140}$`),
+	"Max15NumericText":  regexp.MustCompile(`^[0-9]{1,15}$`),
+	"ISODateTime":       regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?$`),
+	"ISODate":           regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"CountryCode":       regexp.MustCompile(`^[A-Z]{2}$`),
+	"BICFIIdentifier":   regexp.MustCompile(`^[A-Z0-9]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`),
+	"SettlementMethod1": regexp.MustCompile(`^(INDA|INGA|COVE|CLRG)$`),
+	"ChargeBearerType1": regexp.MustCompile(`^(DEBT|CRED|SHAR|SLEV)$`),
+	"TransactionGroup3": regexp.MustCompile(`^(ACTC|RJCT|PDNG|ACCP|ACSP|ACSC|ACWC|PART|RCVD)$`),
+	"TransactionIndiv3": regexp.MustCompile(`^(ACTC|RJCT|PDNG|ACCP|ACSP|ACSC|ACWC)$`),
+	// ActiveCurrencyAndAmount: a decimal of at most 18 digits, 5 of them
+	// fractional, with a Ccy attribute.
+	"Amount": regexp.MustCompile(`^[0-9]{1,13}(\.[0-9]{1,5})?$`),
+}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// The v08 types pacs.008 and pacs.009 share.
+var pacsTypes = map[string][]element{
+	"GroupHeader93": seq(
+		required("MsgId", "Max35Text"),
+		unused("BtchBookg"),
+		required("CreDtTm", "ISODateTime"),
+		required("NbOfTxs", "Max15NumericText"),
+		unused("CtrlSum"),
+		optional("TtlIntrBkSttlmAmt", "Amount"),
+		optional("IntrBkSttlmDt", "ISODate"),
+		required("SttlmInf", "SettlementInstruction7"),
+		unused("PmtTpInf", "InstgAgt", "InstdAgt"),
+	),
+	"SettlementInstruction7": seq(
+		required("SttlmMtd", "SettlementMethod1"),
+		unused("SttlmAcct", "ClrSys", "InstgRmbrsmntAgt", "InstgRmbrsmntAgtAcct", "InstdRmbrsmntAgt",
+			"InstdRmbrsmntAgtAcct", "ThrdRmbrsmntAgt", "ThrdRmbrsmntAgtAcct"),
+	),
+	"PaymentIdentification7": seq(
+		optional("InstrId", "Max35Text"),
+		required("EndToEndId", "Max35Text"),
+		optional("TxId", "Max35Text"),
+		unused("UETR", "ClrSysRef"),
+	),
+	"BranchAndFinancialInstitutionIdentification6": seq(
+		required("FinInstnId", "FinancialInstitutionIdentification18"),
+		unused("BrnchId"),
+	),
+	"FinancialInstitutionIdentification18": seq(
+		optional("BICFI", "BICFIIdentifier"),
+		unused("ClrSysMmbId", "LEI"),
+		optional("Nm", "Max140Text"),
+		unused("PstlAdr"),
+		optional("Othr", "GenericFinancialIdentification1"),
+	),
+	"GenericFinancialIdentification1": seq(
+		required("Id", "Max35Text"),
+		unused("SchmeNm", "Issr"),
+	),
+	"PartyIdentification135": seq(
+		optional("Nm", "Max140Text"),
+		optional("PstlAdr", "PostalAddress24"),
+		unused("Id", "CtryOfRes", "CtctDtls"),
+	),
+	"PostalAddress24": seq(
+		unused("AdrTp", "Dept", "SubDept", "StrtNm", "BldgNb", "BldgNm", "Flr", "PstBx", "Room", "PstCd", "TwnNm",
+			"TwnLctnNm", "DstrctNm", "CtrySubDvsn"),
+		optional("Ctry", "CountryCode"),
+		unused("AdrLine"),
+	),
+}
+
+// pacs.008.001.08, FIToFICustomerCreditTransferV08.
+var pacs008Schema = schema{
+	namespace: Pacs008Namespace,
+	root:      "Document",
+	types: with(pacsTypes, map[string][]element{
+		"Document": seq(required("FIToFICstmrCdtTrf", "FIToFICustomerCreditTransferV08")),
+		"FIToFICustomerCreditTransferV08": seq(
+			required("GrpHdr", "GroupHeader93"),
+			element{"CdtTrfTxInf", "CreditTransferTransaction39", 1, unbounded},
+			unused("SplmtryData"),
+		),
+		"CreditTransferTransaction39": seq(
+			required("PmtId", "PaymentIdentification7"),
+			unused("PmtTpInf"),
+			required("IntrBkSttlmAmt", "Amount"),
+			unused("IntrBkSttlmDt", "SttlmPrty", "SttlmTmIndctn", "SttlmTmReq", "AccptncDtTm", "PoolgAdjstmntDt",
+				"InstdAmt", "XchgRate"),
+			required("ChrgBr", "ChargeBearerType1"),
+			unused("ChrgsInf", "PrvsInstgAgt1", "PrvsInstgAgt1Acct", "PrvsInstgAgt2", "PrvsInstgAgt2Acct", "PrvsInstgAgt3",
+				"PrvsInstgAgt3Acct", "InstgAgt", "InstdAgt", "IntrmyAgt1", "IntrmyAgt1Acct", "IntrmyAgt2",
+				"IntrmyAgt2Acct", "IntrmyAgt3", "IntrmyAgt3Acct", "UltmtDbtr", "InitgPty"),
+			required("Dbtr", "PartyIdentification135"),
+			unused("DbtrAcct"),
+			required("DbtrAgt", "BranchAndFinancialInstitutionIdentification6"),
+			unused("DbtrAgtAcct"),
+			required("CdtrAgt", "BranchAndFinancialInstitutionIdentification6"),
+			unused("CdtrAgtAcct"),
+			required("Cdtr", "PartyIdentification135"),
+			unused("CdtrAcct", "UltmtCdtr", "InstrForCdtrAgt", "InstrForNxtAgt", "Purp", "RgltryRptg", "Tax",
+				"RltdRmtInf", "RmtInf", "SplmtryData"),
+		),
+	}),
+}
+
+// pacs.009.001.08, FinancialInstitutionCreditTransferV08.
+var pacs009Schema = schema{
+	namespace: Pacs009Namespace,
+	root:      "Document",
+	types: with(pacsTypes, map[string][]element{
+		"Document": seq(required("FICdtTrf", "FinancialInstitutionCreditTransferV08")),
+		"FinancialInstitutionCreditTransferV08": seq(
+			required("GrpHdr", "GroupHeader93"),
+			element{"CdtTrfTxInf", "CreditTransferTransaction36", 1, unbounded},
+			unused("SplmtryData"),
+		),
+		"CreditTransferTransaction36": seq(
+			required("PmtId", "PaymentIdentification7"),
+			unused("PmtTpInf"),
+			required("IntrBkSttlmAmt", "Amount"),
+			optional("IntrBkSttlmDt", "ISODate"),
+			unused("SttlmPrty", "SttlmTmIndctn", "SttlmTmReq", "PrvsInstgAgt1", "PrvsInstgAgt1Acct", "PrvsInstgAgt2",
+				"PrvsInstgAgt2Acct", "PrvsInstgAgt3", "PrvsInstgAgt3Acct", "InstgAgt", "InstdAgt", "IntrmyAgt1",
+				"IntrmyAgt1Acct", "IntrmyAgt2", "IntrmyAgt2Acct", "IntrmyAgt3", "IntrmyAgt3Acct", "UltmtDbtr"),
+			required("Dbtr", "BranchAndFinancialInstitutionIdentification6"),
+			unused("DbtrAcct", "DbtrAgt", "DbtrAgtAcct", "CdtrAgt", "CdtrAgtAcct"),
+			required("Cdtr", "BranchAndFinancialInstitutionIdentification6"),
+			unused("CdtrAcct", "UltmtCdtr", "InstrForCdtrAgt", "InstrForNxtAgt", "Purp", "RmtInf",
+				"UndrlygCstmrCdtTrf", "SplmtryData"),
+		),
+	}),
+}
+
+// pain.002.001.03, CustomerPaymentStatusReportV03.
+var pain002Schema = schema{
+	namespace: Pain002Namespace,
+	root:      "Document",
+	types: map[string][]element{
+		"Document": seq(required("CstmrPmtStsRpt", "CustomerPaymentStatusReportV03")),
+		"CustomerPaymentStatusReportV03": seq(
+			required("GrpHdr", "GroupHeader36"),
+			required("OrgnlGrpInfAndSts", "OriginalGroupInformation20"),
+			repeated("OrgnlPmtInfAndSts", "OriginalPaymentInformation1"),
+		),
+		"GroupHeader36": seq(
+			required("MsgId", "Max35Text"),
+			required("CreDtTm", "ISODateTime"),
+			unused("InitgPty", "FwdgAgt", "DbtrAgt", "CdtrAgt"),
+		),
+		"OriginalGroupInformation20": seq(
+			required("OrgnlMsgId", "Max35Text"),
+			required("OrgnlMsgNmId", "Max35Text"),
+			unused("OrgnlCreDtTm", "OrgnlNbOfTxs", "OrgnlCtrlSum"),
+			optional("GrpSts", "TransactionGroup3"),
+			unused("StsRsnInf", "NbOfTxsPerSts"),
+		),
+		"OriginalPaymentInformation1": seq(
+			required("OrgnlPmtInfId", "Max35Text"),
+			unused("OrgnlNbOfTxs", "OrgnlCtrlSum", "PmtInfSts", "StsRsnInf", "NbOfTxsPerSts"),
+			repeated("TxInfAndSts", "PaymentTransactionInformation25"),
+		),
+		"PaymentTransactionInformation25": seq(
+			unused("StsId", "OrgnlInstrId"),
+			optional("OrgnlEndToEndId", "Max35Text"),
+			optional("TxSts", "TransactionIndiv3"),
+			repeated("StsRsnInf", "StatusReasonInformation8"),
+			unused("ChrgsInf", "AccptncDtTm", "AcctSvcrRef", "ClrSysRef", "OrgnlTxRef"),
+		),
+		"StatusReasonInformation8": seq(
+			unused("Orgtr"),
+			optional("Rsn", "StatusReason6Choice"),
+			repeated("AddtlInf", "Max105Text"),
+		),
+		// A choice of Cd or Prtry; the writer only uses Cd.
+		"StatusReason6Choice": seq(
+			required("Cd", "Max4Text"),
+		),
+	},
+}
+
+func with(base map[string][]element, more map[string][]element) map[string][]element {
+	out := map[string][]element{}
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range more {
+		out[k] = v
+	}
+	return out
+}
+
+// node is a decoded XML element.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	text     string
+	children []*node
+}
+
+func parseXML(doc []byte) (*node, error) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	var stack []*node
+	var root *node
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: tok.Name, attrs: tok.Attr}
+			if len(stack) == 0 {
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(tok)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return root, nil
+}
+
+// checkSchema fails the test unless doc is valid against s: every element in
+// the document's namespace and in sequence order, required elements present,
+// no element repeated beyond its bound, and every value of its simple type.
+func checkSchema(t *testing.T, doc []byte, s schema) {
+	t.Helper()
+
+	root, err := parseXML(doc)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, doc)
+	}
+	if root.name.Local != s.root || root.name.Space != s.namespace {
+		t.Fatalf("root {%s}%s, want {%s}%s", root.name.Space, root.name.Local, s.namespace, s.root)
+	}
+
+	if err := s.check(root, s.root, s.root); err != nil {
+		t.Fatalf("%v\n%s", err, doc)
+	}
+}
+
+func (s schema) check(n *node, typ string, path string) error {
+	if n.name.Space != s.namespace {
+		return fmt.Errorf("%s: namespace %q", path, n.name.Space)
+	}
+
+	if pattern, ok := simpleTypes[typ]; ok {
+		if len(n.children) > 0 {
+			return fmt.Errorf("%s: %s holds elements", path, typ)
+		}
+		if !pattern.MatchString(n.text) {
+			return fmt.Errorf("%s: %q is not a valid %s", path, n.text, typ)
+		}
+		if typ == "Amount" {
+			ccy := ""
+			for _, a := range n.attrs {
+				if a.Name.Local == "Ccy" {
+					ccy = a.Value
+				}
+			}
+			if !currencyCode.MatchString(ccy) {
+				return fmt.Errorf("%s: Ccy %q", path, ccy)
+			}
+		}
+		return nil
+	}
+
+	elements, ok := s.types[typ]
+	if !ok {
+		return fmt.Errorf("%s: no type %q in the schema", path, typ)
+	}
+	if strings.TrimSpace(n.text) != "" {
+		return fmt.Errorf("%s: %s holds text %q", path, typ, n.text)
+	}
+
+	i, count := 0, 0
+	for _, c := range n.children {
+		at := -1
+		for j, e := range elements {
+			if e.name == c.name.Local {
+				at = j
+			}
+		}
+		switch {
+		case at < 0:
+			return fmt.Errorf("%s: %s is not in %s", path, c.name.Local, typ)
+		case at < i:
+			return fmt.Errorf("%s: %s is out of order", path, c.name.Local)
+		}
+		for ; i < at; i, count = i+1, 0 {
+			if count < elements[i].min {
+				return fmt.Errorf("%s: missing %s before %s", path, elements[i].name, c.name.Local)
+			}
+		}
+
+		count++
+		if e := elements[i]; e.max != unbounded && count > e.max {
+			return fmt.Errorf("%s: more than %d %s", path, e.max, e.name)
+		}
+		if elements[i].typ == "" {
+			return fmt.Errorf("%s: unexpected %s", path, c.name.Local)
+		}
+		if err := s.check(c, elements[i].typ, path+"/"+c.name.Local); err != nil {
+			return err
+		}
+	}
+
+	for ; i < len(elements); i, count = i+1, 0 {
+		if count < elements[i].min {
+			return fmt.Errorf("%s: missing %s", path, elements[i].name)
+		}
+	}
+
+	return nil
+}
+
+func TestCheckSchema(t *testing.T) {
+	valid := `<Document xmlns="` + Pacs009Namespace + `"><FICdtTrf><GrpHdr><MsgId>M1</MsgId><CreDtTm>2016-11-28T09:30:00</CreDtTm>` +
+		`<NbOfTxs>1</NbOfTxs><SttlmInf><SttlmMtd>CLRG</SttlmMtd></SttlmInf></GrpHdr><CdtTrfTxInf><PmtId><EndToEndId>1-1</EndToEndId></PmtId>` +
+		`<IntrBkSttlmAmt Ccy="USD">1.00</IntrBkSttlmAmt>%s<Dbtr><FinInstnId><BICFI>WALMUS33</BICFI></FinInstnId></Dbtr>` +
+		`<Cdtr><FinInstnId><Othr><Id>bancomer</Id></Othr></FinInstnId></Cdtr></CdtTrfTxInf></FICdtTrf></Document>`
+
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"valid", fmt.Sprintf(valid, ""), ""},
+		{"optional element", fmt.Sprintf(valid, "<IntrBkSttlmDt>2016-11-29</IntrBkSttlmDt>"), ""},
+		{"missing element", strings.Replace(fmt.Sprintf(valid, ""), "<NbOfTxs>1</NbOfTxs>", "", 1), "missing NbOfTxs"},
+		{"element after its place", fmt.Sprintf(valid, "<PmtTpInf/>"), "PmtTpInf is out of order"},
+		{"unknown element", fmt.Sprintf(valid, "<Purpose/>"), "Purpose is not in"},
+		{"bad value", strings.Replace(fmt.Sprintf(valid, ""), "CLRG", "WIRE", 1), "SettlementMethod1"},
+		{"no currency", strings.Replace(fmt.Sprintf(valid, ""), ` Ccy="USD"`, "", 1), "Ccy"},
+		{"other namespace", strings.Replace(fmt.Sprintf(valid, ""), Pacs009Namespace, Pacs008Namespace, 1), "root"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseXML([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root.name.Space != pacs009Schema.namespace {
+				err = fmt.Errorf("root namespace %s", root.name.Space)
+			} else {
+				err = pacs009Schema.check(root, "Document", "Document")
+			}
+
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	Member - A participant in the clearing network. NetDebitCap is the most the member may owe the rest of the network
+//			 at any time and NetPosition is what it currently owes (negative when it is owed). Amounts are held in minor
+//			 units (cents) so that exposure arithmetic is exact.
+//==============================================================================================================================
+type Member struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MemberID      string `json:"memberID"`
+	Name          string `json:"name"`
+	NetDebitCap   int64  `json:"netDebitCap"`
+	NetPosition   int64  `json:"netPosition"`
+	CreatedAt     string `json:"createdAt,omitempty"`
+	UpdatedAt     string `json:"updatedAt,omitempty"`
+}
+
+//==============================================================================================================================
+//	Member Holder - Defines the structure that holds all the memberIDs that have been registered.
+//==============================================================================================================================
+type MEMBER_Holder struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	MemberIDs     []string `json:"memberIDs"`
+}
+
+//==============================================================================================================================
+//	BilateralLimit - The credit a creditor member has agreed to front a debtor member, and what the debtor owes it
+//					 gross since the last settlement. Stored under "limit_<creditor>_<debtor>".
+//==============================================================================================================================
+type BilateralLimit struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Creditor      string `json:"creditor"`
+	Debtor        string `json:"debtor"`
+	Limit         int64  `json:"limit"`
+	Owed          int64  `json:"owed"`
+	UpdatedAt     string `json:"updatedAt,omitempty"`
+}
+
+//==============================================================================================================================
+//	Exposure - Utilisation report for a member returned by the get_exposure query.
+//==============================================================================================================================
+type Exposure struct {
+	MemberID       string                 `json:"memberID"`
+	NetDebitCap    string                 `json:"netDebitCap"`
+	NetPosition    string                 `json:"netPosition"`
+	NetDebitUnused string                 `json:"netDebitUnused"`
+	Counterparties []CounterpartyExposure `json:"counterparties"`
+}
+
+//==============================================================================================================================
+//	CounterpartyExposure - One line of an Exposure. LimitGranted is the credit the counterparty has agreed to front the
+//						   member; NetOwed is what the member owes the counterparty after netting both directions.
+//==============================================================================================================================
+type CounterpartyExposure struct {
+	Counterparty string `json:"counterparty"`
+	LimitGranted string `json:"limitGranted"`
+	LimitGiven   string `json:"limitGiven"`
+	NetOwed      string `json:"netOwed"`
+	Available    string `json:"available"`
+}
+
+//==============================================================================================================================
+//	 MAX_AMOUNT_DIGITS - The most digits before the decimal point of any amount, limit or cap. It keeps every amount
+//						 below a trillion, so that positions and totals built from them cannot overflow.
+//==============================================================================================================================
+const MAX_AMOUNT_DIGITS = 12
+
+//==============================================================================================================================
+//	 parse_amount - Converts a decimal amount string such as "100" or "100.50" into minor units. Negative amounts, more
+//					than two decimal places and more than MAX_AMOUNT_DIGITS before the point are rejected. Keep
+//					model.ParseAmount in step with it.
+//==============================================================================================================================
+func parse_amount(amount string) (int64, error) {
+
+	parts := strings.Split(strings.TrimSpace(amount), ".")
+
+	if len(parts) > 2 || parts[0] == "" || strings.HasPrefix(parts[0], "-") || strings.HasPrefix(parts[0], "+") {
+		return 0, errors.New("Amount must be a non-negative decimal number")
+	}
+
+	if len(parts[0]) > MAX_AMOUNT_DIGITS {
+		return 0, errors.New(fmt.Sprintf("Amount must have at most %v digits before the decimal point", MAX_AMOUNT_DIGITS))
+	}
+
+	units, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, errors.New("Amount must be a non-negative decimal number")
+	}
+
+	var cents int64
+	if len(parts) == 2 {
+		if len(parts[1]) == 0 || len(parts[1]) > 2 {
+			return 0, errors.New("Amount must have at most two decimal places")
+		}
+
+		cents, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || strings.HasPrefix(parts[1], "-") || strings.HasPrefix(parts[1], "+") {
+			return 0, errors.New("Amount must be a non-negative decimal number")
+		}
+		if len(parts[1]) == 1 {
+			cents = cents * 10
+		}
+	}
+
+	return units*100 + cents, nil
+}
+
+//==============================================================================================================================
+//	 format_amount - Converts minor units back into a decimal amount string with two decimal places.
+//==============================================================================================================================
+func format_amount(amount int64) string {
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+//==============================================================================================================================
+//	 retrieve_member - Gets the Member record for memberID from the ledger. Returns an error if it is not registered.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_member(stub shim.ChaincodeStubInterface, memberID string) (Member, error) {
+
+	var m Member
+
+	found, err := read_record(stub, KIND_MEMBER, "member_"+memberID, &m)
+
+	if err != nil {
+		return m, errors.New("retrieve_member: " + err.Error())
+	}
+
+	if !found {
+		return m, errors.New("retrieve_member: Unknown member " + memberID)
+	}
+
+	return m, nil
+}
+
+//==============================================================================================================================
+//	 save_member - Writes the Member record to the ledger, stamped with the time of the transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_member(stub shim.ChaincodeStubInterface, m Member) error {
+
+	var err error
+
+	m.SchemaVersion = SCHEMA_VERSION
+
+	m.UpdatedAt, err = tx_timestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(m)
+
+	if err != nil {
+		return errors.New("Error converting member record")
+	}
+
+	err = stub.PutState("member_"+m.MemberID, bytes)
+
+	if err != nil {
+		return errors.New("Error storing member record")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_member_ids - Reads the memberIDs index.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_member_ids(stub shim.ChaincodeStubInterface) (MEMBER_Holder, error) {
+
+	var memberHld MEMBER_Holder
+
+	_, err := read_record(stub, KIND_MEMBER_HOLDER, "memberIDs", &memberHld)
+
+	if err != nil {
+		return memberHld, errors.New("Unable to get memberIDs: " + err.Error())
+	}
+
+	return memberHld, nil
+}
+
+//==============================================================================================================================
+//	 retrieve_limit - Gets the bilateral limit the creditor grants the debtor. A pair that has never been configured
+//					  has a zero limit, so no credit is fronted until the creditor agrees to it.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_limit(stub shim.ChaincodeStubInterface, creditor string, debtor string) (BilateralLimit, error) {
+
+	l := BilateralLimit{Creditor: creditor, Debtor: debtor}
+
+	_, err := read_record(stub, KIND_LIMIT, "limit_"+creditor+"_"+debtor, &l)
+
+	if err != nil {
+		return l, errors.New("retrieve_limit: " + err.Error())
+	}
+
+	return l, nil
+}
+
+//==============================================================================================================================
+//	 save_limit - Writes the BilateralLimit record to the ledger, stamped with the time of the transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_limit(stub shim.ChaincodeStubInterface, l BilateralLimit) error {
+
+	var err error
+
+	l.SchemaVersion = SCHEMA_VERSION
+
+	l.UpdatedAt, err = tx_timestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(l)
+
+	if err != nil {
+		return errors.New("Error converting limit record")
+	}
+
+	err = stub.PutState("limit_"+l.Creditor+"_"+l.Debtor, bytes)
+
+	if err != nil {
+		return errors.New("Error storing limit record")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 Member Registry Functions
+//=================================================================================================================================
+//	 register_member - Adds a member to the network, or renames an existing one. Only an admin may register members.
+//=================================================================================================================================
+func (t *SimpleChaincode) register_member(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//		0		  1		   2
+	//	memberID, name, netDebitCap
+
+	if args[0] == "" || strings.Contains(args[0], "_") {
+		return nil, errors.New("Invalid memberID provided")
+	}
+
+	netDebitCap, err := parse_amount(args[2])
+	if err != nil {
+		return nil, errors.New("Invalid net debit cap: " + err.Error())
+	}
+
+	record, err := stub.GetState("member_" + args[0])
+	if err != nil {
+		return nil, errors.New("Unable to get member " + args[0])
+	}
+
+	m := Member{MemberID: args[0]}
+
+	if record != nil {
+		m, err = t.retrieve_member(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+
+		memberHld, err := t.retrieve_member_ids(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		memberHld.MemberIDs = append(memberHld.MemberIDs, args[0])
+		memberHld.SchemaVersion = SCHEMA_VERSION
+
+		bytes, err := json.Marshal(memberHld)
+		if err != nil {
+			return nil, errors.New("Error creating MEMBER_Holder record")
+		}
+
+		err = stub.PutState("memberIDs", bytes)
+		if err != nil {
+			return nil, errors.New("Error storing memberIDs")
+		}
+
+		m.CreatedAt, err = tx_timestamp(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.Name = args[1]
+	m.NetDebitCap = netDebitCap
+
+	err = t.save_member(stub, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_net_debit_cap - Changes the network-wide net debit cap of a member. Only an admin may change caps. Lowering a cap
+//						 below the current position blocks new transfers from that member until it settles.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_net_debit_cap(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//		0			1
+	//	memberID, netDebitCap
+
+	netDebitCap, err := parse_amount(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid net debit cap: " + err.Error())
+	}
+
+	m, err := t.retrieve_member(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m.NetDebitCap = netDebitCap
+
+	err = t.save_member(stub, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_credit_limit - Sets the credit the creditor agrees to front the debtor. Only the creditor itself (or an admin)
+//						may set the limit it grants.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_credit_limit(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, args []string) ([]byte, error) {
+
+	//Args
+	//		0		 1		 2
+	//	creditor, debtor, limit
+
+	if caller_role != ROLE_ADMIN && caller_member != args[0] {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. set_credit_limit. %v === %v", caller_member, args[0]))
+	}
+
+	if args[0] == args[1] {
+		return nil, errors.New("A member cannot grant credit to itself")
+	}
+
+	limit, err := parse_amount(args[2])
+	if err != nil {
+		return nil, errors.New("Invalid credit limit: " + err.Error())
+	}
+
+	_, err = t.retrieve_member(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.retrieve_member(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := t.retrieve_limit(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	l.Limit = limit
+
+	err = t.save_limit(stub, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 Exposure Functions
+//=================================================================================================================================
+//	 apply_exposure - Checks that the payout member may front amount on behalf of the sending member and records it.
+//					  The net bilateral position after the transfer must stay within the limit the payout member granted,
+//					  and the sending member's network-wide net position must stay within its net debit cap.
+//=================================================================================================================================
+func (t *SimpleChaincode) apply_exposure(stub shim.ChaincodeStubInterface, sending string, payout string, amount int64) error {
+
+	if sending == payout {
+		return errors.New("Sending and payout member must differ")
+	}
+
+	sender, err := t.retrieve_member(stub, sending)
+	if err != nil {
+		return err
+	}
+
+	payer, err := t.retrieve_member(stub, payout)
+	if err != nil {
+		return err
+	}
+
+	owed, err := t.retrieve_limit(stub, payout, sending)
+	if err != nil {
+		return err
+	}
+
+	reverse, err := t.retrieve_limit(stub, sending, payout)
+	if err != nil {
+		return err
+	}
+
+	net := owed.Owed + amount - reverse.Owed
+
+	if net > owed.Limit {
+		return errors.New(fmt.Sprintf("Bilateral credit limit exceeded. %v would owe %v %v against a limit of %v", sending, payout, format_amount(net), format_amount(owed.Limit)))
+	}
+
+	if sender.NetPosition+amount > sender.NetDebitCap {
+		return errors.New(fmt.Sprintf("Net debit cap exceeded. %v would owe the network %v against a cap of %v", sending, format_amount(sender.NetPosition+amount), format_amount(sender.NetDebitCap)))
+	}
+
+	owed.Owed = owed.Owed + amount
+	sender.NetPosition = sender.NetPosition + amount
+	payer.NetPosition = payer.NetPosition - amount
+
+	err = t.save_limit(stub, owed)
+	if err != nil {
+		return err
+	}
+
+	err = t.save_member(stub, sender)
+	if err != nil {
+		return err
+	}
+
+	return t.save_member(stub, payer)
+}
+
+//=================================================================================================================================
+//	 get_exposure - Returns the member's net debit cap utilisation and its bilateral position with every other member.
+//					Admins may read any member's exposure; other callers only their own.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_exposure(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, memberID string) ([]byte, error) {
+
+	if caller_role != ROLE_ADMIN && caller_member != memberID {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. get_exposure. %v === %v", memberID, caller_member))
+	}
+
+	m, err := t.retrieve_member(stub, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberHld, err := t.retrieve_member_ids(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	exposure := Exposure{
+		MemberID:       m.MemberID,
+		NetDebitCap:    format_amount(m.NetDebitCap),
+		NetPosition:    format_amount(m.NetPosition),
+		NetDebitUnused: format_amount(m.NetDebitCap - m.NetPosition),
+		Counterparties: []CounterpartyExposure{},
+	}
+
+	for _, other := range memberHld.MemberIDs {
+
+		if other == memberID {
+			continue
+		}
+
+		granted, err := t.retrieve_limit(stub, other, memberID)
+		if err != nil {
+			return nil, err
+		}
+
+		given, err := t.retrieve_limit(stub, memberID, other)
+		if err != nil {
+			return nil, err
+		}
+
+		net := granted.Owed - given.Owed
+
+		exposure.Counterparties = append(exposure.Counterparties, CounterpartyExposure{
+			Counterparty: other,
+			LimitGranted: format_amount(granted.Limit),
+			LimitGiven:   format_amount(given.Limit),
+			NetOwed:      format_amount(net),
+			Available:    format_amount(granted.Limit - net),
+		})
+	}
+
+	bytes, err := json.Marshal(exposure)
+	if err != nil {
+		return nil, errors.New("Error converting exposure")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 get_members - Returns the memberIDs index, in the order the members were registered.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_members(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	memberHld, err := t.retrieve_member_ids(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if memberHld.MemberIDs == nil {
+		memberHld.MemberIDs = []string{}
+	}
+
+	bytes, err := json.Marshal(memberHld)
+	if err != nil {
+		return nil, errors.New("Error converting memberIDs")
+	}
+
+	return bytes, nil
+}
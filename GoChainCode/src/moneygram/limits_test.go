@@ -0,0 +1,169 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"moneygram/model"
+)
+
+func TestLimits(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		limit   string
+		cap     string
+		amounts []string
+		err     string
+	}{
+		{"within limit", "5000", "10000", []string{"3000", "2000"}, ""},
+		{"bilateral limit", "5000", "10000", []string{"3000", "2000.01"}, "Bilateral credit limit exceeded"},
+		{"net debit cap", "5000", "4000", []string{"3000", "1000.01"}, "Net debit cap exceeded"},
+		{"no limit", "0", "10000", []string{"0.01"}, "Bilateral credit limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("set_credit_limit", "bancomer", "walmart", tt.limit)
+			l.invoke("set_net_debit_cap", "walmart", tt.cap)
+
+			var err error
+			for i, amount := range tt.amounts {
+				_, err = l.Invoke("create_event", transfer(fmt.Sprint("t", i), amount).CreateArgs()...)
+				if err != nil && i < len(tt.amounts)-1 {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.err != "" {
+				expect_error(t, err, tt.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("payments back free credit", func(t *testing.T) {
+
+		l := new_network(t)
+		l.invoke("create_event", transfer("t1", "5000").CreateArgs()...)
+
+		back := transfer("t2", "1000")
+		back.SendingMember, back.PayoutMember = "bancomer", "walmart"
+		l.invoke("create_event", back.CreateArgs()...)
+		l.invoke("create_event", transfer("t3", "1000").CreateArgs()...)
+
+		var exposure Exposure
+		l.query(&exposure, "get_exposure", "walmart")
+
+		if exposure.NetPosition != "5000.00" {
+			t.Fatalf("net position %v, want 5000.00", exposure.NetPosition)
+		}
+	})
+
+	t.Run("only the creditor sets a limit", func(t *testing.T) {
+
+		l := new_network(t)
+		l.SetCaller(walmart_caller)
+		l.invoke_fails("Permission Denied", "set_credit_limit", "bancomer", "walmart", "9000")
+		l.invoke("set_credit_limit", "walmart", "bancomer", "9000")
+	})
+}
+
+func TestZeroAmount(t *testing.T) {
+
+	tests := []struct {
+		name string
+		args []string
+		bulk bool
+	}{
+		{"zero", transfer("t1", "0").CreateArgs(), false},
+		{"zero with cents", transfer("t1", "0.00").CreateArgs(), false},
+		{"zero in a batch", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+
+			if tt.bulk {
+				bulk, err := json.Marshal([]model.TransactionEvent{transfer("t1", "10"), transfer("t2", "0.0")})
+				if err != nil {
+					t.Fatal(err)
+				}
+				l.invoke_fails("greater than zero", "create_events", string(bulk))
+			} else {
+				l.invoke_fails("greater than zero", "create_event", tt.args...)
+			}
+
+			// A zero fee is still allowed
+			l.invoke("create_event", append(transfer("t3", "10").CreateArgs(), "0")...)
+		})
+	}
+}
+
+func TestExposureAccess(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		caller map[string]string
+		member string
+		err    string
+	}{
+		{"admin", admin_caller, "walmart", ""},
+		{"own exposure", walmart_caller, "walmart", ""},
+		{"other member", bancomer_caller, "walmart", "Permission Denied"},
+		{"auditor", map[string]string{"role": ROLE_AUDITOR, "member": "bancomer"}, "walmart", "Permission Denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.SetCaller(tt.caller)
+
+			if tt.err != "" {
+				l.query_fails(tt.err, "get_exposure", tt.member)
+				return
+			}
+
+			var exposure Exposure
+			l.query(&exposure, "get_exposure", tt.member)
+
+			if exposure.MemberID != tt.member || exposure.NetDebitCap != "10000.00" {
+				t.Fatalf("exposure %+v", exposure)
+			}
+		})
+	}
+}
+
+func TestAmountsMatchModel(t *testing.T) {
+
+	for _, amount := range []string{"0", "0.00", "1", "100.5", "100.50", "100.05", " 7.25 ", "999999999999.99", "1000000000000",
+		"", ".5", "5.", "1.005", "-1", "+1", "1.-5", "1.+5", "1.2.3", "ten", "1e3", "0x10"} {
+
+		cents, err := parse_amount(amount)
+		model_cents, model_err := model.ParseAmount(amount)
+
+		if cents != model_cents || (err == nil) != (model_err == nil) ||
+			(err != nil && !strings.EqualFold(err.Error(), model_err.Error())) {
+			t.Fatalf("%q: parse_amount gives %v, %v and model.ParseAmount %v, %v", amount, cents, err, model_cents, model_err)
+		}
+	}
+
+	for _, cents := range []int64{0, 1, 10, 99, 100, 10050, -1, -10050, 99999999999999} {
+		if got, want := format_amount(cents), model.FormatAmount(cents); got != want {
+			t.Fatalf("%v: format_amount gives %v and model.FormatAmount %v", cents, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+//go:build loadgen && !fabric1
+// +build loadgen,!fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"ledgersim"
+	"moneygram/loadgen"
+)
+
+//==============================================================================================================================
+//	 Load generator entry point - Built with the loadgen tag, the chaincode runs in-process on a ledgersim ledger and
+//								  the loadgen package sends it synthetic traffic, so its own cost per transfer can be
+//								  measured and the compliance rules exercised without a peer:
+//
+//									go build -tags loadgen -o mgiload-inprocess moneygram
+//									mgiload-inprocess -n 20000 -config traffic.json -results outcomes.jsonl
+//
+//								  Transfers are created by an admin, one ledgersim second apart, after the config's
+//								  members and credit limits are set up. The report ends with the CTR reports and SAR
+//								  candidates raised and what the structuring rules find in the open batch.
+//==============================================================================================================================
+func main() {
+
+	configFile := flag.String("config", "", "traffic config file (JSON)")
+	seed := flag.Int64("seed", 0, "random seed, overriding the config's")
+	n := flag.Int("n", 1000, "transfers to send")
+	concurrency := flag.Int("c", 1, "transfers in flight")
+	netDebitCap := flag.String("cap", "1000000000", "net debit cap of every member")
+	limit := flag.String("limit", "1000000000", "credit limit between the members of a corridor")
+	results := flag.String("results", "", "file to write each transfer's outcome to")
+	flag.Parse()
+
+	cfg := loadgen.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadgen.LoadConfig(*configFile); err != nil {
+			load_fail(err)
+		}
+	}
+	if *seed != 0 {
+		cfg.Seed = *seed
+	}
+
+	g, err := loadgen.NewGenerator(cfg)
+	if err != nil {
+		load_fail(err)
+	}
+
+	l := ledgersim.New("mgi", new(SimpleChaincode))
+	l.SetCaller(map[string]string{"role": ROLE_ADMIN, "member": "moneygram"})
+
+	_, err = l.Init("init", "loadgen")
+	if err != nil {
+		load_fail(err)
+	}
+
+	err = loadgen.Setup(sim_invoker{l}, cfg, *netDebitCap, *limit)
+	if err != nil {
+		load_fail(err)
+	}
+
+	opts := loadgen.Options{Transfers: *n, Concurrency: *concurrency}
+	if *results != "" {
+		f, err := os.Create(*results)
+		if err != nil {
+			load_fail(err)
+		}
+		defer f.Close()
+		opts.Results = f
+	}
+
+	report, err := loadgen.Run(g, loadgen.ChaincodeTarget{Invoker: sim_invoker{l}}, opts)
+	if err != nil {
+		load_fail(err)
+	}
+	report.Print(os.Stdout)
+
+	var ctr, sar ReportPage
+	var detection DetectionResult
+
+	for _, q := range []struct {
+		function string
+		args     []string
+		v        interface{}
+	}{
+		{"get_ctr_reports", []string{"0", "1"}, &ctr},
+		{"get_sar_candidates", []string{"0", "1"}, &sar},
+		{"detect_structuring", []string{BATCH_OPEN}, &detection},
+	} {
+		out, err := l.Query(q.function, q.args...)
+		if err == nil {
+			err = json.Unmarshal(out, q.v)
+		}
+		if err != nil {
+			load_fail(err)
+		}
+	}
+
+	fmt.Printf("compliance  %d CTR reports, %d SAR candidates\n", ctr.Total, sar.Total)
+
+	findings := map[string]int{}
+	for _, f := range detection.Findings {
+		findings[f.RuleID]++
+	}
+	for _, rule := range detection.Rules {
+		fmt.Printf("  %6d  %s findings\n", findings[rule.RuleID], rule.RuleID)
+	}
+}
+
+//==============================================================================================================================
+//	 read_cert_attribute - Reads an attribute from the caller's eCert, as in the v0.6 build.
+//==============================================================================================================================
+func read_cert_attribute(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	return stub.ReadCertAttribute(name)
+}
+
+//==============================================================================================================================
+//	 sim_invoker - Lets the loadgen package invoke the chaincode on a ledgersim ledger.
+//==============================================================================================================================
+type sim_invoker struct {
+	l *ledgersim.Ledger
+}
+
+func (s sim_invoker) Invoke(function string, args []string) (string, error) {
+	_, err := s.l.Invoke(function, args...)
+	return "", err
+}
+
+//==============================================================================================================================
+//	 load_fail - Reports an error that stops the load generator.
+//==============================================================================================================================
+func load_fail(err error) {
+	fmt.Fprintf(os.Stderr, "mgiload: %v\n", err)
+	os.Exit(1)
+}
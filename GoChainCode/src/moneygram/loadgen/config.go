@@ -0,0 +1,206 @@
+// Package loadgen produces synthetic remittance traffic for sizing the
+// network and exercising the compliance rules before go-live. A Generator
+// turns a Config into a reproducible stream of transfers that follows the
+// corridor mix, amount distribution and sender reuse of real traffic, with
+// duplicates, structuring bursts and sanctioned names mixed in at the rates
+// given. Run submits the stream to a Target and reports throughput and
+// latency; every transfer carries the anomaly it was generated as, so the
+// alerts the chaincode raises can be checked against what was injected.
+package loadgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Anomalies injected into the traffic. Normal transfers have no anomaly.
+const (
+	// AnomalyDuplicate is a transfer sent again with the tranID of an
+	// earlier one; the chaincode must reject it.
+	AnomalyDuplicate = "duplicate"
+	// AnomalyStructuring is one of a burst of transfers from a single
+	// sender, each just under the threshold and each from a different
+	// sending location.
+	AnomalyStructuring = "structuring"
+	// AnomalySanctions is a transfer whose sender is on the sanctions list.
+	AnomalySanctions = "sanctions"
+)
+
+// Config describes the traffic to generate. Amounts are in the settlement
+// currency, USD.
+type Config struct {
+	// Seed makes the traffic reproducible: the same Config always
+	// generates the same transfers.
+	Seed int64 `json:"seed"`
+	// TranIDPrefix starts every tranID; "lg" when empty. Use a new prefix
+	// for every run against the same ledger.
+	TranIDPrefix string     `json:"tranIDPrefix"`
+	Corridors    []Corridor `json:"corridors"`
+	Amounts      Amounts    `json:"amounts"`
+	// FeePercent is the fee charged on top of the amount.
+	FeePercent float64 `json:"feePercent"`
+	// SenderReuse is the share of transfers sent by a sender who has sent
+	// in the corridor before, to the same receiver from the same location.
+	SenderReuse float64   `json:"senderReuse"`
+	Anomalies   Anomalies `json:"anomalies"`
+}
+
+// Corridor is a country pair and the members serving it. A corridor is
+// picked for each transfer in proportion to its Weight.
+type Corridor struct {
+	SenderCountry   string   `json:"senderCountry"`
+	ReceiverCountry string   `json:"receiverCountry"`
+	Weight          float64  `json:"weight"`
+	SendingMembers  []string `json:"sendingMembers"`
+	PayoutMembers   []string `json:"payoutMembers"`
+	// PayoutCurrency and FXRate, the units of it paid per USD, are set when
+	// the receiver is paid in another currency.
+	PayoutCurrency string  `json:"payoutCurrency,omitempty"`
+	FXRate         float64 `json:"fxRate,omitempty"`
+}
+
+// Amounts is a log-normal distribution of transfer amounts with the given
+// median, clamped to [Min, Max]. Sigma sets the spread; around 0.8 gives
+// the long tail of real remittances.
+type Amounts struct {
+	Median float64 `json:"median"`
+	Sigma  float64 `json:"sigma"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// Anomalies sets how often each anomaly is injected, as a share of the
+// transfers generated.
+type Anomalies struct {
+	Duplicates float64 `json:"duplicates"`
+	// Structuring is the share of transfers that start a burst of
+	// StructuringBurst transfers just under StructuringThreshold.
+	Structuring          float64  `json:"structuring"`
+	StructuringThreshold float64  `json:"structuringThreshold"`
+	StructuringBurst     int      `json:"structuringBurst"`
+	Sanctions            float64  `json:"sanctions"`
+	SanctionedNames      []string `json:"sanctionedNames"`
+}
+
+// DefaultConfig returns a mix of four corridors with the detection rules'
+// default threshold, and a few percent of each anomaly.
+func DefaultConfig() Config {
+	return Config{
+		Seed:         1,
+		TranIDPrefix: "lg",
+		Corridors: []Corridor{
+			{SenderCountry: "USA", ReceiverCountry: "MEX", Weight: 50, SendingMembers: []string{"walmart", "cvs", "kroger"}, PayoutMembers: []string{"bancomer", "elektra"}, PayoutCurrency: "MXN", FXRate: 17.25},
+			{SenderCountry: "USA", ReceiverCountry: "PHL", Weight: 20, SendingMembers: []string{"walmart", "cvs"}, PayoutMembers: []string{"bdo"}, PayoutCurrency: "PHP", FXRate: 56.10},
+			{SenderCountry: "USA", ReceiverCountry: "IND", Weight: 20, SendingMembers: []string{"walmart", "kroger"}, PayoutMembers: []string{"icici"}, PayoutCurrency: "INR", FXRate: 83.20},
+			{SenderCountry: "GBR", ReceiverCountry: "NGA", Weight: 10, SendingMembers: []string{"tesco", "boots"}, PayoutMembers: []string{"gtbank"}},
+		},
+		Amounts:     Amounts{Median: 300, Sigma: 0.8, Min: 10, Max: 9000},
+		FeePercent:  1.5,
+		SenderReuse: 0.6,
+		Anomalies: Anomalies{
+			Duplicates:           0.01,
+			Structuring:          0.005,
+			StructuringThreshold: 3000,
+			StructuringBurst:     3,
+			Sanctions:            0.002,
+			SanctionedNames:      []string{"Sdn Testname One", "Sdn Testname Two", "Sdn Testname Three"},
+		},
+	}
+}
+
+// LoadConfig reads a Config from a JSON file. Fields the file leaves out
+// keep their DefaultConfig values.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	// Lists given in the file replace the defaults rather than being
+	// decoded over them
+	defaults := cfg
+	cfg.Corridors = nil
+	cfg.Anomalies.SanctionedNames = nil
+
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	if cfg.Corridors == nil {
+		cfg.Corridors = defaults.Corridors
+	}
+	if cfg.Anomalies.SanctionedNames == nil {
+		cfg.Anomalies.SanctionedNames = defaults.Anomalies.SanctionedNames
+	}
+	return cfg, cfg.Validate()
+}
+
+// Members returns every member the Config sends from or pays out at, in
+// the order they first appear.
+func (cfg Config) Members() []string {
+	var members []string
+	seen := map[string]bool{}
+	for _, c := range cfg.Corridors {
+		for _, list := range [][]string{c.SendingMembers, c.PayoutMembers} {
+			for _, m := range list {
+				if !seen[m] {
+					seen[m] = true
+					members = append(members, m)
+				}
+			}
+		}
+	}
+	return members
+}
+
+// Validate checks the Config can generate traffic.
+func (cfg Config) Validate() error {
+	if len(cfg.Corridors) == 0 {
+		return errors.New("at least one corridor is required")
+	}
+	var weight float64
+	for _, c := range cfg.Corridors {
+		name := c.SenderCountry + ">" + c.ReceiverCountry
+		if c.SenderCountry == "" || c.ReceiverCountry == "" {
+			return fmt.Errorf("corridor %s: both countries are required", name)
+		}
+		if c.Weight < 0 {
+			return fmt.Errorf("corridor %s: weight must not be negative", name)
+		}
+		if len(c.SendingMembers) == 0 || len(c.PayoutMembers) == 0 {
+			return fmt.Errorf("corridor %s: sending and payout members are required", name)
+		}
+		if c.PayoutCurrency != "" && c.FXRate <= 0 {
+			return fmt.Errorf("corridor %s: an fxRate is required with a payoutCurrency", name)
+		}
+		weight += c.Weight
+	}
+	if weight <= 0 {
+		return errors.New("at least one corridor must have a positive weight")
+	}
+
+	a := cfg.Amounts
+	if a.Median <= 0 || a.Sigma < 0 || a.Min <= 0 || a.Max < a.Min {
+		return errors.New("amounts need a positive median and 0 < min <= max")
+	}
+	if cfg.FeePercent < 0 {
+		return errors.New("feePercent must not be negative")
+	}
+	if cfg.SenderReuse < 0 || cfg.SenderReuse > 1 {
+		return errors.New("senderReuse must be between 0 and 1")
+	}
+
+	x := cfg.Anomalies
+	if x.Duplicates < 0 || x.Structuring < 0 || x.Sanctions < 0 || x.Duplicates+x.Structuring+x.Sanctions > 1 {
+		return errors.New("anomaly rates must be between 0 and 1 and add up to at most 1")
+	}
+	if x.Structuring > 0 && (x.StructuringThreshold <= 1 || x.StructuringBurst < 2) {
+		return errors.New("structuring needs a threshold above 1 and a burst of at least 2")
+	}
+	if x.Sanctions > 0 && len(x.SanctionedNames) == 0 {
+		return errors.New("sanctions hits need sanctionedNames")
+	}
+	return nil
+}
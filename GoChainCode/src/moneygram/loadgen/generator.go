@@ -0,0 +1,224 @@
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"moneygram/model"
+)
+
+// Transfer is one generated transfer and the anomaly, if any, it was
+// generated as.
+type Transfer struct {
+	Event   model.TransactionEvent `json:"event"`
+	Anomaly string                 `json:"anomaly,omitempty"`
+}
+
+const (
+	// maxSenders bounds the returning senders kept per corridor.
+	maxSenders = 10000
+	// maxRecent bounds the transfers a duplicate may copy.
+	maxRecent = 1000
+	// agentLocations is the number of agent locations of each sending
+	// member.
+	agentLocations = 200
+)
+
+var firstNames = []string{"Maria", "Jose", "Ana", "Juan", "Rosa", "Luis", "Carmen", "Pedro", "Grace", "Mark",
+	"Joy", "Paolo", "Priya", "Rahul", "Anita", "Vikram", "Chinedu", "Ngozi", "Emeka", "Amaka"}
+
+var lastNames = []string{"Garcia", "Hernandez", "Lopez", "Martinez", "Gonzalez", "Santos", "Reyes", "Cruz",
+	"Bautista", "Ramos", "Sharma", "Patel", "Singh", "Kumar", "Reddy", "Okafor", "Adeyemi", "Eze", "Nwosu", "Bello"}
+
+// sender is a returning customer: the same name, location and receiver
+// every time.
+type sender struct {
+	name     string
+	member   string
+	location string
+	receiver string
+}
+
+// Generator produces the transfers described by a Config. It is not safe
+// for concurrent use.
+type Generator struct {
+	cfg     Config
+	rnd     *rand.Rand
+	weight  float64
+	seq     int
+	senders [][]sender
+	recent  []model.TransactionEvent
+	pending []Transfer
+}
+
+// NewGenerator returns a Generator for cfg.
+func NewGenerator(cfg Config) (*Generator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TranIDPrefix == "" {
+		cfg.TranIDPrefix = "lg"
+	}
+
+	g := &Generator{
+		cfg:     cfg,
+		rnd:     rand.New(rand.NewSource(cfg.Seed)),
+		senders: make([][]sender, len(cfg.Corridors)),
+	}
+	for _, c := range cfg.Corridors {
+		g.weight += c.Weight
+	}
+	return g, nil
+}
+
+// Next returns the next transfer.
+func (g *Generator) Next() Transfer {
+	if len(g.pending) > 0 {
+		t := g.pending[0]
+		g.pending = g.pending[1:]
+		return g.remember(t)
+	}
+
+	x := g.cfg.Anomalies
+	r := g.rnd.Float64()
+
+	switch {
+	case r < x.Duplicates && len(g.recent) > 0:
+		e := g.recent[g.rnd.Intn(len(g.recent))]
+		return Transfer{Event: e, Anomaly: AnomalyDuplicate}
+
+	case r < x.Duplicates+x.Structuring:
+		g.pending = g.burst()
+		return g.Next()
+
+	case r < x.Duplicates+x.Structuring+x.Sanctions:
+		c := g.corridor()
+		e := g.transfer(c, g.party(c))
+		e.SenderName = x.SanctionedNames[g.rnd.Intn(len(x.SanctionedNames))]
+		return g.remember(Transfer{Event: e, Anomaly: AnomalySanctions})
+	}
+
+	c := g.corridor()
+	return g.remember(Transfer{Event: g.transfer(c, g.party(c))})
+}
+
+// remember keeps a transfer as one a later duplicate may copy.
+func (g *Generator) remember(t Transfer) Transfer {
+	if len(g.recent) < maxRecent {
+		g.recent = append(g.recent, t.Event)
+	} else {
+		g.recent[g.rnd.Intn(maxRecent)] = t.Event
+	}
+	return t
+}
+
+// burst returns a new sender's structuring transfers: each between 90% of
+// the threshold and just under it, and each from the next of the
+// corridor's sending members and another of its agent locations.
+func (g *Generator) burst() []Transfer {
+	x := g.cfg.Anomalies
+	c := g.corridor()
+	s := g.newSender(c)
+	threshold := int64(math.Round(x.StructuringThreshold * 100))
+	first := g.rnd.Intn(agentLocations)
+
+	transfers := make([]Transfer, x.StructuringBurst)
+	for i := range transfers {
+		s.member = g.cfg.Corridors[c].SendingMembers[i%len(g.cfg.Corridors[c].SendingMembers)]
+		s.location = agentLocation(s.member, (first+i)%agentLocations)
+		e := g.transfer(c, s)
+		cents := threshold - 1 - g.rnd.Int63n(threshold/10)
+		g.setAmount(&e, c, cents)
+		transfers[i] = Transfer{Event: e, Anomaly: AnomalyStructuring}
+	}
+	return transfers
+}
+
+// corridor picks a corridor by weight.
+func (g *Generator) corridor() int {
+	r := g.rnd.Float64() * g.weight
+	for i, c := range g.cfg.Corridors {
+		if r < c.Weight {
+			return i
+		}
+		r -= c.Weight
+	}
+	return len(g.cfg.Corridors) - 1
+}
+
+// party returns a returning sender of the corridor or, SenderReuse
+// permitting, a new one who is kept for later transfers.
+func (g *Generator) party(c int) sender {
+	pool := g.senders[c]
+	if len(pool) > 0 && g.rnd.Float64() < g.cfg.SenderReuse {
+		return pool[g.rnd.Intn(len(pool))]
+	}
+
+	s := g.newSender(c)
+	if len(pool) < maxSenders {
+		g.senders[c] = append(pool, s)
+	} else {
+		pool[g.rnd.Intn(maxSenders)] = s
+	}
+	return s
+}
+
+func (g *Generator) newSender(c int) sender {
+	corridor := g.cfg.Corridors[c]
+	member := corridor.SendingMembers[g.rnd.Intn(len(corridor.SendingMembers))]
+	return sender{
+		name:     g.name(),
+		member:   member,
+		location: agentLocation(member, g.rnd.Intn(agentLocations)),
+		receiver: g.name(),
+	}
+}
+
+// agentLocation returns the ID of one of a sending member's agent
+// locations.
+func agentLocation(member string, i int) string {
+	return fmt.Sprintf("%s-%03d", member, i)
+}
+
+// name returns a plausible full name. The middle initial keeps most
+// generated senders apart.
+func (g *Generator) name() string {
+	return fmt.Sprintf("%s %c. %s", firstNames[g.rnd.Intn(len(firstNames))], 'A'+g.rnd.Intn(26), lastNames[g.rnd.Intn(len(lastNames))])
+}
+
+// transfer returns a new transfer from s in corridor c with an amount drawn
+// from the distribution.
+func (g *Generator) transfer(c int, s sender) model.TransactionEvent {
+	corridor := g.cfg.Corridors[c]
+	g.seq++
+
+	e := model.TransactionEvent{
+		TranID:          fmt.Sprintf("%s-%08d", g.cfg.TranIDPrefix, g.seq),
+		SenderName:      s.name,
+		SenderCountry:   corridor.SenderCountry,
+		ReceiverName:    s.receiver,
+		ReceiverCountry: corridor.ReceiverCountry,
+		SendingMember:   s.member,
+		AgentLocation:   s.location,
+		PayoutMember:    corridor.PayoutMembers[g.rnd.Intn(len(corridor.PayoutMembers))],
+	}
+
+	a := g.cfg.Amounts
+	amount := math.Exp(math.Log(a.Median) + a.Sigma*g.rnd.NormFloat64())
+	amount = math.Max(a.Min, math.Min(a.Max, amount))
+	g.setAmount(&e, c, int64(math.Round(amount*100)))
+	return e
+}
+
+// setAmount sets the amount, fee and payout amount of e.
+func (g *Generator) setAmount(e *model.TransactionEvent, c int, cents int64) {
+	corridor := g.cfg.Corridors[c]
+
+	e.Amount = model.FormatAmount(cents)
+	e.Fee = model.FormatAmount(int64(math.Round(float64(cents) * g.cfg.FeePercent / 100)))
+	if corridor.PayoutCurrency != "" {
+		e.PayoutCurrency = corridor.PayoutCurrency
+		e.PayoutAmount = model.FormatAmount(int64(math.Round(float64(cents) * corridor.FXRate)))
+	}
+}
@@ -0,0 +1,302 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"moneygram/model"
+)
+
+// Target accepts generated transfers.
+type Target interface {
+	Submit(e model.TransactionEvent) error
+}
+
+// Invoker runs chaincode invokes. fabric.Client satisfies it.
+type Invoker interface {
+	Invoke(function string, args []string) (string, error)
+}
+
+// ChaincodeTarget submits each transfer with a create_event invoke. On a
+// Fabric v0.6 peer an invoke is accepted before the chaincode runs it, so
+// rejections and the chaincode's own time are not measured; count the
+// transfers on the ledger afterwards.
+type ChaincodeTarget struct {
+	Invoker Invoker
+}
+
+// Submit invokes create_event.
+func (t ChaincodeTarget) Submit(e model.TransactionEvent) error {
+	_, err := t.Invoker.Invoke("create_event", e.CreateArgs())
+	return err
+}
+
+// GatewayTarget posts each transfer to a gateway's /api/transfers.
+type GatewayTarget struct {
+	// URL is the gateway's address, e.g. http://localhost:8080.
+	URL string
+	// Token, when set, is the bearer token of the enrolled user the gateway
+	// submits as.
+	Token string
+	// HTTPClient is used for requests; one with a 30 second timeout when nil.
+	HTTPClient *http.Client
+}
+
+// Submit posts the transfer.
+func (t GatewayTarget) Submit(e model.TransactionEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(t.URL, "/")+"/api/transfers", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+
+	client := t.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var gwErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&gwErr) == nil && gwErr.Error != "" {
+			return errors.New(gwErr.Error)
+		}
+		return fmt.Errorf("gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Setup registers every member of cfg with the given net debit cap and has
+// each corridor's payout members grant its sending members the given
+// credit limit, so the traffic is not rejected for want of credit. The
+// invoker must act as an admin.
+func Setup(inv Invoker, cfg Config, netDebitCap string, limit string) error {
+	for _, m := range cfg.Members() {
+		if _, err := inv.Invoke("register_member", []string{m, m, netDebitCap}); err != nil {
+			return fmt.Errorf("registering %s: %v", m, err)
+		}
+	}
+
+	done := map[string]bool{}
+	for _, c := range cfg.Corridors {
+		for _, creditor := range c.PayoutMembers {
+			for _, debtor := range c.SendingMembers {
+				if creditor == debtor || done[creditor+">"+debtor] {
+					continue
+				}
+				done[creditor+">"+debtor] = true
+				if _, err := inv.Invoke("set_credit_limit", []string{creditor, debtor, limit}); err != nil {
+					return fmt.Errorf("setting the limit %s grants %s: %v", creditor, debtor, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Options controls a Run.
+type Options struct {
+	// Transfers is the number of transfers to submit.
+	Transfers int
+	// Concurrency is the number of submissions in flight; 1 when zero.
+	Concurrency int
+	// Rate is the most transfers started per second; unlimited when zero.
+	Rate float64
+	// Results, when set, receives one JSON line per transfer, in the order
+	// generated, with its anomaly and outcome.
+	Results io.Writer
+}
+
+// Result is the outcome of one submitted transfer.
+type Result struct {
+	TranID    string  `json:"tranID"`
+	Anomaly   string  `json:"anomaly,omitempty"`
+	Accepted  bool    `json:"accepted"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latencyMs"`
+}
+
+// Report summarises a Run.
+type Report struct {
+	Transfers  int                      `json:"transfers"`
+	Accepted   int                      `json:"accepted"`
+	Rejected   int                      `json:"rejected"`
+	Seconds    float64                  `json:"seconds"`
+	Throughput float64                  `json:"throughput"`
+	Latency    Latency                  `json:"latency"`
+	Anomalies  map[string]AnomalyResult `json:"anomalies"`
+	// Errors counts rejections by message, with the tranID taken out.
+	Errors map[string]int `json:"errors"`
+}
+
+// Latency percentiles of the submissions, in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// AnomalyResult counts the transfers generated as one anomaly.
+type AnomalyResult struct {
+	Sent     int `json:"sent"`
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+}
+
+// Run submits opts.Transfers transfers from g to target and reports how it
+// went. Transfers are generated in order but submitted concurrently, so
+// with Concurrency above 1 a duplicate may reach the target before the
+// transfer it copies.
+func Run(g *Generator, target Target, opts Options) (Report, error) {
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	transfers := make([]Transfer, opts.Transfers)
+	for i := range transfers {
+		transfers[i] = g.Next()
+	}
+
+	results := make([]Result, len(transfers))
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				t := transfers[i]
+				began := time.Now()
+				err := target.Submit(t.Event)
+				r := Result{TranID: t.Event.TranID, Anomaly: t.Anomaly, Accepted: err == nil,
+					LatencyMS: float64(time.Since(began)) / float64(time.Millisecond)}
+				if err != nil {
+					r.Error = err.Error()
+				}
+				results[i] = r
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := range transfers {
+		if opts.Rate > 0 {
+			due := start.Add(time.Duration(float64(i) / opts.Rate * float64(time.Second)))
+			time.Sleep(time.Until(due))
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := summarise(results, elapsed)
+
+	if opts.Results != nil {
+		enc := json.NewEncoder(opts.Results)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+func summarise(results []Result, elapsed time.Duration) Report {
+	report := Report{
+		Transfers: len(results),
+		Seconds:   elapsed.Seconds(),
+		Anomalies: map[string]AnomalyResult{},
+		Errors:    map[string]int{},
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+
+	latencies := make([]float64, 0, len(results))
+	var total float64
+
+	for _, r := range results {
+		latencies = append(latencies, r.LatencyMS)
+		total += r.LatencyMS
+
+		a := report.Anomalies[r.Anomaly]
+		a.Sent++
+		if r.Accepted {
+			report.Accepted++
+			a.Accepted++
+		} else {
+			report.Rejected++
+			a.Rejected++
+			report.Errors[strings.Replace(r.Error, r.TranID, "<tranID>", -1)]++
+		}
+		if r.Anomaly != "" {
+			report.Anomalies[r.Anomaly] = a
+		}
+	}
+
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		at := func(p float64) float64 {
+			return latencies[int(p*float64(len(latencies)-1))]
+		}
+		report.Latency = Latency{
+			Mean: total / float64(len(latencies)),
+			P50:  at(0.50),
+			P90:  at(0.90),
+			P99:  at(0.99),
+			Max:  latencies[len(latencies)-1],
+		}
+	}
+	return report
+}
+
+// Print writes the report for a person to read.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "transfers   %d in %.1fs, %.1f/s\n", r.Transfers, r.Seconds, r.Throughput)
+	fmt.Fprintf(w, "accepted    %d\n", r.Accepted)
+	fmt.Fprintf(w, "rejected    %d\n", r.Rejected)
+	fmt.Fprintf(w, "latency ms  mean %.2f  p50 %.2f  p90 %.2f  p99 %.2f  max %.2f\n",
+		r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+
+	for _, name := range []string{AnomalyDuplicate, AnomalyStructuring, AnomalySanctions} {
+		if a, ok := r.Anomalies[name]; ok {
+			fmt.Fprintf(w, "%-11s %d sent, %d accepted, %d rejected\n", name, a.Sent, a.Accepted, a.Rejected)
+		}
+	}
+
+	messages := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return r.Errors[messages[i]] > r.Errors[messages[j]] })
+	for _, msg := range messages {
+		fmt.Fprintf(w, "  %6d  %s\n", r.Errors[msg], msg)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Merkle commitments - When a batch closes, the hash of every transfer in it is stored and a Merkle root over them,
+//						  in batch order, is stored on the batch. An inclusion proof for one transfer is the sibling
+//						  hashes on the way from its leaf to the root, so an auditor or settlement bank holding the
+//						  transfer and the root can check it was in the batch without seeing any other transfer.
+//
+//						  Leaves and nodes are SHA-256 hashes prefixed as in RFC 6962: a leaf is SHA-256(0x00 || fields)
+//						  and a node SHA-256(0x01 || left || right). A node without a sibling is carried up a level
+//						  unchanged. The fields are those of the transfer that never change after it is created, each
+//						  written as a 4-byte big-endian length followed by its bytes, in the order of leaf_fields.
+//						  Keep moneygram/merkle in step with this file.
+//==============================================================================================================================
+const MERKLE_LEAF_PREFIX = 0x00
+const MERKLE_NODE_PREFIX = 0x01
+
+//==============================================================================================================================
+//	BatchLeaves - The leaf hashes of a closed batch, in the order of its tranIDs. Stored under "batchleaves_<batchID>" so
+//				  proofs do not depend on the transfers being read again.
+//==============================================================================================================================
+type BatchLeaves struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	BatchID       string   `json:"batchID"`
+	Leaves        []string `json:"leaves"`
+}
+
+//==============================================================================================================================
+//	InclusionProof - The result of get_inclusion_proof. Path runs from the leaf up to the root; Left is set on a step
+//					 whose hash is the left-hand sibling.
+//==============================================================================================================================
+type InclusionProof struct {
+	TranID     string      `json:"tranID"`
+	BatchID    string      `json:"batchID"`
+	MerkleRoot string      `json:"merkleRoot"`
+	LeafIndex  int         `json:"leafIndex"`
+	LeafCount  int         `json:"leafCount"`
+	LeafHash   string      `json:"leafHash"`
+	Path       []ProofStep `json:"path"`
+}
+
+//==============================================================================================================================
+//	ProofStep - One sibling hash of an InclusionProof.
+//==============================================================================================================================
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left,omitempty"`
+}
+
+//==============================================================================================================================
+//	 leaf_fields - The fields of a transfer a leaf commits to. Status, times of later changes and anything else that
+//				   changes after creation are left out so the leaf can be checked against the transfer at any time.
+//==============================================================================================================================
+func leaf_fields(e TransactionEvent) []string {
+	return []string{e.TranID, e.SenderName, e.SenderCountry, e.ReceiverName, e.ReceiverCountry, e.Amount, e.Fee,
+		e.PayoutCurrency, e.PayoutAmount, e.SendingMember, e.PayoutMember, e.BatchID, e.CreatedAt}
+}
+
+//==============================================================================================================================
+//	 leaf_hash - Returns the leaf hash of a transfer.
+//==============================================================================================================================
+func leaf_hash(e TransactionEvent) []byte {
+
+	h := sha256.New()
+	h.Write([]byte{MERKLE_LEAF_PREFIX})
+
+	for _, field := range leaf_fields(e) {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+
+	return h.Sum(nil)
+}
+
+//==============================================================================================================================
+//	 node_hash - Returns the hash of an inner node.
+//==============================================================================================================================
+func node_hash(left []byte, right []byte) []byte {
+
+	h := sha256.New()
+	h.Write([]byte{MERKLE_NODE_PREFIX})
+	h.Write(left)
+	h.Write(right)
+
+	return h.Sum(nil)
+}
+
+//==============================================================================================================================
+//	 next_level - Hashes each pair of nodes of a level into the level above, carrying a last unpaired node up as it is.
+//==============================================================================================================================
+func next_level(level [][]byte) [][]byte {
+
+	var next [][]byte
+
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, node_hash(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+
+	return next
+}
+
+//==============================================================================================================================
+//	 merkle_root - Returns the root over leaves. The root of a batch without transfers is the hash of nothing.
+//==============================================================================================================================
+func merkle_root(leaves [][]byte) []byte {
+
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+
+	level := leaves
+
+	for len(level) > 1 {
+		level = next_level(level)
+	}
+
+	return level[0]
+}
+
+//==============================================================================================================================
+//	 merkle_path - Returns the sibling hashes from leaves[index] up to the root. Levels where the node has no sibling are
+//				   skipped, as the node is carried up unchanged.
+//==============================================================================================================================
+func merkle_path(leaves [][]byte, index int) []ProofStep {
+
+	path := []ProofStep{}
+	level := leaves
+
+	for len(level) > 1 {
+
+		sibling := index ^ 1
+		if sibling < len(level) {
+			path = append(path, ProofStep{Hash: hex.EncodeToString(level[sibling]), Left: sibling < index})
+		}
+
+		level = next_level(level)
+		index /= 2
+	}
+
+	return path
+}
+
+//==============================================================================================================================
+//	 commit_batch - Hashes every transfer of a closing batch, stores the leaves and sets the batch's Merkle root.
+//==============================================================================================================================
+func (t *SimpleChaincode) commit_batch(stub shim.ChaincodeStubInterface, b *SettlementBatch) error {
+
+	record := BatchLeaves{SchemaVersion: SCHEMA_VERSION, BatchID: b.BatchID, Leaves: []string{}}
+	leaves := [][]byte{}
+
+	for _, tranID := range b.TranIDs {
+
+		e, err := t.retrieve_tranEvent(stub, tranID)
+		if err != nil {
+			return err
+		}
+
+		leaf := leaf_hash(e)
+		leaves = append(leaves, leaf)
+		record.Leaves = append(record.Leaves, hex.EncodeToString(leaf))
+	}
+
+	err := save_record(stub, "batchleaves_"+b.BatchID, record)
+	if err != nil {
+		return err
+	}
+
+	b.MerkleRoot = hex.EncodeToString(merkle_root(leaves))
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 get_inclusion_proof - Returns the proof that a transfer is in its settlement batch. The batch must be closed; the
+//						   proof is only as good as the root it is checked against, which should be read from the
+//						   batch separately. Like get_event_details, only admins and the transfer's sending and payout
+//						   members may have it.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_inclusion_proof(stub shim.ChaincodeStubInterface, tranID string) ([]byte, error) {
+
+	caller_member, caller_role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, errors.New("Error retrieving caller information")
+	}
+
+	e, err := t.retrieve_tranEvent(stub, tranID)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller_role != ROLE_ADMIN && caller_member != e.SendingMember && caller_member != e.PayoutMember {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. get_inclusion_proof. %v === %v|%v", caller_member, e.SendingMember, e.PayoutMember))
+	}
+
+	b, err := t.retrieve_batch(stub, e.BatchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Status != BATCH_CLOSED {
+		return nil, errors.New("Batch " + b.BatchID + " is still open. Inclusion proofs are available once it closes")
+	}
+
+	if b.MerkleRoot == "" {
+		return nil, errors.New("Batch " + b.BatchID + " was closed before Merkle roots were recorded")
+	}
+
+	var record BatchLeaves
+
+	found, err := read_record(stub, KIND_BATCH_LEAVES, "batchleaves_"+b.BatchID, &record)
+	if err != nil {
+		return nil, errors.New("get_inclusion_proof: " + err.Error())
+	}
+
+	if !found || len(record.Leaves) != len(b.TranIDs) {
+		return nil, errors.New("The leaves of batch " + b.BatchID + " are missing")
+	}
+
+	index := -1
+	for i, id := range b.TranIDs {
+		if id == tranID {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		return nil, errors.New("Transfer " + tranID + " is not in batch " + b.BatchID)
+	}
+
+	leaves := make([][]byte, len(record.Leaves))
+	for i, leaf := range record.Leaves {
+		leaves[i], err = hex.DecodeString(leaf)
+		if err != nil {
+			return nil, errors.New("Corrupt leaf in batch " + b.BatchID)
+		}
+	}
+
+	proof := InclusionProof{
+		TranID:     tranID,
+		BatchID:    b.BatchID,
+		MerkleRoot: b.MerkleRoot,
+		LeafIndex:  index,
+		LeafCount:  len(leaves),
+		LeafHash:   record.Leaves[index],
+		Path:       merkle_path(leaves, index),
+	}
+
+	bytes, err := json.Marshal(proof)
+	if err != nil {
+		return nil, errors.New("Error converting inclusion proof")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,169 @@
+// Package merkle checks that a transfer was in a settlement batch. When a
+// batch closes the chaincode stores a Merkle root over its transfers, and
+// get_inclusion_proof returns the sibling hashes from one transfer up to that
+// root. An auditor or settlement bank holding the transfer, the proof and a
+// root it trusts can run Verify without seeing any other transfer.
+//
+// Leaves and nodes are SHA-256 hashes with the prefixes of RFC 6962: a leaf
+// is SHA-256(0x00 || fields) and a node SHA-256(0x01 || left || right). A node
+// without a sibling is carried up a level unchanged, so the tree is not the
+// RFC 6962 tree for batches whose size is not a power of two. The fields are
+// those of the transfer that never change after it is created, each written
+// as a 4-byte big-endian length followed by its bytes, in the order LeafHash
+// lists them. This package must stay in step with merkle.go in the chaincode.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"moneygram/model"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// LeafHash returns the hex leaf hash of a transfer as returned by
+// get_event_details.
+func LeafHash(e model.TransactionEvent) string {
+	fields := []string{e.TranID, e.SenderName, e.SenderCountry, e.ReceiverName, e.ReceiverCountry, e.Amount, e.Fee,
+		e.PayoutCurrency, e.PayoutAmount, e.SendingMember, e.PayoutMember, e.BatchID, e.CreatedAt}
+
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	for _, f := range fields {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(f)))
+		h.Write(size[:])
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Root returns the hex root over hex leaf hashes in batch order, for
+// checking a batch whose transfers are all known.
+func Root(leaves []string) (string, error) {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		var err error
+		if level[i], err = decode(leaf); err != nil {
+			return "", fmt.Errorf("leaf %d: %v", i, err)
+		}
+	}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0]), nil
+}
+
+// VerifyPath checks that the proof's path leads from its leaf hash to root.
+// The root must come from somewhere the caller trusts, such as the closed
+// batch read from a peer of its own, not from the proof.
+func VerifyPath(p model.InclusionProof, root string) error {
+	if p.LeafCount <= 0 || p.LeafIndex < 0 || p.LeafIndex >= p.LeafCount {
+		return fmt.Errorf("leaf %d of %d is out of range", p.LeafIndex, p.LeafCount)
+	}
+	if len(p.Path) != pathLength(p.LeafIndex, p.LeafCount) {
+		return fmt.Errorf("a proof for leaf %d of %d has %d steps, not %d", p.LeafIndex, p.LeafCount, len(p.Path), pathLength(p.LeafIndex, p.LeafCount))
+	}
+
+	node, err := decode(p.LeafHash)
+	if err != nil {
+		return fmt.Errorf("leaf hash: %v", err)
+	}
+	want, err := decode(root)
+	if err != nil {
+		return fmt.Errorf("root: %v", err)
+	}
+
+	// The side of each sibling follows from the leaf's position; a proof
+	// that says otherwise has been tampered with
+	index, count, step := p.LeafIndex, p.LeafCount, 0
+	for count > 1 {
+		sibling := index ^ 1
+		if sibling < count {
+			s := p.Path[step]
+			if s.Left != (sibling < index) {
+				return fmt.Errorf("step %d is on the wrong side", step)
+			}
+			hash, err := decode(s.Hash)
+			if err != nil {
+				return fmt.Errorf("step %d: %v", step, err)
+			}
+			if s.Left {
+				node = nodeHash(hash, node)
+			} else {
+				node = nodeHash(node, hash)
+			}
+			step++
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+
+	if !bytes.Equal(node, want) {
+		return errors.New("the proof does not lead to the root")
+	}
+	return nil
+}
+
+// Verify checks that e was in the batch with the given root: that e hashes
+// to the proof's leaf and the proof leads from it to root.
+func Verify(p model.InclusionProof, e model.TransactionEvent, root string) error {
+	if e.TranID != p.TranID || e.BatchID != p.BatchID {
+		return fmt.Errorf("the proof is for %s in batch %s, not %s in batch %s", p.TranID, p.BatchID, e.TranID, e.BatchID)
+	}
+	if LeafHash(e) != p.LeafHash {
+		return errors.New("the transfer does not match the leaf the proof is for")
+	}
+	return VerifyPath(p, root)
+}
+
+// pathLength returns the number of siblings on the way up from a leaf.
+func pathLength(index, count int) int {
+	n := 0
+	for count > 1 {
+		if index^1 < count {
+			n++
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+	return n
+}
+
+func decode(h string) ([]byte, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("%q is not a hex SHA-256 hash", h)
+	}
+	return b, nil
+}
@@ -0,0 +1,139 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"moneygram/merkle"
+	"moneygram/model"
+)
+
+func TestInclusionProof(t *testing.T) {
+
+	for _, size := range []int{1, 2, 3, 5, 8} {
+		t.Run(fmt.Sprint(size, " transfers"), func(t *testing.T) {
+
+			l := new_network(t)
+			for i := 0; i < size; i++ {
+				l.invoke("create_event", transfer(fmt.Sprint("t", i), fmt.Sprint(10+i)).CreateArgs()...)
+			}
+
+			l.query_fails("still open", "get_inclusion_proof", "t0")
+			l.invoke("close_settlement_batch")
+
+			var b model.SettlementBatch
+			l.query(&b, "get_settlement_batch", "1")
+
+			leaves := []string{}
+
+			for i := 0; i < size; i++ {
+				tranID := fmt.Sprint("t", i)
+
+				var e model.TransactionEvent
+				l.query(&e, "get_event_details", tranID)
+
+				var p model.InclusionProof
+				l.query(&p, "get_inclusion_proof", tranID)
+
+				if err := merkle.Verify(p, e, b.MerkleRoot); err != nil {
+					t.Fatalf("%v: %v", tranID, err)
+				}
+				leaves = append(leaves, merkle.LeafHash(e))
+
+				tests := []struct {
+					name string
+					p    model.InclusionProof
+					e    model.TransactionEvent
+					root string
+				}{
+					{"changed amount", p, with_amount(e, "999"), b.MerkleRoot},
+					{"other transfer", p, with_tran_id(e, "t99"), b.MerkleRoot},
+					{"other root", p, e, merkle.LeafHash(with_amount(e, "999"))},
+					{"moved leaf", with_index(p, (p.LeafIndex+1)%size), e, b.MerkleRoot},
+				}
+
+				for _, tt := range tests {
+					if tt.name == "moved leaf" && size == 1 {
+						continue
+					}
+					if err := merkle.Verify(tt.p, tt.e, tt.root); err == nil {
+						t.Fatalf("%v: %v verified", tranID, tt.name)
+					}
+				}
+			}
+
+			root, err := merkle.Root(leaves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != b.MerkleRoot {
+				t.Fatalf("root %v, the batch has %v", root, b.MerkleRoot)
+			}
+		})
+	}
+}
+
+func with_amount(e model.TransactionEvent, amount string) model.TransactionEvent {
+	e.Amount = amount
+	return e
+}
+
+func with_tran_id(e model.TransactionEvent, tranID string) model.TransactionEvent {
+	e.TranID = tranID
+	return e
+}
+
+func with_index(p model.InclusionProof, index int) model.InclusionProof {
+	p.LeafIndex = index
+	return p
+}
+
+func TestInclusionProofAccess(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		caller map[string]string
+		err    string
+	}{
+		{"admin", admin_caller, ""},
+		{"sending member", walmart_caller, ""},
+		{"payout member", bancomer_caller, ""},
+		{"other member", map[string]string{"role": ROLE_MEMBER, "member": "ria"}, "Permission Denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("register_member", "ria", "Ria", "10000")
+			for i := 0; i < 3; i++ {
+				l.invoke("create_event", transfer(fmt.Sprint("t", i), "10").CreateArgs()...)
+			}
+			l.invoke("close_settlement_batch")
+
+			l.SetCaller(tt.caller)
+
+			if tt.err != "" {
+				l.query_fails(tt.err, "get_inclusion_proof", "t1")
+				return
+			}
+
+			// The proof checks against the root of the caller's own view of the batch
+			var e model.TransactionEvent
+			l.query(&e, "get_event_details", "t1")
+
+			var b model.SettlementBatch
+			l.query(&b, "get_settlement_batch", "1")
+
+			var p model.InclusionProof
+			l.query(&p, "get_inclusion_proof", "t1")
+
+			if err := merkle.Verify(p, e, b.MerkleRoot); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
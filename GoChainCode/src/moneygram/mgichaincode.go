@@ -4,40 +4,105 @@ import (
 	"fmt"
 	"errors"
 	"encoding/json"
+	"strconv"
+	"time"
+	"sync"
+	"router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
 var logger = shim.NewLogger("CLDChaincode")
 
+//==============================================================================================================================
+//	 Participant roles - Each caller's eCert carries a 'role' attribute, and a 'member' attribute naming the network
+//						 member (e.g. moneygram, walmart, bancomer) they act for. Compliance reviewers work the
+//						 network-wide reporting queues. Auditors read transfers for audit extracts: their own
+//						 member's, or every member's when they act for the member that deployed the chaincode.
+//==============================================================================================================================
+const   ROLE_ADMIN       =  "admin"
+const   ROLE_MEMBER      =  "member"
+const   ROLE_COMPLIANCE  =  "compliance"
+const   ROLE_AUDITOR     =  "auditor"
+
+//==============================================================================================================================
+//	 SCAN_MAX_EVENTS - The most entries of the tranIDs index one scan_events query reads.
+//==============================================================================================================================
+const   SCAN_MAX_EVENTS  =  1000
+
+//==============================================================================================================================
+//	 Transfer status types - A transfer is sent when it is created and paid when its payout member confirms the payout
+//							 (see payout.go). Each change of status is counted in the statistics.
+//==============================================================================================================================
+const   STATUS_SENT   =  "sent"
+const   STATUS_PAID   =  "paid"
+
+//==============================================================================================================================
+//	 Init modes - Init always preserves the data already on the ledger. There is no reset: emptying the indexes alone
+//				  would leave every record they list behind, and deleting every record does not fit in one transaction.
+//				  Deploy the chaincode under a new name for an empty ledger.
+//==============================================================================================================================
+const   INIT_PRESERVE  =  "preserve"
+
+//==============================================================================================================================
+//	 TIME_LAYOUT - How times are stored on records. Every time is the timestamp of the transaction that wrote it, never
+//				   the peer's clock, so every endorsing peer writes the same value.
+//==============================================================================================================================
+const   TIME_LAYOUT    =  time.RFC3339
+
 //==============================================================================================================================
 //	 Structure Definitions
 //==============================================================================================================================
-//	Chaincode - A blank struct for use with Shim (A HyperLedger included go file used for get/put state
-//				and other HyperLedger functions)
+//	Chaincode - The struct for use with Shim (A HyperLedger included go file used for get/put state
+//				and other HyperLedger functions). It only holds the routing table, which is built once and shared
+//				by every call. The peer entry points for each Fabric release are in fabric06.go and fabric1.go.
 //==============================================================================================================================
 type  SimpleChaincode struct {
-}
-
-func main() {
-	err := shim.Start(new(SimpleChaincode))
-	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
-	}
+	routes_once		sync.Once
+	routing_table	*router.Router
 }
 
 //==============================================================================================================================
 //	TransactionEvent - Defines the structure for a event object. JSON on right tells it what JSON fields to map to
 //			  that element when reading a JSON object into the struct e.g. JSON datetime -> Struct datetime.
+//			  DateTime and AccountNumber are not set by create_event; they are kept for events written by releases
+//			  that did set them. Fee is charged in the settlement currency; a transfer paid out in another currency
+//			  has a PayoutCurrency and the PayoutAmount promised in it. CreatedAt, UpdatedAt and StatusHistory are
+//			  transaction timestamps; events written before schema version 3 do not have them. ReferenceNumber is the
+//			  number the receiver quotes at pickup (see pickup.go); events written before schema version 5 do not
+//			  have one. AgentLocation is the sending member's ID for the agent location that took the transfer,
+//			  when it was given; events written before schema version 6 do not have one.
 //==============================================================================================================================
 type TransactionEvent struct {
+	SchemaVersion         int    `json:"schemaVersion"`
 	TranID           	  string `json:"tranID"`
 	SenderName            string `json:"senderName"`
 	SenderCountry         string `json:"senderCountry"`
 	ReceiverName          string `json:"receiverName"`
 	ReceiverCountry       string `json:"receiverCountry"`
 	Amount		          string `json:"amount"`
-//	DateTime	          string `json:"datetime"`
-//	AccountNumber         string `json:"accountNumber"`
+	SendingMember         string `json:"sendingMember"`
+	PayoutMember          string `json:"payoutMember"`
+	BatchID               string `json:"batchID"`
+	Status                string `json:"status"`
+	Fee                   string `json:"fee"`
+	PayoutCurrency        string `json:"payoutCurrency,omitempty"`
+	PayoutAmount          string `json:"payoutAmount,omitempty"`
+	ReferenceNumber       string `json:"referenceNumber,omitempty"`
+	AgentLocation         string `json:"agentLocation,omitempty"`
+	DateTime	          string `json:"datetime,omitempty"`
+	AccountNumber         string `json:"accountNumber,omitempty"`
+	CreatedAt             string `json:"createdAt,omitempty"`
+	UpdatedAt             string `json:"updatedAt,omitempty"`
+	StatusHistory         []StatusChange `json:"statusHistory,omitempty"`
+}
+
+//==============================================================================================================================
+//	StatusChange - A status a transfer reached, when, and in which transaction.
+//==============================================================================================================================
+type StatusChange struct {
+	Status                string `json:"status"`
+	At                    string `json:"at"`
+	TxID                  string `json:"txID"`
 }
 
 //==============================================================================================================================
@@ -45,78 +110,778 @@ type TransactionEvent struct {
 //				    Used as an index when querying all transactions.
 //==============================================================================================================================
 type TRAN_Holder struct {
-	TranIDs []string `json:"tranID"`
+	SchemaVersion int      `json:"schemaVersion"`
+	TranIDs       []string `json:"tranID"`
+}
+
+//==============================================================================================================================
+//	Change Holder - Defines the structure that holds the tranID of every change of status, in the order they were made.
+//					Stored under "changes" so copies of the ledger can pick up changes without reading every event.
+//==============================================================================================================================
+type CHANGE_Holder struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	TranIDs       []string `json:"tranIDs"`
+}
+
+//==============================================================================================================================
+//	EventPage - One page of TransactionEvents returned by get_events, in the order they were created, or by get_changes.
+//				Total is the number of events visible to the caller before paging, or the number of changes.
+//==============================================================================================================================
+type EventPage struct {
+	Total            int                `json:"total"`
+	Offset           int                `json:"offset"`
+	Events           []TransactionEvent `json:"events"`
+}
+
+//==============================================================================================================================
+//	EventScan - The events scan_events read from Position up to Next in the tranIDs index. Member is the member they
+//				were limited to, empty for every member.
+//==============================================================================================================================
+type EventScan struct {
+	Member           string             `json:"member,omitempty"`
+	Position         int                `json:"position"`
+	Next             int                `json:"next"`
+	More             bool               `json:"more"`
+	Events           []TransactionEvent `json:"events"`
 }
 
 
 //==============================================================================================================================
-//	Init Function - Called when the user deploys the chaincode
+//	Deployment - Who deployed the chaincode, with which version and parameters, and every later Init. Stored under
+//				 "deployment" by the first Init.
+//==============================================================================================================================
+type Deployment struct {
+	SchemaVersion         int                `json:"schemaVersion"`
+	ChaincodeVersion      string             `json:"chaincodeVersion"`
+	DeployedBy            string             `json:"deployedBy"`
+	DeployTxID            string             `json:"deployTxID"`
+	DeployedAt            string             `json:"deployedAt,omitempty"`
+	Parameters            []string           `json:"parameters"`
+	Reinitialisations     []Reinitialisation `json:"reinitialisations"`
+}
+
+//==============================================================================================================================
+//	Reinitialisation - An Init after the first, from a chaincode upgrade or the 'init' invoke.
+//==============================================================================================================================
+type Reinitialisation struct {
+	ChaincodeVersion      string             `json:"chaincodeVersion"`
+	By                    string             `json:"by"`
+	TxID                  string             `json:"txID"`
+	At                    string             `json:"at,omitempty"`
+	Mode                  string             `json:"mode"`
+	Parameters            []string           `json:"parameters"`
+}
+
+//==============================================================================================================================
+//	Init Function - Called when the user deploys or upgrades the chaincode, and by the 'init' invoke.
+//==============================================================================================================================
+//	Args - version, [mode]. The first Init creates the indexes and records the deployment. Once the ledger holds data
+//		   only an admin may call Init again; the only mode, preserve, records the new version and creates any missing
+//		   index.
 //==============================================================================================================================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke Init Method")
-	
-	var tranHld TRAN_Holder
-	  
-    if len(args) != 1 {
-        return nil, errors.New("Incorrect number of arguments. Expecting 1")
-    }
 
-    bytes, err := json.Marshal(tranHld)
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2 (version, [mode])")
+	}
 
-    if err != nil { 
-    	return nil, errors.New("Error creating TRAN_Holder record") 
-    }
+	mode := INIT_PRESERVE
+	if len(args) > 1 {
+		mode = args[1]
+	}
 
-	err = stub.PutState("tranIDs", bytes)
-	
-    return nil, nil
+	if mode != INIT_PRESERVE {
+		return nil, errors.New("Invalid init mode " + mode + ". Expecting " + INIT_PRESERVE + "; deploy the chaincode under a new name for an empty ledger")
+	}
+
+	deployment, deployed, err := t.retrieve_deployment(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := stub.GetState("tranIDs")
+	if err != nil {
+		return nil, errors.New("Unable to get tranIDs")
+	}
+
+	// The deployer's eCert may not carry attributes, so they are only required once there is data to protect
+	caller_member, caller_role, err := t.get_caller_data(stub)
+
+	if deployed || existing != nil {
+		if err != nil {
+			return nil, errors.New("Error retrieving caller information")
+		}
+		if caller_role != ROLE_ADMIN {
+			return nil, errors.New(fmt.Sprintf("Permission Denied. init. %v === %v", caller_role, ROLE_ADMIN))
+		}
+	}
+
+	tranHld := TRAN_Holder{TranIDs: []string{}}
+	if existing != nil {
+		tranHld, err = t.retrieve_tran_ids(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	memberHld, err := t.retrieve_member_ids(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.save_tran_ids(stub, tranHld)
+	if err != nil {
+		return nil, err
+	}
+
+	memberHld.SchemaVersion = SCHEMA_VERSION
+
+	bytes, err := json.Marshal(memberHld)
+	if err != nil {
+		return nil, errors.New("Error creating MEMBER_Holder record")
+	}
+
+	err = stub.PutState("memberIDs", bytes)
+	if err != nil {
+		return nil, errors.New("Error storing memberIDs")
+	}
+
+	// A new ledger is written at the current schema version, so there is nothing to migrate
+	if !deployed && existing == nil {
+		err = t.save_migration(stub, MigrationStatus{SchemaVersion: SCHEMA_VERSION, Stage: migration_stages[len(migration_stages)-1], Done: true})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now, err := tx_timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if !deployed {
+		deployment = Deployment{
+			ChaincodeVersion:  args[0],
+			DeployedBy:        caller_member,
+			DeployTxID:        stub.GetTxID(),
+			DeployedAt:        now,
+			Parameters:        args,
+			Reinitialisations: []Reinitialisation{},
+		}
+	} else {
+		deployment.ChaincodeVersion = args[0]
+		deployment.Reinitialisations = append(deployment.Reinitialisations, Reinitialisation{
+			ChaincodeVersion: args[0],
+			By:               caller_member,
+			TxID:             stub.GetTxID(),
+			At:               now,
+			Mode:             mode,
+			Parameters:       args,
+		})
+	}
+
+	return nil, t.save_deployment(stub, deployment)
+}
+
+//==============================================================================================================================
+//	 retrieve_deployment - Reads the deployment record. Returns false if Init has not recorded one yet.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_deployment(stub shim.ChaincodeStubInterface) (Deployment, bool, error) {
+
+	var deployment Deployment
+
+	found, err := read_record(stub, KIND_DEPLOYMENT, "deployment", &deployment)
+	if err != nil {
+		return deployment, found, errors.New("Unable to get deployment: " + err.Error())
+	}
+
+	return deployment, found, nil
+}
+
+//==============================================================================================================================
+//	 save_deployment - Writes the deployment record.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_deployment(stub shim.ChaincodeStubInterface, deployment Deployment) error {
+
+	deployment.SchemaVersion = SCHEMA_VERSION
+
+	bytes, err := json.Marshal(deployment)
+	if err != nil {
+		return errors.New("Error converting deployment record")
+	}
+
+	err = stub.PutState("deployment", bytes)
+	if err != nil {
+		return errors.New("Error storing deployment record")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 get_deployment - Returns the deployment record.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_deployment(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	deployment, deployed, err := t.retrieve_deployment(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if !deployed {
+		return nil, errors.New("No deployment has been recorded")
+	}
+
+	bytes, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, errors.New("Error converting deployment record")
+	}
+
+	return bytes, nil
 }
 
 //==============================================================================================================================
 //	Router Functions
 //==============================================================================================================================
-//	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function.
+//	Invoke - Called on chaincode invoke. Looks the function up in the routing table and calls it.
 //==============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "init" {													//initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	}else if function == "create_event" {
-        return t.create_event(stub, args)
-	}else if function == "ping" {
-        return t.ping(stub)
-    }
-	
-	return nil, errors.New("Received unknown function invocation: " + function)
+	return t.routes().Invoke(stub, function, args)
 }
 
 //=================================================================================================================================
-//	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
-//  		initial arguments passed are passed on to the called function.
+//	Query - Called on chaincode query. Looks the function up in the routing table and calls it.
 //=================================================================================================================================
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("Query is running " + function)
-	
-	if function == "get_event_details" {
-		if len(args) != 1 { 
-			fmt.Printf("Incorrect number of arguments passed"); 
-			return nil, errors.New("QUERY: Incorrect number of arguments passed") 
-		}
-		
-		tranEvent, err := t.retrieve_tranEvent(stub, args[0])
-		if err != nil { 
-			fmt.Printf("QUERY: Error retrieving tranEvent: %s", err); 
-			return nil, errors.New("QUERY: Error retrieving tranEvent "+err.Error()) 
-		}
-		
-		bytes, err := json.Marshal(tranEvent)
-		
-		return bytes, nil
+
+	return t.routes().Query(stub, function, args)
+}
+
+//=================================================================================================================================
+//	routes - Returns the routing table, built the first time it is needed and kept for the life of the chaincode.
+//=================================================================================================================================
+func (t *SimpleChaincode) routes() *router.Router {
+
+	t.routes_once.Do(func() {
+		t.routing_table = t.build_routes()
+	})
+
+	return t.routing_table
+}
+
+//=================================================================================================================================
+//	build_routes - Builds the routing table. Each function declares whether it is an invoke or a query, the roles that may
+//				   call it and its arguments. The router checks these before calling the function and lists them for
+//				   'describe'.
+//=================================================================================================================================
+func (t *SimpleChaincode) build_routes() *router.Router {
+
+	r := router.New(t.get_caller)
+
+	r.Add(router.Function{
+		Name: "init", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{
+			{Name: "version", Type: router.String},
+			{Name: "mode", Type: router.String, Optional: true},
+		},
+		Description: "Records a new chaincode version, keeping the data on the ledger. The only mode is 'preserve'.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.Init(stub, "init", c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "create_event", Kind: router.Invoke,
+		Args: []router.Arg{
+			{Name: "tranID", Type: router.String},
+			{Name: "senderName", Type: router.String},
+			{Name: "senderCountry", Type: router.String},
+			{Name: "receiverName", Type: router.String},
+			{Name: "receiverCountry", Type: router.String},
+			{Name: "amount", Type: router.Positive},
+			{Name: "sendingMember", Type: router.String},
+			{Name: "payoutMember", Type: router.String},
+			{Name: "fee", Type: router.Amount, Optional: true},
+			{Name: "payoutCurrency", Type: router.Text, Optional: true},
+			{Name: "payoutAmount", Type: router.Text, Optional: true},
+			{Name: "pickupCodeHash", Type: router.Hash, Optional: true, Empty: true},
+			{Name: "agentLocation", Type: router.Text, Optional: true},
+		},
+		Description: "Records a transfer and assigns its reference number. The caller must be an admin or act for the sending member. payoutCurrency and payoutAmount go together and may both be empty. A pickupCodeHash, the keyed hash of a pickup code, makes it a cash pickup transfer; it may be empty when an agentLocation, the sending member's ID for the agent location that took the transfer, is given.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.create_event(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "create_events", Kind: router.Invoke,
+		Args: []router.Arg{{Name: "transfers", Type: router.JSON}},
+		Description: "Records a JSON array of transfers, each with the create_event fields and an optional pickupCodeHash, all or none. Fails naming the rejected transfers if any is rejected; otherwise returns the settlement batch each transfer joined.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.create_events(stub, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "diagnostics", Kind: router.Both,
+		Description: "Reports the chaincode and schema version, index sizes, the open settlement batch, the configuration hash and any index inconsistencies.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.diagnostics(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "register_member", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{
+			{Name: "memberID", Type: router.String},
+			{Name: "name", Type: router.String},
+			{Name: "netDebitCap", Type: router.Amount},
+		},
+		Description: "Adds a member to the network, or renames it and changes its net debit cap.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.register_member(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "set_net_debit_cap", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{
+			{Name: "memberID", Type: router.String},
+			{Name: "netDebitCap", Type: router.Amount},
+		},
+		Description: "Changes a member's net debit cap.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.set_net_debit_cap(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "set_credit_limit", Kind: router.Invoke,
+		Args: []router.Arg{
+			{Name: "creditor", Type: router.String},
+			{Name: "debtor", Type: router.String},
+			{Name: "limit", Type: router.Amount},
+		},
+		Description: "Sets the credit the creditor grants the debtor. The caller must be an admin or act for the creditor.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil { 
+				return nil, errors.New("Error retrieving caller information") 
+			}
+			return t.set_credit_limit(stub, caller.ID, caller.Role, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "close_settlement_batch", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Description: "Nets the open batch into obligations, closes it and opens the next one. Returns the closed batch.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.close_settlement_batch(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "migrate", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{{Name: "limit", Type: router.Int, Optional: true}},
+		Description: "Upgrades up to limit (default 100) stored records to the current schema version, continuing from the previous call.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			limit := 100
+			if len(c.Args) == 1 {
+				limit, _ = strconv.Atoi(c.Args[0])
+			}
+			return t.migrate(stub, limit)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_migration_status", Kind: router.Query,
+		Description: "Returns the progress of migrate.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_migration_status(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_statistics", Kind: router.Query,
+		Args: []router.Arg{
+			{Name: "dimension", Type: router.String},
+			{Name: "key", Type: router.String},
+			{Name: "from", Type: router.String},
+			{Name: "to", Type: router.String, Optional: true},
+		},
+		Description: "Returns transfer counts, principal, fees and payout amounts by day (YYYY-MM-DD) or month (YYYY-MM) for the network ('all'), a corridor ('USA>Mexico') or a sending or payout member.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.get_statistics(stub, caller.ID, caller.Role, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "set_compliance_config", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{
+			{Name: "ctrThreshold", Type: router.Amount},
+			{Name: "sarThreshold", Type: router.Amount},
+			{Name: "nearMissPercent", Type: router.Int},
+			{Name: "maxDailySends", Type: router.Int},
+		},
+		Description: "Sets the currency transaction report and suspicious activity thresholds.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.set_compliance_config(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_compliance_config", Kind: router.Query, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Description: "Returns the reporting thresholds.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_compliance_config(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_ctr_reports", Kind: router.Query, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Args: []router.Arg{
+			{Name: "offset", Type: router.Int},
+			{Name: "limit", Type: router.Int},
+		},
+		Description: "Returns a page of currency transaction reports, oldest first.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_reports(stub, "ctrIDs", c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_sar_candidates", Kind: router.Query, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Args: []router.Arg{
+			{Name: "offset", Type: router.Int},
+			{Name: "limit", Type: router.Int},
+			{Name: "status", Type: router.String, Optional: true},
+		},
+		Description: "Returns a page of suspicious activity candidates, oldest first, optionally only those with a status.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_reports(stub, "sarIDs", c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "disposition_sar_candidate", Kind: router.Invoke, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Args: []router.Arg{
+			{Name: "sarID", Type: router.String},
+			{Name: "disposition", Type: router.String},
+			{Name: "note", Type: router.Text},
+		},
+		Description: "Marks a suspicious activity candidate filed, dismissed or escalated.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.disposition_sar_candidate(stub, caller.ID, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "set_detection_rule", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{
+			{Name: "ruleID", Type: router.String},
+			{Name: "enabled", Type: router.Bool},
+			{Name: "threshold", Type: router.Amount},
+			{Name: "percent", Type: router.Int},
+			{Name: "minCount", Type: router.Int},
+			{Name: "windowDays", Type: router.Int, Optional: true},
+		},
+		Description: "Changes a structuring detection rule. windowDays is how many days before a batch's last transfer the rule looks back; the rule keeps its window when it is omitted.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.set_detection_rule(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_detection_rules", Kind: router.Query, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Description: "Returns the structuring detection rules.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_detection_rules(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "detect_structuring", Kind: router.Query, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Args: []router.Arg{{Name: "batchID", Type: router.String}},
+		Description: "Runs the detection rules over a settlement batch, or 'open', and the earlier transfers in their windows without raising alerts.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.detect_structuring(stub, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "raise_alerts", Kind: router.Invoke, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Args: []router.Arg{{Name: "batchID", Type: router.String}},
+		Description: "Runs the detection rules over a settlement batch, or 'open', and the earlier transfers in their windows, and records what they find as alerts.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.raise_alerts(stub, caller.ID, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_alerts", Kind: router.Query, Roles: []string{ROLE_ADMIN, ROLE_COMPLIANCE},
+		Args: []router.Arg{
+			{Name: "offset", Type: router.Int},
+			{Name: "limit", Type: router.Int},
+			{Name: "ruleID", Type: router.String, Optional: true},
+		},
+		Description: "Returns a page of structuring alerts, oldest first, optionally only those of a rule.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_reports(stub, "alertIDs", c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "verify_pickup", Kind: router.Invoke,
+		Args: []router.Arg{
+			{Name: "referenceNumber", Type: router.String},
+			{Name: "pickupCodeHash", Type: router.Hash},
+		},
+		Description: "Checks the keyed hash of the pickup code a receiver gives against a cash pickup transfer's. The caller must be an admin or act for the payout member. Bad codes are counted and lock the transfer after " + strconv.Itoa(PICKUP_MAX_ATTEMPTS) + " in a row.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.verify_pickup(stub, caller.ID, caller.Role, c.Args[0], c.Args[1])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "unlock_pickup", Kind: router.Invoke, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{{Name: "referenceNumber", Type: router.String}},
+		Description: "Clears the bad pickup codes counted against a cash pickup transfer and unlocks it.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.unlock_pickup(stub, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_pickup", Kind: router.Query,
+		Args: []router.Arg{{Name: "referenceNumber", Type: router.String}},
+		Description: "Returns whether a cash pickup transfer's code has been verified, the bad codes counted and whether it is locked. The caller must be an admin or act for the sending or payout member.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.get_pickup(stub, caller.ID, caller.Role, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "confirm_payout", Kind: router.Invoke,
+		Args: []router.Arg{
+			{Name: "tranID", Type: router.String},
+			{Name: "receiverIDHash", Type: router.Hash},
+			{Name: "location", Type: router.String},
+			{Name: "currency", Type: router.String},
+			{Name: "amount", Type: router.Amount},
+		},
+		Description: "Confirms a transfer has been paid to its receiver and moves it to paid. Only the payout member may confirm, once, in the currency and amount promised; a cash pickup transfer needs a verified pickup code. The receiver's ID document is only ever sent as a hash keyed by the payout member.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.confirm_payout(stub, caller.ID, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_payout", Kind: router.Query,
+		Args: []router.Arg{{Name: "tranID", Type: router.String}},
+		Description: "Returns the payout confirmation of a paid transfer. The caller must be an admin or act for the sending or payout member.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.get_payout(stub, caller.ID, caller.Role, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_deployment", Kind: router.Query,
+		Description: "Returns who deployed the chaincode, the version and parameters, and every later Init.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_deployment(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_event_details", Kind: router.Query,
+		Args: []router.Arg{{Name: "tranID", Type: router.String}},
+		Description: "Returns a transfer. The caller must be an admin or act for the sending or payout member.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.get_event_details(stub, caller.ID, caller.Role, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_events", Kind: router.Query,
+		Args: []router.Arg{
+			{Name: "offset", Type: router.Int},
+			{Name: "limit", Type: router.Int},
+			{Name: "member", Type: router.String, Optional: true},
+		},
+		Description: "Returns a page of transfers, optionally only those a member sent or pays out.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_events(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "scan_events", Kind: router.Query,
+		Args: []router.Arg{
+			{Name: "position", Type: router.Int},
+			{Name: "limit", Type: router.Int},
+			{Name: "member", Type: router.String, Optional: true},
+		},
+		Description: "Returns the transfers from a position in the tranIDs index, optionally only those a member sent or pays out, and the position to carry on from.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.scan_events(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_changes", Kind: router.Query, Roles: []string{ROLE_ADMIN},
+		Args: []router.Arg{
+			{Name: "offset", Type: router.Int},
+			{Name: "limit", Type: router.Int},
+		},
+		Description: "Returns a page of the transfers whose status changed, as they are now, in the order the changes were made.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_changes(stub, c.Args)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_exposure", Kind: router.Query,
+		Args: []router.Arg{{Name: "memberID", Type: router.String}},
+		Description: "Returns a member's net position, cap and bilateral exposures. Members may only read their own.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil {
+				return nil, errors.New("Error retrieving caller information")
+			}
+			return t.get_exposure(stub, caller.ID, caller.Role, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_members", Kind: router.Query,
+		Description: "Returns the IDs of every registered member.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_members(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_settlement_batch", Kind: router.Query,
+		Args: []router.Arg{{Name: "batchID", Type: router.String}},
+		Description: "Returns a settlement batch, or the open batch for batchID 'open'. Members only see their own transfers and obligations in it.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_settlement_batch(stub, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_settlement_batches", Kind: router.Query,
+		Description: "Returns the IDs of every settlement batch; the last one is open.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_settlement_batches(stub)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_inclusion_proof", Kind: router.Query,
+		Args: []router.Arg{{Name: "tranID", Type: router.String}},
+		Description: "Returns the Merkle proof that a transfer is in its closed settlement batch, to admins and the transfer's sending and payout members.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_inclusion_proof(stub, c.Args[0])
+		},
+	})
+
+	return r
+}
+
+//==============================================================================================================================
+//	 get_caller - Returns the caller's member and role for the router.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_caller(stub shim.ChaincodeStubInterface) (router.Caller, error) {
+
+	member, role, err := t.get_caller_data(stub)
+	if err != nil { 
+		return router.Caller{}, err 
+	}
+
+	return router.Caller{ID: member, Role: role}, nil
+}
+
+//==============================================================================================================================
+//	 get_caller_data - Reads the 'member' and 'role' attributes from the caller's eCert. Returns the member the caller
+//					 acts for and their role.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (string, string, error) {
+
+	member, err := read_cert_attribute(stub, "member")
+	if err != nil { 
+		return "", "", errors.New("Couldn't get attribute 'member'. Error: " + err.Error()) 
+	}
+
+	role, err := read_cert_attribute(stub, "role")
+	if err != nil { 
+		return "", "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error()) 
 	}
 
-	return nil, errors.New("Received unknown function invocation " + function)
+	return string(member), string(role), nil
+}
+
+//==============================================================================================================================
+//	 tx_time - Returns the timestamp of the transaction, which every endorsing peer sees the same. Chaincode must never
+//			   use the peer's clock.
+//==============================================================================================================================
+func tx_time(stub shim.ChaincodeStubInterface) (time.Time, error) {
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, errors.New("Unable to get the transaction timestamp")
+	}
+
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+//==============================================================================================================================
+//	 tx_timestamp - Returns the timestamp of the transaction as stored on records, in RFC 3339 form in UTC.
+//==============================================================================================================================
+func tx_timestamp(stub shim.ChaincodeStubInterface) (string, error) {
+
+	now, err := tx_time(stub)
+	if err != nil {
+		return "", err
+	}
+
+	return now.Format(TIME_LAYOUT), nil
 }
 
 //==============================================================================================================================
@@ -128,90 +893,531 @@ func (t *SimpleChaincode) retrieve_tranEvent(stub shim.ChaincodeStubInterface, t
 
 	var tranEvent TransactionEvent
 
-	bytes, err := stub.GetState(tranEventID);
+	found, err := read_record(stub, KIND_EVENT, tranEventID, &tranEvent)
 
 	if err != nil {	
 		fmt.Printf("retrieve_tranEvent: Failed to retrieving TransactionEvent: %s", err); 
-		return tranEvent, errors.New("retrieve_tranEvent: Error retrieving TransactionEvent with tranEventID = " + tranEventID) 
+		return tranEvent, errors.New("retrieve_tranEvent: " + err.Error()) 
 	}
 
-	err = json.Unmarshal(bytes, &tranEvent);
-
-    if err != nil {	
-    	fmt.Printf("retrieve_tranEvent: Corrupt Event record "+string(bytes)+": %s", err); 
-    	return tranEvent, errors.New("retrieve_tranEvent: Corrupt Event record"+string(bytes))	
-    }
+	if !found {
+		return tranEvent, errors.New("retrieve_tranEvent: Unknown TransactionEvent with tranEventID = " + tranEventID)
+	}
 
 	return tranEvent, nil
 }
 
+//==============================================================================================================================
+//	 retrieve_tran_ids - Reads the tranIDs index.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_tran_ids(stub shim.ChaincodeStubInterface) (TRAN_Holder, error) {
+
+	var tranHld TRAN_Holder
+
+	found, err := read_record(stub, KIND_TRAN_HOLDER, "tranIDs", &tranHld)
+
+	if err != nil {
+		return tranHld, errors.New("Unable to get tranIDs: " + err.Error())
+	}
+
+	if !found {
+		return tranHld, errors.New("Unable to get tranIDs")
+	}
+
+	return tranHld, nil
+}
+
+//==============================================================================================================================
+//	 save_tran_ids - Writes the tranIDs index.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_tran_ids(stub shim.ChaincodeStubInterface, tranHld TRAN_Holder) error {
+
+	tranHld.SchemaVersion = SCHEMA_VERSION
+
+	bytes, err := json.Marshal(tranHld)
+	if err != nil {
+		return errors.New("Error creating TRAN_Holder record")
+	}
+
+	err = stub.PutState("tranIDs", bytes)
+	if err != nil {
+		fmt.Printf("save_tran_ids: Error storing TranIDs: %s", err);
+		return errors.New("Error storing TranIDs")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 get_event_details - Returns the transaction event with the given tranID. As with get_events, callers that are not
+//						 admins only see their own member's events.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_event_details(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, tranID string) ([]byte, error) {
+
+	tranEvent, err := t.retrieve_tranEvent(stub, tranID)
+	if err != nil { 
+		fmt.Printf("QUERY: Error retrieving tranEvent: %s", err); 
+		return nil, errors.New("QUERY: Error retrieving tranEvent "+err.Error()) 
+	}
+
+	if caller_role != ROLE_ADMIN && caller_member != tranEvent.SendingMember && caller_member != tranEvent.PayoutMember {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. get_event_details. %v === %v|%v", caller_member, tranEvent.SendingMember, tranEvent.PayoutMember))
+	}
+
+	bytes, err := json.Marshal(tranEvent)
+	if err != nil { 
+		return nil, errors.New("Error converting transaction event") 
+	}
+
+	return bytes, nil
+}
+
 //=================================================================================================================================
 //	 Create Function
 //=================================================================================================================================
 //	 Create Transaction Event - Creates the initial JSON for the Transaction Event and then saves it to the ledger.
 //=================================================================================================================================
 func (t *SimpleChaincode) create_event(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	idx := new_event_index()
+
+	_, err := t.add_event(stub, args, idx)
+	if err != nil { 
+		return nil, err 
+	}
+
+	return nil, t.save_event_index(stub, idx) 
+}
+
+//=================================================================================================================================
+//	 add_event - Checks and saves a new transaction event with everything it is counted in, except the indexes that list
+//				 every transfer: the tranIDs index, the open batch and the statistics are updated in idx and written by
+//				 save_event_index, once for however many events were added. Returns the saved event.
+//=================================================================================================================================
+func (t *SimpleChaincode) add_event(stub shim.ChaincodeStubInterface, args []string, idx *event_index) (TransactionEvent, error) {
 	var tEvent TransactionEvent
-	
-	tranID     			:= "\"TranID\":\""+args[0]+"\", "
-	senderName     		:= "\"SenderName\":\""+args[1]+"\", "
-	senderCountry       := "\"SenderCountry\":\""+args[2]+"\", "
-	receiverName     	:= "\"ReceiverName\":\""+args[3]+"\", "
-	receiverCountry     := "\"ReceiverCountry\":\""+args[4]+"\", "
-	amount     			:= "\"Amount\":\""+args[5]+"\", "
 
-    // Concatenates the variables to create the total JSON object
-	event_json := "{"+tranID+senderName+senderCountry+receiverName+receiverCountry+amount+"}" 		
-	// Convert the JSON defined above into a TransactionEvent object for go
-	err := json.Unmarshal([]byte(event_json), &tEvent)										
+	//Args
+	//	   0		1			2			  3			  4				5		 6				7			8		9				10				11				12
+	//	tranID, senderName, senderCountry, receiverName, receiverCountry, amount, sendingMember, payoutMember, [fee], [payoutCurrency], [payoutAmount], [pickupCodeHash], [agentLocation]
+
+	caller_member, caller_role, err := t.get_caller_data(stub)
 	if err != nil { 
-		return nil, errors.New("Invalid JSON object") 
+		return TransactionEvent{}, errors.New("Error retrieving caller information") 
 	}
 
-	bytes, err := json.Marshal(tEvent)
+	tEvent.TranID          = args[0]
+	tEvent.SenderName      = args[1]
+	tEvent.SenderCountry   = args[2]
+	tEvent.ReceiverName    = args[3]
+	tEvent.ReceiverCountry = args[4]
+	tEvent.Amount          = args[5]
+	tEvent.SendingMember   = args[6]
+	tEvent.PayoutMember    = args[7]
+	tEvent.Status          = STATUS_SENT
+
+	if tEvent.TranID == "" {
+		return TransactionEvent{}, errors.New("Invalid tranID provided")
+	}
+
+	if is_record_key(tEvent.TranID) {
+		return TransactionEvent{}, errors.New("Invalid tranID provided. " + tEvent.TranID + " is reserved for another kind of record")
+	}
+
+	amount, err := parse_amount(tEvent.Amount)
 	if err != nil { 
-		return nil, errors.New("Error converting transaction event") 
+		return TransactionEvent{}, errors.New("Invalid amount: " + err.Error()) 
+	}
+
+	if amount == 0 {
+		return TransactionEvent{}, errors.New("Invalid amount: Amount must be greater than zero")
+	}
+
+	var fee int64
+	if len(args) > 8 {
+		fee, err = parse_amount(args[8])
+		if err != nil { 
+			return TransactionEvent{}, errors.New("Invalid fee: " + err.Error()) 
+		}
+	}
+	tEvent.Fee = format_amount(fee)
+
+	if len(args) == 10 {
+		return TransactionEvent{}, errors.New("A payoutCurrency must be given with a payoutAmount")
+	}
+
+	if len(args) > 10 {
+		tEvent.PayoutCurrency = args[9]
+		tEvent.PayoutAmount   = args[10]
+	}
+
+	if (tEvent.PayoutCurrency == "") != (tEvent.PayoutAmount == "") {
+		return TransactionEvent{}, errors.New("A payoutCurrency must be given with a payoutAmount")
+	}
+
+	if tEvent.PayoutAmount != "" {
+		_, err = parse_amount(tEvent.PayoutAmount)
+		if err != nil { 
+			return TransactionEvent{}, errors.New("Invalid payoutAmount: " + err.Error()) 
+		}
+	}
+
+	pickupCodeHash := ""
+	if len(args) > 11 {
+		pickupCodeHash = args[11]
+	}
+
+	if len(args) > 12 {
+		tEvent.AgentLocation = args[12]
+	}
+
+	if caller_role != ROLE_ADMIN && caller_member != tEvent.SendingMember {
+		return TransactionEvent{}, errors.New(fmt.Sprintf("Permission Denied. create_event. %v === %v", caller_member, tEvent.SendingMember))
+	}
+
+	record, err := stub.GetState(tEvent.TranID)
+	if err != nil { 
+		return TransactionEvent{}, errors.New("Unable to get " + tEvent.TranID) 
+	}
+
+	if record != nil { 
+		return TransactionEvent{}, errors.New("Transaction event already exists") 
+	}
+
+	// Reserve the payout member's credit before the event is written
+	err = t.apply_exposure(stub, tEvent.SendingMember, tEvent.PayoutMember, amount)
+	if err != nil { 
+		return TransactionEvent{}, err 
+	}
+
+	tEvent.BatchID, err = idx.open_batch_id(t, stub)
+	if err != nil { 
+		return TransactionEvent{}, err 
+	}
+
+	now, err := tx_time(stub)
+	if err != nil { 
+		return TransactionEvent{}, err 
+	}
+
+	tEvent.CreatedAt     = now.Format(TIME_LAYOUT)
+	tEvent.StatusHistory = []StatusChange{{Status: tEvent.Status, At: tEvent.CreatedAt, TxID: stub.GetTxID()}}
+
+	tEvent.ReferenceNumber, err = t.assign_reference(stub, tEvent.TranID)
+	if err != nil { 
+		return TransactionEvent{}, err 
+	}
+
+	if pickupCodeHash != "" {
+		err = t.set_pickup_code(stub, tEvent, pickupCodeHash)
+		if err != nil { 
+			return TransactionEvent{}, err 
+		}
+	}
+
+	err = t.record_statistics(stub, idx, tEvent, now, true)
+	if err != nil { 
+		return TransactionEvent{}, err 
+	}
+
+	err = t.check_compliance(stub, tEvent, amount, now)
+	if err != nil { 
+		return TransactionEvent{}, err 
 	}
 
 	// Save new tran event record
+	err = t.save_event(stub, tEvent)
+	if err != nil { 
+		return TransactionEvent{}, err 
+	}
+
+	idx.tranIDs = append(idx.tranIDs, tEvent.TranID)
+
+	return tEvent, nil 
+}
+
+
+//=================================================================================================================================
+//	 save_event - Writes a transaction event to the ledger, stamped with the time of the transaction.
+//=================================================================================================================================
+func (t *SimpleChaincode) save_event(stub shim.ChaincodeStubInterface, tEvent TransactionEvent) error {
+
+	var err error
+
+	tEvent.SchemaVersion = SCHEMA_VERSION
+
+	tEvent.UpdatedAt, err = tx_timestamp(stub)
+	if err != nil { 
+		return err 
+	}
+
+	bytes, err := json.Marshal(tEvent)
+	if err != nil { 
+		return errors.New("Error converting transaction event") 
+	}
+
 	err = stub.PutState(tEvent.TranID, bytes)
 	if err != nil { 
-		fmt.Printf("create_event: Error storing transaction event: %s", err); 
-		return nil, errors.New("Error storing transaction event") 
+		fmt.Printf("save_event: Error storing transaction event: %s", err); 
+		return errors.New("Error storing transaction event") 
 	}
 
-	// Update tranIDs with newly created ID and store it in chain.
-	bytes, err = stub.GetState("tranIDs")
+	return nil
+}
+
+//=================================================================================================================================
+//	 change_status - Moves a transfer to a new status, adds it to the status history, counts the change in the
+//					 statistics and appends it to the changes index. The caller saves the event.
+//=================================================================================================================================
+func (t *SimpleChaincode) change_status(stub shim.ChaincodeStubInterface, tEvent *TransactionEvent, status string) error {
+
+	now, err := tx_time(stub)
 	if err != nil { 
-		return nil, errors.New("Unable to get tranIDs") 
+		return err 
 	}
 
-	var tranHld TRAN_Holder
-	err = json.Unmarshal(bytes, &tranHld)
-	if err != nil {	
-		return nil, errors.New("Corrupt TRAN_Holder record") 
+	tEvent.Status        = status
+	tEvent.StatusHistory = append(tEvent.StatusHistory, StatusChange{Status: status, At: now.Format(TIME_LAYOUT), TxID: stub.GetTxID()})
+
+	err = t.record_statistics(stub, nil, *tEvent, now, false)
+	if err != nil { 
+		return err 
 	}
 
-	tranHld.TranIDs = append(tranHld.TranIDs, args[0])
-	bytes, err = json.Marshal(tranHld)
+	changeHld, err := t.retrieve_changes(stub)
+	if err != nil { 
+		return err 
+	}
 
-	err = stub.PutState("tranIDs", bytes)
+	changeHld.SchemaVersion = SCHEMA_VERSION
+	changeHld.TranIDs       = append(changeHld.TranIDs, tEvent.TranID)
+
+	bytes, err := json.Marshal(changeHld)
+	if err != nil { 
+		return errors.New("Error creating CHANGE_Holder record") 
+	}
+
+	err = stub.PutState("changes", bytes)
 	if err != nil { 
-		fmt.Printf("create_event: Error storing TranIDs: %s", err); 
-		return nil, errors.New("Error storing TranIDs") 
+		return errors.New("Error storing changes") 
 	}
 
-	return nil, nil 
+	return nil
 }
 
+//==============================================================================================================================
+//	 retrieve_changes - Reads the changes index. A ledger where no status has changed yet has none.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_changes(stub shim.ChaincodeStubInterface) (CHANGE_Holder, error) {
+
+	changeHld := CHANGE_Holder{TranIDs: []string{}}
+
+	_, err := read_record(stub, KIND_CHANGE_HOLDER, "changes", &changeHld)
+	if err != nil { 
+		return changeHld, errors.New("Unable to get changes: " + err.Error()) 
+	}
+
+	return changeHld, nil
+}
 
 //=================================================================================================================================
-//	 Ping Function
+//	 get_changes - Returns a page of the changes index: the transfers whose status changed, each as it is now, in the
+//				   order the changes were made. A transfer appears once for every change. Total is the number of
+//				   changes, so a copy of the ledger that has read up to Total carries on from there next time.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_changes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	offset, err := strconv.Atoi(args[0])
+	if err != nil || offset < 0 {
+		return nil, errors.New("Invalid offset: " + args[0])
+	}
+
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit <= 0 {
+		return nil, errors.New("Invalid limit: " + args[1])
+	}
+
+	changeHld, err := t.retrieve_changes(stub)
+	if err != nil { 
+		return nil, err 
+	}
+
+	page := EventPage{Total: len(changeHld.TranIDs), Offset: offset, Events: []TransactionEvent{}}
+
+	for i := offset; i < len(changeHld.TranIDs) && len(page.Events) < limit; i++ {
+		tEvent, err := t.retrieve_tranEvent(stub, changeHld.TranIDs[i])
+		if err != nil { 
+			return nil, err 
+		}
+		page.Events = append(page.Events, tEvent)
+	}
+
+	bytes, err := json.Marshal(page)
+	if err != nil { 
+		return nil, errors.New("Error converting event page") 
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 event_scope - Returns the member whose events a caller may read for function, given the member they asked for.
+//				   Admins, and auditors acting for the member that deployed the chaincode, may read any member's events
+//				   or, asking for none, every event. Everyone else only ever reads their own member's.
+//=================================================================================================================================
+func (t *SimpleChaincode) event_scope(stub shim.ChaincodeStubInterface, function string, member string) (string, error) {
+
+	caller_member, caller_role, err := t.get_caller_data(stub)
+	if err != nil { 
+		return "", errors.New("Error retrieving caller information") 
+	}
+
+	if caller_role == ROLE_ADMIN {
+		return member, nil
+	}
+
+	if caller_role == ROLE_AUDITOR {
+		deployment, _, err := t.retrieve_deployment(stub)
+		if err != nil { 
+			return "", err 
+		}
+		if deployment.DeployedBy != "" && deployment.DeployedBy == caller_member {
+			return member, nil
+		}
+	}
+
+	if member != "" && member != caller_member {
+		return "", errors.New(fmt.Sprintf("Permission Denied. %v. %v === %v", function, caller_member, member))
+	}
+
+	return caller_member, nil
+}
+
 //=================================================================================================================================
-//	 Pings the peer to keep the connection alive
+//	 get_events - Returns a page of transaction events. Args are offset, limit and an optional member; only events that
+//				  member sent or pays out are returned, and Total counts them all. Which members a caller may read is
+//				  set by event_scope. Without a member the page is read straight from the tranIDs index; with one
+//				  every event is read to count them, so callers paging through all of them use scan_events.
 //=================================================================================================================================
-func (t *SimpleChaincode) ping(stub shim.ChaincodeStubInterface) ([]byte, error) {
-	return []byte("Hello, world!"), nil
+func (t *SimpleChaincode) get_events(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	offset, err := strconv.Atoi(args[0])
+	if err != nil || offset < 0 {
+		return nil, errors.New("Invalid offset: " + args[0])
+	}
+
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit <= 0 {
+		return nil, errors.New("Invalid limit: " + args[1])
+	}
+
+	member := ""
+	if len(args) == 3 {
+		member = args[2]
+	}
+
+	member, err = t.event_scope(stub, "get_events", member)
+	if err != nil { 
+		return nil, err 
+	}
+
+	tranHld, err := t.retrieve_tran_ids(stub)
+	if err != nil { 
+		return nil, err 
+	}
+
+	page := EventPage{Offset: offset, Events: []TransactionEvent{}}
+
+	if member == "" {
+		page.Total = len(tranHld.TranIDs)
+
+		for i := offset; i < len(tranHld.TranIDs) && len(page.Events) < limit; i++ {
+			tEvent, err := t.retrieve_tranEvent(stub, tranHld.TranIDs[i])
+			if err != nil { 
+				return nil, err 
+			}
+			page.Events = append(page.Events, tEvent)
+		}
+	} else {
+		for _, tranID := range tranHld.TranIDs {
+			tEvent, err := t.retrieve_tranEvent(stub, tranID)
+			if err != nil { 
+				return nil, err 
+			}
+
+			if tEvent.SendingMember != member && tEvent.PayoutMember != member {
+				continue
+			}
+
+			if page.Total >= offset && len(page.Events) < limit {
+				page.Events = append(page.Events, tEvent)
+			}
+			page.Total++
+		}
+	}
+
+	bytes, err := json.Marshal(page)
+	if err != nil { 
+		return nil, errors.New("Error converting event page") 
+	}
+
+	return bytes, nil
 }
 
+//=================================================================================================================================
+//	 scan_events - Returns the next events from a position in the tranIDs index. Args are position, limit and an
+//				   optional member, scoped as for get_events. Reading stops after limit events or SCAN_MAX_EVENTS
+//				   entries of the index, whichever comes first; Next is the position to carry on from and More is false
+//				   once the end of the index is reached. A page may hold no events while there are more to come.
+//=================================================================================================================================
+func (t *SimpleChaincode) scan_events(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	position, err := strconv.Atoi(args[0])
+	if err != nil || position < 0 {
+		return nil, errors.New("Invalid position: " + args[0])
+	}
+
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit <= 0 {
+		return nil, errors.New("Invalid limit: " + args[1])
+	}
+
+	member := ""
+	if len(args) == 3 {
+		member = args[2]
+	}
 
+	member, err = t.event_scope(stub, "scan_events", member)
+	if err != nil { 
+		return nil, err 
+	}
+
+	tranHld, err := t.retrieve_tran_ids(stub)
+	if err != nil { 
+		return nil, err 
+	}
+
+	scan := EventScan{Member: member, Position: position, Next: position, Events: []TransactionEvent{}}
+
+	for scan.Next < len(tranHld.TranIDs) && len(scan.Events) < limit && scan.Next-position < SCAN_MAX_EVENTS {
+		tEvent, err := t.retrieve_tranEvent(stub, tranHld.TranIDs[scan.Next])
+		if err != nil { 
+			return nil, err 
+		}
+		scan.Next++
+
+		if member != "" && tEvent.SendingMember != member && tEvent.PayoutMember != member {
+			continue
+		}
+		scan.Events = append(scan.Events, tEvent)
+	}
+
+	scan.More = scan.Next < len(tranHld.TranIDs)
+
+	bytes, err := json.Marshal(scan)
+	if err != nil { 
+		return nil, errors.New("Error converting event scan") 
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,182 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"ledgersim"
+	"moneygram/model"
+)
+
+var (
+	admin_caller    = map[string]string{"role": ROLE_ADMIN, "member": "moneygram"}
+	walmart_caller  = map[string]string{"role": ROLE_MEMBER, "member": "walmart"}
+	bancomer_caller = map[string]string{"role": ROLE_MEMBER, "member": "bancomer"}
+)
+
+// test_pickup_key is the key the tests hash pickup codes and receiver IDs with.
+var test_pickup_key = []byte("0123456789abcdef0123456789abcdef")
+
+//==============================================================================================================================
+//	test_ledger - A ledgersim ledger whose calls fail the test they are made from.
+//==============================================================================================================================
+type test_ledger struct {
+	*ledgersim.Ledger
+	t *testing.T
+}
+
+//==============================================================================================================================
+//	 new_network - Returns a ledger with walmart and bancomer registered, each with a net debit cap of 10000.00 and
+//				   extending the other 5000.00 of credit. Calls are made as the MoneyGram admin.
+//==============================================================================================================================
+func new_network(t *testing.T) test_ledger {
+
+	l := test_ledger{ledgersim.New("mgi", new(SimpleChaincode)), t}
+	l.SetCaller(admin_caller)
+
+	if _, err := l.Init("init", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	l.invoke("register_member", "walmart", "Walmart", "10000")
+	l.invoke("register_member", "bancomer", "Bancomer", "10000")
+	l.invoke("set_credit_limit", "bancomer", "walmart", "5000")
+	l.invoke("set_credit_limit", "walmart", "bancomer", "5000")
+
+	return l
+}
+
+//==============================================================================================================================
+//	 invoke - Runs an invoke that must succeed and returns its result.
+//==============================================================================================================================
+func (l test_ledger) invoke(function string, args ...string) []byte {
+
+	l.t.Helper()
+
+	out, err := l.Invoke(function, args...)
+	if err != nil {
+		l.t.Fatalf("%v: %v", function, err)
+	}
+
+	return out
+}
+
+//==============================================================================================================================
+//	 invoke_fails - Runs an invoke that must fail with an error containing want.
+//==============================================================================================================================
+func (l test_ledger) invoke_fails(want string, function string, args ...string) {
+
+	l.t.Helper()
+
+	_, err := l.Invoke(function, args...)
+	expect_error(l.t, err, want)
+}
+
+//==============================================================================================================================
+//	 query - Runs a query that must succeed and decodes its result into v.
+//==============================================================================================================================
+func (l test_ledger) query(v interface{}, function string, args ...string) {
+
+	l.t.Helper()
+
+	out, err := l.Query(function, args...)
+	if err != nil {
+		l.t.Fatalf("%v: %v", function, err)
+	}
+
+	if err := json.Unmarshal(out, v); err != nil {
+		l.t.Fatalf("%v: %v", function, err)
+	}
+}
+
+//==============================================================================================================================
+//	 query_fails - Runs a query that must fail with an error containing want.
+//==============================================================================================================================
+func (l test_ledger) query_fails(want string, function string, args ...string) {
+
+	l.t.Helper()
+
+	_, err := l.Query(function, args...)
+	expect_error(l.t, err, want)
+}
+
+//==============================================================================================================================
+//	 expect_error - Fails the test unless err contains want.
+//==============================================================================================================================
+func expect_error(t *testing.T, err error, want string) {
+
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("succeeded, want an error containing %q", want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("error %q, want one containing %q", err, want)
+	}
+}
+
+//==============================================================================================================================
+//	 transfer - Returns a transfer from walmart to bancomer.
+//==============================================================================================================================
+func transfer(tranID string, amount string) model.TransactionEvent {
+
+	return model.TransactionEvent{
+		TranID:          tranID,
+		SenderName:      "Ann Smith",
+		SenderCountry:   "USA",
+		ReceiverName:    "Jose Perez",
+		ReceiverCountry: "Mexico",
+		Amount:          amount,
+		SendingMember:   "walmart",
+		PayoutMember:    "bancomer",
+	}
+}
+
+func TestCreateEvent(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		caller map[string]string
+		args   []string
+		err    string
+	}{
+		{"admin", admin_caller, transfer("t1", "100").CreateArgs(), ""},
+		{"sending member", walmart_caller, transfer("t1", "100.50").CreateArgs(), ""},
+		{"with payout", walmart_caller, append(transfer("t1", "100").CreateArgs(), "0", "MXN", "1700.00"), ""},
+		{"with agent location", walmart_caller, append(transfer("t1", "100").CreateArgs(), "0", "", "", "", "walmart-001"), ""},
+		{"other member", bancomer_caller, transfer("t1", "100").CreateArgs(), "Permission Denied"},
+		{"bad amount", walmart_caller, transfer("t1", "100.001").CreateArgs(), "amount"},
+		{"negative amount", walmart_caller, transfer("t1", "-5").CreateArgs(), "amount"},
+		{"too few args", walmart_caller, transfer("t1", "100").CreateArgs()[:7], "arguments"},
+		{"same member", admin_caller, []string{"t1", "Ann", "USA", "Jose", "Mexico", "100", "walmart", "walmart"}, "must differ"},
+		{"unknown member", admin_caller, []string{"t1", "Ann", "USA", "Jose", "Mexico", "100", "walmart", "ria"}, "Unknown member ria"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.SetCaller(tt.caller)
+
+			if tt.err != "" {
+				l.invoke_fails(tt.err, "create_event", tt.args...)
+				return
+			}
+
+			l.invoke("create_event", tt.args...)
+
+			var e TransactionEvent
+			l.query(&e, "get_event_details", "t1")
+
+			if e.Status != STATUS_SENT || e.BatchID != "1" || e.SchemaVersion != SCHEMA_VERSION {
+				t.Fatalf("created %+v", e)
+			}
+
+			l.invoke_fails("already exists", "create_event", tt.args...)
+		})
+	}
+}
@@ -0,0 +1,451 @@
+// Package model mirrors the records the MoneyGram chaincode keeps on the ledger so
+// that off-chain tools can decode query results. The chaincode does not import
+// this package, so keep the JSON tags here in step with it. ParseAmount and
+// FormatAmount repeat the chaincode's parse_amount and format_amount; the
+// chaincode's tests check the two agree.
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// SettlementCurrency is the currency every amount on the ledger is held in.
+const SettlementCurrency = "USD"
+
+// Transfer statuses. A transfer is sent when it is created and paid once its
+// payout member has confirmed the payout.
+const (
+	StatusSent = "sent"
+	StatusPaid = "paid"
+)
+
+// Settlement batch statuses.
+const (
+	BatchOpen   = "open"
+	BatchClosed = "closed"
+)
+
+// SchemaVersion is the version of the records the chaincode writes. Records
+// written before versioning have no schemaVersion and read as 0.
+const SchemaVersion = 6
+
+// TransactionEvent is a single remittance as stored by create_event.
+// DateTime and AccountNumber are only present on events written by early
+// releases of the chaincode. Fee is in the settlement currency; PayoutAmount
+// is in PayoutCurrency and only set when the sender chose one. CreatedAt,
+// UpdatedAt and the StatusHistory times are RFC 3339 transaction timestamps;
+// events written before schema version 3 do not have them. ReferenceNumber
+// is assigned by create_event from schema version 5. A cash pickup transfer
+// is created with a PickupCodeHash; PickupCode only carries the code as far
+// as the client that hashes it with HashPickupCode, and is never sent to the
+// chaincode. AgentLocation is the sending member's ID for the agent location
+// that took the transfer; the structuring rules count locations by it.
+type TransactionEvent struct {
+	SchemaVersion   int            `json:"schemaVersion,omitempty"`
+	TranID          string         `json:"tranID"`
+	SenderName      string         `json:"senderName"`
+	SenderCountry   string         `json:"senderCountry"`
+	ReceiverName    string         `json:"receiverName"`
+	ReceiverCountry string         `json:"receiverCountry"`
+	Amount          string         `json:"amount"`
+	SendingMember   string         `json:"sendingMember"`
+	PayoutMember    string         `json:"payoutMember"`
+	BatchID         string         `json:"batchID"`
+	Status          string         `json:"status,omitempty"`
+	Fee             string         `json:"fee,omitempty"`
+	PayoutCurrency  string         `json:"payoutCurrency,omitempty"`
+	PayoutAmount    string         `json:"payoutAmount,omitempty"`
+	ReferenceNumber string         `json:"referenceNumber,omitempty"`
+	AgentLocation   string         `json:"agentLocation,omitempty"`
+	PickupCode      string         `json:"pickupCode,omitempty"`
+	PickupCodeHash  string         `json:"pickupCodeHash,omitempty"`
+	DateTime        string         `json:"datetime,omitempty"`
+	AccountNumber   string         `json:"accountNumber,omitempty"`
+	CreatedAt       string         `json:"createdAt,omitempty"`
+	UpdatedAt       string         `json:"updatedAt,omitempty"`
+	StatusHistory   []StatusChange `json:"statusHistory,omitempty"`
+}
+
+// StatusChange is a status a transfer reached and the transaction that
+// moved it there.
+type StatusChange struct {
+	Status string `json:"status"`
+	At     string `json:"at"`
+	TxID   string `json:"txID"`
+}
+
+// CreateArgs returns the arguments create_event expects for the event. The
+// optional fee, payout currency and amount, pickup code hash and agent
+// location are only passed when set.
+func (e TransactionEvent) CreateArgs() []string {
+	args := []string{e.TranID, e.SenderName, e.SenderCountry, e.ReceiverName, e.ReceiverCountry, e.Amount, e.SendingMember, e.PayoutMember}
+
+	fee := e.Fee
+	if fee == "" {
+		fee = "0"
+	}
+	if e.AgentLocation != "" {
+		return append(args, fee, e.PayoutCurrency, e.PayoutAmount, e.PickupCodeHash, e.AgentLocation)
+	}
+	if e.PickupCodeHash != "" {
+		return append(args, fee, e.PayoutCurrency, e.PayoutAmount, e.PickupCodeHash)
+	}
+	if e.PayoutCurrency != "" || e.PayoutAmount != "" {
+		return append(args, fee, e.PayoutCurrency, e.PayoutAmount)
+	}
+	if e.Fee != "" {
+		return append(args, fee)
+	}
+	return args
+}
+
+// MaxBulkEvents is the most transfers one create_events invoke may carry.
+const MaxBulkEvents = 5000
+
+// BulkCreated is the status of every transfer of an applied create_events
+// batch. When any transfer is rejected none are created and create_events
+// fails, naming the rejected transfers.
+const BulkCreated = "created"
+
+// CreateEventsArg encodes transfers as the argument of create_events. Their
+// pickup codes must have been hashed.
+func CreateEventsArg(events []TransactionEvent) (string, error) {
+	for _, e := range events {
+		if e.PickupCode != "" {
+			return "", fmt.Errorf("transfer %s has a pickup code that has not been hashed", e.TranID)
+		}
+	}
+	out, err := json.Marshal(events)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// BulkResult is returned by create_events on peers that pass invoke results
+// back, with one entry per transfer in the order they were given.
+type BulkResult struct {
+	Applied  bool             `json:"applied"`
+	Count    int              `json:"count"`
+	Rejected int              `json:"rejected"`
+	Results  []BulkItemResult `json:"results"`
+}
+
+// BulkItemResult is what happened to one transfer of a create_events batch.
+type BulkItemResult struct {
+	Index   int    `json:"index"`
+	TranID  string `json:"tranID"`
+	Status  string `json:"status"`
+	BatchID string `json:"batchID,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EventPage is one page of events returned by get_events or get_changes.
+// Total counts every event visible to the caller before paging, or for
+// get_changes every change of status.
+type EventPage struct {
+	Total  int                `json:"total"`
+	Offset int                `json:"offset"`
+	Events []TransactionEvent `json:"events"`
+}
+
+// EventScan is what scan_events read from Position up to Next in the
+// ledger's tranIDs index. Member is the member the chaincode limited the
+// events to for the caller, empty for every member. While More is true,
+// carry on from Next; a scan may hold no events and still have More.
+type EventScan struct {
+	Member   string             `json:"member,omitempty"`
+	Position int                `json:"position"`
+	Next     int                `json:"next"`
+	More     bool               `json:"more"`
+	Events   []TransactionEvent `json:"events"`
+}
+
+// SettlementBatch is a group of transfers settled together, with the net
+// obligations between members computed when the batch closed. MerkleRoot
+// commits to the batch's transfers; see package merkle. Batches closed
+// before schema version 4 do not have one. Member is set when the chaincode
+// limited the batch to that member's transfers and obligations for the
+// caller; such a batch cannot be settled from.
+type SettlementBatch struct {
+	SchemaVersion int          `json:"schemaVersion,omitempty"`
+	Member        string       `json:"member,omitempty"`
+	BatchID       string       `json:"batchID"`
+	Status        string       `json:"status"`
+	Currency      string       `json:"currency"`
+	TranIDs       []string     `json:"tranIDs"`
+	Obligations   []Obligation `json:"obligations"`
+	OpenedAt      string       `json:"openedAt,omitempty"`
+	ClosedAt      string       `json:"closedAt,omitempty"`
+	MerkleRoot    string       `json:"merkleRoot,omitempty"`
+}
+
+// InclusionProof is returned by get_inclusion_proof: the sibling hashes
+// from a transfer's leaf up to its batch's Merkle root. Left is set on a
+// step whose hash is the left-hand sibling.
+type InclusionProof struct {
+	TranID     string      `json:"tranID"`
+	BatchID    string      `json:"batchID"`
+	MerkleRoot string      `json:"merkleRoot"`
+	LeafIndex  int         `json:"leafIndex"`
+	LeafCount  int         `json:"leafCount"`
+	LeafHash   string      `json:"leafHash"`
+	Path       []ProofStep `json:"path"`
+}
+
+// ProofStep is one sibling hash of an InclusionProof.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left,omitempty"`
+}
+
+// PickupCodeMinLength is the fewest characters a pickup code may have.
+const PickupCodeMinLength = 8
+
+// PickupCodeHash returns what create_event and verify_pickup take in place of
+// a pickup code: the hex HMAC-SHA256 of the tranID and the code under the
+// network's pickup key. Every member holds the key off the ledger, so the
+// code cannot be found from the hash by trying each candidate.
+func PickupCodeHash(key []byte, tranID, code string) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("a pickup key is required to hash a pickup code")
+	}
+	if len(code) < PickupCodeMinLength {
+		return "", fmt.Errorf("a pickup code must have at least %d characters", PickupCodeMinLength)
+	}
+	return keyedHash(key, tranID, code), nil
+}
+
+// HashPickupCode replaces the event's PickupCode with its PickupCodeHash
+// under key.
+func (e *TransactionEvent) HashPickupCode(key []byte) error {
+	if e.PickupCode == "" {
+		return nil
+	}
+	hash, err := PickupCodeHash(key, e.TranID, e.PickupCode)
+	if err != nil {
+		return err
+	}
+	e.PickupCode, e.PickupCodeHash = "", hash
+	return nil
+}
+
+// ReceiverIDHash returns what confirm_payout takes in place of the
+// receiver's ID document: the hex HMAC-SHA256 of the tranID and the
+// document's type and number under a key the payout member holds off the
+// ledger. The type and number are hashed without surrounding spaces or
+// case, as agents type them, so the member can check the hash against the
+// document later.
+func ReceiverIDHash(key []byte, tranID, idType, idNumber string) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("a receiver ID key is required to hash a receiver's ID")
+	}
+	idType = strings.ToUpper(strings.TrimSpace(idType))
+	idNumber = strings.ToUpper(strings.TrimSpace(idNumber))
+	if idType == "" || idNumber == "" {
+		return "", errors.New("an ID type and number are required")
+	}
+	return keyedHash(key, tranID, idType, idNumber), nil
+}
+
+// MinKeyBytes is the fewest bytes a hashing key may have.
+const MinKeyBytes = 32
+
+// LoadKey reads a hashing key, such as the pickup key or a receiver ID key,
+// from a file holding it in hex.
+func LoadKey(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: the key is not hex: %v", path, err)
+	}
+	if len(key) < MinKeyBytes {
+		return nil, fmt.Errorf("%s: the key must have at least %d bytes", path, MinKeyBytes)
+	}
+	return key, nil
+}
+
+// keyedHash returns the hex HMAC-SHA256 of fields under key, each field
+// prefixed with its length so that no two lists hash alike.
+func keyedHash(key []byte, fields ...string) string {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range fields {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		mac.Write(size[:])
+		mac.Write([]byte(field))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PickupStatus is returned by get_pickup. Verified is
+// whether the last code checked was right, and VerifiedAt when a right code
+// was last given. A transfer is locked after too many bad codes in a row
+// until an admin runs unlock_pickup.
+type PickupStatus struct {
+	TranID          string `json:"tranID"`
+	ReferenceNumber string `json:"referenceNumber"`
+	Status          string `json:"status"`
+	Verified        bool   `json:"verified"`
+	VerifiedAt      string `json:"verifiedAt,omitempty"`
+	FailedAttempts  int    `json:"failedAttempts"`
+	AttemptsLeft    int    `json:"attemptsLeft"`
+	Locked          bool   `json:"locked"`
+}
+
+// Payout is the confirmation of a transfer's payout, returned by get_payout.
+// ReceiverIDHash is the payout member's ReceiverIDHash of the receiver's ID
+// document; the document itself is never sent to the chaincode.
+type Payout struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	TranID         string `json:"tranID"`
+	PayoutMember   string `json:"payoutMember"`
+	ReceiverIDHash string `json:"receiverIDHash"`
+	Location       string `json:"location"`
+	Currency       string `json:"currency"`
+	Amount         string `json:"amount"`
+	PaidAt         string `json:"paidAt"`
+	TxID           string `json:"txID"`
+}
+
+// Diagnostics is the health report returned by the diagnostics query.
+// Status is "ok" unless Problems lists an inconsistency between the indexes
+// and the records they point to.
+type Diagnostics struct {
+	Status           string         `json:"status"`
+	ChaincodeVersion string         `json:"chaincodeVersion"`
+	SchemaVersion    int            `json:"schemaVersion"`
+	EventCount       int            `json:"eventCount"`
+	Indexes          map[string]int `json:"indexes"`
+	OpenBatch        *OpenBatch     `json:"openBatch"`
+	Migration        string         `json:"migration"`
+	ConfigHash       string         `json:"configHash"`
+	Problems         []string       `json:"problems"`
+}
+
+// OpenBatch summarises the settlement batch collecting transfers.
+type OpenBatch struct {
+	BatchID   string `json:"batchID"`
+	Transfers int    `json:"transfers"`
+	Total     string `json:"total"`
+}
+
+// Statistics dimensions accepted by get_statistics. The network dimension has
+// the single key NetworkKey; corridor keys are "<senderCountry>><receiverCountry>".
+const (
+	StatsNetwork  = "network"
+	StatsCorridor = "corridor"
+	StatsSending  = "sending"
+	StatsPayout   = "payout"
+	NetworkKey    = "all"
+)
+
+// Statistics is returned by get_statistics: the figures of a dimension key
+// for every day or month in a range, and their total.
+type Statistics struct {
+	Dimension string             `json:"dimension"`
+	Key       string             `json:"key"`
+	From      string             `json:"from"`
+	To        string             `json:"to"`
+	Currency  string             `json:"currency"`
+	Periods   []StatisticsPeriod `json:"periods"`
+	Total     StatisticsPeriod   `json:"total"`
+}
+
+// StatisticsPeriod holds the transfers created in one period and the number
+// that reached each status in it. Payout is keyed by payout currency.
+type StatisticsPeriod struct {
+	Period        string            `json:"period"`
+	Count         int               `json:"count"`
+	Principal     string            `json:"principal"`
+	Fees          string            `json:"fees"`
+	Payout        map[string]string `json:"payout"`
+	StatusChanges map[string]int    `json:"statusChanges"`
+}
+
+// Obligation is the net amount a debtor member owes a creditor member.
+type Obligation struct {
+	Debtor   string `json:"debtor"`
+	Creditor string `json:"creditor"`
+	Amount   string `json:"amount"`
+}
+
+// Exposure is returned by get_exposure: a member's net debit cap, its net
+// position against the network and its position with every other member.
+type Exposure struct {
+	MemberID       string                 `json:"memberID"`
+	NetDebitCap    string                 `json:"netDebitCap"`
+	NetPosition    string                 `json:"netPosition"`
+	NetDebitUnused string                 `json:"netDebitUnused"`
+	Counterparties []CounterpartyExposure `json:"counterparties"`
+}
+
+// CounterpartyExposure is one line of an Exposure. LimitGranted is the credit
+// the counterparty fronts the member and LimitGiven the credit the member
+// fronts the counterparty.
+type CounterpartyExposure struct {
+	Counterparty string `json:"counterparty"`
+	LimitGranted string `json:"limitGranted"`
+	LimitGiven   string `json:"limitGiven"`
+	NetOwed      string `json:"netOwed"`
+	Available    string `json:"available"`
+}
+
+// MaxAmountDigits is the most digits the chaincode accepts before the
+// decimal point of an amount, limit or cap.
+const MaxAmountDigits = 12
+
+// ParseAmount converts a decimal amount such as "100" or "100.50" into minor
+// units, using the same rules as the chaincode.
+func ParseAmount(amount string) (int64, error) {
+	parts := strings.Split(strings.TrimSpace(amount), ".")
+	if len(parts) > 2 || parts[0] == "" || strings.HasPrefix(parts[0], "-") || strings.HasPrefix(parts[0], "+") {
+		return 0, errors.New("amount must be a non-negative decimal number")
+	}
+	if len(parts[0]) > MaxAmountDigits {
+		return 0, fmt.Errorf("amount must have at most %d digits before the decimal point", MaxAmountDigits)
+	}
+
+	units, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, errors.New("amount must be a non-negative decimal number")
+	}
+
+	var cents int64
+	if len(parts) == 2 {
+		if len(parts[1]) == 0 || len(parts[1]) > 2 {
+			return 0, errors.New("amount must have at most two decimal places")
+		}
+		cents, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || strings.HasPrefix(parts[1], "-") || strings.HasPrefix(parts[1], "+") {
+			return 0, errors.New("amount must be a non-negative decimal number")
+		}
+		if len(parts[1]) == 1 {
+			cents *= 10
+		}
+	}
+
+	return units*100 + cents, nil
+}
+
+// FormatAmount converts minor units into a decimal string with two places.
+func FormatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
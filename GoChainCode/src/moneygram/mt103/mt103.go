@@ -0,0 +1,443 @@
+// Package mt103 reads and writes SWIFT MT103 single customer credit transfers
+// and maps them to and from ledger TransactionEvents.
+package mt103
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"moneygram/iso20022"
+	"moneygram/model"
+)
+
+// Party is an ordering customer (50K) or beneficiary customer (59): an optional
+// account line followed by up to four lines of name and address.
+type Party struct {
+	Account string
+	Name    string
+	Address []string
+}
+
+// Message is the text block (block 4) of an MT103 plus the BICs from the basic
+// and application headers when the message carried them.
+type Message struct {
+	SenderBIC   string
+	ReceiverBIC string
+
+	SenderReference        string    // 20
+	BankOperationCode      string    // 23B
+	ValueDate              time.Time // 32A date
+	Currency               string    // 32A currency
+	Amount                 string    // 32A amount, in ledger form ("100.50")
+	OrderingCustomer       Party     // 50K
+	OrderingInstitution    string    // 52A
+	AccountWithInstitution string    // 57A
+	Beneficiary            Party     // 59
+	DetailsOfCharges       string    // 71A
+}
+
+// Options maps between network members and the BICs used on the MT side.
+type Options struct {
+	// Banks maps member IDs to BICs.
+	Banks map[string]string
+	// ValueDate is used for 32A when generating; today when zero.
+	ValueDate time.Time
+}
+
+var (
+	blockPattern = regexp.MustCompile(`\{([1-5]):`)
+	tagPattern   = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):(.*)$`)
+)
+
+// Read parses an MT103 from r. The input may be a complete FIN message with
+// blocks 1 to 5 or just the text block. The message is validated; a
+// ValidationError lists every field that failed.
+func Read(r io.Reader) (*Message, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(raw))
+}
+
+// Parse is Read for a string.
+func Parse(raw string) (*Message, error) {
+	m := &Message{}
+	text := raw
+
+	if blockPattern.MatchString(raw) {
+		blocks := splitBlocks(raw)
+		if b1, ok := blocks["1"]; ok && len(b1) >= 15 {
+			m.SenderBIC = bic12to11(b1[3:15])
+		}
+		if b2, ok := blocks["2"]; ok && strings.HasPrefix(b2, "I103") && len(b2) >= 16 {
+			m.ReceiverBIC = bic12to11(b2[4:16])
+		} else if ok && !strings.HasPrefix(b2, "I103") && !strings.HasPrefix(b2, "O103") {
+			return nil, errors.New("mt103: application header is not an MT103")
+		}
+		var ok bool
+		if text, ok = blocks["4"]; !ok {
+			return nil, errors.New("mt103: no text block")
+		}
+	}
+
+	fields, err := splitFields(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var verr ValidationError
+	seen := map[string]bool{}
+
+	for _, f := range fields {
+		if seen[f.tag] {
+			verr = append(verr, FieldError{f.tag, "field repeated"})
+			continue
+		}
+		seen[f.tag] = true
+
+		switch f.tag {
+		case "20":
+			m.SenderReference = f.value
+		case "23B":
+			m.BankOperationCode = f.value
+		case "32A":
+			if err := m.parse32A(f.value); err != nil {
+				verr = append(verr, FieldError{"32A", err.Error()})
+			}
+		case "50K":
+			m.OrderingCustomer = parseParty(f.value)
+		case "52A":
+			m.OrderingInstitution = optionA(f.value)
+		case "57A":
+			m.AccountWithInstitution = optionA(f.value)
+		case "59":
+			m.Beneficiary = parseParty(f.value)
+		case "71A":
+			m.DetailsOfCharges = f.value
+		}
+	}
+
+	for _, tag := range []string{"20", "23B", "32A", "50K", "59", "71A"} {
+		if !seen[tag] {
+			verr = append(verr, FieldError{tag, "mandatory field missing"})
+		}
+	}
+
+	// Report format errors for every field, not just the first that failed to
+	// parse; fields that did not parse are not validated a second time.
+	if err, ok := m.Validate().(ValidationError); ok {
+		failed := map[string]bool{}
+		for _, e := range verr {
+			failed[e.Tag] = true
+		}
+		for _, e := range err {
+			if !failed[e.Tag] {
+				verr = append(verr, e)
+			}
+		}
+	}
+
+	if len(verr) > 0 {
+		return m, verr
+	}
+	return m, nil
+}
+
+// Format renders the message as a FIN MT103 with basic, application, text and
+// trailer blocks. Newlines are CRLF as SWIFT requires. A message read from a
+// bare text block has no header BICs; the ordering and account with
+// institutions are addressed instead.
+func (m *Message) Format() (string, error) {
+	if err := m.Validate(); err != nil {
+		return "", err
+	}
+
+	sender, err := bic11to12(firstNonEmpty(m.SenderBIC, m.OrderingInstitution))
+	if err != nil {
+		return "", ValidationError{{"1", err.Error()}}
+	}
+	receiver, err := bic11to12(firstNonEmpty(m.ReceiverBIC, m.AccountWithInstitution))
+	if err != nil {
+		return "", ValidationError{{"2", err.Error()}}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "{1:F01%s0000000000}", sender)
+	fmt.Fprintf(&b, "{2:I103%sN}", receiver)
+	b.WriteString("{4:\r\n")
+
+	field := func(tag string, lines ...string) {
+		b.WriteString(":" + tag + ":" + strings.Join(lines, "\r\n") + "\r\n")
+	}
+
+	field("20", m.SenderReference)
+	field("23B", m.BankOperationCode)
+	field("32A", m.ValueDate.Format("060102")+m.Currency+toSwiftAmount(m.Amount))
+	field("50K", m.OrderingCustomer.lines()...)
+	if m.OrderingInstitution != "" {
+		field("52A", m.OrderingInstitution)
+	}
+	if m.AccountWithInstitution != "" {
+		field("57A", m.AccountWithInstitution)
+	}
+	field("59", m.Beneficiary.lines()...)
+	field("71A", m.DetailsOfCharges)
+
+	b.WriteString("-}")
+
+	return b.String(), nil
+}
+
+// FromEvent builds an MT103 for a ledger event. The sending member's BIC is the
+// sender and ordering institution, the payout member's the receiver and account
+// with institution. Names and countries are carried in 50K and 59.
+func FromEvent(e model.TransactionEvent, opts Options) (*Message, error) {
+	sender, ok := opts.Banks[e.SendingMember]
+	if !ok {
+		return nil, fmt.Errorf("mt103: no BIC for sending member %s", e.SendingMember)
+	}
+	receiver, ok := opts.Banks[e.PayoutMember]
+	if !ok {
+		return nil, fmt.Errorf("mt103: no BIC for payout member %s", e.PayoutMember)
+	}
+
+	cents, err := model.ParseAmount(e.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("mt103: %v", err)
+	}
+
+	date := opts.ValueDate
+	if date.IsZero() {
+		date = time.Now().UTC()
+	}
+
+	m := &Message{
+		SenderBIC:              sender,
+		ReceiverBIC:            receiver,
+		SenderReference:        e.TranID,
+		BankOperationCode:      "CRED",
+		ValueDate:              date,
+		Currency:               model.SettlementCurrency,
+		Amount:                 model.FormatAmount(cents),
+		OrderingCustomer:       Party{Name: e.SenderName, Address: []string{e.SenderCountry}},
+		OrderingInstitution:    sender,
+		AccountWithInstitution: receiver,
+		Beneficiary:            Party{Name: e.ReceiverName, Address: []string{e.ReceiverCountry}},
+		DetailsOfCharges:       "SHA",
+	}
+
+	return m, m.Validate()
+}
+
+// Event maps the message onto TransactionEvent fields. Members are resolved
+// from 52A/57A, falling back to the header BICs. Countries are taken from the
+// last address line of 50K and 59.
+func (m *Message) Event(opts Options) (model.TransactionEvent, error) {
+	var e model.TransactionEvent
+	var verr ValidationError
+
+	if m.Currency != model.SettlementCurrency {
+		verr = append(verr, FieldError{"32A", "currency " + m.Currency + " is not " + model.SettlementCurrency})
+	}
+
+	e.TranID = m.SenderReference
+	e.Amount = m.Amount
+	e.SenderName = m.OrderingCustomer.Name
+	e.ReceiverName = m.Beneficiary.Name
+
+	var err error
+	if e.SenderCountry, err = m.OrderingCustomer.country(); err != nil {
+		verr = append(verr, FieldError{"50K", err.Error()})
+	}
+	if e.ReceiverCountry, err = m.Beneficiary.country(); err != nil {
+		verr = append(verr, FieldError{"59", err.Error()})
+	}
+
+	if e.SendingMember = memberFor(firstNonEmpty(m.OrderingInstitution, m.SenderBIC), opts.Banks); e.SendingMember == "" {
+		verr = append(verr, FieldError{"52A", "ordering institution is not a network member"})
+	}
+	if e.PayoutMember = memberFor(firstNonEmpty(m.AccountWithInstitution, m.ReceiverBIC), opts.Banks); e.PayoutMember == "" {
+		verr = append(verr, FieldError{"57A", "account with institution is not a network member"})
+	}
+
+	if len(verr) > 0 {
+		return e, verr
+	}
+	return e, nil
+}
+
+type rawField struct {
+	tag   string
+	value string
+}
+
+func splitBlocks(raw string) map[string]string {
+	blocks := map[string]string{}
+	for _, loc := range blockPattern.FindAllStringSubmatchIndex(raw, -1) {
+		id := raw[loc[2]:loc[3]]
+		if _, done := blocks[id]; done {
+			continue
+		}
+		start := loc[1]
+		end := strings.Index(raw[start:], "}")
+		if id == "4" {
+			end = strings.Index(raw[start:], "\n-}")
+			if end < 0 {
+				end = strings.Index(raw[start:], "-}")
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		blocks[id] = raw[start : start+end]
+	}
+	return blocks
+}
+
+func splitFields(text string) ([]rawField, error) {
+	var fields []rawField
+	scanner := bufio.NewScanner(strings.NewReader(strings.Replace(text, "\r\n", "\n", -1)))
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || line == "-" {
+			continue
+		}
+		if m := tagPattern.FindStringSubmatch(line); m != nil {
+			fields = append(fields, rawField{tag: m[1], value: m[2]})
+			continue
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("mt103: text before first field: %q", line)
+		}
+		fields[len(fields)-1].value += "\n" + line
+	}
+
+	return fields, scanner.Err()
+}
+
+func (m *Message) parse32A(v string) error {
+	if len(v) < 10 {
+		return errors.New("expected 6!n3!a15d")
+	}
+	date, err := time.Parse("060102", v[:6])
+	if err != nil {
+		return errors.New("invalid value date " + v[:6])
+	}
+	m.ValueDate = date
+	m.Currency = v[6:9]
+	m.Amount, err = fromSwiftAmount(v[9:])
+	return err
+}
+
+func parseParty(v string) Party {
+	var p Party
+	lines := strings.Split(v, "\n")
+	if strings.HasPrefix(lines[0], "/") {
+		p.Account = strings.TrimPrefix(lines[0], "/")
+		lines = lines[1:]
+	}
+	if len(lines) > 0 {
+		p.Name = lines[0]
+		p.Address = lines[1:]
+	}
+	return p
+}
+
+func (p Party) lines() []string {
+	var out []string
+	if p.Account != "" {
+		out = append(out, "/"+p.Account)
+	}
+	out = append(out, p.Name)
+	return append(out, p.Address...)
+}
+
+func (p Party) country() (string, error) {
+	if len(p.Address) == 0 {
+		return "", errors.New("no address line to take the country from")
+	}
+	last := strings.TrimSpace(p.Address[len(p.Address)-1])
+	if _, err := iso20022.CountryCode(last); err != nil {
+		return "", err
+	}
+	return last, nil
+}
+
+func optionA(v string) string {
+	lines := strings.Split(v, "\n")
+	if strings.HasPrefix(lines[0], "/") && len(lines) > 1 {
+		return lines[1]
+	}
+	return lines[0]
+}
+
+func memberFor(bic string, banks map[string]string) string {
+	if bic == "" {
+		return ""
+	}
+	for id, b := range banks {
+		if b == bic || (len(b) == 8 && b+"XXX" == bic) || (len(bic) == 8 && bic+"XXX" == b) {
+			return id
+		}
+	}
+	return ""
+}
+
+func toSwiftAmount(amount string) string {
+	if !strings.Contains(amount, ".") {
+		return amount + ","
+	}
+	return strings.Replace(amount, ".", ",", 1)
+}
+
+func fromSwiftAmount(v string) (string, error) {
+	if len(v) > 15 || !amountPattern.MatchString(v) {
+		return "", errors.New("invalid amount " + v + ", expected 15d with a decimal comma")
+	}
+
+	parts := strings.SplitN(v, ",", 2)
+	amount := parts[0]
+	if frac := strings.TrimRight(parts[1], "0"); frac != "" {
+		amount += "." + frac
+	}
+
+	cents, err := model.ParseAmount(amount)
+	if err != nil {
+		return "", errors.New("invalid amount " + v + ": at most two decimal places")
+	}
+	return model.FormatAmount(cents), nil
+}
+
+// bic11to12 pads a BIC to the 12 character logical terminal address used in
+// the basic and application headers.
+func bic11to12(bic string) (string, error) {
+	if !bicPattern.MatchString(bic) {
+		return "", fmt.Errorf("invalid BIC %q", bic)
+	}
+	if len(bic) == 8 {
+		bic += "XXX"
+	}
+	return bic[:8] + "X" + bic[8:], nil
+}
+
+func bic12to11(lt string) string {
+	return lt[:8] + lt[9:]
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package mt103
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FieldError is a problem with one field of the message.
+type FieldError struct {
+	Tag     string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return ":" + e.Tag + ": " + e.Message
+}
+
+// ValidationError lists every field of a message that failed validation.
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return "mt103: " + strings.Join(msgs, "; ")
+}
+
+var (
+	// xChars is the SWIFT X character set.
+	xChars        = regexp.MustCompile(`^[A-Za-z0-9/\-?:().,'+ ]*$`)
+	bicPattern    = regexp.MustCompile(`^[A-Z]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	currencyCode  = regexp.MustCompile(`^[A-Z]{3}$`)
+	amountPattern = regexp.MustCompile(`^[0-9]+,[0-9]*$`)
+
+	operationCodes = map[string]bool{"CRED": true, "CRTS": true, "SPAY": true, "SPRI": true, "SSTD": true}
+	chargeCodes    = map[string]bool{"BEN": true, "OUR": true, "SHA": true}
+)
+
+// Validate checks the format rules of tags 20, 23B, 32A, 50K, 52A, 57A, 59 and
+// 71A and returns a ValidationError listing every failure.
+func (m *Message) Validate() error {
+	var v ValidationError
+	add := func(tag string, format string, args ...interface{}) {
+		v = append(v, FieldError{tag, fmt.Sprintf(format, args...)})
+	}
+
+	// 20: 16x, no leading or trailing slash and no double slash.
+	ref := m.SenderReference
+	switch {
+	case ref == "" || len(ref) > 16:
+		add("20", "sender's reference must be 1 to 16 characters")
+	case !xChars.MatchString(ref):
+		add("20", "sender's reference contains characters outside the SWIFT X set")
+	case strings.HasPrefix(ref, "/") || strings.HasSuffix(ref, "/") || strings.Contains(ref, "//"):
+		add("20", "sender's reference must not start or end with '/' or contain '//'")
+	}
+
+	// 23B: 4!c from the MT103 code list.
+	if !operationCodes[m.BankOperationCode] {
+		add("23B", "bank operation code %q is not one of CRED, CRTS, SPAY, SPRI, SSTD", m.BankOperationCode)
+	}
+
+	// 32A: 6!n3!a15d.
+	if m.ValueDate.IsZero() {
+		add("32A", "value date missing")
+	}
+	if !currencyCode.MatchString(m.Currency) {
+		add("32A", "currency %q must be three upper-case letters", m.Currency)
+	}
+	if swift := toSwiftAmount(m.Amount); len(swift) > 15 || !amountPattern.MatchString(swift) || strings.Trim(swift, "0,") == "" {
+		add("32A", "amount %q must be a positive amount of at most 15 characters", m.Amount)
+	}
+
+	validateParty(add, "50K", "ordering customer", m.OrderingCustomer)
+	validateParty(add, "59", "beneficiary customer", m.Beneficiary)
+
+	// 52A, 57A and the header BICs: 4!a2!a2!c[3!c].
+	for _, b := range []struct{ tag, bic string }{
+		{"52A", m.OrderingInstitution},
+		{"57A", m.AccountWithInstitution},
+		{"1", m.SenderBIC},
+		{"2", m.ReceiverBIC},
+	} {
+		if b.bic != "" && !bicPattern.MatchString(b.bic) {
+			add(b.tag, "invalid BIC %q", b.bic)
+		}
+	}
+	if m.SenderBIC == "" && m.OrderingInstitution == "" {
+		add("52A", "no sender BIC or ordering institution")
+	}
+	if m.ReceiverBIC == "" && m.AccountWithInstitution == "" {
+		add("57A", "no receiver BIC or account with institution")
+	}
+
+	// 71A: 3!a.
+	if !chargeCodes[m.DetailsOfCharges] {
+		add("71A", "details of charges %q is not one of BEN, OUR, SHA", m.DetailsOfCharges)
+	}
+
+	if len(v) > 0 {
+		return v
+	}
+	return nil
+}
+
+// validateParty checks [/34x] followed by 4*35x with a non-empty name.
+func validateParty(add func(string, string, ...interface{}), tag string, what string, p Party) {
+	if len(p.Account) > 34 || !xChars.MatchString(p.Account) {
+		add(tag, "%s account must be at most 34 SWIFT X characters", what)
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		add(tag, "%s name missing", what)
+	}
+
+	lines := append([]string{p.Name}, p.Address...)
+	if len(lines) > 4 {
+		add(tag, "%s has %d name and address lines, at most 4 allowed", what, len(lines))
+	}
+	for i, l := range lines {
+		if len(l) > 35 {
+			add(tag, "%s line %d is longer than 35 characters", what, i+1)
+		}
+		if !xChars.MatchString(l) {
+			add(tag, "%s line %d contains characters outside the SWIFT X set", what, i+1)
+		}
+	}
+}
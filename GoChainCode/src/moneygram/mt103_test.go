@@ -0,0 +1,117 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"moneygram/model"
+	"moneygram/mt103"
+)
+
+var test_banks = mt103.Options{
+	Banks:     map[string]string{"walmart": "WALMUS33XXX", "bancomer": "BCMRMXMMXXX"},
+	ValueDate: time.Date(2016, 11, 28, 0, 0, 0, 0, time.UTC),
+}
+
+func TestMT103RoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		event model.TransactionEvent
+	}{
+		{"whole amount", transfer("t1", "100")},
+		{"cents", transfer("t1", "1234.56")},
+		{"one decimal", transfer("t1", "0.5")},
+		{"reversed", model.TransactionEvent{TranID: "REF-2016-0001", SenderName: "Maria Lopez", SenderCountry: "Mexico",
+			ReceiverName: "John Smith", ReceiverCountry: "USA", Amount: "75.10", SendingMember: "bancomer", PayoutMember: "walmart"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("create_event", tt.event.CreateArgs()...)
+
+			var e model.TransactionEvent
+			l.query(&e, "get_event_details", tt.event.TranID)
+
+			m, err := mt103.FromEvent(e, test_banks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fin, err := m.Format()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := mt103.Parse(fin)
+			if err != nil {
+				t.Fatalf("%v\n%v", err, fin)
+			}
+			back, err := parsed.Event(test_banks)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The transfer read back from the MT103 creates the same transfer on another network
+			other := new_network(t)
+			other.invoke("create_event", back.CreateArgs()...)
+
+			var again model.TransactionEvent
+			other.query(&again, "get_event_details", tt.event.TranID)
+
+			if !same_amount(t, e.Amount, again.Amount) {
+				t.Fatalf("%v came back as %v\n%v", e.Amount, again.Amount, fin)
+			}
+
+			for _, f := range [][2]string{
+				{e.TranID, again.TranID},
+				{e.SenderName, again.SenderName},
+				{e.SenderCountry, again.SenderCountry},
+				{e.ReceiverName, again.ReceiverName},
+				{e.ReceiverCountry, again.ReceiverCountry},
+				{e.SendingMember, again.SendingMember},
+				{e.PayoutMember, again.PayoutMember},
+			} {
+				if f[0] != f[1] {
+					t.Fatalf("%q came back as %q\n%v", f[0], f[1], fin)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown bank", func(t *testing.T) {
+
+		l := new_network(t)
+		l.invoke("create_event", transfer("t1", "100").CreateArgs()...)
+
+		var e model.TransactionEvent
+		l.query(&e, "get_event_details", "t1")
+
+		if _, err := mt103.FromEvent(e, mt103.Options{Banks: map[string]string{"walmart": "WALMUS33XXX"}}); err == nil {
+			t.Fatal("an MT103 was built for a member without a BIC")
+		}
+	})
+}
+
+//==============================================================================================================================
+//	 same_amount - Returns whether two amounts are the same number of cents. MT103 writes every amount with its cents.
+//==============================================================================================================================
+func same_amount(t *testing.T, a string, b string) bool {
+
+	t.Helper()
+
+	ac, err := model.ParseAmount(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := model.ParseAmount(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ac == bc
+}
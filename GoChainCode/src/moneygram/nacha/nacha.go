@@ -0,0 +1,277 @@
+// Package nacha writes NACHA ACH files for the USD settlement legs of a closed
+// settlement batch, and verifies the control totals of a file it wrote.
+package nacha
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"moneygram/model"
+)
+
+const (
+	recordLength   = 94
+	blockingFactor = 10
+)
+
+// Transaction codes for entry detail records.
+const (
+	checkingCredit = "22"
+	checkingDebit  = "27"
+	savingsCredit  = "32"
+	savingsDebit   = "37"
+)
+
+// Account is a member's US settlement account.
+type Account struct {
+	Name    string `json:"name"`
+	Routing string `json:"routing"`
+	Number  string `json:"account"`
+	Savings bool   `json:"savings"`
+}
+
+// Config describes the originator of the settlement file and the accounts of
+// the members that settle by ACH. Obligations of members without an account
+// here are left to their other settlement channels.
+type Config struct {
+	ImmediateDestination string             `json:"immediateDestination"`
+	DestinationName      string             `json:"destinationName"`
+	ImmediateOrigin      string             `json:"immediateOrigin"`
+	OriginName           string             `json:"originName"`
+	CompanyName          string             `json:"companyName"`
+	CompanyID            string             `json:"companyID"`
+	ODFI                 string             `json:"odfi"`
+	EntryDescription     string             `json:"entryDescription"`
+	Accounts             map[string]Account `json:"accounts"`
+}
+
+var digits = regexp.MustCompile(`^[0-9]+$`)
+
+// Generate writes one CCD batch per settlement batch. Every obligation becomes
+// a debit to the debtor's account and a credit to the creditor's, each with an
+// addenda record naming the obligation. Legs for members without a US account
+// are skipped.
+func Generate(batch model.SettlementBatch, cfg Config, created time.Time, effective time.Time, fileIDModifier string) ([]byte, error) {
+	if batch.Status != model.BatchClosed {
+		return nil, fmt.Errorf("nacha: settlement batch %s is %s, not closed", batch.BatchID, batch.Status)
+	}
+	if batch.Member != "" {
+		return nil, fmt.Errorf("nacha: settlement batch %s only holds the obligations of %s", batch.BatchID, batch.Member)
+	}
+	if batch.Currency != "" && batch.Currency != "USD" {
+		return nil, fmt.Errorf("nacha: settlement batch %s is in %s", batch.BatchID, batch.Currency)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if len(fileIDModifier) != 1 || !strings.ContainsAny(fileIDModifier, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") {
+		return nil, errors.New("nacha: file ID modifier must be one of A-Z or 0-9")
+	}
+
+	batchNumber, err := strconv.Atoi(batch.BatchID)
+	if err != nil {
+		batchNumber = 1
+	}
+
+	w := &writer{}
+	w.record("1", "01",
+		rightAlign(cfg.ImmediateDestination, 10),
+		rightAlign(cfg.ImmediateOrigin, 10),
+		created.Format("060102"), created.Format("1504"),
+		fileIDModifier, "094", "10", "1",
+		alpha(cfg.DestinationName, 23),
+		alpha(cfg.OriginName, 23),
+		alpha("MGI"+batch.BatchID, 8))
+
+	w.record("5", "200",
+		alpha(cfg.CompanyName, 16),
+		alpha("SETTLEMENT BATCH "+batch.BatchID, 20),
+		alpha(cfg.CompanyID, 10),
+		"CCD",
+		alpha(cfg.EntryDescription, 10),
+		effective.Format("060102"),
+		effective.Format("060102"),
+		"   ", "1",
+		cfg.ODFI,
+		w.numeric("batch number", int64(batchNumber), 7))
+
+	var entries, hash, debits, credits int64
+
+	leg := func(member string, code func(Account) string, cents int64, info string) {
+		acct, ok := cfg.Accounts[member]
+		if !ok {
+			return
+		}
+		entries++
+		trace := cfg.ODFI + w.numeric("trace number", entries, 7)
+		w.record("6", code(acct),
+			acct.Routing,
+			alpha(acct.Number, 17),
+			w.numeric("amount of "+info, cents, 10),
+			alpha(member, 15),
+			alpha(acct.Name, 22),
+			"  ", "1",
+			trace)
+		w.record("7", "05", alpha(info, 80), w.numeric("addenda sequence", 1, 4), w.numeric("entry sequence", entries, 7))
+
+		routing, _ := strconv.ParseInt(acct.Routing[:8], 10, 64)
+		hash += routing
+		if code(acct) == checkingDebit || code(acct) == savingsDebit {
+			debits += cents
+		} else {
+			credits += cents
+		}
+	}
+
+	for i, o := range batch.Obligations {
+		cents, err := model.ParseAmount(o.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("nacha: obligation %d: %v", i+1, err)
+		}
+		info := fmt.Sprintf("MGI SETTLEMENT %s-%d %s TO %s", batch.BatchID, i+1, o.Debtor, o.Creditor)
+
+		leg(o.Debtor, debitCode, cents, info)
+		leg(o.Creditor, creditCode, cents, info)
+	}
+
+	if entries == 0 {
+		return nil, fmt.Errorf("nacha: settlement batch %s has no legs for members with US accounts", batch.BatchID)
+	}
+
+	w.record("8", "200",
+		w.numeric("batch entry and addenda count", entries*2, 6),
+		w.numeric("batch entry hash", hash%10000000000, 10),
+		w.numeric("batch total debits", debits, 12),
+		w.numeric("batch total credits", credits, 12),
+		alpha(cfg.CompanyID, 10),
+		alpha("", 19), alpha("", 6),
+		cfg.ODFI,
+		w.numeric("batch number", int64(batchNumber), 7))
+
+	blocks := (w.count + 1 + blockingFactor - 1) / blockingFactor
+
+	w.record("9",
+		w.numeric("batch count", 1, 6),
+		w.numeric("block count", int64(blocks), 6),
+		w.numeric("file entry and addenda count", entries*2, 8),
+		w.numeric("file entry hash", hash%10000000000, 10),
+		w.numeric("file total debits", debits, 12),
+		w.numeric("file total credits", credits, 12),
+		alpha("", 39))
+
+	for w.count%blockingFactor != 0 {
+		w.line(strings.Repeat("9", recordLength))
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+func debitCode(a Account) string {
+	if a.Savings {
+		return savingsDebit
+	}
+	return checkingDebit
+}
+
+func creditCode(a Account) string {
+	if a.Savings {
+		return savingsCredit
+	}
+	return checkingCredit
+}
+
+func (cfg Config) validate() error {
+	if !validRouting(strings.TrimSpace(cfg.ImmediateDestination)) {
+		return errors.New("nacha: immediate destination must be a 9 digit routing number")
+	}
+	if len(strings.TrimSpace(cfg.ImmediateOrigin)) == 0 || len(cfg.ImmediateOrigin) > 10 {
+		return errors.New("nacha: immediate origin must be 1 to 10 characters")
+	}
+	if len(cfg.ODFI) != 8 || !digits.MatchString(cfg.ODFI) {
+		return errors.New("nacha: ODFI must be the first 8 digits of the originating routing number")
+	}
+	if cfg.CompanyID == "" || len(cfg.CompanyID) > 10 {
+		return errors.New("nacha: company ID must be 1 to 10 characters")
+	}
+	for member, a := range cfg.Accounts {
+		if !validRouting(a.Routing) {
+			return fmt.Errorf("nacha: member %s: invalid routing number %q", member, a.Routing)
+		}
+		if a.Number == "" || len(a.Number) > 17 {
+			return fmt.Errorf("nacha: member %s: account number must be 1 to 17 characters", member)
+		}
+	}
+	return nil
+}
+
+// validRouting checks the ABA check digit of a nine digit routing number.
+func validRouting(r string) bool {
+	if len(r) != 9 || !digits.MatchString(r) {
+		return false
+	}
+	weights := []int{3, 7, 1, 3, 7, 1, 3, 7, 1}
+	sum := 0
+	for i, c := range r {
+		sum += int(c-'0') * weights[i]
+	}
+	return sum%10 == 0
+}
+
+type writer struct {
+	buf   bytes.Buffer
+	count int
+	err   error
+}
+
+func (w *writer) record(fields ...string) {
+	w.line(strings.Join(fields, ""))
+}
+
+func (w *writer) line(s string) {
+	if len(s) != recordLength && w.err == nil {
+		w.err = fmt.Errorf("nacha: internal error: record %d is %d characters: %q", w.count+1, len(s), s)
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\n")
+	w.count++
+}
+
+// alpha left-justifies s in a field of n characters, upper-cased and truncated.
+func alpha(s string, n int) string {
+	s = strings.ToUpper(s)
+	if len(s) > n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// rightAlign right-justifies s in a field of n characters.
+func rightAlign(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return strings.Repeat(" ", n-len(s)) + s
+}
+
+// numeric zero-fills v in a field of n digits. A value that is negative or
+// does not fit is an error rather than being cut to its low-order digits,
+// which would leave a file whose totals still agree but are wrong.
+func (w *writer) numeric(what string, v int64, n int) string {
+	s := strconv.FormatInt(v, 10)
+	if v < 0 || len(s) > n {
+		if w.err == nil {
+			w.err = fmt.Errorf("nacha: %s %d does not fit in %d digits", what, v, n)
+		}
+		return strings.Repeat("0", n)
+	}
+	return strings.Repeat("0", n-len(s)) + s
+}
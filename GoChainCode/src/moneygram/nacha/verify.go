@@ -0,0 +1,210 @@
+package nacha
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Summary holds the totals recomputed from a file by Verify.
+type Summary struct {
+	Batches   int    `json:"batches"`
+	Entries   int    `json:"entries"`
+	Addenda   int    `json:"addenda"`
+	Blocks    int    `json:"blocks"`
+	EntryHash int64  `json:"entryHash"`
+	Debits    string `json:"debits"`
+	Credits   string `json:"credits"`
+}
+
+// VerifyError lists every problem Verify found in a file.
+type VerifyError struct {
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	return "nacha: " + strings.Join(e.Problems, "; ")
+}
+
+type batchTotals struct {
+	count, hash, debits, credits int64
+	serviceClass, batchNumber    string
+}
+
+// Verify re-reads an ACH file and checks record lengths, record order, the
+// entry/addenda counts, entry hashes and debit and credit totals of every
+// batch control and of the file control against the entries themselves.
+func Verify(r io.Reader) (*Summary, error) {
+	var problems []string
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("record %d: ", line)+fmt.Sprintf(format, args...))
+	}
+
+	var sum Summary
+	var fileCount, fileHash, fileDebits, fileCredits int64
+	var batch *batchTotals
+	var lines int
+	sawHeader, sawControl := false, false
+	lastEntry := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		lines++
+
+		if len(line) != recordLength {
+			report(lines, "is %d characters, want %d", len(line), recordLength)
+			continue
+		}
+
+		if sawControl {
+			if line != strings.Repeat("9", recordLength) {
+				report(lines, "follows the file control but is not block padding")
+			}
+			continue
+		}
+
+		switch line[0] {
+		case '1':
+			if sawHeader || lines != 1 {
+				report(lines, "unexpected file header")
+			}
+			sawHeader = true
+			if line[34:37] != "094" || line[37:39] != "10" {
+				report(lines, "record size %s and blocking factor %s, want 094 and 10", line[34:37], line[37:39])
+			}
+
+		case '5':
+			if !sawHeader {
+				report(lines, "batch header before file header")
+			}
+			if batch != nil {
+				report(lines, "batch header inside an open batch")
+			}
+			batch = &batchTotals{serviceClass: line[1:4], batchNumber: line[87:94]}
+			sum.Batches++
+
+		case '6':
+			if batch == nil {
+				report(lines, "entry detail outside a batch")
+				batch = &batchTotals{}
+			}
+			sum.Entries++
+			batch.count++
+			lastEntry = line
+
+			routing := line[3:12]
+			if !validRouting(routing) {
+				report(lines, "invalid RDFI routing number %s", routing)
+			}
+			rdfi, _ := strconv.ParseInt(line[3:11], 10, 64)
+			batch.hash += rdfi
+
+			amount, err := strconv.ParseInt(line[29:39], 10, 64)
+			if err != nil {
+				report(lines, "invalid amount %q", line[29:39])
+			}
+			switch line[1:3] {
+			case checkingDebit, savingsDebit:
+				batch.debits += amount
+			case checkingCredit, savingsCredit:
+				batch.credits += amount
+			default:
+				report(lines, "unsupported transaction code %s", line[1:3])
+			}
+
+		case '7':
+			if batch == nil || lastEntry == "" {
+				report(lines, "addenda without an entry detail")
+				continue
+			}
+			sum.Addenda++
+			batch.count++
+			if lastEntry[78] != '1' {
+				report(lines, "addenda for an entry whose addenda indicator is not set")
+			}
+			if line[87:94] != lastEntry[87:94] {
+				report(lines, "addenda entry sequence %s does not match trace %s", line[87:94], lastEntry[79:94])
+			}
+
+		case '8':
+			if batch == nil {
+				report(lines, "batch control without a batch header")
+				continue
+			}
+			checkNumber(report, lines, "batch entry/addenda count", line[4:10], batch.count)
+			checkNumber(report, lines, "batch entry hash", line[10:20], batch.hash%10000000000)
+			checkNumber(report, lines, "batch total debits", line[20:32], batch.debits)
+			checkNumber(report, lines, "batch total credits", line[32:44], batch.credits)
+			if line[1:4] != batch.serviceClass {
+				report(lines, "service class %s does not match batch header %s", line[1:4], batch.serviceClass)
+			}
+			if line[87:94] != batch.batchNumber {
+				report(lines, "batch number %s does not match batch header %s", line[87:94], batch.batchNumber)
+			}
+
+			fileCount += batch.count
+			fileHash += batch.hash
+			fileDebits += batch.debits
+			fileCredits += batch.credits
+			batch = nil
+			lastEntry = ""
+
+		case '9':
+			if batch != nil {
+				report(lines, "file control inside an open batch")
+			}
+			sawControl = true
+			checkNumber(report, lines, "file batch count", line[1:7], int64(sum.Batches))
+			checkNumber(report, lines, "file entry/addenda count", line[13:21], fileCount)
+			checkNumber(report, lines, "file entry hash", line[21:31], fileHash%10000000000)
+			checkNumber(report, lines, "file total debits", line[31:43], fileDebits)
+			checkNumber(report, lines, "file total credits", line[43:55], fileCredits)
+			sum.Blocks, _ = strconv.Atoi(line[7:13])
+
+		default:
+			report(lines, "unknown record type %q", line[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !sawHeader {
+		problems = append(problems, "missing file header")
+	}
+	if !sawControl {
+		problems = append(problems, "missing file control")
+	}
+	if lines%blockingFactor != 0 {
+		problems = append(problems, fmt.Sprintf("%d records is not a whole number of blocks", lines))
+	} else if sawControl && sum.Blocks != lines/blockingFactor {
+		problems = append(problems, fmt.Sprintf("file control block count %d but file holds %d blocks", sum.Blocks, lines/blockingFactor))
+	}
+
+	sum.EntryHash = fileHash % 10000000000
+	sum.Debits = formatCents(fileDebits)
+	sum.Credits = formatCents(fileCredits)
+
+	if len(problems) > 0 {
+		return &sum, &VerifyError{problems}
+	}
+	return &sum, nil
+}
+
+func checkNumber(report func(int, string, ...interface{}), line int, name string, field string, want int64) {
+	got, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		report(line, "%s %q is not numeric", name, field)
+		return
+	}
+	if got != want {
+		report(line, "%s is %d, entries give %d", name, got, want)
+	}
+}
+
+func formatCents(v int64) string {
+	return fmt.Sprintf("%d.%02d", v/100, v%100)
+}
@@ -0,0 +1,121 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"moneygram/model"
+	"moneygram/nacha"
+)
+
+var test_ach = nacha.Config{
+	ImmediateDestination: "021000021",
+	DestinationName:      "FED RESERVE",
+	ImmediateOrigin:      "1111000025",
+	OriginName:           "MONEYGRAM",
+	CompanyName:          "MONEYGRAM",
+	CompanyID:            "1411234567",
+	ODFI:                 "11100002",
+	EntryDescription:     "SETTLEMENT",
+	Accounts: map[string]nacha.Account{
+		"walmart":  {Name: "WALMART", Routing: "111000025", Number: "12345678"},
+		"bancomer": {Name: "BANCOMER USA", Routing: "026009593", Number: "87654321", Savings: true},
+	},
+}
+
+func TestNACHARoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		walmart  []string
+		bancomer []string
+		total    string
+		entries  int
+		err      string
+	}{
+		{"one obligation", []string{"100", "0.99"}, nil, "100.99", 2, ""},
+		{"netted", []string{"250"}, []string{"100.25"}, "149.75", 2, ""},
+		{"settled even", []string{"40"}, []string{"40"}, "", 0, "no legs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+
+			n := 0
+			for _, amount := range tt.walmart {
+				n++
+				l.invoke("create_event", transfer(fmt.Sprint("t", n), amount).CreateArgs()...)
+			}
+			for _, amount := range tt.bancomer {
+				n++
+				e := transfer(fmt.Sprint("t", n), amount)
+				e.SendingMember, e.PayoutMember = "bancomer", "walmart"
+				l.invoke("create_event", e.CreateArgs()...)
+			}
+
+			var open model.SettlementBatch
+			l.query(&open, "get_settlement_batch", "open")
+
+			created := time.Date(2016, 11, 28, 9, 30, 0, 0, time.UTC)
+			if _, err := nacha.Generate(open, test_ach, created, created, "A"); err == nil {
+				t.Fatal("an ACH file was written for an open batch")
+			}
+
+			l.invoke("close_settlement_batch")
+
+			var b model.SettlementBatch
+			l.query(&b, "get_settlement_batch", "1")
+
+			file, err := nacha.Generate(b, test_ach, created, created.AddDate(0, 0, 1), "A")
+			if tt.err != "" {
+				expect_error(t, err, tt.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A member's view of the batch holds only its own obligations
+			l.SetCaller(walmart_caller)
+			var partial model.SettlementBatch
+			l.query(&partial, "get_settlement_batch", "1")
+			l.SetCaller(admin_caller)
+
+			if _, err := nacha.Generate(partial, test_ach, created, created.AddDate(0, 0, 1), "A"); err == nil {
+				t.Fatal("an ACH file was written from a member's view of the batch")
+			}
+
+			sum, err := nacha.Verify(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("%v\n%s", err, file)
+			}
+			if sum.Entries != tt.entries || sum.Debits != tt.total || sum.Credits != tt.total {
+				t.Fatalf("summary %+v", sum)
+			}
+
+			// Changing a digit of an entry breaks the control totals
+			lines := strings.Split(string(file), "\n")
+			for i, line := range lines {
+				if strings.HasPrefix(line, "6") {
+					digit := byte('1')
+					if line[35] == '1' {
+						digit = '2'
+					}
+					lines[i] = line[:35] + string(digit) + line[36:]
+					break
+				}
+			}
+			if _, err := nacha.Verify(strings.NewReader(strings.Join(lines, "\n"))); err == nil {
+				t.Fatal("a changed entry verified")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	Payout - The confirmation of a transfer's payout. Stored under "payout_<tranID>". The payout member confirms a transfer
+//			 has been paid to its receiver with confirm_payout, which moves it from sent to paid. The receiver's ID
+//			 document never reaches the chaincode, as every argument is kept in the blocks: the payout member sends an
+//			 HMAC of the tranID and the document under a key only it holds (see model.ReceiverIDHash). The member can
+//			 check the hash against the document later; nobody without the key can find the document from it. A
+//			 transfer is paid out once, in the currency and amount it promised; a cash pickup transfer only once its
+//			 pickup code has been verified.
+//==============================================================================================================================
+type Payout struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	TranID         string `json:"tranID"`
+	PayoutMember   string `json:"payoutMember"`
+	ReceiverIDHash string `json:"receiverIDHash"`
+	Location       string `json:"location"`
+	Currency       string `json:"currency"`
+	Amount         string `json:"amount"`
+	PaidAt         string `json:"paidAt"`
+	TxID           string `json:"txID"`
+}
+
+//==============================================================================================================================
+//	 promised_payout - Returns the currency and amount a transfer promised its receiver: the payout currency and amount
+//					   when it has them, the amount in the settlement currency when it does not.
+//==============================================================================================================================
+func promised_payout(tEvent TransactionEvent) (string, string) {
+
+	if tEvent.PayoutCurrency != "" {
+		return tEvent.PayoutCurrency, tEvent.PayoutAmount
+	}
+
+	return SETTLEMENT_CURRENCY, tEvent.Amount
+}
+
+//=================================================================================================================================
+//	 confirm_payout - Records that a transfer has been paid to its receiver and moves it to paid. Only the payout member
+//					  may confirm, only while the transfer is sent, and only for the currency and amount it promised. A
+//					  cash pickup transfer must have had its pickup code verified first.
+//=================================================================================================================================
+func (t *SimpleChaincode) confirm_payout(stub shim.ChaincodeStubInterface, caller_member string, args []string) ([]byte, error) {
+
+	tranID, receiverIDHash, location, currency, amount := args[0], args[1], args[2], args[3], args[4]
+
+	tEvent, err := t.retrieve_tranEvent(stub, tranID)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller_member != tEvent.PayoutMember {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. confirm_payout. %v === %v", caller_member, tEvent.PayoutMember))
+	}
+
+	var payout Payout
+
+	found, err := read_record(stub, KIND_PAYOUT, "payout_"+tranID, &payout)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, errors.New("Transfer " + tranID + " was already paid out at " + payout.PaidAt)
+	}
+
+	if tEvent.Status != STATUS_SENT {
+		return nil, errors.New("Transfer " + tranID + " is " + tEvent.Status + " and cannot be paid out")
+	}
+
+	promised_currency, promised_amount := promised_payout(tEvent)
+
+	if currency != promised_currency {
+		return nil, errors.New("Transfer " + tranID + " is paid out in " + promised_currency + ", not " + currency)
+	}
+
+	paid, err := parse_amount(amount)
+	if err != nil {
+		return nil, errors.New("Invalid amount: " + err.Error())
+	}
+
+	promised, err := parse_amount(promised_amount)
+	if err != nil {
+		return nil, errors.New("Transfer " + tranID + " has an invalid payout amount " + promised_amount)
+	}
+
+	if paid != promised {
+		return nil, errors.New(fmt.Sprintf("Transfer %v promised %v %v, not %v", tranID, format_amount(promised), currency, format_amount(paid)))
+	}
+
+	var pickup Pickup
+
+	found, err = read_record(stub, KIND_PICKUP, "pickup_"+tranID, &pickup)
+	if err != nil {
+		return nil, err
+	}
+	if found && (pickup.Locked || !pickup.LastVerified) {
+		return nil, errors.New("Transfer " + tranID + " is a cash pickup transfer and its pickup code has not been verified")
+	}
+
+	now, err := tx_timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	payout = Payout{
+		SchemaVersion:  SCHEMA_VERSION,
+		TranID:         tranID,
+		PayoutMember:   tEvent.PayoutMember,
+		ReceiverIDHash: receiverIDHash,
+		Location:       location,
+		Currency:       currency,
+		Amount:         format_amount(paid),
+		PaidAt:         now,
+		TxID:           stub.GetTxID(),
+	}
+
+	err = save_record(stub, "payout_"+tranID, payout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.change_status(stub, &tEvent, STATUS_PAID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, t.save_event(stub, tEvent)
+}
+
+//=================================================================================================================================
+//	 get_payout - Returns the payout confirmation of a transfer. The caller must be an admin or act for the sending or
+//				  payout member.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_payout(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, tranID string) ([]byte, error) {
+
+	tEvent, err := t.retrieve_tranEvent(stub, tranID)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller_role != ROLE_ADMIN && caller_member != tEvent.SendingMember && caller_member != tEvent.PayoutMember {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. get_payout. %v === %v|%v", caller_member, tEvent.SendingMember, tEvent.PayoutMember))
+	}
+
+	var payout Payout
+
+	found, err := read_record(stub, KIND_PAYOUT, "payout_"+tranID, &payout)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Transfer " + tranID + " has not been paid out")
+	}
+
+	bytes, err := json.Marshal(payout)
+	if err != nil {
+		return nil, errors.New("Error converting payout")
+	}
+
+	return bytes, nil
+}
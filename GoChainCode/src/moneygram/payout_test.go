@@ -0,0 +1,104 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"moneygram/model"
+)
+
+func TestDoublePayout(t *testing.T) {
+
+	idHash, err := model.ReceiverIDHash(test_pickup_key, "t1", "passport", "G123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		caller map[string]string
+		args   []string
+		err    string
+	}{
+		{"again", bancomer_caller, []string{"t1", idHash, "Puebla", "USD", "100"}, "already paid out"},
+		{"elsewhere", bancomer_caller, []string{"t1", idHash, "Oaxaca", "USD", "100"}, "already paid out"},
+		{"by the sender", walmart_caller, []string{"t1", idHash, "Puebla", "USD", "100"}, "Permission Denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("create_event", transfer("t1", "100").CreateArgs()...)
+
+			l.SetCaller(bancomer_caller)
+			l.invoke_fails("promised 100.00 USD, not 90.00", "confirm_payout", "t1", idHash, "Puebla", "USD", "90")
+			l.invoke("confirm_payout", "t1", idHash, "Puebla", "USD", "100")
+
+			l.SetCaller(tt.caller)
+			l.invoke_fails(tt.err, "confirm_payout", tt.args...)
+
+			var payout Payout
+			l.query(&payout, "get_payout", "t1")
+
+			if payout.Location != "Puebla" {
+				t.Fatalf("payout %+v", payout)
+			}
+
+			var e TransactionEvent
+			l.query(&e, "get_event_details", "t1")
+
+			if e.Status != STATUS_PAID {
+				t.Fatalf("status %v, want %v", e.Status, STATUS_PAID)
+			}
+		})
+	}
+}
+
+func TestRecordKeyTranIDs(t *testing.T) {
+
+	idHash, err := model.ReceiverIDHash(test_pickup_key, "t1", "passport", "G123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tranID := range []string{"payout_t1", "pickup_t1", "ref_t1", "changes", "tranIDs", "member_walmart", "migration"} {
+		t.Run(tranID, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("create_event", transfer("t1", "100").CreateArgs()...)
+
+			l.SetCaller(walmart_caller)
+			l.invoke_fails("reserved", "create_event", transfer(tranID, "100").CreateArgs()...)
+
+			bulk, err := json.Marshal([]model.TransactionEvent{transfer("t2", "100"), transfer(tranID, "100")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.invoke_fails("reserved", "create_events", string(bulk))
+
+			// The real transfer is still paid out, and its status change recorded
+			l.SetCaller(bancomer_caller)
+			l.invoke("confirm_payout", "t1", idHash, "Puebla", "USD", "100")
+
+			l.SetCaller(admin_caller)
+			var e TransactionEvent
+			l.query(&e, "get_event_details", "t1")
+
+			if e.Status != STATUS_PAID {
+				t.Fatalf("status %v, want %v", e.Status, STATUS_PAID)
+			}
+		})
+	}
+
+	t.Run("similar tranIDs", func(t *testing.T) {
+
+		l := new_network(t)
+		for _, tranID := range []string{"payout", "changes-1", "refund_1", "tranids"} {
+			l.invoke("create_event", transfer(tranID, "10").CreateArgs()...)
+		}
+	})
+}
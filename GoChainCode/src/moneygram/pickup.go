@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Cash pickup - Every transfer gets a reference number the receiver quotes at the payout agent. It is derived from the
+//				   tranID inside create_event, so every peer derives the same one, and is unique across the network: a
+//				   number already taken is derived again with a counter. The last digit is a Luhn check digit so a
+//				   mistyped number is caught before it counts against the transfer.
+//
+//				   A transfer created with a pickup code is a cash pickup transfer. The code itself never reaches the
+//				   chaincode, as every argument is kept in the blocks: clients send a keyed hash of it instead, an
+//				   HMAC of the tranID and code under a pickup key the members hold off the ledger (see
+//				   model.PickupCodeHash), so a short code cannot be found by hashing every candidate. That hash is
+//				   hashed again and kept under "pickup_<tranID>", and no query returns it. verify_pickup checks the
+//				   keyed hash of the code a receiver gives and counts the failures; after PICKUP_MAX_ATTEMPTS in a row
+//				   the transfer is locked until an admin unlocks it.
+//
+//				   verify_pickup is an invoke, as a query cannot count the attempt, and it returns nothing: whether the
+//				   code was good is read with get_pickup once the attempt is committed. A Fabric 1.x client holds the
+//				   endorsement before it is ordered and may never submit it, so an attempt it abandons is not counted.
+//				   The endorsement's write set still holds the new pickup record, so on Fabric 1.x a client that can
+//				   reach an endorsing peer can test codes without them counting; the lockout only holds on v0.6,
+//				   where an invoke is ordered before it runs.
+//==============================================================================================================================
+const PICKUP_REFERENCE_DIGITS = 10
+const PICKUP_REFERENCE_TRIES = 100
+const PICKUP_MAX_ATTEMPTS = 3
+
+//==============================================================================================================================
+//	PickupReference - Maps a reference number to its transfer. Stored under "ref_<referenceNumber>".
+//==============================================================================================================================
+type PickupReference struct {
+	SchemaVersion   int    `json:"schemaVersion"`
+	ReferenceNumber string `json:"referenceNumber"`
+	TranID          string `json:"tranID"`
+}
+
+//==============================================================================================================================
+//	Pickup - The pickup code of a cash pickup transfer and the attempts made to verify it. FailedAttempts counts the bad
+//			 codes since the last good one or unlock; LastVerified is whether the last code checked was good.
+//==============================================================================================================================
+type Pickup struct {
+	SchemaVersion   int    `json:"schemaVersion"`
+	TranID          string `json:"tranID"`
+	ReferenceNumber string `json:"referenceNumber"`
+	CodeHash        string `json:"codeHash"`
+	FailedAttempts  int    `json:"failedAttempts"`
+	Locked          bool   `json:"locked"`
+	LockedAt        string `json:"lockedAt,omitempty"`
+	LastAttemptAt   string `json:"lastAttemptAt,omitempty"`
+	LastVerified    bool   `json:"lastVerified"`
+	VerifiedAt      string `json:"verifiedAt,omitempty"`
+	VerifiedBy      string `json:"verifiedBy,omitempty"`
+}
+
+//==============================================================================================================================
+//	PickupStatus - The result of get_pickup. Verified is whether the last code checked was good, and
+//				   VerifiedAt when a good code was last given.
+//==============================================================================================================================
+type PickupStatus struct {
+	TranID          string `json:"tranID"`
+	ReferenceNumber string `json:"referenceNumber"`
+	Status          string `json:"status"`
+	Verified        bool   `json:"verified"`
+	VerifiedAt      string `json:"verifiedAt,omitempty"`
+	FailedAttempts  int    `json:"failedAttempts"`
+	AttemptsLeft    int    `json:"attemptsLeft"`
+	Locked          bool   `json:"locked"`
+}
+
+//==============================================================================================================================
+//	 luhn_digit - Returns the Luhn check digit of a string of digits.
+//==============================================================================================================================
+func luhn_digit(digits string) string {
+
+	sum := 0
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+//==============================================================================================================================
+//	 valid_reference - Reports whether a reference number has the right length and check digit.
+//==============================================================================================================================
+func valid_reference(reference string) bool {
+
+	if len(reference) != PICKUP_REFERENCE_DIGITS {
+		return false
+	}
+
+	for _, c := range reference {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	body := reference[:len(reference)-1]
+
+	return luhn_digit(body) == reference[len(reference)-1:]
+}
+
+//==============================================================================================================================
+//	 assign_reference - Derives the reference number of a new transfer and records it. Called by create_event.
+//==============================================================================================================================
+func (t *SimpleChaincode) assign_reference(stub shim.ChaincodeStubInterface, tranID string) (string, error) {
+
+	modulus := uint64(1)
+	for i := 1; i < PICKUP_REFERENCE_DIGITS; i++ {
+		modulus *= 10
+	}
+
+	for try := 0; try < PICKUP_REFERENCE_TRIES; try++ {
+
+		sum := sha256.Sum256([]byte(tranID + "#" + strconv.Itoa(try)))
+		body := fmt.Sprintf("%0*d", PICKUP_REFERENCE_DIGITS-1, binary.BigEndian.Uint64(sum[:8])%modulus)
+		reference := body + luhn_digit(body)
+
+		taken, err := stub.GetState("ref_" + reference)
+		if err != nil {
+			return "", errors.New("Error retrieving ref_" + reference)
+		}
+
+		if taken == nil {
+			return reference, save_record(stub, "ref_"+reference, PickupReference{SchemaVersion: SCHEMA_VERSION, ReferenceNumber: reference, TranID: tranID})
+		}
+	}
+
+	return "", errors.New("Unable to assign a reference number to " + tranID)
+}
+
+//==============================================================================================================================
+//	 pickup_code_hash - Returns the stored hash of a pickup code's keyed hash.
+//==============================================================================================================================
+func pickup_code_hash(tranID string, code_hash string) string {
+	return hash_fields(tranID, code_hash)
+}
+
+//==============================================================================================================================
+//	 hash_fields - Returns the SHA-256 of fields, each prefixed with its length so that no two lists hash alike.
+//==============================================================================================================================
+func hash_fields(fields ...string) string {
+
+	h := sha256.New()
+
+	for _, field := range fields {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//==============================================================================================================================
+//	 set_pickup_code - Makes a new transfer a cash pickup transfer, given the keyed hash of its pickup code. Called by
+//					   create_event.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_pickup_code(stub shim.ChaincodeStubInterface, tEvent TransactionEvent, code_hash string) error {
+
+	pickup := Pickup{
+		SchemaVersion:   SCHEMA_VERSION,
+		TranID:          tEvent.TranID,
+		ReferenceNumber: tEvent.ReferenceNumber,
+		CodeHash:        pickup_code_hash(tEvent.TranID, code_hash),
+	}
+
+	return save_record(stub, "pickup_"+tEvent.TranID, pickup)
+}
+
+//==============================================================================================================================
+//	 retrieve_pickup - Returns the transfer with a reference number and its pickup record. The transfer must be a cash
+//					   pickup transfer.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_pickup(stub shim.ChaincodeStubInterface, reference string) (TransactionEvent, Pickup, error) {
+
+	var ref PickupReference
+	var pickup Pickup
+
+	if !valid_reference(reference) {
+		return TransactionEvent{}, pickup, errors.New("Invalid reference number " + reference)
+	}
+
+	found, err := read_record(stub, KIND_PICKUP_REFERENCE, "ref_"+reference, &ref)
+	if err != nil {
+		return TransactionEvent{}, pickup, err
+	}
+	if !found {
+		return TransactionEvent{}, pickup, errors.New("No transfer has reference number " + reference)
+	}
+
+	tEvent, err := t.retrieve_tranEvent(stub, ref.TranID)
+	if err != nil {
+		return tEvent, pickup, err
+	}
+
+	found, err = read_record(stub, KIND_PICKUP, "pickup_"+ref.TranID, &pickup)
+	if err != nil {
+		return tEvent, pickup, err
+	}
+	if !found {
+		return tEvent, pickup, errors.New("Transfer " + reference + " is not a cash pickup transfer")
+	}
+
+	return tEvent, pickup, nil
+}
+
+//==============================================================================================================================
+//	 pickup_status - Returns the status of a pickup as callers see it.
+//==============================================================================================================================
+func pickup_status(tEvent TransactionEvent, pickup Pickup) PickupStatus {
+
+	left := PICKUP_MAX_ATTEMPTS - pickup.FailedAttempts
+	if left < 0 || pickup.Locked {
+		left = 0
+	}
+
+	return PickupStatus{
+		TranID:          tEvent.TranID,
+		ReferenceNumber: pickup.ReferenceNumber,
+		Status:          tEvent.Status,
+		Verified:        pickup.LastVerified,
+		VerifiedAt:      pickup.VerifiedAt,
+		FailedAttempts:  pickup.FailedAttempts,
+		AttemptsLeft:    left,
+		Locked:          pickup.Locked,
+	}
+}
+
+//=================================================================================================================================
+//	 verify_pickup - Checks the keyed hash of the pickup code a receiver gives a payout agent. The caller must be an admin
+//					 or act for the payout member, and the transfer must still be waiting to be paid out. A bad code is
+//					 counted rather than returned as an error, so the count is kept; after PICKUP_MAX_ATTEMPTS in a row
+//					 the transfer is locked. The outcome is read with get_pickup once the transaction is committed.
+//=================================================================================================================================
+func (t *SimpleChaincode) verify_pickup(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, reference string, code_hash string) ([]byte, error) {
+
+	tEvent, pickup, err := t.retrieve_pickup(stub, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller_role != ROLE_ADMIN && caller_member != tEvent.PayoutMember {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. verify_pickup. %v === %v", caller_member, tEvent.PayoutMember))
+	}
+
+	if tEvent.Status != STATUS_SENT {
+		return nil, errors.New("Transfer " + reference + " is " + tEvent.Status + " and cannot be picked up")
+	}
+
+	if pickup.Locked {
+		return nil, errors.New(fmt.Sprintf("Transfer %v is locked after %v bad pickup codes", reference, pickup.FailedAttempts))
+	}
+
+	now, err := tx_timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	pickup.LastAttemptAt = now
+	pickup.LastVerified = subtle.ConstantTimeCompare([]byte(pickup_code_hash(tEvent.TranID, code_hash)), []byte(pickup.CodeHash)) == 1
+
+	if pickup.LastVerified {
+		pickup.FailedAttempts = 0
+		pickup.VerifiedAt = now
+		pickup.VerifiedBy = caller_member
+	} else {
+		pickup.FailedAttempts++
+		if pickup.FailedAttempts >= PICKUP_MAX_ATTEMPTS {
+			pickup.Locked = true
+			pickup.LockedAt = now
+		}
+	}
+
+	pickup.SchemaVersion = SCHEMA_VERSION
+
+	return nil, save_record(stub, "pickup_"+tEvent.TranID, pickup)
+}
+
+//=================================================================================================================================
+//	 unlock_pickup - Clears the failed attempts of a cash pickup transfer, once the sender has been contacted. Only an
+//					 admin may unlock.
+//=================================================================================================================================
+func (t *SimpleChaincode) unlock_pickup(stub shim.ChaincodeStubInterface, reference string) ([]byte, error) {
+
+	tEvent, pickup, err := t.retrieve_pickup(stub, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	pickup.SchemaVersion = SCHEMA_VERSION
+	pickup.FailedAttempts = 0
+	pickup.LastVerified = false
+	pickup.Locked = false
+	pickup.LockedAt = ""
+
+	return nil, save_record(stub, "pickup_"+tEvent.TranID, pickup)
+}
+
+//=================================================================================================================================
+//	 get_pickup - Returns the PickupStatus of a cash pickup transfer, without its code. The caller must be an admin or act
+//				  for the sending or payout member.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_pickup(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, reference string) ([]byte, error) {
+
+	tEvent, pickup, err := t.retrieve_pickup(stub, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller_role != ROLE_ADMIN && caller_member != tEvent.SendingMember && caller_member != tEvent.PayoutMember {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. get_pickup. %v === %v|%v", caller_member, tEvent.SendingMember, tEvent.PayoutMember))
+	}
+
+	bytes, err := json.Marshal(pickup_status(tEvent, pickup))
+	if err != nil {
+		return nil, errors.New("Error converting pickup status")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,108 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"testing"
+
+	"moneygram/model"
+)
+
+//==============================================================================================================================
+//	 pickup_transfer - Creates a cash pickup transfer with a pickup code and returns its reference number.
+//==============================================================================================================================
+func pickup_transfer(t *testing.T, l test_ledger, tranID string, code string) string {
+
+	t.Helper()
+
+	e := transfer(tranID, "100")
+	e.PickupCode = code
+	if err := e.HashPickupCode(test_pickup_key); err != nil {
+		t.Fatal(err)
+	}
+	l.invoke("create_event", e.CreateArgs()...)
+
+	var created TransactionEvent
+	l.query(&created, "get_event_details", tranID)
+
+	return created.ReferenceNumber
+}
+
+//==============================================================================================================================
+//	 code_hash - Returns the keyed hash of a pickup code, as a payout agent sends it to verify_pickup.
+//==============================================================================================================================
+func code_hash(t *testing.T, tranID string, code string) string {
+
+	t.Helper()
+
+	e := model.TransactionEvent{TranID: tranID, PickupCode: code}
+	if err := e.HashPickupCode(test_pickup_key); err != nil {
+		t.Fatal(err)
+	}
+
+	return e.PickupCodeHash
+}
+
+func TestPickupLockout(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		codes    []string
+		verified bool
+		locked   bool
+	}{
+		{"right code", []string{"12345678"}, true, false},
+		{"right after wrong", []string{"00000000", "11111111", "12345678"}, true, false},
+		{"wrong codes", []string{"00000000", "11111111", "22222222"}, false, true},
+		{"right code once locked", []string{"00000000", "11111111", "22222222", "12345678"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			reference := pickup_transfer(t, l, "t1", "12345678")
+
+			l.SetCaller(walmart_caller)
+			l.invoke_fails("Permission Denied", "verify_pickup", reference, code_hash(t, "t1", "12345678"))
+
+			l.SetCaller(bancomer_caller)
+			for i, code := range tt.codes {
+				out, err := l.Invoke("verify_pickup", reference, code_hash(t, "t1", code))
+				if err != nil && !(tt.locked && i >= PICKUP_MAX_ATTEMPTS) {
+					t.Fatal(err)
+				}
+				if len(out) != 0 {
+					t.Fatalf("verify_pickup returned %s, want nothing until get_pickup", out)
+				}
+				if err == nil && i >= PICKUP_MAX_ATTEMPTS {
+					t.Fatalf("attempt %v was accepted after the lockout", i+1)
+				}
+			}
+
+			var status PickupStatus
+			l.query(&status, "get_pickup", reference)
+
+			if status.Verified != tt.verified || status.Locked != tt.locked {
+				t.Fatalf("status %+v", status)
+			}
+
+			idHash, err := model.ReceiverIDHash(test_pickup_key, "t1", "passport", "G123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = l.Invoke("confirm_payout", "t1", idHash, "Puebla", "USD", "100")
+			if tt.verified != (err == nil) {
+				t.Fatalf("confirm_payout after verified=%v: %v", tt.verified, err)
+			}
+
+			if tt.locked {
+				l.SetCaller(admin_caller)
+				l.invoke("unlock_pickup", reference)
+				l.SetCaller(bancomer_caller)
+				l.invoke("verify_pickup", reference, code_hash(t, "t1", "12345678"))
+			}
+		})
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 SCHEMA_VERSION - The version of every record this chaincode writes, stored in the record's 'schemaVersion' field.
+//					  Records written before versioning was introduced have no field and are version 0. Bump it together
+//					  with a new entry for every kind in upgrades whenever a stored structure changes.
+//==============================================================================================================================
+const SCHEMA_VERSION = 6
+
+//==============================================================================================================================
+//	 Record kinds - Each kind of stored record has its own list of upgrades.
+//==============================================================================================================================
+const KIND_EVENT = "event"
+const KIND_TRAN_HOLDER = "tranIDs"
+const KIND_CHANGE_HOLDER = "changes"
+const KIND_MEMBER_HOLDER = "memberIDs"
+const KIND_BATCH_HOLDER = "batchIDs"
+const KIND_MEMBER = "member"
+const KIND_LIMIT = "limit"
+const KIND_BATCH = "batch"
+const KIND_DEPLOYMENT = "deployment"
+const KIND_AGGREGATE = "aggregate"
+const KIND_COMPLIANCE_CONFIG = "compliance_config"
+const KIND_SENDER_DAY = "senderday"
+const KIND_CTR = "ctr"
+const KIND_SAR = "sar"
+const KIND_REPORT_HOLDER = "reportIDs"
+const KIND_DETECTION_RULES = "detection_rules"
+const KIND_ALERT = "alert"
+const KIND_BATCH_ALERTS = "batchalerts"
+const KIND_BATCH_LEAVES = "batchleaves"
+const KIND_PICKUP = "pickup"
+const KIND_PICKUP_REFERENCE = "ref"
+const KIND_PAYOUT = "payout"
+
+//==============================================================================================================================
+//	 Record keys - A transfer is stored under its tranID, alongside every other record. So that no transfer can take
+//				   another record's place, a tranID may not be one of record_keys or start with one of
+//				   record_prefixes.
+//==============================================================================================================================
+var record_keys = []string{"tranIDs", "changes", "memberIDs", "batchIDs", "deployment", "compliance_config",
+	"detection_rules", "ctrIDs", "sarIDs", "alertIDs", "migration"}
+
+var record_prefixes = []string{"member_", "limit_", "batch_", "batchleaves_", "batchalerts_", "stats_", "senderday_",
+	"ctr_", "sar_", "alert_", "pickup_", "ref_", "payout_"}
+
+//==============================================================================================================================
+//	 is_record_key - Returns whether key is, or may become, the key of a record other than a transfer.
+//==============================================================================================================================
+func is_record_key(key string) bool {
+
+	for _, k := range record_keys {
+		if key == k {
+			return true
+		}
+	}
+
+	for _, prefix := range record_prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//==============================================================================================================================
+//	 upgrade_func - Upgrades a decoded record by one schema version in place. Fields the upgrade does not know about must
+//					be left alone.
+//==============================================================================================================================
+type upgrade_func func(record map[string]interface{}) error
+
+//==============================================================================================================================
+//	 upgrades - The upgrades for each kind of record. upgrades[kind][v] turns a version v record into a version v+1 one,
+//				so every list has SCHEMA_VERSION entries. Upgrades are never removed: a ledger may still hold records
+//				from the first release.
+//==============================================================================================================================
+var upgrades = map[string][]upgrade_func{
+	KIND_EVENT:         {upgrade_event_v1, upgrade_event_v2, upgrade_v3, no_change, upgrade_v5, upgrade_v6},
+	KIND_TRAN_HOLDER:   {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_CHANGE_HOLDER: {no_change, no_change, no_change, no_change, no_change, no_change},
+	KIND_MEMBER_HOLDER: {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_BATCH_HOLDER:  {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_MEMBER:        {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_LIMIT:         {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_BATCH:         {no_change, no_change, upgrade_v3, upgrade_v4, no_change, no_change},
+	KIND_DEPLOYMENT:    {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_AGGREGATE:     {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+
+	KIND_COMPLIANCE_CONFIG: {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_SENDER_DAY:        {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_CTR:               {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_SAR:               {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_REPORT_HOLDER:     {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_DETECTION_RULES:   {no_change, no_change, upgrade_v3, no_change, no_change, upgrade_detection_rules_v6},
+	KIND_ALERT:             {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_BATCH_ALERTS:      {no_change, no_change, upgrade_v3, no_change, no_change, no_change},
+	KIND_BATCH_LEAVES:      {no_change, no_change, no_change, no_change, no_change, no_change},
+	KIND_PICKUP:            {no_change, no_change, no_change, no_change, no_change, no_change},
+	KIND_PICKUP_REFERENCE:  {no_change, no_change, no_change, no_change, no_change, no_change},
+	KIND_PAYOUT:            {no_change, no_change, no_change, no_change, no_change, no_change},
+}
+
+//==============================================================================================================================
+//	 no_change - The upgrade for a version whose layout did not change for a kind; only the version is stamped.
+//==============================================================================================================================
+func no_change(record map[string]interface{}) error {
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_event_v1 - Events written while DateTime and AccountNumber were enabled carry 'datetime' and
+//						'accountNumber', usually empty. Empty values are dropped so those events read the same as the
+//						ones written without the fields; non-empty values are kept.
+//==============================================================================================================================
+func upgrade_event_v1(record map[string]interface{}) error {
+
+	for _, field := range []string{"datetime", "accountNumber"} {
+		if v, ok := record[field]; ok && (v == nil || v == "") {
+			delete(record, field)
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_event_v2 - Version 2 added a status, a fee and an optional payout currency and amount. Earlier transfers
+//						were all sent without a fee. They were created before the statistics existed and are not counted
+//						in them.
+//==============================================================================================================================
+func upgrade_event_v2(record map[string]interface{}) error {
+
+	if _, ok := record["status"]; !ok {
+		record["status"] = STATUS_SENT
+	}
+
+	if _, ok := record["fee"]; !ok {
+		record["fee"] = "0.00"
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_v3 - Version 3 added the times records were created and changed. They are not known for earlier records,
+//				  which are left without them rather than given the time of the migration.
+//==============================================================================================================================
+func upgrade_v3(record map[string]interface{}) error {
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_v4 - Version 4 added the Merkle root of a batch's transfers, set when it closes. Batches closed before then
+//				  are left without one and have no inclusion proofs.
+//==============================================================================================================================
+func upgrade_v4(record map[string]interface{}) error {
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_v5 - Version 5 added the reference number of a transfer, assigned when it is created. Earlier transfers are
+//				  left without one and cannot be picked up with verify_pickup.
+//==============================================================================================================================
+func upgrade_v5(record map[string]interface{}) error {
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_v6 - Version 6 added the agent location a transfer was sent from. Earlier transfers are left without one and
+//				  are not counted by the many_locations rule.
+//==============================================================================================================================
+func upgrade_v6(record map[string]interface{}) error {
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_detection_rules_v6 - Version 6 added the window each detection rule looks back over. Rules set before then
+//								  get the default window.
+//==============================================================================================================================
+func upgrade_detection_rules_v6(record map[string]interface{}) error {
+
+	rules, ok := record["rules"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			return errors.New("Corrupt detection rule")
+		}
+		if _, ok := rule["windowDays"]; !ok {
+			rule["windowDays"] = DETECTION_WINDOW_DAYS
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade_record - Upgrades the stored JSON of a record of the given kind to SCHEMA_VERSION. Returns the upgraded JSON
+//					  and the version it was stored at. A record from a newer chaincode is an error rather than being
+//					  read without the fields this chaincode does not know.
+//==============================================================================================================================
+func upgrade_record(kind string, stored []byte) ([]byte, int, error) {
+
+	var record map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(stored))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&record)
+	if err != nil || record == nil {
+		return nil, 0, errors.New("Corrupt " + kind + " record " + string(stored))
+	}
+
+	version := 0
+	if v, ok := record["schemaVersion"]; ok {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, 0, errors.New("Corrupt " + kind + " record " + string(stored))
+		}
+		version, err = strconv.Atoi(n.String())
+		if err != nil || version < 0 {
+			return nil, 0, errors.New("Corrupt " + kind + " record " + string(stored))
+		}
+	}
+
+	if version > SCHEMA_VERSION {
+		return nil, version, errors.New(fmt.Sprintf("%v record has schema version %v but this chaincode reads up to %v", kind, version, SCHEMA_VERSION))
+	}
+
+	if version == SCHEMA_VERSION {
+		return stored, version, nil
+	}
+
+	for v := version; v < SCHEMA_VERSION; v++ {
+		err = upgrades[kind][v](record)
+		if err != nil {
+			return nil, version, errors.New(fmt.Sprintf("Error upgrading %v record to schema version %v: %v", kind, v+1, err))
+		}
+	}
+
+	record["schemaVersion"] = SCHEMA_VERSION
+
+	upgraded, err := json.Marshal(record)
+	if err != nil {
+		return nil, version, errors.New("Error converting upgraded " + kind + " record")
+	}
+
+	return upgraded, version, nil
+}
+
+//==============================================================================================================================
+//	 read_record - Reads the record at key, upgrades it and decodes it into v. Returns false if there is no record. The
+//				   upgrade is not written back; the record is stored at the new version the next time it is saved or
+//				   when migrate reaches it.
+//==============================================================================================================================
+func read_record(stub shim.ChaincodeStubInterface, kind string, key string, v interface{}) (bool, error) {
+
+	stored, err := stub.GetState(key)
+	if err != nil {
+		return false, errors.New("Error retrieving " + key)
+	}
+
+	if stored == nil {
+		return false, nil
+	}
+
+	upgraded, _, err := upgrade_record(kind, stored)
+	if err != nil {
+		return true, err
+	}
+
+	err = json.Unmarshal(upgraded, v)
+	if err != nil {
+		return true, errors.New("Corrupt " + kind + " record " + string(stored))
+	}
+
+	return true, nil
+}
+
+//==============================================================================================================================
+//	 Migration - migrate upgrades stored records in bulk, a limited number per call so that no transaction grows too
+//				 large. Its progress is stored under "migration" so the next call carries on where the last stopped.
+//				 Records are visited stage by stage. The first stages walk indexes that are only ever appended to, so
+//				 the offset stays valid between calls. The kinds no index lists are scanned by key prefix instead,
+//				 carrying on after the last key visited; a record added behind that key is already at SCHEMA_VERSION.
+//==============================================================================================================================
+var migration_stages = []string{"indexes", "members", "limits", "batches", "events",
+	"batchleaves", "batchalerts", "aggregates", "senderdays", "ctrs", "sars", "alerts", "pickups", "references", "payouts"}
+
+//==============================================================================================================================
+//	migration_scan - The kind and key prefix of the records a scanned stage visits.
+//==============================================================================================================================
+type migration_scan struct {
+	kind   string
+	prefix string
+}
+
+var migration_scans = map[string]migration_scan{
+	"batchleaves": {KIND_BATCH_LEAVES, "batchleaves_"},
+	"batchalerts": {KIND_BATCH_ALERTS, "batchalerts_"},
+	"aggregates":  {KIND_AGGREGATE, "stats_"},
+	"senderdays":  {KIND_SENDER_DAY, "senderday_"},
+	"ctrs":        {KIND_CTR, "ctr_"},
+	"sars":        {KIND_SAR, "sar_"},
+	"alerts":      {KIND_ALERT, "alert_"},
+	"pickups":     {KIND_PICKUP, "pickup_"},
+	"references":  {KIND_PICKUP_REFERENCE, "ref_"},
+	"payouts":     {KIND_PAYOUT, "payout_"},
+}
+
+//==============================================================================================================================
+//	MigrationStatus - The progress of a bulk migration to SchemaVersion. Offset is the position in an indexed stage and
+//					  After the last key visited in a scanned one. Scanned counts the records visited and Migrated those
+//					  that had to be rewritten.
+//==============================================================================================================================
+type MigrationStatus struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Stage         string `json:"stage"`
+	Offset        int    `json:"offset"`
+	After         string `json:"after,omitempty"`
+	Scanned       int    `json:"scanned"`
+	Migrated      int    `json:"migrated"`
+	Done          bool   `json:"done"`
+}
+
+//==============================================================================================================================
+//	migration_item - One record a migration stage visits.
+//==============================================================================================================================
+type migration_item struct {
+	kind string
+	key  string
+}
+
+//==============================================================================================================================
+//	 retrieve_migration - Reads the migration progress. A migration to an older schema version, or none at all, starts
+//						  again from the first stage.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_migration(stub shim.ChaincodeStubInterface) (MigrationStatus, error) {
+
+	status := MigrationStatus{SchemaVersion: SCHEMA_VERSION, Stage: migration_stages[0]}
+
+	bytes, err := stub.GetState("migration")
+	if err != nil {
+		return status, errors.New("Unable to get migration")
+	}
+
+	if bytes == nil {
+		return status, nil
+	}
+
+	var stored MigrationStatus
+	err = json.Unmarshal(bytes, &stored)
+	if err != nil {
+		return status, errors.New("Corrupt migration record " + string(bytes))
+	}
+
+	if stored.SchemaVersion != SCHEMA_VERSION {
+		return status, nil
+	}
+
+	// A migration that finished before the scanned stages were added carries on from the end of its last stage
+	if stored.Done && stored.Stage != migration_stages[len(migration_stages)-1] {
+		stored.Done = false
+	}
+
+	return stored, nil
+}
+
+//==============================================================================================================================
+//	 migration_items - Returns the records an indexed stage visits, in an order that new records only ever extend. A
+//					   scanned stage returns the next records after the key after, at most limit of them.
+//==============================================================================================================================
+func (t *SimpleChaincode) migration_items(stub shim.ChaincodeStubInterface, stage string, after string, limit int) ([]migration_item, error) {
+
+	if scan, ok := migration_scans[stage]; ok {
+		keys, err := scan_keys(stub, scan.prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) == 0 {
+			return nil, nil
+		}
+
+		transfers, err := t.record_key_transfers(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		// A transfer taken before tranIDs were checked may carry the prefix; it is still upgraded as an event
+		items := []migration_item{}
+		for _, key := range keys {
+			if transfers[key] {
+				items = append(items, migration_item{KIND_EVENT, key})
+			} else {
+				items = append(items, migration_item{scan.kind, key})
+			}
+		}
+		return items, nil
+	}
+
+	switch stage {
+
+	case "indexes":
+		return []migration_item{{KIND_TRAN_HOLDER, "tranIDs"}, {KIND_MEMBER_HOLDER, "memberIDs"}, {KIND_BATCH_HOLDER, "batchIDs"}, {KIND_DEPLOYMENT, "deployment"},
+			{KIND_COMPLIANCE_CONFIG, "compliance_config"}, {KIND_DETECTION_RULES, "detection_rules"},
+			{KIND_REPORT_HOLDER, "ctrIDs"}, {KIND_REPORT_HOLDER, "sarIDs"}, {KIND_REPORT_HOLDER, "alertIDs"}, {KIND_CHANGE_HOLDER, "changes"}}, nil
+
+	case "members", "limits":
+		memberHld, err := t.retrieve_member_ids(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := memberHld.MemberIDs
+		items := []migration_item{}
+
+		if stage == "members" {
+			for _, id := range ids {
+				items = append(items, migration_item{KIND_MEMBER, "member_" + id})
+			}
+			return items, nil
+		}
+
+		// Pairs are grouped by the later of the two members so that registering a member only adds pairs at the end
+		for k := 1; k < len(ids); k++ {
+			for j := 0; j < k; j++ {
+				items = append(items, migration_item{KIND_LIMIT, "limit_" + ids[k] + "_" + ids[j]}, migration_item{KIND_LIMIT, "limit_" + ids[j] + "_" + ids[k]})
+			}
+		}
+		return items, nil
+
+	case "batches":
+		batchHld, err := t.retrieve_batch_ids(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		items := []migration_item{}
+		for _, id := range batchHld.BatchIDs {
+			items = append(items, migration_item{KIND_BATCH, "batch_" + id})
+		}
+		return items, nil
+
+	case "events":
+		tranHld, err := t.retrieve_tran_ids(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		items := []migration_item{}
+		for _, id := range tranHld.TranIDs {
+			items = append(items, migration_item{KIND_EVENT, id})
+		}
+		return items, nil
+	}
+
+	return nil, errors.New("Unknown migration stage " + stage)
+}
+
+//==============================================================================================================================
+//	 record_key_transfers - Returns the tranIDs that are the key of another kind of record, which add_event has since
+//							stopped taking.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_key_transfers(stub shim.ChaincodeStubInterface) (map[string]bool, error) {
+
+	tranHld, err := t.retrieve_tran_ids(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := map[string]bool{}
+	for _, id := range tranHld.TranIDs {
+		if is_record_key(id) {
+			transfers[id] = true
+		}
+	}
+
+	return transfers, nil
+}
+
+//=================================================================================================================================
+//	 Migration Functions
+//=================================================================================================================================
+//	 migrate - Upgrades up to limit stored records to SCHEMA_VERSION, continuing from where the previous call stopped.
+//			   Call it until the returned status is done. Only an admin may migrate.
+//=================================================================================================================================
+func (t *SimpleChaincode) migrate(stub shim.ChaincodeStubInterface, limit int) ([]byte, error) {
+
+	if limit <= 0 {
+		return nil, errors.New("Invalid limit: " + strconv.Itoa(limit))
+	}
+
+	status, err := t.retrieve_migration(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := 0
+
+	for !status.Done && visited < limit {
+
+		_, scanned := migration_scans[status.Stage]
+
+		items, err := t.migration_items(stub, status.Stage, status.After, limit-visited)
+		if err != nil {
+			return nil, err
+		}
+
+		for status.Offset < len(items) && visited < limit {
+
+			item := items[status.Offset]
+
+			stored, err := stub.GetState(item.key)
+			if err != nil {
+				return nil, errors.New("Error retrieving " + item.key)
+			}
+
+			if stored != nil {
+				upgraded, version, err := upgrade_record(item.kind, stored)
+				if err != nil {
+					return nil, errors.New("migrate: " + item.key + ": " + err.Error())
+				}
+
+				if version < SCHEMA_VERSION {
+					err = stub.PutState(item.key, upgraded)
+					if err != nil {
+						return nil, errors.New("Error storing " + item.key)
+					}
+					status.Migrated++
+				}
+			}
+
+			if scanned {
+				status.After = item.key
+			}
+
+			status.Offset++
+			status.Scanned++
+			visited++
+		}
+
+		if scanned {
+			more := len(items) > 0 && visited == limit
+			status.Offset = 0
+			if more {
+				break
+			}
+		} else if status.Offset < len(items) {
+			break
+		}
+
+		next := 0
+		for i, stage := range migration_stages {
+			if stage == status.Stage {
+				next = i + 1
+			}
+		}
+
+		if next == len(migration_stages) {
+			status.Done = true
+		} else {
+			status.Stage = migration_stages[next]
+			status.Offset = 0
+			status.After = ""
+		}
+	}
+
+	err = t.save_migration(stub, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(status)
+}
+
+//==============================================================================================================================
+//	 save_migration - Writes the migration progress.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_migration(stub shim.ChaincodeStubInterface, status MigrationStatus) error {
+
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		return errors.New("Error converting migration record")
+	}
+
+	err = stub.PutState("migration", bytes)
+	if err != nil {
+		return errors.New("Error storing migration record")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 get_migration_status - Returns the progress of the bulk migration to SCHEMA_VERSION.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_migration_status(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	status, err := t.retrieve_migration(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		return nil, errors.New("Error converting migration record")
+	}
+
+	return bytes, nil
+}
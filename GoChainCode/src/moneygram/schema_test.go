@@ -0,0 +1,158 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		from  int
+		limit string
+	}{
+		{"previous version", SCHEMA_VERSION - 1, "100"},
+		{"one record at a time", SCHEMA_VERSION - 1, "1"},
+		{"first version", 1, "7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("set_compliance_config", "100", "50", "10", "3")
+
+			for i := 0; i < 4; i++ {
+				e := transfer(fmt.Sprint("t", i), "90")
+				e.PickupCode = "12345678"
+				if err := e.HashPickupCode(test_pickup_key); err != nil {
+					t.Fatal(err)
+				}
+				l.invoke("create_event", e.CreateArgs()...)
+			}
+			l.invoke("close_settlement_batch")
+			l.invoke("raise_alerts", "1")
+
+			// Put every record back at the old version, as a ledger written by an earlier chaincode would be
+			stamped := stamp_records(t, l, tt.from)
+			if stamped == 0 {
+				t.Fatal("no records to migrate")
+			}
+			l.Put("migration", []byte(fmt.Sprintf(`{"schemaVersion":%v,"stage":"events","done":true}`, tt.from)))
+
+			var status MigrationStatus
+			for i := 0; !status.Done; i++ {
+				if i > stamped+len(migration_stages) {
+					t.Fatalf("migration did not finish: %+v", status)
+				}
+				if err := json.Unmarshal(l.invoke("migrate", tt.limit), &status); err != nil {
+					t.Fatal(err)
+				}
+
+				var d Diagnostics
+				l.query(&d, "diagnostics")
+				if _, scanned := migration_scans[status.Stage]; scanned && !status.Done && status.After != "" && d.Migration != status.Stage+" after "+status.After {
+					t.Fatalf("diagnostics report migration %q for %+v", d.Migration, status)
+				}
+			}
+
+			if status.SchemaVersion != SCHEMA_VERSION {
+				t.Fatalf("finished %+v", status)
+			}
+			if left := stamp_records(t, l, -1); left != 0 {
+				t.Fatalf("%v records left unmigrated", left)
+			}
+
+			// The migrated ledger is still usable
+			l.invoke("create_event", transfer("t9", "90").CreateArgs()...)
+		})
+	}
+
+	t.Run("transfer under a record prefix", func(t *testing.T) {
+
+		l := new_network(t)
+		l.invoke("create_event", transfer("t1", "90").CreateArgs()...)
+		stamp_records(t, l, SCHEMA_VERSION-1)
+
+		// A version 1 transfer taken before add_event checked tranIDs, listed in the index like any other
+		for _, tranID := range []string{"pickup_1", "stats_1"} {
+			l.Put(tranID, []byte(`{"schemaVersion":1,"tranID":"`+tranID+`","amount":"5","sendingMember":"walmart","payoutMember":"bancomer"}`))
+
+			var tranHld TRAN_Holder
+			if err := json.Unmarshal(l.Get("tranIDs"), &tranHld); err != nil {
+				t.Fatal(err)
+			}
+			tranHld.TranIDs = append(tranHld.TranIDs, tranID)
+			bytes, err := json.Marshal(tranHld)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Put("tranIDs", bytes)
+		}
+
+		var status MigrationStatus
+		for i := 0; !status.Done; i++ {
+			if i > 100 {
+				t.Fatalf("migration did not finish: %+v", status)
+			}
+			if err := json.Unmarshal(l.invoke("migrate", "1"), &status); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tranID := range []string{"pickup_1", "stats_1"} {
+			var e TransactionEvent
+			l.query(&e, "get_event_details", tranID)
+
+			if e.Status != STATUS_SENT || e.Fee != "0.00" || e.SchemaVersion != SCHEMA_VERSION {
+				t.Fatalf("migrated %+v", e)
+			}
+		}
+	})
+}
+
+//==============================================================================================================================
+//	 stamp_records - Sets the schemaVersion of every versioned record but the migration status to version and returns
+//					 how many there are. With a version below zero the records are only counted, and only those not
+//					 at SCHEMA_VERSION.
+//==============================================================================================================================
+func stamp_records(t *testing.T, l test_ledger, version int) int {
+
+	n := 0
+
+	for _, key := range l.Keys() {
+
+		var record map[string]interface{}
+		if json.Unmarshal(l.Get(key), &record) != nil || key == "migration" {
+			continue
+		}
+
+		v, ok := record["schemaVersion"]
+		if !ok {
+			continue
+		}
+
+		if version < 0 {
+			if v != float64(SCHEMA_VERSION) {
+				t.Logf("%v is at version %v", key, v)
+				n++
+			}
+			continue
+		}
+
+		record["schemaVersion"] = version
+		bytes, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Put(key, bytes)
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Batch status types - A settlement batch collects transfers while it is open. Closing it nets what every pair of
+//						  members owes each other into obligations and opens the next batch.
+//==============================================================================================================================
+const   BATCH_OPEN    =  "open"
+const   BATCH_CLOSED  =  "closed"
+
+//==============================================================================================================================
+//	 SETTLEMENT_CURRENCY - Transfer amounts, limits and obligations are all held in the network's settlement currency.
+//==============================================================================================================================
+const   SETTLEMENT_CURRENCY  =  "USD"
+
+//==============================================================================================================================
+//	SettlementBatch - Defines the structure for a settlement batch. Stored under "batch_<batchID>". Member is only set
+//					  in what get_settlement_batch returns, when it is limited to one member's transfers and obligations.
+//==============================================================================================================================
+type SettlementBatch struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Member        string       `json:"member,omitempty"`
+	BatchID       string       `json:"batchID"`
+	Status        string       `json:"status"`
+	Currency      string       `json:"currency"`
+	TranIDs       []string     `json:"tranIDs"`
+	Obligations   []Obligation `json:"obligations"`
+	OpenedAt      string       `json:"openedAt,omitempty"`
+	ClosedAt      string       `json:"closedAt,omitempty"`
+	MerkleRoot    string       `json:"merkleRoot,omitempty"`
+}
+
+//==============================================================================================================================
+//	Obligation - The net amount a debtor member must pay a creditor member when a batch closes.
+//==============================================================================================================================
+type Obligation struct {
+	Debtor   string `json:"debtor"`
+	Creditor string `json:"creditor"`
+	Amount   string `json:"amount"`
+}
+
+//==============================================================================================================================
+//	Batch Holder - Defines the structure that holds all the batchIDs, oldest first. The last entry is the open batch.
+//==============================================================================================================================
+type BATCH_Holder struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	BatchIDs      []string `json:"batchIDs"`
+}
+
+//==============================================================================================================================
+//	 retrieve_batch - Gets the SettlementBatch record for batchID from the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_batch(stub shim.ChaincodeStubInterface, batchID string) (SettlementBatch, error) {
+
+	var b SettlementBatch
+
+	found, err := read_record(stub, KIND_BATCH, "batch_"+batchID, &b)
+
+	if err != nil {
+		return b, errors.New("retrieve_batch: " + err.Error())
+	}
+
+	if !found {
+		return b, errors.New("retrieve_batch: Unknown batch " + batchID)
+	}
+
+	return b, nil
+}
+
+//==============================================================================================================================
+//	 save_batch - Writes the SettlementBatch record to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_batch(stub shim.ChaincodeStubInterface, b SettlementBatch) error {
+
+	b.SchemaVersion = SCHEMA_VERSION
+
+	bytes, err := json.Marshal(b)
+
+	if err != nil {
+		return errors.New("Error converting batch record")
+	}
+
+	err = stub.PutState("batch_"+b.BatchID, bytes)
+
+	if err != nil {
+		return errors.New("Error storing batch record")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_batch_ids - Reads the batchIDs index.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_batch_ids(stub shim.ChaincodeStubInterface) (BATCH_Holder, error) {
+
+	var batchHld BATCH_Holder
+
+	_, err := read_record(stub, KIND_BATCH_HOLDER, "batchIDs", &batchHld)
+
+	if err != nil {
+		return batchHld, errors.New("Unable to get batchIDs: " + err.Error())
+	}
+
+	return batchHld, nil
+}
+
+//==============================================================================================================================
+//	 open_batch - Starts the next settlement batch and appends it to the batchIDs index. Batch IDs are sequence numbers
+//				  so every peer allocates the same one.
+//==============================================================================================================================
+func (t *SimpleChaincode) open_batch(stub shim.ChaincodeStubInterface) (SettlementBatch, error) {
+
+	batchHld, err := t.retrieve_batch_ids(stub)
+	if err != nil {
+		return SettlementBatch{}, err
+	}
+
+	now, err := tx_timestamp(stub)
+	if err != nil {
+		return SettlementBatch{}, err
+	}
+
+	b := SettlementBatch{
+		BatchID:     strconv.Itoa(len(batchHld.BatchIDs) + 1),
+		Status:      BATCH_OPEN,
+		Currency:    SETTLEMENT_CURRENCY,
+		TranIDs:     []string{},
+		Obligations: []Obligation{},
+		OpenedAt:    now,
+	}
+
+	batchHld.BatchIDs = append(batchHld.BatchIDs, b.BatchID)
+	batchHld.SchemaVersion = SCHEMA_VERSION
+
+	bytes, err := json.Marshal(batchHld)
+	if err != nil {
+		return b, errors.New("Error creating BATCH_Holder record")
+	}
+
+	err = stub.PutState("batchIDs", bytes)
+	if err != nil {
+		return b, errors.New("Error storing batchIDs")
+	}
+
+	return b, t.save_batch(stub, b)
+}
+
+//==============================================================================================================================
+//	 retrieve_open_batch - Gets the batch currently collecting transfers, opening the first one if none exists yet.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_open_batch(stub shim.ChaincodeStubInterface) (SettlementBatch, error) {
+
+	batchHld, err := t.retrieve_batch_ids(stub)
+	if err != nil {
+		return SettlementBatch{}, err
+	}
+
+	if len(batchHld.BatchIDs) == 0 {
+		return t.open_batch(stub)
+	}
+
+	return t.retrieve_batch(stub, batchHld.BatchIDs[len(batchHld.BatchIDs)-1])
+}
+
+//==============================================================================================================================
+//	 add_to_open_batch - Records tranIDs in the open batch.
+//==============================================================================================================================
+func (t *SimpleChaincode) add_to_open_batch(stub shim.ChaincodeStubInterface, tranIDs []string) error {
+
+	b, err := t.retrieve_open_batch(stub)
+	if err != nil {
+		return err
+	}
+
+	b.TranIDs = append(b.TranIDs, tranIDs...)
+
+	return t.save_batch(stub, b)
+}
+
+//=================================================================================================================================
+//	 Settlement Functions
+//=================================================================================================================================
+//	 close_settlement_batch - Closes the open batch. The gross amounts each pair of members owe each other are netted
+//							  into a single obligation per pair, positions are reset to zero, the batch's transfers are
+//							  committed to in a Merkle root and the next batch is opened. Only an admin may close a batch.
+//							  Returns the closed batch.
+//=================================================================================================================================
+func (t *SimpleChaincode) close_settlement_batch(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	b, err := t.retrieve_open_batch(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	memberHld, err := t.retrieve_member_ids(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, a := range memberHld.MemberIDs {
+		for _, c := range memberHld.MemberIDs[i+1:] {
+
+			aOwes, err := t.retrieve_limit(stub, c, a)
+			if err != nil {
+				return nil, err
+			}
+
+			cOwes, err := t.retrieve_limit(stub, a, c)
+			if err != nil {
+				return nil, err
+			}
+
+			net := aOwes.Owed - cOwes.Owed
+
+			if net > 0 {
+				b.Obligations = append(b.Obligations, Obligation{Debtor: a, Creditor: c, Amount: format_amount(net)})
+			} else if net < 0 {
+				b.Obligations = append(b.Obligations, Obligation{Debtor: c, Creditor: a, Amount: format_amount(-net)})
+			}
+
+			if aOwes.Owed != 0 {
+				aOwes.Owed = 0
+				err = t.save_limit(stub, aOwes)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if cOwes.Owed != 0 {
+				cOwes.Owed = 0
+				err = t.save_limit(stub, cOwes)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, memberID := range memberHld.MemberIDs {
+
+		m, err := t.retrieve_member(stub, memberID)
+		if err != nil {
+			return nil, err
+		}
+
+		if m.NetPosition != 0 {
+			m.NetPosition = 0
+			err = t.save_member(stub, m)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = t.commit_batch(stub, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Status = BATCH_CLOSED
+
+	// The transaction's timestamp is the cutoff: every transfer in the batch was created before it
+	b.ClosedAt, err = tx_timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.save_batch(stub, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.open_batch(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(b)
+	if err != nil {
+		return nil, errors.New("Error converting batch record")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 get_settlement_batch - Returns the batch with the given batchID, or the open batch when batchID is "open". Callers
+//							event_scope limits to a member only see the transfers that member sent or pays out and the
+//							obligations it is a party to; the Merkle root is always returned so they can check
+//							inclusion proofs against it.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_settlement_batch(stub shim.ChaincodeStubInterface, batchID string) ([]byte, error) {
+
+	var b SettlementBatch
+	var err error
+
+	member, err := t.event_scope(stub, "get_settlement_batch", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if batchID == BATCH_OPEN {
+		batchHld, err := t.retrieve_batch_ids(stub)
+		if err != nil {
+			return nil, err
+		}
+		if len(batchHld.BatchIDs) == 0 {
+			return nil, errors.New("No settlement batch has been opened")
+		}
+		batchID = batchHld.BatchIDs[len(batchHld.BatchIDs)-1]
+	}
+
+	b, err = t.retrieve_batch(stub, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if member != "" {
+		b, err = t.member_batch(stub, b, member)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bytes, err := json.Marshal(b)
+	if err != nil {
+		return nil, errors.New("Error converting batch record")
+	}
+
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	 member_batch - Returns b with only the transfers member sent or pays out and the obligations it owes or is owed.
+//==============================================================================================================================
+func (t *SimpleChaincode) member_batch(stub shim.ChaincodeStubInterface, b SettlementBatch, member string) (SettlementBatch, error) {
+
+	tranIDs := []string{}
+	for _, tranID := range b.TranIDs {
+
+		e, err := t.retrieve_tranEvent(stub, tranID)
+		if err != nil {
+			return b, err
+		}
+
+		if e.SendingMember == member || e.PayoutMember == member {
+			tranIDs = append(tranIDs, tranID)
+		}
+	}
+
+	obligations := []Obligation{}
+	for _, o := range b.Obligations {
+		if o.Debtor == member || o.Creditor == member {
+			obligations = append(obligations, o)
+		}
+	}
+
+	b.Member = member
+	b.TranIDs = tranIDs
+	b.Obligations = obligations
+
+	return b, nil
+}
+
+//=================================================================================================================================
+//	 get_settlement_batches - Returns the batchIDs index.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_settlement_batches(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	batchHld, err := t.retrieve_batch_ids(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if batchHld.BatchIDs == nil {
+		batchHld.BatchIDs = []string{}
+	}
+
+	bytes, err := json.Marshal(batchHld)
+	if err != nil {
+		return nil, errors.New("Error converting batchIDs")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,132 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestCloseSettlementBatch(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		walmart     []string
+		bancomer    []string
+		obligations []Obligation
+	}{
+		{"empty", nil, nil, nil},
+		{"one way", []string{"100", "50.25"}, nil, []Obligation{{"walmart", "bancomer", "150.25"}}},
+		{"netted", []string{"100"}, []string{"30"}, []Obligation{{"walmart", "bancomer", "70.00"}}},
+		{"reversed", []string{"30"}, []string{"100"}, []Obligation{{"bancomer", "walmart", "70.00"}}},
+		{"even", []string{"40"}, []string{"40"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+
+			n := 0
+			for _, amount := range tt.walmart {
+				n++
+				l.invoke("create_event", transfer(fmt.Sprint("t", n), amount).CreateArgs()...)
+			}
+			for _, amount := range tt.bancomer {
+				n++
+				e := transfer(fmt.Sprint("t", n), amount)
+				e.SendingMember, e.PayoutMember = "bancomer", "walmart"
+				l.invoke("create_event", e.CreateArgs()...)
+			}
+
+			l.SetCaller(walmart_caller)
+			l.invoke_fails("Permission Denied", "close_settlement_batch")
+			l.SetCaller(admin_caller)
+
+			var b SettlementBatch
+			if err := json.Unmarshal(l.invoke("close_settlement_batch"), &b); err != nil {
+				t.Fatal(err)
+			}
+
+			if b.BatchID != "1" || b.Status != BATCH_CLOSED || len(b.TranIDs) != n || b.ClosedAt == "" {
+				t.Fatalf("closed %+v", b)
+			}
+			if n > 0 && b.MerkleRoot == "" {
+				t.Fatal("closed batch has no merkle root")
+			}
+			if fmt.Sprint(b.Obligations) != fmt.Sprint(tt.obligations) {
+				t.Fatalf("obligations %v, want %v", b.Obligations, tt.obligations)
+			}
+
+			var open SettlementBatch
+			l.query(&open, "get_settlement_batch", "open")
+
+			if open.BatchID != "2" || open.Status != BATCH_OPEN || len(open.TranIDs) != 0 {
+				t.Fatalf("opened %+v", open)
+			}
+
+			for _, member := range []string{"walmart", "bancomer"} {
+				var exposure Exposure
+				l.query(&exposure, "get_exposure", member)
+
+				if exposure.NetPosition != "0.00" {
+					t.Fatalf("%v net position %v after close, want 0.00", member, exposure.NetPosition)
+				}
+			}
+		})
+	}
+}
+
+func TestSettlementBatchAccess(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		caller      map[string]string
+		member      string
+		tranIDs     string
+		obligations string
+	}{
+		{"admin", admin_caller, "", "[t1 t2 t3]", "[{walmart bancomer 100.00} {walmart ria 50.00} {ria bancomer 25.00}]"},
+		{"sending member", walmart_caller, "walmart", "[t1 t2]", "[{walmart bancomer 100.00} {walmart ria 50.00}]"},
+		{"payout member", bancomer_caller, "bancomer", "[t1 t3]", "[{walmart bancomer 100.00} {ria bancomer 25.00}]"},
+		{"deployer's auditor", map[string]string{"role": ROLE_AUDITOR, "member": "moneygram"}, "", "[t1 t2 t3]", "[{walmart bancomer 100.00} {walmart ria 50.00} {ria bancomer 25.00}]"},
+		{"member's auditor", map[string]string{"role": ROLE_AUDITOR, "member": "ria"}, "ria", "[t2 t3]", "[{walmart ria 50.00} {ria bancomer 25.00}]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_network(t)
+			l.invoke("register_member", "ria", "Ria", "10000")
+			l.invoke("set_credit_limit", "ria", "walmart", "5000")
+			l.invoke("set_credit_limit", "bancomer", "ria", "5000")
+
+			l.invoke("create_event", transfer("t1", "100").CreateArgs()...)
+			e := transfer("t2", "50")
+			e.PayoutMember = "ria"
+			l.invoke("create_event", e.CreateArgs()...)
+			e = transfer("t3", "25")
+			e.SendingMember = "ria"
+			l.invoke("create_event", e.CreateArgs()...)
+
+			l.invoke("close_settlement_batch")
+
+			var full SettlementBatch
+			l.query(&full, "get_settlement_batch", "1")
+
+			l.SetCaller(tt.caller)
+
+			var b SettlementBatch
+			l.query(&b, "get_settlement_batch", "1")
+
+			if b.Member != tt.member || fmt.Sprint(b.TranIDs) != tt.tranIDs || fmt.Sprint(b.Obligations) != tt.obligations {
+				t.Fatalf("%v sees %+v", tt.name, b)
+			}
+			if b.MerkleRoot != full.MerkleRoot {
+				t.Fatalf("root %v, want %v", b.MerkleRoot, full.MerkleRoot)
+			}
+		})
+	}
+}
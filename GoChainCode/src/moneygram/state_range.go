@@ -0,0 +1,41 @@
+//go:build !fabric1
+// +build !fabric1
+
+package main
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 scan_keys - Returns up to limit keys that start with prefix and sort after the key after, in key order. An empty
+//				 after starts from the first key with the prefix. The v0.6 range query includes its end key, which no
+//				 stored key reaches.
+//==============================================================================================================================
+func scan_keys(stub shim.ChaincodeStubInterface, prefix string, after string, limit int) ([]string, error) {
+
+	start := prefix
+	if after != "" {
+		start = after + "\x00"
+	}
+
+	iter, err := stub.RangeQueryState(start, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, errors.New("Error scanning " + prefix + " keys")
+	}
+	defer iter.Close()
+
+	keys := []string{}
+	for iter.HasNext() && len(keys) < limit {
+		key, _, err := iter.Next()
+		if err != nil {
+			return nil, errors.New("Error scanning " + prefix + " keys")
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
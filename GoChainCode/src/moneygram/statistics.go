@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Statistics dimensions - Every transfer is counted once in each dimension: the whole network (key "all"), its
+//							 corridor ("<senderCountry>><receiverCountry>"), its sending member and its payout member.
+//==============================================================================================================================
+const STATS_NETWORK = "network"
+const STATS_CORRIDOR = "corridor"
+const STATS_SENDING = "sending"
+const STATS_PAYOUT = "payout"
+
+const STATS_NETWORK_KEY = "all"
+
+//==============================================================================================================================
+//	 Statistics periods - Each dimension is aggregated by day and by month of the transaction timestamp, in UTC.
+//==============================================================================================================================
+const STATS_DAY = "2006-01-02"
+const STATS_MONTH = "2006-01"
+
+//==============================================================================================================================
+//	 STATS_MAX_PERIODS - The most periods one get_statistics query may return.
+//==============================================================================================================================
+const STATS_MAX_PERIODS = 400
+
+//==============================================================================================================================
+//	Aggregate - The running totals of one dimension key over one day or month. Stored under
+//				"stats_<dimension>_<key>_<period>" and updated by every create_event and status change, so statistics never
+//				need a scan of the transfers. Count, Principal, Fees and Payout cover the transfers created in the period;
+//				StatusChanges counts the transfers that reached each status in the period, creation included. Payout holds
+//				the paid out amounts by payout currency for transfers that were sent with one.
+//==============================================================================================================================
+type Aggregate struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Dimension     string           `json:"dimension"`
+	Key           string           `json:"key"`
+	Period        string           `json:"period"`
+	Count         int              `json:"count"`
+	Principal     int64            `json:"principal"`
+	Fees          int64            `json:"fees"`
+	Payout        map[string]int64 `json:"payout"`
+	StatusChanges map[string]int   `json:"statusChanges"`
+}
+
+//==============================================================================================================================
+//	Statistics - The result of get_statistics: one entry per period from From to To, and their sum.
+//==============================================================================================================================
+type Statistics struct {
+	Dimension string             `json:"dimension"`
+	Key       string             `json:"key"`
+	From      string             `json:"from"`
+	To        string             `json:"to"`
+	Currency  string             `json:"currency"`
+	Periods   []StatisticsPeriod `json:"periods"`
+	Total     StatisticsPeriod   `json:"total"`
+}
+
+//==============================================================================================================================
+//	StatisticsPeriod - The figures of one period, with amounts as decimal strings.
+//==============================================================================================================================
+type StatisticsPeriod struct {
+	Period        string            `json:"period"`
+	Count         int               `json:"count"`
+	Principal     string            `json:"principal"`
+	Fees          string            `json:"fees"`
+	Payout        map[string]string `json:"payout"`
+	StatusChanges map[string]int    `json:"statusChanges"`
+}
+
+//==============================================================================================================================
+//	 event_dimensions - Returns the dimension and key pairs a transfer is counted under.
+//==============================================================================================================================
+func event_dimensions(e TransactionEvent) [][2]string {
+	return [][2]string{
+		{STATS_NETWORK, STATS_NETWORK_KEY},
+		{STATS_CORRIDOR, e.SenderCountry + ">" + e.ReceiverCountry},
+		{STATS_SENDING, e.SendingMember},
+		{STATS_PAYOUT, e.PayoutMember},
+	}
+}
+
+//==============================================================================================================================
+//	 aggregate_key - The state key of an aggregate.
+//==============================================================================================================================
+func aggregate_key(dimension string, key string, period string) string {
+	return "stats_" + dimension + "_" + key + "_" + period
+}
+
+//==============================================================================================================================
+//	 retrieve_aggregate - Gets an aggregate. A period with no transfers has an empty aggregate.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_aggregate(stub shim.ChaincodeStubInterface, dimension string, key string, period string) (Aggregate, error) {
+
+	a := Aggregate{Dimension: dimension, Key: key, Period: period}
+
+	_, err := read_record(stub, KIND_AGGREGATE, aggregate_key(dimension, key, period), &a)
+	if err != nil {
+		return a, errors.New("retrieve_aggregate: " + err.Error())
+	}
+
+	if a.Payout == nil {
+		a.Payout = map[string]int64{}
+	}
+	if a.StatusChanges == nil {
+		a.StatusChanges = map[string]int{}
+	}
+
+	return a, nil
+}
+
+//==============================================================================================================================
+//	 save_aggregate - Writes an aggregate.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_aggregate(stub shim.ChaincodeStubInterface, a Aggregate) error {
+
+	a.SchemaVersion = SCHEMA_VERSION
+
+	bytes, err := json.Marshal(a)
+	if err != nil {
+		return errors.New("Error converting aggregate record")
+	}
+
+	err = stub.PutState(aggregate_key(a.Dimension, a.Key, a.Period), bytes)
+	if err != nil {
+		return errors.New("Error storing aggregate record")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 record_statistics - Adds a transfer to the day and month aggregates of every dimension it is counted under. A new
+//						 transfer adds its amounts; every call counts the transfer's current status as reached at when.
+//						 The aggregates are kept in idx until it is saved, or written straight away when idx is nil.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_statistics(stub shim.ChaincodeStubInterface, idx *event_index, e TransactionEvent, when time.Time, created bool) error {
+
+	var principal, fee, payout int64
+	var err error
+
+	if created {
+		principal, err = parse_amount(e.Amount)
+		if err != nil {
+			return errors.New("Invalid amount: " + err.Error())
+		}
+
+		fee, err = parse_amount(e.Fee)
+		if err != nil {
+			return errors.New("Invalid fee: " + err.Error())
+		}
+
+		if e.PayoutCurrency != "" {
+			payout, err = parse_amount(e.PayoutAmount)
+			if err != nil {
+				return errors.New("Invalid payout amount: " + err.Error())
+			}
+		}
+	}
+
+	for _, dimension := range event_dimensions(e) {
+		for _, layout := range []string{STATS_DAY, STATS_MONTH} {
+
+			a, err := idx.aggregate(t, stub, dimension[0], dimension[1], when.UTC().Format(layout))
+			if err != nil {
+				return err
+			}
+
+			if created {
+				a.Count++
+				a.Principal += principal
+				a.Fees += fee
+				if e.PayoutCurrency != "" {
+					a.Payout[e.PayoutCurrency] += payout
+				}
+			}
+
+			a.StatusChanges[e.Status]++
+
+			err = idx.put_aggregate(t, stub, a)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 Statistics Functions
+//=================================================================================================================================
+//	 get_statistics - Returns the aggregates of a dimension key for every day or month from from to to inclusive. Periods
+//					  are days for dates (2016-11-26) and months for months (2016-11). Admins may read any dimension;
+//					  other callers only their own member's sending and payout figures.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_statistics(stub shim.ChaincodeStubInterface, caller_member string, caller_role string, args []string) ([]byte, error) {
+
+	//Args
+	//		0		1	  2		3
+	//	dimension, key, from, [to]
+
+	dimension, key, from, to := args[0], args[1], args[2], args[2]
+	if len(args) == 4 {
+		to = args[3]
+	}
+
+	if dimension != STATS_NETWORK && dimension != STATS_CORRIDOR && dimension != STATS_SENDING && dimension != STATS_PAYOUT {
+		return nil, errors.New("Invalid dimension " + dimension + ". Expecting " + STATS_NETWORK + ", " + STATS_CORRIDOR + ", " + STATS_SENDING + " or " + STATS_PAYOUT)
+	}
+
+	if caller_role != ROLE_ADMIN && ((dimension != STATS_SENDING && dimension != STATS_PAYOUT) || key != caller_member) {
+		return nil, errors.New(fmt.Sprintf("Permission Denied. get_statistics. %v %v === %v", dimension, key, caller_member))
+	}
+
+	layout := STATS_DAY
+	if len(from) == len(STATS_MONTH) {
+		layout = STATS_MONTH
+	}
+
+	start, err := time.Parse(layout, from)
+	if err != nil {
+		return nil, errors.New("Invalid from period " + from + ". Expecting YYYY-MM-DD or YYYY-MM")
+	}
+
+	end, err := time.Parse(layout, to)
+	if err != nil || len(to) != len(from) {
+		return nil, errors.New("Invalid to period " + to + ". Expecting the same form as from")
+	}
+
+	if end.Before(start) {
+		return nil, errors.New("to must not be before from")
+	}
+
+	stats := Statistics{Dimension: dimension, Key: key, From: from, To: to, Currency: SETTLEMENT_CURRENCY, Periods: []StatisticsPeriod{}}
+	total := Aggregate{Payout: map[string]int64{}, StatusChanges: map[string]int{}}
+
+	for p := start; !p.After(end); {
+
+		if len(stats.Periods) == STATS_MAX_PERIODS {
+			return nil, errors.New(fmt.Sprintf("Too many periods. At most %v may be queried at once", STATS_MAX_PERIODS))
+		}
+
+		a, err := t.retrieve_aggregate(stub, dimension, key, p.Format(layout))
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Periods = append(stats.Periods, format_aggregate(a))
+
+		total.Count += a.Count
+		total.Principal += a.Principal
+		total.Fees += a.Fees
+		for currency, amount := range a.Payout {
+			total.Payout[currency] += amount
+		}
+		for status, count := range a.StatusChanges {
+			total.StatusChanges[status] += count
+		}
+
+		if layout == STATS_DAY {
+			p = p.AddDate(0, 0, 1)
+		} else {
+			p = p.AddDate(0, 1, 0)
+		}
+	}
+
+	stats.Total = format_aggregate(total)
+	stats.Total.Period = from + "/" + to
+
+	bytes, err := json.Marshal(stats)
+	if err != nil {
+		return nil, errors.New("Error converting statistics")
+	}
+
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	 format_aggregate - Converts an aggregate's minor units into decimal strings.
+//==============================================================================================================================
+func format_aggregate(a Aggregate) StatisticsPeriod {
+
+	p := StatisticsPeriod{
+		Period:        a.Period,
+		Count:         a.Count,
+		Principal:     format_amount(a.Principal),
+		Fees:          format_amount(a.Fees),
+		Payout:        map[string]string{},
+		StatusChanges: a.StatusChanges,
+	}
+
+	for currency, amount := range a.Payout {
+		p.Payout[currency] = format_amount(amount)
+	}
+
+	return p
+}
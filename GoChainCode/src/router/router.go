@@ -0,0 +1,373 @@
+// Package router dispatches chaincode calls through a table of registered
+// functions. Each function declares whether it is an invoke or a query, the
+// roles allowed to call it and a typed argument schema, so arity, type and
+// permission errors are reported the same way for every function. A
+// "describe" query listing every registered function is added automatically.
+//
+//	r := router.New(get_caller)
+//	r.Add(router.Function{
+//		Name: "create_event", Kind: router.Invoke,
+//		Args: []router.Arg{{Name: "tranID", Type: router.String}, {Name: "amount", Type: router.Positive}},
+//		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) { ... },
+//	})
+//	return r.Invoke(stub, function, args)
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Kind says how a function may be called.
+type Kind int
+
+const (
+	// Invoke functions run as transactions and may change state.
+	Invoke Kind = iota
+	// Query functions only read state.
+	Query
+	// Both may be called either way, e.g. diagnostics.
+	Both
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Invoke:
+		return "invoke"
+	case Query:
+		return "query"
+	}
+	return "both"
+}
+
+// MarshalJSON writes the kind as its name.
+func (k Kind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+// ArgType is the type an argument must parse as.
+type ArgType int
+
+const (
+	// String is any non-empty string.
+	String ArgType = iota
+	// Text is any string, including the empty string.
+	Text
+	// Int is a base 10 integer.
+	Int
+	// Amount is a non-negative decimal with at most two places and twelve
+	// digits before the point, e.g. 100.50.
+	Amount
+	// Bool is "true" or "false".
+	Bool
+	// JSON is a JSON document.
+	JSON
+	// Hash is a hex SHA-256 digest or HMAC: 64 lower-case hex digits.
+	Hash
+	// Positive is an Amount greater than zero, e.g. a transfer's principal.
+	Positive
+)
+
+var argTypeNames = []string{"string", "text", "int", "amount", "bool", "json", "hash", "positive"}
+
+func (a ArgType) String() string {
+	if int(a) < len(argTypeNames) {
+		return argTypeNames[a]
+	}
+	return "unknown"
+}
+
+// MarshalJSON writes the type as its name.
+func (a ArgType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+var amountPattern = regexp.MustCompile(`^[0-9]{1,12}(\.[0-9]{1,2})?$`)
+
+var zeroPattern = regexp.MustCompile(`^0+(\.0*)?$`)
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func (a ArgType) check(v string) error {
+	switch a {
+	case String:
+		if v == "" {
+			return errors.New("must not be empty")
+		}
+	case Int:
+		if _, err := strconv.Atoi(v); err != nil {
+			return errors.New("must be an integer")
+		}
+	case Amount, Positive:
+		if !amountPattern.MatchString(v) {
+			return errors.New("must be a decimal amount with at most twelve digits before the point and two after")
+		}
+		if a == Positive && zeroPattern.MatchString(v) {
+			return errors.New("must be greater than zero")
+		}
+	case Bool:
+		if v != "true" && v != "false" {
+			return errors.New("must be true or false")
+		}
+	case JSON:
+		if !json.Valid([]byte(v)) {
+			return errors.New("must be valid JSON")
+		}
+	case Hash:
+		if !hashPattern.MatchString(v) {
+			return errors.New("must be a hex SHA-256 hash")
+		}
+	}
+	return nil
+}
+
+// Arg describes one positional argument. Optional arguments must come last.
+// A Variadic argument must be the last one and matches any number of values.
+// An Empty argument may also be the empty string whatever its type, so an
+// optional argument can be left out when a later one is given.
+type Arg struct {
+	Name     string  `json:"name"`
+	Type     ArgType `json:"type"`
+	Optional bool    `json:"optional,omitempty"`
+	Variadic bool    `json:"variadic,omitempty"`
+	Empty    bool    `json:"empty,omitempty"`
+}
+
+// Handler runs a function once its arguments and the caller's role have been
+// checked.
+type Handler func(stub shim.ChaincodeStubInterface, c *Call) ([]byte, error)
+
+// Function is one entry in the routing table.
+type Function struct {
+	Name        string   `json:"name"`
+	Kind        Kind     `json:"kind"`
+	Roles       []string `json:"roles,omitempty"`
+	Args        []Arg    `json:"args"`
+	Description string   `json:"description,omitempty"`
+	Handler     Handler  `json:"-"`
+}
+
+// Caller is the identity a call is made under.
+type Caller struct {
+	// ID names the caller, e.g. their member or user name.
+	ID   string
+	Role string
+}
+
+// CallerFunc reads the caller's identity from the stub.
+type CallerFunc func(stub shim.ChaincodeStubInterface) (Caller, error)
+
+// Call is handed to a Handler.
+type Call struct {
+	Function string
+	Args     []string
+
+	stub       shim.ChaincodeStubInterface
+	callerFunc CallerFunc
+	caller     *Caller
+}
+
+// Caller returns the caller's identity, reading it on first use.
+func (c *Call) Caller() (Caller, error) {
+	if c.caller != nil {
+		return *c.caller, nil
+	}
+	if c.callerFunc == nil {
+		return Caller{}, errors.New("Caller identity is not available")
+	}
+	caller, err := c.callerFunc(c.stub)
+	if err != nil {
+		return Caller{}, err
+	}
+	c.caller = &caller
+	return caller, nil
+}
+
+// Arg returns the i'th argument, or "" when an optional argument was omitted.
+func (c *Call) Arg(i int) string {
+	if i < len(c.Args) {
+		return c.Args[i]
+	}
+	return ""
+}
+
+// Router is a table of functions.
+type Router struct {
+	callerFunc CallerFunc
+	functions  map[string]*Function
+	order      []string
+}
+
+// New returns a router with only the describe query. callerFunc is used to
+// check roles and by Call.Caller; it may be nil if no function needs it.
+func New(callerFunc CallerFunc) *Router {
+	r := &Router{callerFunc: callerFunc, functions: map[string]*Function{}}
+	r.Add(Function{
+		Name:        "describe",
+		Kind:        Query,
+		Description: "Lists every function with its kind, roles and arguments.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *Call) ([]byte, error) {
+			return json.Marshal(r.Functions())
+		},
+	})
+	return r
+}
+
+// Add registers a function. It panics on a duplicate name or an invalid
+// schema, as the table is fixed when the chaincode is built.
+func (r *Router) Add(f Function) {
+	if _, ok := r.functions[f.Name]; ok {
+		panic("router: function " + f.Name + " registered twice")
+	}
+	for i, a := range f.Args {
+		last := i == len(f.Args)-1
+		if a.Variadic && !last {
+			panic("router: " + f.Name + ": only the last argument may be variadic")
+		}
+		if !a.Optional && !a.Variadic && i > 0 && (f.Args[i-1].Optional || f.Args[i-1].Variadic) {
+			panic("router: " + f.Name + ": required argument " + a.Name + " follows an optional one")
+		}
+	}
+	if f.Args == nil {
+		f.Args = []Arg{}
+	}
+
+	r.functions[f.Name] = &f
+	r.order = append(r.order, f.Name)
+}
+
+// Functions returns the table in registration order.
+func (r *Router) Functions() []Function {
+	out := make([]Function, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, *r.functions[name])
+	}
+	return out
+}
+
+// IsQuery reports whether a function is registered as a query. Fabric 1.x
+// entry points use it to route the merged invoke path.
+func (r *Router) IsQuery(function string) bool {
+	f, ok := r.functions[function]
+	return ok && f.Kind == Query
+}
+
+// Invoke runs a function called as a transaction.
+func (r *Router) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	return r.call(stub, Invoke, function, args)
+}
+
+// Query runs a function called as a query.
+func (r *Router) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	return r.call(stub, Query, function, args)
+}
+
+// Check makes the checks a call of function as kind would get, its kind,
+// arguments and the caller's role, without running it. It returns the Call
+// the Handler would be given.
+func (r *Router) Check(stub shim.ChaincodeStubInterface, kind Kind, function string, args []string) (*Call, error) {
+	f, ok := r.functions[function]
+	if !ok {
+		return nil, errors.New("Received unknown function invocation: " + function)
+	}
+	if f.Kind != Both && f.Kind != kind {
+		return nil, fmt.Errorf("%s must be called as %s, not %s", function, f.Kind, kind)
+	}
+
+	if err := checkArgs(f, args); err != nil {
+		return nil, err
+	}
+
+	c := &Call{Function: function, Args: args, stub: stub, callerFunc: r.callerFunc}
+
+	if len(f.Roles) > 0 {
+		caller, err := c.Caller()
+		if err != nil {
+			return nil, errors.New("Error retrieving caller information: " + err.Error())
+		}
+		if !contains(f.Roles, caller.Role) {
+			return nil, fmt.Errorf("Permission Denied. %s. %v === %v", function, caller.Role, strings.Join(f.Roles, "|"))
+		}
+	}
+
+	return c, nil
+}
+
+func (r *Router) call(stub shim.ChaincodeStubInterface, kind Kind, function string, args []string) ([]byte, error) {
+	c, err := r.Check(stub, kind, function, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.functions[function].Handler(stub, c)
+}
+
+func checkArgs(f *Function, args []string) error {
+	min, max := 0, len(f.Args)
+	for _, a := range f.Args {
+		if a.Variadic {
+			max = -1
+		} else if !a.Optional {
+			min++
+		}
+	}
+
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return fmt.Errorf("Incorrect number of arguments for %s. Expecting %s", f.Name, expecting(f.Args, min, max))
+	}
+
+	for i, v := range args {
+		a := f.Args[len(f.Args)-1]
+		if i < len(f.Args) {
+			a = f.Args[i]
+		}
+		if v == "" && a.Empty {
+			continue
+		}
+		if err := a.Type.check(v); err != nil {
+			return fmt.Errorf("Invalid argument %d (%s) for %s: %v", i, a.Name, f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func expecting(args []Arg, min, max int) string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = a.Name
+		if a.Variadic {
+			names[i] += "..."
+		} else if a.Optional {
+			names[i] = "[" + names[i] + "]"
+		}
+	}
+
+	count := strconv.Itoa(min)
+	switch {
+	case max < 0:
+		count = "at least " + count
+	case max != min:
+		count = count + " to " + strconv.Itoa(max)
+	}
+
+	if len(names) == 0 {
+		return count
+	}
+	return count + " (" + strings.Join(names, ", ") + ")"
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newTestRouter returns a router whose callers act as role, with a function
+// of each kind. Every handler returns its name and arguments.
+func newTestRouter(role string) *Router {
+	r := New(func(stub shim.ChaincodeStubInterface) (Caller, error) {
+		if role == "" {
+			return Caller{}, errors.New("no certificate")
+		}
+		return Caller{ID: "walmart", Role: role}, nil
+	})
+
+	echo := func(stub shim.ChaincodeStubInterface, c *Call) ([]byte, error) {
+		return []byte(c.Function + "(" + strings.Join(c.Args, ",") + ")"), nil
+	}
+
+	r.Add(Function{Name: "create", Kind: Invoke, Roles: []string{"admin", "member"}, Handler: echo, Args: []Arg{
+		{Name: "id", Type: String},
+		{Name: "amount", Type: Positive},
+		{Name: "fee", Type: Amount, Optional: true, Empty: true},
+		{Name: "note", Type: Text, Optional: true},
+	}})
+	r.Add(Function{Name: "read", Kind: Query, Handler: echo, Args: []Arg{{Name: "id", Type: String}}})
+	r.Add(Function{Name: "tag", Kind: Both, Handler: echo, Args: []Arg{
+		{Name: "count", Type: Int},
+		{Name: "hashes", Type: Hash, Variadic: true},
+	}})
+	r.Add(Function{Name: "configure", Kind: Invoke, Roles: []string{"admin"}, Handler: echo, Args: []Arg{
+		{Name: "enabled", Type: Bool},
+		{Name: "config", Type: JSON},
+	}})
+
+	return r
+}
+
+func TestArgs(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name     string
+		function string
+		args     []string
+		err      string
+	}{
+		{"required only", "create", []string{"t1", "100"}, ""},
+		{"every optional", "create", []string{"t1", "100.50", "2.5", "a note"}, ""},
+		{"empty optional before a later one", "create", []string{"t1", "100", "", "a note"}, ""},
+		{"empty text", "create", []string{"t1", "100", "1", ""}, ""},
+		{"too few", "create", []string{"t1"}, "Incorrect number of arguments for create. Expecting 2 to 4 (id, amount, [fee], [note])"},
+		{"too many", "create", []string{"t1", "1", "1", "n", "x"}, "Expecting 2 to 4"},
+		{"empty string", "create", []string{"", "100"}, "Invalid argument 0 (id) for create: must not be empty"},
+		{"zero", "create", []string{"t1", "0.00"}, "Invalid argument 1 (amount) for create: must be greater than zero"},
+		{"negative", "create", []string{"t1", "-5"}, "Invalid argument 1 (amount)"},
+		{"three places", "create", []string{"t1", "1.005"}, "two after"},
+		{"zero fee", "create", []string{"t1", "1", "0"}, ""},
+		{"bad fee", "create", []string{"t1", "1", "free"}, "Invalid argument 2 (fee)"},
+		{"exact", "read", []string{"t1"}, ""},
+		{"none for one", "read", nil, "Incorrect number of arguments for read. Expecting 1 (id)"},
+		{"no variadic values", "tag", []string{"3"}, ""},
+		{"variadic values", "tag", []string{"3", hash, hash}, ""},
+		{"none for variadic", "tag", nil, "Expecting at least 1 (count, hashes...)"},
+		{"not an int", "tag", []string{"three"}, "Invalid argument 0 (count) for tag: must be an integer"},
+		{"bad variadic value", "tag", []string{"3", hash, "ABCD"}, "Invalid argument 2 (hashes) for tag: must be a hex SHA-256 hash"},
+		{"upper-case hash", "tag", []string{"3", strings.ToUpper(hash)}, "hex SHA-256"},
+		{"bool and JSON", "configure", []string{"true", `{"x":1}`}, ""},
+		{"not a bool", "configure", []string{"yes", "{}"}, "must be true or false"},
+		{"not JSON", "configure", []string{"false", "{x"}, "must be valid JSON"},
+		{"no arguments expected", "describe", []string{"x"}, "Incorrect number of arguments for describe. Expecting 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouter("admin")
+
+			kind := Invoke
+			if r.IsQuery(tt.function) {
+				kind = Query
+			}
+
+			out, err := r.call(nil, kind, tt.function, tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.function != "describe" && string(out) != tt.function+"("+strings.Join(tt.args, ",")+")" {
+				t.Fatalf("handler got %s", out)
+			}
+		})
+	}
+}
+
+func TestCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		query    bool
+		function string
+		args     []string
+		err      string
+	}{
+		{"invoke", "member", false, "create", []string{"t1", "1"}, ""},
+		{"query", "member", true, "read", []string{"t1"}, ""},
+		{"both as invoke", "member", false, "tag", []string{"1"}, ""},
+		{"both as query", "member", true, "tag", []string{"1"}, ""},
+		{"invoke as query", "member", true, "create", []string{"t1", "1"}, "create must be called as invoke, not query"},
+		{"query as invoke", "member", false, "read", []string{"t1"}, "read must be called as query, not invoke"},
+		{"unknown", "admin", false, "destroy", nil, "Received unknown function invocation: destroy"},
+		{"role allowed", "admin", false, "configure", []string{"true", "{}"}, ""},
+		{"role denied", "member", false, "configure", []string{"true", "{}"}, "Permission Denied. configure. member === admin"},
+		{"no caller", "", false, "create", []string{"t1", "1"}, "Error retrieving caller information: no certificate"},
+		{"no roles needed", "", true, "read", []string{"t1"}, ""},
+		{"arguments before roles", "member", false, "configure", nil, "Incorrect number of arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouter(tt.role)
+
+			call := r.Invoke
+			if tt.query {
+				call = r.Query
+			}
+
+			_, err := call(nil, tt.function, tt.args)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	r := newTestRouter("member")
+
+	c, err := r.Check(nil, Invoke, "create", []string{"t1", "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Function != "create" || c.Arg(1) != "100" || c.Arg(3) != "" {
+		t.Fatalf("call %+v", c)
+	}
+
+	caller, err := c.Caller()
+	if err != nil || caller != (Caller{ID: "walmart", Role: "member"}) {
+		t.Fatalf("caller %+v, %v", caller, err)
+	}
+
+	if _, err := New(nil).Check(nil, Query, "describe", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Call{}).Caller(); err == nil {
+		t.Fatal("caller without a caller func")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		f     Function
+		panic string
+	}{
+		{"duplicate", Function{Name: "read"}, "registered twice"},
+		{"describe replaced", Function{Name: "describe"}, "registered twice"},
+		{"variadic not last", Function{Name: "f", Args: []Arg{{Name: "a", Variadic: true}, {Name: "b", Optional: true}}}, "only the last argument may be variadic"},
+		{"required after optional", Function{Name: "f", Args: []Arg{{Name: "a", Optional: true}, {Name: "b"}}}, "required argument b follows an optional one"},
+		{"optional after optional", Function{Name: "f", Args: []Arg{{Name: "a"}, {Name: "b", Optional: true}, {Name: "c", Optional: true}}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				p := recover()
+				if tt.panic == "" && p != nil {
+					t.Fatal(p)
+				}
+				if tt.panic != "" && (p == nil || !strings.Contains(p.(string), tt.panic)) {
+					t.Fatalf("panic %v, want one containing %q", p, tt.panic)
+				}
+			}()
+
+			newTestRouter("admin").Add(tt.f)
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	r := newTestRouter("member")
+
+	out, err := r.Query(nil, "describe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var functions []struct {
+		Name  string   `json:"name"`
+		Kind  string   `json:"kind"`
+		Roles []string `json:"roles"`
+		Args  []struct {
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Optional bool   `json:"optional"`
+			Variadic bool   `json:"variadic"`
+			Empty    bool   `json:"empty"`
+		} `json:"args"`
+	}
+	if err := json.Unmarshal(out, &functions); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range functions {
+		names = append(names, f.Name+":"+f.Kind)
+	}
+	if got := strings.Join(names, " "); got != "describe:query create:invoke read:query tag:both configure:invoke" {
+		t.Fatalf("functions %s", got)
+	}
+
+	create := functions[1]
+	if strings.Join(create.Roles, "|") != "admin|member" || len(create.Args) != 4 {
+		t.Fatalf("create %+v", create)
+	}
+	if a := create.Args[1]; a.Name != "amount" || a.Type != "positive" || a.Optional {
+		t.Fatalf("amount %+v", a)
+	}
+	if a := create.Args[2]; a.Type != "amount" || !a.Optional || !a.Empty {
+		t.Fatalf("fee %+v", a)
+	}
+	if a := functions[3].Args[1]; a.Type != "hash" || !a.Variadic {
+		t.Fatalf("hashes %+v", a)
+	}
+	if functions[0].Args == nil {
+		t.Fatal("describe args are null, want []")
+	}
+}
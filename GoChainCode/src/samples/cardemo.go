@@ -4,10 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"encoding/json"
 	"regexp"
+	"sync"
+	"router"
 )
 
 var logger = shim.NewLogger("CLDChaincode")
@@ -37,10 +38,13 @@ const   STATE_BEING_SCRAPPED  		=  4
 //==============================================================================================================================
 //	 Structure Definitions
 //==============================================================================================================================
-//	Chaincode - A blank struct for use with Shim (A HyperLedger included go file used for get/put state
-//				and other HyperLedger functions)
+//	Chaincode - The struct for use with Shim (A HyperLedger included go file used for get/put state
+//				and other HyperLedger functions). It only holds the routing table, which is built once and shared
+//				by every call.
 //==============================================================================================================================
 type  SimpleChaincode struct {
+	routes_once		sync.Once
+	routing_table	*router.Router
 }
 
 //==============================================================================================================================
@@ -142,7 +146,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 
 func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string, error) {
 
-    username, err := stub.ReadCertAttribute("username");
+    username, err := read_cert_attribute(stub, "username");
 	if err != nil { return "", errors.New("Couldn't get attribute 'username'. Error: " + err.Error()) }
 	return string(username), nil
 }
@@ -153,7 +157,7 @@ func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string
 //==============================================================================================================================
 
 func (t *SimpleChaincode) check_affiliation(stub shim.ChaincodeStubInterface) (string, error) {
-    affiliation, err := stub.ReadCertAttribute("role");
+    affiliation, err := read_cert_attribute(stub, "role");
 	if err != nil { return "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error()) }
 	return string(affiliation), nil
 
@@ -204,84 +208,180 @@ func (t *SimpleChaincode) retrieve_v5c(stub shim.ChaincodeStubInterface, v5cID s
 //==============================================================================================================================
 //	 Router Functions
 //==============================================================================================================================
-//	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
-//		  initial arguments passed to other things for use in the called function e.g. name -> ecert
+//	Invoke - Called on chaincode invoke. Looks the function up in the routing table and calls it.
 //==============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	caller, caller_affiliation, err := t.get_caller_data(stub)
+	return t.routes().Invoke(stub, function, args)
+}
+//=================================================================================================================================
+//	Query - Called on chaincode query. Looks the function up in the routing table and calls it.
+//=================================================================================================================================
+func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	if err != nil { return nil, errors.New("Error retrieving caller information")}
+    logger.Debug("function: ", function)
 
+	return t.routes().Query(stub, function, args)
+}
 
-	if function == "create_vehicle" {
-        return t.create_vehicle(stub, caller, caller_affiliation, args[0])
-	} else if function == "ping" {
-        return t.ping(stub)
-    } else { 																				// If the function is not a create then there must be a car so we need to retrieve the car.
-		argPos := 1
+//==============================================================================================================================
+//	 Vehicle handlers - Most functions act on an existing vehicle. These types let the routing table retrieve the
+//						vehicle named by the v5cID argument before calling them.
+//==============================================================================================================================
+type transfer_func func(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error)
+type update_func func(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string) ([]byte, error)
 
-		if function == "scrap_vehicle" {																// If its a scrap vehicle then only two arguments are passed (no update value) all others have three arguments and the v5cID is expected in the last argument
-			argPos = 0
-		}
+//=================================================================================================================================
+//	routes - Returns the routing table, built the first time it is needed and kept for the life of the chaincode.
+//=================================================================================================================================
+func (t *SimpleChaincode) routes() *router.Router {
 
-		v, err := t.retrieve_v5c(stub, args[argPos])
+	t.routes_once.Do(func() {
+		t.routing_table = t.build_routes()
+	})
 
-        if err != nil { fmt.Printf("INVOKE: Error retrieving v5c: %s", err); return nil, errors.New("Error retrieving v5c") }
+	return t.routing_table
+}
 
+//=================================================================================================================================
+//	build_routes - Builds the routing table. Each function declares whether it is an invoke or a query, the affiliation
+//				   that may call it and its arguments. The router checks these before calling the function and lists
+//				   them for 'describe'. The functions still check the vehicle's owner and status themselves.
+//=================================================================================================================================
+func (t *SimpleChaincode) build_routes() *router.Router {
 
-        if strings.Contains(function, "update") == false && function != "scrap_vehicle"    { 									// If the function is not an update or a scrappage it must be a transfer so we need to get the ecert of the recipient.
+	r := router.New(t.get_caller)
 
+	with_vehicle := func(v5c_arg int, fn func(stub shim.ChaincodeStubInterface, c *router.Call, v Vehicle, caller router.Caller) ([]byte, error)) router.Handler {
+		return func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
 
-				if 		   function == "authority_to_manufacturer" { return t.authority_to_manufacturer(stub, v, caller, caller_affiliation, args[0], "manufacturer")
-				} else if  function == "manufacturer_to_private"   { return t.manufacturer_to_private(stub, v, caller, caller_affiliation, args[0], "private")
-				} else if  function == "private_to_private" 	   { return t.private_to_private(stub, v, caller, caller_affiliation, args[0], "private")
-				} else if  function == "private_to_lease_company"  { return t.private_to_lease_company(stub, v, caller, caller_affiliation, args[0], "lease_company")
-				} else if  function == "lease_company_to_private"  { return t.lease_company_to_private(stub, v, caller, caller_affiliation, args[0], "private")
-				} else if  function == "private_to_scrap_merchant" { return t.private_to_scrap_merchant(stub, v, caller, caller_affiliation, args[0], "scrap_merchant")
-				}
+			caller, err := c.Caller()
+			if err != nil { return nil, errors.New("Error retrieving caller information")}
 
-		} else if function == "update_make"  	    { return t.update_make(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "update_model"        { return t.update_model(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "update_reg" { return t.update_registration(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "update_vin" 			{ return t.update_vin(stub, v, caller, caller_affiliation, args[0])
-        } else if function == "update_colour" 		{ return t.update_colour(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "scrap_vehicle" 		{ return t.scrap_vehicle(stub, v, caller, caller_affiliation) }
+			v, err := t.retrieve_v5c(stub, c.Args[v5c_arg])
+			if err != nil { fmt.Printf("INVOKE: Error retrieving v5c: %s", err); return nil, errors.New("Error retrieving v5c") }
 
-		return nil, errors.New("Function of the name "+ function +" doesn't exist.")
+			return fn(stub, c, v, caller)
+		}
+	}
 
+	add_transfer := func(name string, role string, recipient_affiliation string, fn transfer_func) {
+		r.Add(router.Function{
+			Name: name, Kind: router.Invoke, Roles: []string{role},
+			Args: []router.Arg{{Name: "recipient", Type: router.String}, {Name: "v5cID", Type: router.String}},
+			Description: "Transfers the vehicle to a " + recipient_affiliation + ".",
+			Handler: with_vehicle(1, func(stub shim.ChaincodeStubInterface, c *router.Call, v Vehicle, caller router.Caller) ([]byte, error) {
+				return fn(stub, v, caller.ID, caller.Role, c.Args[0], recipient_affiliation)
+			}),
+		})
 	}
+
+	add_update := func(name string, roles []string, field string, arg_type router.ArgType, fn update_func) {
+		r.Add(router.Function{
+			Name: name, Kind: router.Invoke, Roles: roles,
+			Args: []router.Arg{{Name: "value", Type: arg_type}, {Name: "v5cID", Type: router.String}},
+			Description: "Changes the vehicle's " + field + ".",
+			Handler: with_vehicle(1, func(stub shim.ChaincodeStubInterface, c *router.Call, v Vehicle, caller router.Caller) ([]byte, error) {
+				return fn(stub, v, caller.ID, caller.Role, c.Args[0])
+			}),
+		})
+	}
+
+	r.Add(router.Function{
+		Name: "create_vehicle", Kind: router.Invoke, Roles: []string{AUTHORITY},
+		Args: []router.Arg{{Name: "v5cID", Type: router.String}},
+		Description: "Creates a vehicle template owned by the regulator.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil { return nil, errors.New("Error retrieving caller information")}
+			return t.create_vehicle(stub, caller.ID, caller.Role, c.Args[0])
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "ping", Kind: router.Both,
+		Description: "Checks the chaincode is running.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.ping(stub)
+		},
+	})
+
+	add_transfer("authority_to_manufacturer", AUTHORITY,      MANUFACTURER,   t.authority_to_manufacturer)
+	add_transfer("manufacturer_to_private",   MANUFACTURER,   PRIVATE_ENTITY, t.manufacturer_to_private)
+	add_transfer("private_to_private",        PRIVATE_ENTITY, PRIVATE_ENTITY, t.private_to_private)
+	add_transfer("private_to_lease_company",  PRIVATE_ENTITY, LEASE_COMPANY,  t.private_to_lease_company)
+	add_transfer("lease_company_to_private",  LEASE_COMPANY,  PRIVATE_ENTITY, t.lease_company_to_private)
+	add_transfer("private_to_scrap_merchant", PRIVATE_ENTITY, SCRAP_MERCHANT, t.private_to_scrap_merchant)
+
+	add_update("update_make",   []string{MANUFACTURER}, "make",                router.String, t.update_make)
+	add_update("update_model",  []string{MANUFACTURER}, "model",               router.String, t.update_model)
+	add_update("update_reg",    nil,                    "registration",        router.String, t.update_registration)
+	add_update("update_vin",    []string{MANUFACTURER}, "VIN",                 router.Int,    t.update_vin)
+	add_update("update_colour", []string{MANUFACTURER}, "colour",              router.String, t.update_colour)
+
+	r.Add(router.Function{
+		Name: "scrap_vehicle", Kind: router.Invoke, Roles: []string{SCRAP_MERCHANT},
+		Args: []router.Arg{{Name: "v5cID", Type: router.String}},
+		Description: "Marks the vehicle as scrapped.",
+		Handler: with_vehicle(0, func(stub shim.ChaincodeStubInterface, c *router.Call, v Vehicle, caller router.Caller) ([]byte, error) {
+			return t.scrap_vehicle(stub, v, caller.ID, caller.Role)
+		}),
+	})
+
+	r.Add(router.Function{
+		Name: "get_vehicle_details", Kind: router.Query,
+		Args: []router.Arg{{Name: "v5cID", Type: router.String}},
+		Description: "Returns a vehicle the caller owns, or any vehicle for the regulator.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil { fmt.Printf("QUERY: Error retrieving caller details: %s", err); return nil, errors.New("QUERY: Error retrieving caller details: "+err.Error()) }
+			v, err := t.retrieve_v5c(stub, c.Args[0])
+			if err != nil { fmt.Printf("QUERY: Error retrieving v5c: %s", err); return nil, errors.New("QUERY: Error retrieving v5c "+err.Error()) }
+			return t.get_vehicle_details(stub, v, caller.ID, caller.Role)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "check_unique_v5c", Kind: router.Query,
+		Args: []router.Arg{{Name: "v5cID", Type: router.String}},
+		Description: "Returns true if no vehicle has the v5cID.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.check_unique_v5c(stub, c.Args[0], "", "")
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_vehicles", Kind: router.Query,
+		Description: "Returns every vehicle the caller may see.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			caller, err := c.Caller()
+			if err != nil { fmt.Printf("QUERY: Error retrieving caller details: %s", err); return nil, errors.New("QUERY: Error retrieving caller details: "+err.Error()) }
+			return t.get_vehicles(stub, caller.ID, caller.Role)
+		},
+	})
+
+	r.Add(router.Function{
+		Name: "get_ecert", Kind: router.Query,
+		Args: []router.Arg{{Name: "name", Type: router.String}},
+		Description: "Returns the eCert stored for a user.",
+		Handler: func(stub shim.ChaincodeStubInterface, c *router.Call) ([]byte, error) {
+			return t.get_ecert(stub, c.Args[0])
+		},
+	})
+
+	return r
 }
-//=================================================================================================================================
-//	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
-//  		initial arguments passed are passed on to the called function.
-//=================================================================================================================================
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	caller, caller_affiliation, err := t.get_caller_data(stub)
-	if err != nil { fmt.Printf("QUERY: Error retrieving caller details", err); return nil, errors.New("QUERY: Error retrieving caller details: "+err.Error()) }
+//==============================================================================================================================
+//	 get_caller - Returns the caller's username and affiliation for the router.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_caller(stub shim.ChaincodeStubInterface) (router.Caller, error) {
 
-    logger.Debug("function: ", function)
-    logger.Debug("caller: ", caller)
-    logger.Debug("affiliation: ", caller_affiliation)
-
-	if function == "get_vehicle_details" {
-		if len(args) != 1 { fmt.Printf("Incorrect number of arguments passed"); return nil, errors.New("QUERY: Incorrect number of arguments passed") }
-		v, err := t.retrieve_v5c(stub, args[0])
-		if err != nil { fmt.Printf("QUERY: Error retrieving v5c: %s", err); return nil, errors.New("QUERY: Error retrieving v5c "+err.Error()) }
-		return t.get_vehicle_details(stub, v, caller, caller_affiliation)
-	} else if function == "check_unique_v5c" {
-		return t.check_unique_v5c(stub, args[0], caller, caller_affiliation)
-	} else if function == "get_vehicles" {
-		return t.get_vehicles(stub, caller, caller_affiliation)
-	} else if function == "get_ecert" {
-		return t.get_ecert(stub, args[0])
-	} else if function == "ping" {
-		return t.ping(stub)
-	}
+	user, affiliation, err := t.get_caller_data(stub)
 
-	return nil, errors.New("Received unknown function invocation " + function)
+	if err != nil { return router.Caller{}, err }
 
+	return router.Caller{ID: user, Role: affiliation}, nil
 }
 
 //=================================================================================================================================
@@ -570,7 +670,7 @@ func (t *SimpleChaincode) update_vin(stub shim.ChaincodeStubInterface, v Vehicle
 					v.VIN = new_vin					// Update to the new value
 	} else {
 
-        return nil, errors.New(fmt.Sprintf("Permission denied. update_vin %v %v %v %v %v", v.Status, STATE_MANUFACTURE, v.Owner, caller, v.VIN, v.Scrapped))
+        return nil, errors.New(fmt.Sprintf("Permission denied. update_vin %v %v %v %v %v %v", v.Status, STATE_MANUFACTURE, v.Owner, caller, v.VIN, v.Scrapped))
 
 	}
 
@@ -621,7 +721,7 @@ func (t *SimpleChaincode) update_colour(stub shim.ChaincodeStubInterface, v Vehi
 					v.Colour = new_value
 	} else {
 
-		return nil, errors.New(fmt.Sprint("Permission denied. update_colour %t %t %t" + v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
+		return nil, errors.New(fmt.Sprintf("Permission denied. update_colour %t %t %t", v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
 	}
 
 	_, err := t.save_changes(stub, v)
@@ -645,7 +745,7 @@ func (t *SimpleChaincode) update_make(stub shim.ChaincodeStubInterface, v Vehicl
 					v.Make = new_value
 	} else {
 
-        return nil, errors.New(fmt.Sprint("Permission denied. update_make %t %t %t" + v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
+        return nil, errors.New(fmt.Sprintf("Permission denied. update_make %t %t %t", v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
 
 
 	}
@@ -671,7 +771,7 @@ func (t *SimpleChaincode) update_model(stub shim.ChaincodeStubInterface, v Vehic
 					v.Model = new_value
 
 	} else {
-        return nil, errors.New(fmt.Sprint("Permission denied. update_model %t %t %t" + v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
+        return nil, errors.New(fmt.Sprintf("Permission denied. update_model %t %t %t", v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
 
 	}
 
@@ -781,13 +881,3 @@ func (t *SimpleChaincode) check_unique_v5c(stub shim.ChaincodeStubInterface, v5c
 		return []byte("true"), nil
 	}
 }
-
-//=================================================================================================================================
-//	 Main - main - Starts up the chaincode
-//=================================================================================================================================
-func main() {
-
-	err := shim.Start(new(SimpleChaincode))
-
-															if err != nil { fmt.Printf("Error starting Chaincode: %s", err) }
-}
\ No newline at end of file
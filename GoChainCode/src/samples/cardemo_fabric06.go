@@ -0,0 +1,28 @@
+//go:build !fabric1
+// +build !fabric1
+
+// Fabric v0.6 entry point for cardemo.go: go build cardemo.go cardemo_fabric06.go
+
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//=================================================================================================================================
+//	 Main - main - Starts up the chaincode
+//=================================================================================================================================
+func main() {
+
+	err := shim.Start(new(SimpleChaincode))
+
+	if err != nil { fmt.Printf("Error starting Chaincode: %s", err) }
+}
+
+//==============================================================================================================================
+//	 read_cert_attribute - Reads an attribute from the caller's eCert.
+//==============================================================================================================================
+func read_cert_attribute(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	return stub.ReadCertAttribute(name)
+}
@@ -0,0 +1,108 @@
+//go:build fabric1
+// +build fabric1
+
+// Fabric 1.x entry points for cardemo.go: go build -tags fabric1 cardemo.go cardemo_fabric1.go
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//==============================================================================================================================
+//	 Fabric1Chaincode - Adapts SimpleChaincode to the Fabric 1.x Init/Invoke API. Functions registered as queries in the
+//						routing table are routed to Query, everything else to Invoke.
+//==============================================================================================================================
+type Fabric1Chaincode struct {
+	SimpleChaincode
+}
+
+//==============================================================================================================================
+//	 Attribute extension - Fabric CA stores enrollment attributes as JSON, {"attrs":{"role":"manufacturer"}}, in an
+//						   extension with this OID.
+//==============================================================================================================================
+var attrs_oid = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+//=================================================================================================================================
+//	 Main - main - Starts up the chaincode
+//=================================================================================================================================
+func main() {
+
+	err := shim.Start(new(Fabric1Chaincode))
+
+	if err != nil { fmt.Printf("Error starting Chaincode: %s", err) }
+}
+
+//==============================================================================================================================
+//	Init - Called on instantiate and upgrade, with the same function and args as a v0.6 deploy.
+//==============================================================================================================================
+func (t *Fabric1Chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	return to_response(t.SimpleChaincode.Init(stub, function, args))
+}
+
+//==============================================================================================================================
+//	Invoke - Called for every invoke and query.
+//==============================================================================================================================
+func (t *Fabric1Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	if t.routes().IsQuery(function) {
+		return to_response(t.SimpleChaincode.Query(stub, function, args))
+	}
+
+	return to_response(t.SimpleChaincode.Invoke(stub, function, args))
+}
+
+func to_response(payload []byte, err error) pb.Response {
+	if err != nil { return shim.Error(err.Error()) }
+
+	return shim.Success(payload)
+}
+
+//==============================================================================================================================
+//	 read_cert_attribute - Reads an attribute from the enrollment certificate of the proposal's creator. When the
+//						   certificate has no 'username' attribute its common name is used, as Fabric CA enrolls users
+//						   under their name.
+//==============================================================================================================================
+func read_cert_attribute(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+
+	creator, err := stub.GetCreator()
+	if err != nil { return nil, errors.New("Couldn't get creator. Error: " + err.Error()) }
+
+	var identity msp.SerializedIdentity
+	err = proto.Unmarshal(creator, &identity)
+	if err != nil { return nil, errors.New("Couldn't decode creator identity. Error: " + err.Error()) }
+
+	block, _ := pem.Decode(identity.IdBytes)
+	if block == nil { return nil, errors.New("Creator identity is not a PEM certificate") }
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil { return nil, errors.New("Couldn't parse creator certificate. Error: " + err.Error()) }
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(attrs_oid) { continue }
+
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		err = json.Unmarshal(ext.Value, &attrs)
+		if err != nil { return nil, errors.New("Corrupt attribute extension in creator certificate") }
+
+		if value, ok := attrs.Attrs[name]; ok { return []byte(value), nil }
+	}
+
+	if name == "username" && cert.Subject.CommonName != "" { return []byte(cert.Subject.CommonName), nil }
+
+	return nil, errors.New("Attribute '" + name + "' not found in creator certificate")
+}
@@ -0,0 +1,188 @@
+//go:build !fabric1
+// +build !fabric1
+
+// Tests for cardemo.go: go test cardemo.go cardemo_fabric06.go cardemo_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"ledgersim"
+)
+
+//==============================================================================================================================
+//	 car_step - One call of a vehicle's life: who makes it, the function and its arguments, and the error it must fail
+//				with, if any.
+//==============================================================================================================================
+type car_step struct {
+	user     string
+	role     string
+	function string
+	args     []string
+	err      string
+}
+
+//==============================================================================================================================
+//	 run_steps - Runs steps in order on l, failing the test on the first that does not end as it should.
+//==============================================================================================================================
+func run_steps(t *testing.T, l *ledgersim.Ledger, steps []car_step) {
+
+	t.Helper()
+
+	for i, s := range steps {
+
+		l.SetCaller(map[string]string{"username": s.user, "role": s.role})
+
+		_, err := l.Invoke(s.function, s.args...)
+
+		if s.err == "" && err != nil {
+			t.Fatalf("step %v %v: %v", i, s.function, err)
+		}
+		if s.err != "" && (err == nil || !strings.Contains(err.Error(), s.err)) {
+			t.Fatalf("step %v %v: error %v, want one containing %q", i, s.function, err, s.err)
+		}
+	}
+}
+
+//==============================================================================================================================
+//	 new_car_ledger - Returns a ledger with the cardemo chaincode deployed.
+//==============================================================================================================================
+func new_car_ledger(t *testing.T) *ledgersim.Ledger {
+
+	l := ledgersim.New("cardemo", new(SimpleChaincode))
+
+	if _, err := l.Init("init", "DVLA", "ecert-of-dvla"); err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+// Every step of a vehicle's life up to its being scrapped, in order.
+var car_life = []car_step{
+	{"DVLA", AUTHORITY, "create_vehicle", []string{"AB1234567"}, ""},
+	{"DVLA", AUTHORITY, "authority_to_manufacturer", []string{"Jaguar", "AB1234567"}, ""},
+	{"Jaguar", MANUFACTURER, "update_make", []string{"Jaguar", "AB1234567"}, ""},
+	{"Jaguar", MANUFACTURER, "update_model", []string{"F-Type", "AB1234567"}, ""},
+	{"Jaguar", MANUFACTURER, "update_reg", []string{"JA16 UAR", "AB1234567"}, ""},
+	{"Jaguar", MANUFACTURER, "update_colour", []string{"Red", "AB1234567"}, ""},
+	{"Jaguar", MANUFACTURER, "update_vin", []string{"123456789012345", "AB1234567"}, ""},
+	{"Jaguar", MANUFACTURER, "manufacturer_to_private", []string{"Joe", "AB1234567"}, ""},
+	{"Joe", PRIVATE_ENTITY, "private_to_lease_company", []string{"LeaseCan", "AB1234567"}, ""},
+	{"LeaseCan", LEASE_COMPANY, "lease_company_to_private", []string{"Ann", "AB1234567"}, ""},
+	{"Ann", PRIVATE_ENTITY, "private_to_private", []string{"Bob", "AB1234567"}, ""},
+	{"Bob", PRIVATE_ENTITY, "private_to_scrap_merchant", []string{"Cray", "AB1234567"}, ""},
+	{"Cray", SCRAP_MERCHANT, "scrap_vehicle", []string{"AB1234567"}, ""},
+}
+
+func TestVehicleLife(t *testing.T) {
+
+	l := new_car_ledger(t)
+	run_steps(t, l, car_life)
+
+	l.SetCaller(map[string]string{"username": "DVLA", "role": AUTHORITY})
+
+	out, err := l.Query("get_vehicle_details", "AB1234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v Vehicle
+	if err := json.Unmarshal(out, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Vehicle{Make: "Jaguar", Model: "F-Type", Reg: "JA16 UAR", VIN: 123456789012345, Owner: "Cray", Scrapped: true,
+		Status: STATE_BEING_SCRAPPED, Colour: "Red", V5cID: "AB1234567", LeaseContractID: "UNDEFINED"}
+	if v != want {
+		t.Fatalf("vehicle %+v, want %+v", v, want)
+	}
+
+	out, err = l.Query("get_ecert", "DVLA")
+	if err != nil || string(out) != "ecert-of-dvla" {
+		t.Fatalf("get_ecert = %q, %v", out, err)
+	}
+}
+
+func TestVehicleRules(t *testing.T) {
+
+	tests := []struct {
+		name string
+		done int
+		step car_step
+	}{
+		{"bad v5cID", 0, car_step{"DVLA", AUTHORITY, "create_vehicle", []string{"12AB"}, "Invalid v5cID"}},
+		{"create as manufacturer", 0, car_step{"Jaguar", MANUFACTURER, "create_vehicle", []string{"AB1234567"}, "Permission Denied"}},
+		{"create twice", 1, car_step{"DVLA", AUTHORITY, "create_vehicle", []string{"AB1234567"}, "already exists"}},
+		{"unknown vehicle", 1, car_step{"DVLA", AUTHORITY, "authority_to_manufacturer", []string{"Jaguar", "CD1234567"}, "Error retrieving v5c"}},
+		{"other manufacturer", 2, car_step{"Ford", MANUFACTURER, "update_make", []string{"Ford", "AB1234567"}, "Permission denied"}},
+		{"short VIN", 2, car_step{"Jaguar", MANUFACTURER, "update_vin", []string{"1234", "AB1234567"}, "Invalid value"}},
+		{"VIN not a number", 2, car_step{"Jaguar", MANUFACTURER, "update_vin", []string{"12345678901234X", "AB1234567"}, "vin"}},
+		{"sold unfinished", 6, car_step{"Jaguar", MANUFACTURER, "manufacturer_to_private", []string{"Joe", "AB1234567"}, "not fully defined"}},
+		{"VIN changed", 7, car_step{"Jaguar", MANUFACTURER, "update_vin", []string{"999999999999999", "AB1234567"}, "Permission denied"}},
+		{"sold by former owner", 8, car_step{"Jaguar", MANUFACTURER, "manufacturer_to_private", []string{"Ann", "AB1234567"}, "Permission Denied"}},
+		{"sold by someone else", 8, car_step{"Eve", PRIVATE_ENTITY, "private_to_private", []string{"Eve", "AB1234567"}, "Permission Denied"}},
+		{"scrapped too early", 8, car_step{"Joe", SCRAP_MERCHANT, "scrap_vehicle", []string{"AB1234567"}, "Permission denied"}},
+		{"scrapped twice", 13, car_step{"Cray", SCRAP_MERCHANT, "scrap_vehicle", []string{"AB1234567"}, "Permission denied"}},
+		{"reregistered after scrapping", 13, car_step{"Cray", SCRAP_MERCHANT, "update_reg", []string{"NEW", "AB1234567"}, "Permission denied"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := new_car_ledger(t)
+			run_steps(t, l, car_life[:tt.done])
+			run_steps(t, l, []car_step{tt.step})
+		})
+	}
+}
+
+func TestGetVehicles(t *testing.T) {
+
+	l := new_car_ledger(t)
+	run_steps(t, l, car_life[:8])
+	run_steps(t, l, []car_step{{"DVLA", AUTHORITY, "create_vehicle", []string{"CD7654321"}, ""}})
+
+	tests := []struct {
+		user string
+		role string
+		want []string
+	}{
+		{"DVLA", AUTHORITY, []string{"AB1234567", "CD7654321"}},
+		{"Joe", PRIVATE_ENTITY, []string{"AB1234567"}},
+		{"Jaguar", MANUFACTURER, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+
+			l.SetCaller(map[string]string{"username": tt.user, "role": tt.role})
+
+			out, err := l.Query("get_vehicles")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var vehicles []Vehicle
+			if err := json.Unmarshal(out, &vehicles); err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+
+			got := []string{}
+			for _, v := range vehicles {
+				got = append(got, v.V5cID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("%v sees %v, want %v", tt.user, got, tt.want)
+			}
+
+			_, err = l.Query("get_vehicle_details", "CD7654321")
+			if (err == nil) != (tt.role == AUTHORITY) {
+				t.Fatalf("get_vehicle_details of another's vehicle: %v", err)
+			}
+		})
+	}
+}
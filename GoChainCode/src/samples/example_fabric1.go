@@ -0,0 +1,49 @@
+//go:build fabric1
+// +build fabric1
+
+// Fabric 1.x entry points for example.go: go build -tags fabric1 example.go example_fabric1.go
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Fabric1Chaincode adapts SimpleChaincode to the Fabric 1.x Init/Invoke API.
+// "read" was a query on v0.6 and is routed to Query; everything else goes to
+// Invoke.
+type Fabric1Chaincode struct {
+	SimpleChaincode
+}
+
+func main() {
+	err := shim.Start(new(Fabric1Chaincode))
+	if err != nil {
+		fmt.Printf("Error starting Simple chaincode: %s", err)
+	}
+}
+
+// Init is called when the chaincode is instantiated or upgraded
+func (t *Fabric1Chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	return toResponse(t.SimpleChaincode.Init(stub, function, args))
+}
+
+// Invoke is called for every invoke and query
+func (t *Fabric1Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function == "read" {
+		return toResponse(t.SimpleChaincode.Query(stub, function, args))
+	}
+	return toResponse(t.SimpleChaincode.Invoke(stub, function, args))
+}
+
+func toResponse(payload []byte, err error) pb.Response {
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
@@ -0,0 +1,79 @@
+//go:build !fabric1
+// +build !fabric1
+
+// Tests for example.go: go test example.go example_fabric06.go example_test.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"ledgersim"
+)
+
+func TestExample(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		function string
+		args     []string
+		key      string
+		want     string
+		err      string
+	}{
+		{"read init value", "", nil, "hello_world", "hi", ""},
+		{"write", "write", []string{"a", "1"}, "a", "1", ""},
+		{"overwrite", "write", []string{"hello_world", "bye"}, "hello_world", "bye", ""},
+		{"init again", "init", []string{"again"}, "hello_world", "again", ""},
+		{"unknown key", "", nil, "nothing", "", ""},
+		{"write one arg", "write", []string{"a"}, "", "", "Expecting 2"},
+		{"init no args", "init", nil, "", "", "Expecting 1"},
+		{"unknown function", "delete", []string{"a"}, "", "", "unknown function"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := ledgersim.New("example", new(SimpleChaincode))
+			if _, err := l.Init("init", "hi"); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.function != "" {
+				_, err := l.Invoke(tt.function, tt.args...)
+				if tt.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("error %v, want one containing %q", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			out, err := l.Query("read", tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Fatalf("read %v = %q, want %q", tt.key, out, tt.want)
+			}
+		})
+	}
+
+	t.Run("write is not a query", func(t *testing.T) {
+
+		l := ledgersim.New("example", new(SimpleChaincode))
+		if _, err := l.Init("init", "hi"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Query("write", "a", "1"); err == nil {
+			t.Fatal("write ran as a query")
+		}
+		if _, err := l.Query("read"); err == nil {
+			t.Fatal("read without a key succeeded")
+		}
+	})
+}
@@ -58,16 +58,6 @@ type AllTrades struct{
 	OpenTrades []AnOpenTrade `json:"open_trades"`
 }
 
-// ============================================================================================================================
-// Main
-// ============================================================================================================================
-func main() {
-	err := shim.Start(new(SimpleChaincode))
-	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
-	}
-}
-
 // ============================================================================================================================
 // Init - reset all the things
 // ============================================================================================================================
@@ -216,7 +206,7 @@ func (t *SimpleChaincode) Delete(stub shim.ChaincodeStubInterface, args []string
 			fmt.Println("found marble")
 			marbleIndex = append(marbleIndex[:i], marbleIndex[i+1:]...)			//remove it
 			for x:= range marbleIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + marbleIndex[x])
+				fmt.Println(strconv.Itoa(x) + " - " + marbleIndex[x])
 			}
 			break
 		}
@@ -375,7 +365,10 @@ func (t *SimpleChaincode) open_trade(stub shim.ChaincodeStubInterface, args []st
 
 	open := AnOpenTrade{}
 	open.User = args[0]
-	open.Timestamp = makeTimestamp()											//use timestamp as an ID
+	open.Timestamp, err = makeTimestamp(stub)									//use timestamp as an ID
+	if err != nil {
+		return nil, err
+	}
 	open.Want.Color = args[1]
 	open.Want.Size =  size1
 	fmt.Println("- start open trade")
@@ -532,10 +525,14 @@ func findMarble4Trade(stub shim.ChaincodeStubInterface, user string, color strin
 }
 
 // ============================================================================================================================
-// Make Timestamp - create a timestamp in ms
+// Make Timestamp - create a timestamp in ms from the transaction's timestamp, which every endorsing peer agrees on
 // ============================================================================================================================
-func makeTimestamp() int64 {
-    return time.Now().UnixNano() / (int64(time.Millisecond)/int64(time.Nanosecond))
+func makeTimestamp(stub shim.ChaincodeStubInterface) (int64, error) {
+    ts, err := stub.GetTxTimestamp()
+    if err != nil || ts == nil {
+        return 0, errors.New("Unable to get the transaction timestamp")
+    }
+    return time.Unix(ts.Seconds, int64(ts.Nanos)).UnixNano() / (int64(time.Millisecond)/int64(time.Nanosecond)), nil
 }
 
 // ============================================================================================================================
@@ -0,0 +1,22 @@
+//go:build !fabric1
+// +build !fabric1
+
+// Fabric v0.6 entry point for marbelsdemo.go: go build marbelsdemo.go marbelsdemo_fabric06.go
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ============================================================================================================================
+// Main
+// ============================================================================================================================
+func main() {
+	err := shim.Start(new(SimpleChaincode))
+	if err != nil {
+		fmt.Printf("Error starting Simple chaincode: %s", err)
+	}
+}
@@ -0,0 +1,54 @@
+//go:build fabric1
+// +build fabric1
+
+// Fabric 1.x entry points for marbelsdemo.go: go build -tags fabric1 marbelsdemo.go marbelsdemo_fabric1.go
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Fabric1Chaincode adapts SimpleChaincode to the Fabric 1.x Init/Invoke API
+type Fabric1Chaincode struct {
+	SimpleChaincode
+}
+
+// ============================================================================================================================
+// Main
+// ============================================================================================================================
+func main() {
+	err := shim.Start(new(Fabric1Chaincode))
+	if err != nil {
+		fmt.Printf("Error starting Simple chaincode: %s", err)
+	}
+}
+
+// ============================================================================================================================
+// Init - called on instantiate and upgrade, with the same function and args as a v0.6 deploy
+// ============================================================================================================================
+func (t *Fabric1Chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	return toResponse(t.SimpleChaincode.Init(stub, function, args))
+}
+
+// ============================================================================================================================
+// Invoke - entry point for invokes and queries; "read" was a v0.6 query
+// ============================================================================================================================
+func (t *Fabric1Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function == "read" {													//read a variable
+		return toResponse(t.SimpleChaincode.Query(stub, function, args))
+	}
+	return toResponse(t.SimpleChaincode.Invoke(stub, function, args))
+}
+
+func toResponse(payload []byte, err error) pb.Response {
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
@@ -0,0 +1,215 @@
+//go:build !fabric1
+// +build !fabric1
+
+// Tests for marbelsdemo.go: go test marbelsdemo.go marbelsdemo_fabric06.go marbelsdemo_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ledgersim"
+)
+
+// ============================================================================================================================
+// new_marbles - Returns a ledger with bob's blue 16 and red 16 and alice's green 35 marbles
+// ============================================================================================================================
+func new_marbles(t *testing.T) *ledgersim.Ledger {
+	l := ledgersim.New("marbles", new(SimpleChaincode))
+
+	if _, err := l.Init("init", "99"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range [][]string{{"m1", "blue", "16", "bob"}, {"m2", "red", "16", "bob"}, {"m3", "green", "35", "alice"}} {
+		if _, err := l.Invoke("init_marble", m...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return l
+}
+
+// ============================================================================================================================
+// read_json - Reads a key and decodes it into v
+// ============================================================================================================================
+func read_json(t *testing.T, l *ledgersim.Ledger, key string, v interface{}) {
+	t.Helper()
+
+	out, err := l.Query("read", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		t.Fatalf("%v: %v", key, err)
+	}
+}
+
+// ============================================================================================================================
+// owners - Returns "name:user" for every marble in the index, sorted
+// ============================================================================================================================
+func owners(t *testing.T, l *ledgersim.Ledger) string {
+	t.Helper()
+
+	var index []string
+	read_json(t, l, marbleIndexStr, &index)
+
+	list := []string{}
+	for _, name := range index {
+		var m Marble
+		read_json(t, l, name, &m)
+		list = append(list, name+":"+m.User)
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+func TestInitMarble(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"new", []string{"m4", "Yellow", "5", "Carol"}, ""},
+		{"existing", []string{"m1", "yellow", "5", "carol"}, "arleady exists"},
+		{"size not a number", []string{"m4", "yellow", "big", "carol"}, "numeric"},
+		{"empty color", []string{"m4", "", "5", "carol"}, "2nd argument"},
+		{"too few args", []string{"m4", "yellow", "5"}, "Expecting 4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := new_marbles(t)
+
+			_, err := l.Invoke("init_marble", tt.args...)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var m Marble
+			read_json(t, l, "m4", &m)
+			if m != (Marble{Name: "m4", Color: "yellow", Size: 5, User: "carol"}) {
+				t.Fatalf("marble %+v", m)
+			}
+			if got := owners(t, l); got != "m1:bob m2:bob m3:alice m4:carol" {
+				t.Fatalf("owners %v", got)
+			}
+		})
+	}
+}
+
+func TestTrade(t *testing.T) {
+	tests := []struct {
+		name   string
+		closer []string
+		owners string
+		open   int
+	}{
+		{"matching marble", []string{"alice", "m3", "bob", "blue", "16"}, "m1:alice m2:bob m3:bob", 0},
+		{"other willing marble", []string{"alice", "m3", "bob", "red", "16"}, "m1:bob m2:alice m3:bob", 0},
+		{"wrong size", []string{"alice", "m3", "bob", "blue", "17"}, "m1:bob m2:bob m3:alice", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := new_marbles(t)
+
+			// bob wants a green 35 for his blue 16 or red 16
+			if _, err := l.Invoke("open_trade", "bob", "green", "35", "blue", "16", "red", "16"); err != nil {
+				t.Fatal(err)
+			}
+
+			var trades AllTrades
+			read_json(t, l, openTradesStr, &trades)
+			if len(trades.OpenTrades) != 1 || len(trades.OpenTrades[0].Willing) != 2 {
+				t.Fatalf("open trades %+v", trades)
+			}
+			id := strconv.FormatInt(trades.OpenTrades[0].Timestamp, 10)
+
+			if _, err := l.Invoke("perform_trade", append([]string{id}, tt.closer...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := owners(t, l); got != tt.owners {
+				t.Fatalf("owners %v, want %v", got, tt.owners)
+			}
+			read_json(t, l, openTradesStr, &trades)
+			if len(trades.OpenTrades) != tt.open {
+				t.Fatalf("%v open trades, want %v", len(trades.OpenTrades), tt.open)
+			}
+		})
+	}
+}
+
+func TestCleanTrades(t *testing.T) {
+	tests := []struct {
+		name    string
+		invoke  []string
+		willing int
+	}{
+		{"marble given away", []string{"set_user", "m1", "alice"}, 1},
+		{"every marble given away", []string{"set_user", "m1", "alice"}, 0},
+		{"marble deleted", []string{"delete", "m2"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := new_marbles(t)
+
+			if _, err := l.Invoke("open_trade", "bob", "green", "35", "blue", "16", "red", "16"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.willing == 0 {
+				if _, err := l.Invoke("set_user", "m2", "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := l.Invoke(tt.invoke[0], tt.invoke[1:]...); err != nil {
+				t.Fatal(err)
+			}
+
+			var trades AllTrades
+			read_json(t, l, openTradesStr, &trades)
+
+			if tt.willing == 0 {
+				if len(trades.OpenTrades) != 0 {
+					t.Fatalf("open trades %+v, want none", trades)
+				}
+				return
+			}
+			if len(trades.OpenTrades) != 1 || len(trades.OpenTrades[0].Willing) != tt.willing {
+				t.Fatalf("open trades %+v, want %v willing marbles", trades, tt.willing)
+			}
+		})
+	}
+
+	t.Run("remove trade", func(t *testing.T) {
+		l := new_marbles(t)
+
+		if _, err := l.Invoke("open_trade", "bob", "green", "35", "blue", "16"); err != nil {
+			t.Fatal(err)
+		}
+
+		var trades AllTrades
+		read_json(t, l, openTradesStr, &trades)
+
+		if _, err := l.Invoke("remove_trade", strconv.FormatInt(trades.OpenTrades[0].Timestamp, 10)); err != nil {
+			t.Fatal(err)
+		}
+
+		read_json(t, l, openTradesStr, &trades)
+		if len(trades.OpenTrades) != 0 {
+			t.Fatalf("open trades %+v, want none", trades)
+		}
+	})
+}